@@ -0,0 +1,228 @@
+package chart
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"cloud.google.com/go/civil"
+	"golang.org/x/term"
+)
+
+const DEFAULT_WIDTH = 80
+
+// Eighths of a block for drawing bars, from one eighth to a full block
+var barBlocks = []rune{'▏', '▎', '▍', '▌', '▋', '▊', '▉', '█'}
+
+// Sparkline levels from lowest to highest
+var sparkBlocks = []rune{'▁', '▂', '▃', '▄', '▅', '▆', '▇', '█'}
+var sparkASCII = []rune{'_', '.', ',', '-', '~', '=', '*', '#'}
+
+// Heatmap shades from no spending to the most spending
+var heatBlocks = []rune{'·', '░', '▒', '▓', '█'}
+var heatASCII = []rune{'.', ':', '+', '#', '@'}
+
+// Chart renders charts for the terminal. Width is the number of columns available, and Unicode is set if block
+// characters can be displayed. Otherwise charts are drawn with ASCII characters.
+type Chart struct {
+	Width   int
+	Unicode bool
+}
+
+// New returns a Chart sized to the terminal that standard output is written to.
+func New() *Chart {
+	return &Chart{
+		Width:   Width(),
+		Unicode: Unicode(),
+	}
+}
+
+// Width returns the width of the terminal. The COLUMNS environment variable takes precedence over the size reported
+// by the terminal, and DEFAULT_WIDTH is used if neither is available.
+func Width() int {
+	if columns, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && columns > 0 {
+		return columns
+	}
+	if width, _, err := term.GetSize(int(os.Stdout.Fd())); err == nil && width > 0 {
+		return width
+	}
+	return DEFAULT_WIDTH
+}
+
+// Unicode reports whether the locale supports UTF-8 and so the block characters used to draw charts. The locale
+// variables are checked in order of precedence.
+func Unicode() bool {
+	for _, name := range []string{"LC_ALL", "LC_CTYPE", "LANG"} {
+		if value := os.Getenv(name); value != "" {
+			value = strings.ToUpper(value)
+			return strings.Contains(value, "UTF-8") || strings.Contains(value, "UTF8")
+		}
+	}
+	return false
+}
+
+// Bars draws a horizontal bar chart with one line per label, each followed by its value in dollars. values are in
+// cents, and bars are scaled so that the largest value fills the remaining width.
+func (c *Chart) Bars(labels []string, values []int64) []string {
+	labelWidth := 0
+	valueWidth := 0
+	var largest int64
+	for i, label := range labels {
+		labelWidth = max(labelWidth, utf8.RuneCountInString(label))
+		valueWidth = max(valueWidth, len(formatDollars(values[i])))
+		largest = max(largest, values[i])
+	}
+	labelWidth = min(labelWidth, c.Width/3)
+	barWidth := max(c.Width-labelWidth-valueWidth-2, 1)
+
+	lines := make([]string, len(labels))
+	for i, label := range labels {
+		lines[i] = fmt.Sprintf("%-*s %s %s", labelWidth, truncate(label, labelWidth),
+			c.bar(values[i], largest, barWidth), formatDollars(values[i]))
+	}
+	return lines
+}
+
+// bar draws a bar for value scaled so that largest fills width, padded to width with spaces.
+func (c *Chart) bar(value, largest int64, width int) string {
+	if value <= 0 || largest <= 0 {
+		return strings.Repeat(" ", width)
+	}
+
+	eighths := int(value * int64(width) * 8 / largest)
+	if eighths == 0 {
+		eighths = 1
+	}
+	var sb strings.Builder
+	if c.Unicode {
+		sb.WriteString(strings.Repeat(string(barBlocks[7]), eighths/8))
+		if eighths%8 != 0 {
+			sb.WriteRune(barBlocks[eighths%8-1])
+		}
+	} else {
+		sb.WriteString(strings.Repeat("#", max((eighths+4)/8, 1)))
+	}
+	return sb.String() + strings.Repeat(" ", width-utf8.RuneCountInString(sb.String()))
+}
+
+// Sparkline draws values as a single line of characters whose heights are scaled between the smallest and largest
+// value. Only the last values that fit in the width are drawn.
+func (c *Chart) Sparkline(values []int64) string {
+	if len(values) > c.Width {
+		values = values[len(values)-c.Width:]
+	}
+	levels := sparkASCII
+	if c.Unicode {
+		levels = sparkBlocks
+	}
+	if len(values) == 0 {
+		return ""
+	}
+
+	lowest, highest := values[0], values[0]
+	for _, value := range values {
+		lowest = min(lowest, value)
+		highest = max(highest, value)
+	}
+	var sb strings.Builder
+	for _, value := range values {
+		level := len(levels) / 2
+		if highest != lowest {
+			level = int((value - lowest) * int64(len(levels)-1) / (highest - lowest))
+		}
+		sb.WriteRune(levels[level])
+	}
+	return sb.String()
+}
+
+// Heatmap draws a calendar of daily spending starting on start, with one row per day of the week and one column per
+// week. values holds the spending in cents of each consecutive day. Days are shaded by their spending relative to the
+// largest day, and only the last weeks that fit in the width are drawn.
+func (c *Chart) Heatmap(start civil.Date, values []int64) []string {
+	if len(values) == 0 {
+		return nil
+	}
+	shades := heatASCII
+	if c.Unicode {
+		shades = heatBlocks
+	}
+
+	// align the calendar so that weeks start on Monday
+	offset := (int(start.In(time.UTC).Weekday()) + 6) % 7
+	first := start.AddDays(-offset)
+	weeks := (offset + len(values) + 6) / 7
+	const labelWidth = 4
+	if maxWeeks := max((c.Width-labelWidth)/2, 1); weeks > maxWeeks {
+		first = first.AddDays((weeks - maxWeeks) * 7)
+		weeks = maxWeeks
+	}
+
+	var largest int64
+	for _, value := range values {
+		largest = max(largest, value)
+	}
+
+	// leave room for a label on the last week
+	header := []rune(strings.Repeat(" ", labelWidth+weeks*2+1))
+	for week := 0; week < weeks; week++ {
+		// label the first week of each month, and the first column unless the next label is too close to it
+		day := first.AddDays(week * 7)
+		if day.Day <= 7 || (week == 0 && day.AddDays(14).Month == day.Month) {
+			label := day.In(time.UTC).Format("Jan")
+			copy(header[labelWidth+week*2:], []rune(label))
+		}
+	}
+	lines := []string{strings.TrimRight(string(header), " ")}
+
+	for weekday := 0; weekday < 7; weekday++ {
+		var sb strings.Builder
+		sb.WriteString(fmt.Sprintf("%-*s", labelWidth, first.AddDays(weekday).In(time.UTC).Format("Mon")))
+		for week := 0; week < weeks; week++ {
+			i := first.AddDays(week*7 + weekday).DaysSince(start)
+			if i < 0 || i >= len(values) {
+				sb.WriteString("  ")
+				continue
+			}
+			sb.WriteRune(shades[shade(values[i], largest, len(shades))])
+			sb.WriteRune(' ')
+		}
+		lines = append(lines, strings.TrimRight(sb.String(), " "))
+	}
+
+	legend := []string{"Less"}
+	for _, s := range shades {
+		legend = append(legend, string(s))
+	}
+	lines = append(lines, strings.Repeat(" ", labelWidth)+strings.Join(append(legend, "More"), " "))
+	return lines
+}
+
+// shade returns the index of the shade for value, where 0 is used for no spending and the rest of the shades divide
+// the range up to largest evenly.
+func shade(value, largest int64, shades int) int {
+	if value <= 0 || largest <= 0 {
+		return 0
+	}
+	level := int((value*int64(shades-1) + largest - 1) / largest)
+	return min(max(level, 1), shades-1)
+}
+
+// formatDollars formats an amount in cents as dollars.
+func formatDollars(amount int64) string {
+	return fmt.Sprintf("$%.2f", float64(amount)/100)
+}
+
+// truncate shortens s to at most width characters, marking the cut with a trailing '~'.
+func truncate(s string, width int) string {
+	runes := []rune(s)
+	if len(runes) <= width {
+		return s
+	}
+	if width <= 1 {
+		return string(runes[:width])
+	}
+	return string(runes[:width-1]) + "~"
+}
@@ -0,0 +1,53 @@
+package chart
+
+import (
+	"testing"
+
+	"cloud.google.com/go/civil"
+	"gotest.tools/v3/assert"
+)
+
+func TestBars(t *testing.T) {
+	c := &Chart{Width: 30, Unicode: true}
+	lines := c.Bars([]string{"rent", "food"}, []int64{100000, 12500})
+	assert.DeepEqual(t, lines, []string{
+		"rent ████████████████ $1000.00",
+		"food ██               $125.00",
+	})
+
+	c.Unicode = false
+	lines = c.Bars([]string{"groceries and household", "fun"}, []int64{3000, 1000})
+	assert.DeepEqual(t, lines, []string{
+		"groceries~ ############ $30.00",
+		"fun        ####         $10.00",
+	})
+}
+
+func TestSparkline(t *testing.T) {
+	c := &Chart{Width: 80, Unicode: true}
+	assert.Equal(t, c.Sparkline([]int64{0, 700, 350, 100}), "▁█▄▂")
+	assert.Equal(t, c.Sparkline([]int64{5, 5}), "▅▅")
+
+	c = &Chart{Width: 3, Unicode: false}
+	assert.Equal(t, c.Sparkline([]int64{900, 0, 700, 350, 100}), "#,_")
+}
+
+func TestHeatmap(t *testing.T) {
+	c := &Chart{Width: 80, Unicode: false}
+	values := make([]int64, 10)
+	values[0] = 400
+	values[4] = 100
+	// starts on a Wednesday, so the first week is only partially filled
+	lines := c.Heatmap(civil.Date{Year: 2026, Month: 9, Day: 30}, values)
+	assert.DeepEqual(t, lines, []string{
+		"      Oct",
+		"Mon   .",
+		"Tue   .",
+		"Wed @ .",
+		"Thu . .",
+		"Fri . .",
+		"Sat .",
+		"Sun :",
+		"    Less . : + # @ More",
+	})
+}
@@ -0,0 +1,446 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sage/src/sage/data"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Timeout of requests made by a Client created with New
+const DEFAULT_TIMEOUT = 30 * time.Second
+
+// Error codes returned by the API
+const (
+	CODE_INVALID_REQUEST   = "invalid_request"
+	CODE_VALIDATION_FAILED = "validation_failed"
+	CODE_UNKNOWN_CATEGORY  = "unknown_category"
+	CODE_UNKNOWN_USER      = "unknown_user"
+	CODE_NOT_FOUND         = "not_found"
+	CODE_CONFLICT          = "conflict"
+	CODE_INTERNAL          = "internal"
+	CODE_UNAUTHORIZED      = "unauthorized"
+	CODE_FORBIDDEN         = "forbidden"
+)
+
+// Client makes requests to the v2 API of a sage server, which is described by the server's /openapi.json.
+type Client struct {
+	// BaseURL is the address of the server, such as http://localhost:8080
+	BaseURL    string
+	HTTPClient *http.Client
+	// Token is the personal access token or session token sent with every request, if set
+	Token string
+}
+
+// New returns a client for the server at baseURL.
+func New(baseURL string) *Client {
+	return &Client{
+		BaseURL:    strings.TrimSuffix(baseURL, "/"),
+		HTTPClient: &http.Client{Timeout: DEFAULT_TIMEOUT},
+	}
+}
+
+// Error is an error response from the server. Code is one of the CODE_ constants.
+type Error struct {
+	Status  int
+	Code    string
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// ExpenseInput holds the fields of an expense to add or change. CreateExpense requires Date and Amount, and
+// UpdateExpense only changes the fields that are set. Amount is in dollars, and an empty Category leaves the expense
+// uncategorized. PaidBy defaults to the user the request is authenticated as when creating an expense, and an empty
+// PaidBy leaves the expense unattributed. Split and Participants divide a shared expense between users as taken by
+// cmd.ParseSplit, and a Split of "none" stops splitting it.
+type ExpenseInput struct {
+	Date         *string      `json:"date,omitempty"`
+	Location     *string      `json:"location,omitempty"`
+	Description  *string      `json:"description,omitempty"`
+	Category     *string      `json:"category,omitempty"`
+	Amount       *json.Number `json:"amount,omitempty"`
+	PaidBy       *string      `json:"paid_by,omitempty"`
+	Shared       *bool        `json:"shared,omitempty"`
+	Split        *string      `json:"split,omitempty"`
+	Participants []string     `json:"participants,omitempty"`
+}
+
+// ExpenseQuery filters and pages the expenses returned by ListExpenses. Zero fields are left out of the request.
+type ExpenseQuery struct {
+	Start             string
+	End               string
+	Range             string
+	Year              int
+	Month             int
+	Limit             int
+	PageSize          int
+	Page              int
+	Cursor            string
+	Sort              string
+	Query             string
+	MinAmount         string
+	MaxAmount         string
+	Categories        []string
+	ExcludeCategories []string
+	Uncategorized     bool
+	Location          string
+	Who               string
+}
+
+// values returns the query string parameters of the query.
+func (q *ExpenseQuery) values() url.Values {
+	v := url.Values{}
+	setString(v, "start", q.Start)
+	setString(v, "end", q.End)
+	setString(v, "range", q.Range)
+	setInt(v, "year", q.Year)
+	setInt(v, "month", q.Month)
+	setInt(v, "limit", q.Limit)
+	setInt(v, "page-size", q.PageSize)
+	setInt(v, "page", q.Page)
+	setString(v, "cursor", q.Cursor)
+	setString(v, "sort", q.Sort)
+	setString(v, "query", q.Query)
+	setString(v, "min-amount", q.MinAmount)
+	setString(v, "max-amount", q.MaxAmount)
+	setString(v, "category", strings.Join(q.Categories, ","))
+	setString(v, "exclude-category", strings.Join(q.ExcludeCategories, ","))
+	if q.Uncategorized {
+		v.Set("uncategorized", "true")
+	}
+	setString(v, "location", q.Location)
+	setString(v, "who", q.Who)
+	return v
+}
+
+// ExpensePage is a page of expenses, with the cursors of the adjacent pages if there are any.
+type ExpensePage struct {
+	Data       []data.Expense `json:"data"`
+	NextCursor string         `json:"next_cursor"`
+	PrevCursor string         `json:"prev_cursor"`
+}
+
+// SummaryQuery selects the periods returned by ListSummaries. Zero fields are left out of the request.
+type SummaryQuery struct {
+	Start       string
+	End         string
+	Range       string
+	Year        int
+	Limit       int
+	PageSize    int
+	Page        int
+	Interval    string
+	FiscalStart int
+	Stats       bool
+	Who         string
+}
+
+// values returns the query string parameters of the query.
+func (q *SummaryQuery) values() url.Values {
+	v := url.Values{}
+	setString(v, "start", q.Start)
+	setString(v, "end", q.End)
+	setString(v, "range", q.Range)
+	setInt(v, "year", q.Year)
+	setInt(v, "limit", q.Limit)
+	setInt(v, "page-size", q.PageSize)
+	setInt(v, "page", q.Page)
+	setString(v, "interval", q.Interval)
+	setInt(v, "fiscal-start", q.FiscalStart)
+	if q.Stats {
+		v.Set("stats", "true")
+	}
+	setString(v, "who", q.Who)
+	return v
+}
+
+// ListExpenses returns a page of the expenses matching the query, which may be nil.
+func (c *Client) ListExpenses(q *ExpenseQuery) (*ExpensePage, error) {
+	var values url.Values
+	if q != nil {
+		values = q.values()
+	}
+	page := &ExpensePage{}
+	if err := c.do(http.MethodGet, "/expenses", values, nil, page); err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+// CreateExpense adds an expense and returns it with its ID.
+func (c *Client) CreateExpense(input *ExpenseInput) (*data.Expense, error) {
+	var body struct {
+		Data data.Expense `json:"data"`
+	}
+	if err := c.do(http.MethodPost, "/expenses", nil, input, &body); err != nil {
+		return nil, err
+	}
+	return &body.Data, nil
+}
+
+// GetExpense returns the expense with the given ID.
+func (c *Client) GetExpense(id int) (*data.Expense, error) {
+	var body struct {
+		Data data.Expense `json:"data"`
+	}
+	if err := c.do(http.MethodGet, "/expenses/"+strconv.Itoa(id), nil, nil, &body); err != nil {
+		return nil, err
+	}
+	return &body.Data, nil
+}
+
+// UpdateExpense changes the fields of an expense that are set in input, and returns the updated expense.
+func (c *Client) UpdateExpense(id int, input *ExpenseInput) (*data.Expense, error) {
+	var body struct {
+		Data data.Expense `json:"data"`
+	}
+	if err := c.do(http.MethodPatch, "/expenses/"+strconv.Itoa(id), nil, input, &body); err != nil {
+		return nil, err
+	}
+	return &body.Data, nil
+}
+
+// DeleteExpense removes the expense with the given ID.
+func (c *Client) DeleteExpense(id int) error {
+	return c.do(http.MethodDelete, "/expenses/"+strconv.Itoa(id), nil, nil, nil)
+}
+
+// ListCategories returns every category.
+func (c *Client) ListCategories() ([]data.Category, error) {
+	var body struct {
+		Data []data.Category `json:"data"`
+	}
+	if err := c.do(http.MethodGet, "/categories", nil, nil, &body); err != nil {
+		return nil, err
+	}
+	return body.Data, nil
+}
+
+// CreateCategory adds a category.
+func (c *Client) CreateCategory(name string) (*data.Category, error) {
+	var body struct {
+		Data data.Category `json:"data"`
+	}
+	if err := c.do(http.MethodPost, "/categories", nil, data.Category{Name: name}, &body); err != nil {
+		return nil, err
+	}
+	return &body.Data, nil
+}
+
+// CategoryChange is a renamed or deleted category, along with the number of expenses and recurring expenses that were
+// moved to the new name or uncategorized, or that would be on a dry run.
+type CategoryChange struct {
+	Category          data.Category `json:"data"`
+	Affected          int           `json:"affected"`
+	AffectedRecurring int           `json:"affected_recurring"`
+}
+
+// RenameCategory renames a category, moving its expenses to the new name. If dryRun is set, nothing is changed.
+func (c *Client) RenameCategory(name, newName string, dryRun bool) (*CategoryChange, error) {
+	change := &CategoryChange{}
+	err := c.do(http.MethodPatch, "/categories/"+url.PathEscape(name), dryRunValues(dryRun), data.Category{Name: newName}, change)
+	if err != nil {
+		return nil, err
+	}
+	return change, nil
+}
+
+// DeleteCategory removes a category, uncategorizing its expenses. If dryRun is set, nothing is changed.
+func (c *Client) DeleteCategory(name string, dryRun bool) (*CategoryChange, error) {
+	change := &CategoryChange{}
+	if err := c.do(http.MethodDelete, "/categories/"+url.PathEscape(name), dryRunValues(dryRun), nil, change); err != nil {
+		return nil, err
+	}
+	return change, nil
+}
+
+// ListSummaries returns the total spent in each period selected by the query, which may be nil.
+func (c *Client) ListSummaries(q *SummaryQuery) ([]data.Summary, error) {
+	var values url.Values
+	if q != nil {
+		values = q.values()
+	}
+	var body struct {
+		Data []data.Summary `json:"data"`
+	}
+	if err := c.do(http.MethodGet, "/summaries", values, nil, &body); err != nil {
+		return nil, err
+	}
+	return body.Data, nil
+}
+
+// Login starts a session for a user and returns it. Set Token to the session's token to make requests as the user.
+func (c *Client) Login(name, password string) (*data.Session, error) {
+	var body struct {
+		Data data.Session `json:"data"`
+	}
+	creds := map[string]string{"name": name, "password": password}
+	if err := c.do(http.MethodPost, "/sessions", nil, creds, &body); err != nil {
+		return nil, err
+	}
+	return &body.Data, nil
+}
+
+// Logout ends the session whose token is set as Token.
+func (c *Client) Logout() error {
+	return c.do(http.MethodDelete, "/sessions/current", nil, nil, nil)
+}
+
+// ListUsers returns every user.
+func (c *Client) ListUsers() ([]data.User, error) {
+	var body struct {
+		Data []data.User `json:"data"`
+	}
+	if err := c.do(http.MethodGet, "/users", nil, nil, &body); err != nil {
+		return nil, err
+	}
+	return body.Data, nil
+}
+
+// SettleUpQuery selects the shared expenses settled by SettleUp. Zero fields are left out of the request.
+type SettleUpQuery struct {
+	Start string
+	End   string
+	Range string
+	Year  int
+}
+
+// SettleUp returns what each user paid toward the shared expenses selected by the query, which may be nil, and the
+// transfers that settle their balances.
+func (c *Client) SettleUp(q *SettleUpQuery) (*data.SettleUp, error) {
+	values := url.Values{}
+	if q != nil {
+		setString(values, "start", q.Start)
+		setString(values, "end", q.End)
+		setString(values, "range", q.Range)
+		setInt(values, "year", q.Year)
+	}
+	var body struct {
+		Data data.SettleUp `json:"data"`
+	}
+	if err := c.do(http.MethodGet, "/settle-up", values, nil, &body); err != nil {
+		return nil, err
+	}
+	return &body.Data, nil
+}
+
+// SettlementInput holds a payment from one user to another. Amount is in dollars, and Date defaults to today.
+type SettlementInput struct {
+	From   string      `json:"from"`
+	To     string      `json:"to"`
+	Amount json.Number `json:"amount"`
+	Date   string      `json:"date,omitempty"`
+}
+
+// ListSettlements returns every settlement, most recent first.
+func (c *Client) ListSettlements() ([]data.Settlement, error) {
+	var body struct {
+		Data []data.Settlement `json:"data"`
+	}
+	if err := c.do(http.MethodGet, "/settlements", nil, nil, &body); err != nil {
+		return nil, err
+	}
+	return body.Data, nil
+}
+
+// CreateSettlement records a payment made to settle up and returns it.
+func (c *Client) CreateSettlement(input *SettlementInput) (*data.Settlement, error) {
+	var body struct {
+		Data data.Settlement `json:"data"`
+	}
+	if err := c.do(http.MethodPost, "/settlements", nil, input, &body); err != nil {
+		return nil, err
+	}
+	return &body.Data, nil
+}
+
+// do sends a request to a v2 route with an optional JSON body, and decodes the response into out unless it is nil.
+// Error responses are returned as an *Error.
+func (c *Client) do(method, path string, query url.Values, in, out any) error {
+	u := c.BaseURL + "/api/v2" + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+
+	var reqBody io.Reader
+	if in != nil {
+		encoded, err := json.Marshal(in)
+		if err != nil {
+			return fmt.Errorf("error encoding request: %w", err)
+		}
+		reqBody = bytes.NewReader(encoded)
+	}
+	req, err := http.NewRequest(method, u, reqBody)
+	if err != nil {
+		return fmt.Errorf("error creating request: %w", err)
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", "application/json")
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("error sending request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		return decodeError(resp)
+	}
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("error decoding response: %w", err)
+	}
+	return nil
+}
+
+// decodeError reads the error envelope of a response, falling back to the status if the body isn't one.
+func decodeError(resp *http.Response) error {
+	var body struct {
+		Error struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil || body.Error.Code == "" {
+		return &Error{Status: resp.StatusCode, Code: CODE_INTERNAL, Message: "server responded with " + resp.Status}
+	}
+	return &Error{Status: resp.StatusCode, Code: body.Error.Code, Message: body.Error.Message}
+}
+
+// dryRunValues returns the query string parameters that make a change a dry run if dryRun is set.
+func dryRunValues(dryRun bool) url.Values {
+	if !dryRun {
+		return nil
+	}
+	return url.Values{"dry-run": {"true"}}
+}
+
+// setString sets a query string parameter if value is non-empty.
+func setString(v url.Values, key, value string) {
+	if value != "" {
+		v.Set(key, value)
+	}
+}
+
+// setInt sets a query string parameter if value is non-zero.
+func setInt(v url.Values, key string, value int) {
+	if value != 0 {
+		v.Set(key, strconv.Itoa(value))
+	}
+}
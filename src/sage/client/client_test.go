@@ -0,0 +1,80 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestListExpensesQuery(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, r.Method, "GET")
+		assert.Equal(t, r.URL.Path, "/api/v2/expenses")
+		assert.Equal(t, r.URL.RawQuery, "category=food%2Crent&page-size=2&range=this-month&uncategorized=true")
+		assert.Equal(t, r.Header.Get("Authorization"), "Bearer sage_abc")
+		w.Write([]byte(`{"data": [{"id": 3, "date": "2026-10-01", "amount": {"amount": 1250, "currency": "USD"}}], "next_cursor": "abc"}`))
+	}))
+	defer server.Close()
+
+	c := New(server.URL + "/")
+	c.Token = "sage_abc"
+	page, err := c.ListExpenses(&ExpenseQuery{
+		Range:         "this-month",
+		PageSize:      2,
+		Categories:    []string{"food", "rent"},
+		Uncategorized: true,
+	})
+	assert.NilError(t, err)
+	assert.Equal(t, len(page.Data), 1)
+	assert.Equal(t, page.Data[0].Id, 3)
+	assert.Equal(t, page.Data[0].Amount.Amount(), int64(1250))
+	assert.Equal(t, page.NextCursor, "abc")
+}
+
+func TestUpdateExpenseBody(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, r.Method, "PATCH")
+		assert.Equal(t, r.URL.Path, "/api/v2/expenses/3")
+		assert.Equal(t, r.Header.Get("Content-Type"), "application/json")
+		body, _ := io.ReadAll(r.Body)
+		// unset fields are left out, and an empty category is sent to uncategorize the expense
+		assert.Equal(t, string(body), `{"category":"","amount":20.5}`)
+		w.Write([]byte(`{"data": {"id": 3, "date": "2026-10-01", "amount": {"amount": 2050, "currency": "USD"}}}`))
+	}))
+	defer server.Close()
+
+	category := ""
+	amount := json.Number("20.5")
+	expense, err := New(server.URL).UpdateExpense(3, &ExpenseInput{Category: &category, Amount: &amount})
+	assert.NilError(t, err)
+	assert.Equal(t, expense.Amount.Amount(), int64(2050))
+}
+
+func TestErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/v2/expenses/7" {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error": {"code": "not_found", "message": "no expense with ID 7 found"}}`))
+			return
+		}
+		w.WriteHeader(http.StatusBadGateway)
+		w.Write([]byte("bad gateway"))
+	}))
+	defer server.Close()
+
+	c := New(server.URL)
+	err := c.DeleteExpense(7)
+	var apiErr *Error
+	assert.Assert(t, errors.As(err, &apiErr))
+	assert.Equal(t, *apiErr, Error{Status: 404, Code: CODE_NOT_FOUND, Message: "no expense with ID 7 found"})
+
+	_, err = c.ListCategories()
+	assert.Assert(t, errors.As(err, &apiErr))
+	assert.Equal(t, apiErr.Status, 502)
+	assert.Equal(t, apiErr.Code, CODE_INTERNAL)
+}
@@ -2,35 +2,118 @@ package cmd
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 	"sage/src/sage/data"
+	"sage/src/sage/dates"
 
 	_ "github.com/mattn/go-sqlite3"
 )
 
+// AddRequest adds an expense. If Split is set, the expense is shared between its participants as it says, rather than
+// between every user.
 type AddRequest struct {
 	Expense data.Expense
+	Split   *Split
 }
 
 type AddResponse struct {
 	Success bool
 	Error   error
+	Id      int
 }
 
-// addExpense adds an expense to the database
+// ParseAddArgs takes the fields of an expense and constructs the appropriate AddRequest. The amount is in dollars, and
+// an empty category leaves the expense uncategorized.
+func ParseAddArgs(dateStr, location, description, category, amtStr string) (*AddRequest, error) {
+	date, err := dates.Parse(dateStr)
+	if err != nil {
+		return nil, errors.New("error parsing date: " + err.Error())
+	}
+	amt, err := parseAmount(amtStr)
+	if err != nil {
+		return nil, errors.New("error parsing amount: " + err.Error())
+	}
+
+	return &AddRequest{
+		Expense: data.Expense{
+			Date:        date,
+			Location:    location,
+			Description: description,
+			Category:    category,
+			Amount:      amt,
+		},
+	}, nil
+}
+
+// addExpense adds an expense to the database, along with its shares if it is split
 func AddExpense(db *sql.DB, req *AddRequest) *AddResponse {
-	_, err := db.Exec(fmt.Sprintf("INSERT INTO expenses (date_spent, location, description, category, amt) VALUES ('%s', '%s', '%s', '%s', %d)",
+	var split any
+	users := []string{req.Expense.PaidBy, req.Expense.CreatedBy}
+	if req.Split != nil {
+		split = req.Split.Method
+		req.Expense.Shared = true
+		// check the shares before adding anything
+		if _, err := req.Split.Shares(req.Expense.Amount.Amount()); err != nil {
+			return &AddResponse{
+				Success: false,
+				Error:   err,
+			}
+		}
+		for _, p := range req.Split.Participants {
+			users = append(users, p.User)
+		}
+	}
+
+	txn, err := db.Begin()
+	if err != nil {
+		return &AddResponse{
+			Success: false,
+			Error:   fmt.Errorf("error starting transaction: %w", err),
+		}
+	}
+	defer txn.Rollback()
+
+	result, err := txn.Exec("INSERT INTO expenses (date_spent, location, description, category, amt, paid_by, created_by, shared, split) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
 		req.Expense.Date.String(),
 		req.Expense.Location,
 		req.Expense.Description,
-		req.Expense.Category,
-		req.Expense.Amount.Amount()))
+		nullIfEmpty(req.Expense.Category),
+		req.Expense.Amount.Amount(),
+		nullIfEmpty(req.Expense.PaidBy),
+		nullIfEmpty(req.Expense.CreatedBy),
+		req.Expense.Shared,
+		split)
 	if err != nil {
+		if constraintError(err) == errForeignKey {
+			err = referenceError(db, req.Expense.Category, users...)
+		}
 		return &AddResponse{
 			Success: false,
 			Error:   fmt.Errorf("error adding expense to 'expenses' table: %w", err),
 		}
 	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return &AddResponse{
+			Success: false,
+			Error:   fmt.Errorf("error retrieving expense ID: %w", err),
+		}
+	}
+	if req.Split != nil {
+		if err := saveShares(txn, int(id), req.Split, req.Expense.Amount.Amount()); err != nil {
+			return &AddResponse{
+				Success: false,
+				Error:   fmt.Errorf("error splitting expense: %w", err),
+			}
+		}
+	}
 
-	return &AddResponse{Success: true}
+	if err := txn.Commit(); err != nil {
+		return &AddResponse{
+			Success: false,
+			Error:   fmt.Errorf("error committing transaction: %w", err),
+		}
+	}
+	return &AddResponse{Success: true, Id: int(id)}
 }
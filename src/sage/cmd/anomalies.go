@@ -0,0 +1,280 @@
+package cmd
+
+import (
+	"cmp"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"sage/src/sage/data"
+	"sage/src/sage/dates"
+	"slices"
+	"strings"
+
+	"cloud.google.com/go/civil"
+	"github.com/Rhymond/go-money"
+)
+
+const (
+	DEFAULT_ANOMALY_SENSITIVITY = "medium"
+	DEFAULT_ANOMALY_DAYS        = 30
+	// ANOMALY_MIN_HISTORY is the number of previous charges at a location, or previous months of a category, needed
+	// before anything can be considered unusual for it.
+	ANOMALY_MIN_HISTORY = 3
+	// CATEGORY_SPIKE_MONTHS is the number of previous months a category's monthly total is compared against.
+	CATEGORY_SPIKE_MONTHS = 6
+)
+
+// anomalyThresholds are how far above usual an amount must be to be flagged. An expense is flagged if it is at least
+// payeeRatio times the median at its location and its robust z-score is at least payeeScore. A category is flagged if
+// its monthly total is at least spikeRatio times its rolling average and its z-score is at least spikeScore. Scores
+// are ignored if the history doesn't vary.
+type anomalyThresholds struct {
+	payeeRatio float64
+	payeeScore float64
+	spikeRatio float64
+	spikeScore float64
+}
+
+// anomalySensitivities maps each sensitivity to its thresholds. Higher sensitivities flag more anomalies.
+var anomalySensitivities = map[string]anomalyThresholds{
+	"low":    {payeeRatio: 3, payeeScore: 5, spikeRatio: 2, spikeScore: 3},
+	"medium": {payeeRatio: 2, payeeScore: 3.5, spikeRatio: 1.5, spikeScore: 2},
+	"high":   {payeeRatio: 1.5, payeeScore: 2.5, spikeRatio: 1.25, spikeScore: 1.5},
+}
+
+type AnomalyRequest struct {
+	Since       civil.Date
+	Sensitivity string
+}
+
+type AnomalyResponse struct {
+	Success bool
+	Error   error
+	Result  []data.Anomaly
+}
+
+// ParseAnomalyArgs takes a list of args and constructs the appropriate AnomalyRequest. sinceStr defaults to 30 days
+// ago and sensitivity defaults to "medium".
+func ParseAnomalyArgs(sinceStr, sensitivity string) (*AnomalyRequest, error) {
+	since := dates.Today().AddDays(-DEFAULT_ANOMALY_DAYS)
+	if sinceStr != "" {
+		var err error
+		since, err = dates.Parse(sinceStr)
+		if err != nil {
+			return nil, errors.New("error parsing since date: " + err.Error())
+		}
+	}
+
+	if sensitivity == "" {
+		sensitivity = DEFAULT_ANOMALY_SENSITIVITY
+	}
+	if _, ok := anomalySensitivities[sensitivity]; !ok {
+		return nil, errors.New("sensitivity must be one of low, medium, or high")
+	}
+
+	return &AnomalyRequest{Since: since, Sensitivity: sensitivity}, nil
+}
+
+// DetectAnomalies flags the unusual expenses and category totals since the request's date, ordered by date.
+func DetectAnomalies(db *sql.DB, req *AnomalyRequest) *AnomalyResponse {
+	thresholds, ok := anomalySensitivities[req.Sensitivity]
+	if !ok {
+		thresholds = anomalySensitivities[DEFAULT_ANOMALY_SENSITIVITY]
+	}
+
+	expenses, err := loadExpenses(db, ExpenseFilter{})
+	if err != nil {
+		return &AnomalyResponse{
+			Success: false,
+			Error:   fmt.Errorf("error retrieving expenses: %w", err),
+		}
+	}
+
+	anomalies := []data.Anomaly{}
+	anomalies = append(anomalies, payeeAnomalies(expenses, req.Since, thresholds)...)
+	anomalies = append(anomalies, categoryAnomalies(expenses, req.Since, thresholds)...)
+	anomalies = append(anomalies, recurringAnomalies(expenses, req.Since)...)
+	slices.SortStableFunc(anomalies, func(a, b data.Anomaly) int {
+		return cmp.Compare(a.Date.DaysSince(b.Date), 0)
+	})
+
+	return &AnomalyResponse{Success: true, Result: anomalies}
+}
+
+// payeeAnomalies flags the expenses since the given date that are far above the previous expenses at their location.
+func payeeAnomalies(expenses []data.Expense, since civil.Date, thresholds anomalyThresholds) []data.Anomaly {
+	var anomalies []data.Anomaly
+	history := make(map[string][]int64)
+	for _, expense := range expenses {
+		location := strings.ToLower(strings.TrimSpace(expense.Location))
+		if location == "" {
+			continue
+		}
+		amt := expense.Amount.Amount()
+		previous := history[location]
+		history[location] = append(previous, amt)
+		if expense.Date.Before(since) || len(previous) < ANOMALY_MIN_HISTORY {
+			continue
+		}
+
+		median, deviation := medianDeviation(previous)
+		if median <= 0 || float64(amt) < thresholds.payeeRatio*median {
+			continue
+		}
+		// scale the median absolute deviation to be comparable to a standard deviation
+		score := (float64(amt) - median) / (1.4826 * deviation)
+		if deviation != 0 && score < thresholds.payeeScore {
+			continue
+		}
+
+		expense := expense
+		ratio := float64(amt) / median
+		anomalies = append(anomalies, data.Anomaly{
+			Kind:     "payee",
+			Date:     expense.Date,
+			Expense:  &expense,
+			Amount:   expense.Amount,
+			Expected: money.New(int64(math.Round(median)), money.USD),
+			Score:    math.Round(ratio*100) / 100,
+			Reason: fmt.Sprintf("$%.2f at %s is %.1fx the usual $%.2f (median of %d previous charges)",
+				expense.Amount.AsMajorUnits(), expense.Location, ratio, median/100, len(previous)),
+		})
+	}
+	return anomalies
+}
+
+// categoryAnomalies flags the months since the given date in which a category's total is far above its average over
+// the previous months.
+func categoryAnomalies(expenses []data.Expense, since civil.Date, thresholds anomalyThresholds) []data.Anomaly {
+	if len(expenses) == 0 {
+		return nil
+	}
+
+	monthOf := func(d civil.Date) civil.Date {
+		return civil.Date{Year: d.Year, Month: d.Month, Day: 1}
+	}
+	totals := make(map[string]map[civil.Date]int64)
+	firstMonths := make(map[string]civil.Date)
+	for _, expense := range expenses {
+		month := monthOf(expense.Date)
+		if totals[expense.Category] == nil {
+			totals[expense.Category] = make(map[civil.Date]int64)
+			firstMonths[expense.Category] = month
+		}
+		totals[expense.Category][month] += expense.Amount.Amount()
+	}
+	categories := make([]string, 0, len(totals))
+	for category := range totals {
+		categories = append(categories, category)
+	}
+	slices.Sort(categories)
+
+	var anomalies []data.Anomaly
+	lastMonth := monthOf(expenses[len(expenses)-1].Date)
+	for month := monthOf(since); !month.After(lastMonth); month = addMonths(month, 1) {
+		for _, category := range categories {
+			total, ok := totals[category][month]
+			if !ok {
+				continue
+			}
+
+			var previous []float64
+			for i := 1; i <= CATEGORY_SPIKE_MONTHS; i++ {
+				previousMonth := addMonths(month, -i)
+				if previousMonth.Before(firstMonths[category]) {
+					break
+				}
+				previous = append(previous, float64(totals[category][previousMonth]))
+			}
+			if len(previous) < ANOMALY_MIN_HISTORY {
+				continue
+			}
+
+			mean, deviation := meanDeviation(previous)
+			if mean <= 0 || float64(total) < thresholds.spikeRatio*mean {
+				continue
+			}
+			if deviation != 0 && (float64(total)-mean)/deviation < thresholds.spikeScore {
+				continue
+			}
+
+			ratio := float64(total) / mean
+			period := fmt.Sprintf("%04d-%02d", month.Year, month.Month)
+			anomalies = append(anomalies, data.Anomaly{
+				Kind:     "category",
+				Date:     month,
+				Category: category,
+				Period:   period,
+				Amount:   money.New(total, money.USD),
+				Expected: money.New(int64(math.Round(mean)), money.USD),
+				Score:    math.Round(ratio*100) / 100,
+				Reason: fmt.Sprintf("%s spending of $%.2f in %s is %.1fx the average of $%.2f over the previous %d months",
+					DisplayCategory(category), float64(total)/100, period, ratio, mean/100, len(previous)),
+			})
+		}
+	}
+	return anomalies
+}
+
+// recurringAnomalies flags the recurring charges that started within one interval of their cadence before the given
+// date and have been charged since.
+func recurringAnomalies(expenses []data.Expense, since civil.Date) []data.Anomaly {
+	var anomalies []data.Anomaly
+	for _, series := range detectRecurring(expenses) {
+		first := series.charges[0]
+		last := series.charges[len(series.charges)-1]
+		if last.Date.Before(since) || !series.cadence.after(first.Date).After(since) {
+			continue
+		}
+
+		anomalies = append(anomalies, data.Anomaly{
+			Kind:    "recurring",
+			Date:    last.Date,
+			Expense: &last,
+			Amount:  money.New(series.amount, money.USD),
+			Reason: fmt.Sprintf("new %s charge of about $%.2f at %s, first charged on %s (%d charges so far)",
+				series.cadence.name, float64(series.amount)/100, series.location, first.Date, len(series.charges)),
+		})
+	}
+	return anomalies
+}
+
+// medianDeviation returns the median of the given amounts and their median absolute deviation from it.
+func medianDeviation(amounts []int64) (float64, float64) {
+	values := make([]float64, len(amounts))
+	for i, amt := range amounts {
+		values[i] = float64(amt)
+	}
+	median := medianOf(values)
+	deviations := make([]float64, len(values))
+	for i, value := range values {
+		deviations[i] = math.Abs(value - median)
+	}
+	return median, medianOf(deviations)
+}
+
+// medianOf returns the median of the given values.
+func medianOf(values []float64) float64 {
+	sorted := slices.Clone(values)
+	slices.Sort(sorted)
+	n := len(sorted)
+	if n%2 == 0 {
+		return (sorted[n/2-1] + sorted[n/2]) / 2
+	}
+	return sorted[n/2]
+}
+
+// meanDeviation returns the mean of the given values and their population standard deviation.
+func meanDeviation(values []float64) (float64, float64) {
+	var sum float64
+	for _, value := range values {
+		sum += value
+	}
+	mean := sum / float64(len(values))
+	var squares float64
+	for _, value := range values {
+		squares += (value - mean) * (value - mean)
+	}
+	return mean, math.Sqrt(squares / float64(len(values)))
+}
@@ -5,17 +5,26 @@ import (
 	"fmt"
 )
 
+// CategoryRequest lists categories if Subcommand is empty, or adds, deletes or renames ("edit") the named category.
+// DryRun reports how many expenses a delete or rename would affect without changing anything.
 type CategoryRequest struct {
 	Subcommand      string
 	CategoryName    string
 	NewCategoryName string
+	DryRun          bool
 }
 
+// CategoryResponse holds the category names for a list. Affected and AffectedRecurring are the number of expenses and
+// recurring expenses a delete uncategorized or a rename moved to the new name.
 type CategoryResponse struct {
-	Success    bool
-	Error      error
-	Subcommand string
-	Result     *sql.Rows
+	Success           bool
+	Error             error
+	Subcommand        string
+	Result            *sql.Rows
+	Affected          int
+	AffectedRecurring int
+	// IDs of the expenses moved to the new category or uncategorized, unless it was a dry run
+	ExpenseIds []int
 }
 
 // ExpenseCategory retrieves the list of categories and returns their names
@@ -44,7 +53,10 @@ func ExpenseCategory(db *sql.DB, req *CategoryRequest) *CategoryResponse {
 
 // addCategory adds a new category to the database
 func addCategory(db *sql.DB, req *CategoryRequest) *CategoryResponse {
-	_, err := db.Exec(fmt.Sprintf("INSERT INTO categories (name) VALUES ('%s')", req.CategoryName))
+	_, err := db.Exec("INSERT INTO categories (name) VALUES (?)", req.CategoryName)
+	if constraintError(err) == ErrConflict {
+		err = errorOf(ErrConflict, "category '%s' already exists", req.CategoryName)
+	}
 	if err != nil {
 		return &CategoryResponse{
 			Success: false,
@@ -58,58 +70,85 @@ func addCategory(db *sql.DB, req *CategoryRequest) *CategoryResponse {
 	}
 }
 
-// deleteCategory removes a category from the database
+// deleteCategory removes a category from the database, uncategorizing its expenses and recurring expenses
 func deleteCategory(db *sql.DB, req *CategoryRequest) *CategoryResponse {
-	rows, err := db.Query(fmt.Sprintf("SELECT name FROM categories WHERE name = '%s'", req.CategoryName))
+	txn, err := db.Begin()
 	if err != nil {
 		return &CategoryResponse{
 			Success: false,
-			Error:   fmt.Errorf("error querying 'categories' table: %w", err),
+			Error:   fmt.Errorf("error starting transaction: %w", err),
 		}
 	}
-	if !rows.Next() {
-		rows.Close()
+
+	defer func() {
+		_ = txn.Rollback()
+	}()
+
+	affected, affectedRecurring, err := categoryExpenses(txn, req.CategoryName)
+	if err != nil {
 		return &CategoryResponse{
 			Success: false,
-			Error:   fmt.Errorf("category '%s' not found", req.CategoryName),
+			Error:   err,
+		}
+	}
+	if req.DryRun {
+		return &CategoryResponse{
+			Success:           true,
+			Subcommand:        req.Subcommand,
+			Affected:          affected,
+			AffectedRecurring: affectedRecurring,
 		}
 	}
 
-	rows.Close()
-
-	_, err = db.Exec(fmt.Sprintf("DELETE FROM categories WHERE name = '%s'", req.CategoryName))
+	ids, err := categoryExpenseIds(txn, req.CategoryName)
 	if err != nil {
 		return &CategoryResponse{
 			Success: false,
-			Error:   fmt.Errorf("error deleting category from 'categories' table: %w", err),
+			Error:   err,
 		}
 	}
-
-	return &CategoryResponse{
-		Success:    true,
-		Subcommand: req.Subcommand,
+	_, err = txn.Exec("UPDATE expenses SET category = NULL WHERE category = ?", req.CategoryName)
+	if err != nil {
+		return &CategoryResponse{
+			Success: false,
+			Error:   fmt.Errorf("error uncategorizing expenses in 'expenses' table: %w", err),
+		}
 	}
-}
-
-// editCategory changes the name of a category in the database
-func editCategory(db *sql.DB, req *CategoryRequest) *CategoryResponse {
-	rows, err := db.Query(fmt.Sprintf("SELECT name FROM categories WHERE name = '%s'", req.CategoryName))
+	_, err = txn.Exec("UPDATE recurring_expenses SET category = NULL WHERE category = ?", req.CategoryName)
+	if err != nil {
+		return &CategoryResponse{
+			Success: false,
+			Error:   fmt.Errorf("error uncategorizing recurring expenses in 'recurring_expenses' table: %w", err),
+		}
+	}
+	_, err = txn.Exec("DELETE FROM categories WHERE name = ?", req.CategoryName)
 	if err != nil {
 		return &CategoryResponse{
 			Success: false,
-			Error:   fmt.Errorf("error querying 'categories' table: %w", err),
+			Error:   fmt.Errorf("error deleting category from 'categories' table: %w", err),
 		}
 	}
-	if !rows.Next() {
-		rows.Close()
+
+	err = txn.Commit()
+	if err != nil {
 		return &CategoryResponse{
 			Success: false,
-			Error:   fmt.Errorf("category '%s' not found", req.CategoryName),
+			Error:   fmt.Errorf("error committing transaction: %w", err),
 		}
 	}
 
-	rows.Close()
+	return &CategoryResponse{
+		Success:           true,
+		Subcommand:        req.Subcommand,
+		Affected:          affected,
+		AffectedRecurring: affectedRecurring,
+		ExpenseIds:        ids,
+	}
+}
 
+// editCategory changes the name of a category in the database, moving its expenses and recurring expenses to the new
+// name
+func editCategory(db *sql.DB, req *CategoryRequest) *CategoryResponse {
 	txn, err := db.Begin()
 	if err != nil {
 		return &CategoryResponse{
@@ -122,21 +161,56 @@ func editCategory(db *sql.DB, req *CategoryRequest) *CategoryResponse {
 		_ = txn.Rollback()
 	}()
 
-	_, err = db.Exec(fmt.Sprintf("INSERT INTO categories (name) VALUES ('%s')", req.NewCategoryName))
+	affected, affectedRecurring, err := categoryExpenses(txn, req.CategoryName)
+	if err != nil {
+		return &CategoryResponse{
+			Success: false,
+			Error:   err,
+		}
+	}
+
+	// the new category is added even on a dry run, so that a name that is already taken is reported, and rolled back
+	_, err = txn.Exec("INSERT INTO categories (name) VALUES (?)", req.NewCategoryName)
+	if constraintError(err) == ErrConflict {
+		err = errorOf(ErrConflict, "category '%s' already exists", req.NewCategoryName)
+	}
 	if err != nil {
 		return &CategoryResponse{
 			Success: false,
 			Error:   fmt.Errorf("error adding new category to 'categories' table: %w", err),
 		}
 	}
-	_, err = db.Exec(fmt.Sprintf("UPDATE expenses SET category = '%s' WHERE category = '%s'", req.NewCategoryName, req.CategoryName))
+	if req.DryRun {
+		return &CategoryResponse{
+			Success:           true,
+			Subcommand:        req.Subcommand,
+			Affected:          affected,
+			AffectedRecurring: affectedRecurring,
+		}
+	}
+
+	ids, err := categoryExpenseIds(txn, req.CategoryName)
+	if err != nil {
+		return &CategoryResponse{
+			Success: false,
+			Error:   err,
+		}
+	}
+	_, err = txn.Exec("UPDATE expenses SET category = ? WHERE category = ?", req.NewCategoryName, req.CategoryName)
 	if err != nil {
 		return &CategoryResponse{
 			Success: false,
 			Error:   fmt.Errorf("error updating category in 'expenses' table: %w", err),
 		}
 	}
-	_, err = db.Exec(fmt.Sprintf("DELETE FROM categories WHERE name = '%s'", req.CategoryName))
+	_, err = txn.Exec("UPDATE recurring_expenses SET category = ? WHERE category = ?", req.NewCategoryName, req.CategoryName)
+	if err != nil {
+		return &CategoryResponse{
+			Success: false,
+			Error:   fmt.Errorf("error updating category in 'recurring_expenses' table: %w", err),
+		}
+	}
+	_, err = txn.Exec("DELETE FROM categories WHERE name = ?", req.CategoryName)
 	if err != nil {
 		return &CategoryResponse{
 			Success: false,
@@ -153,7 +227,58 @@ func editCategory(db *sql.DB, req *CategoryRequest) *CategoryResponse {
 	}
 
 	return &CategoryResponse{
-		Success:    true,
-		Subcommand: req.Subcommand,
+		Success:           true,
+		Subcommand:        req.Subcommand,
+		Affected:          affected,
+		AffectedRecurring: affectedRecurring,
+		ExpenseIds:        ids,
+	}
+}
+
+// categoryExpenses returns the number of expenses and recurring expenses filed under a category, or an error if the
+// category doesn't exist
+func categoryExpenses(q rowQuerier, name string) (int, int, error) {
+	var exists bool
+	err := q.QueryRow("SELECT EXISTS (SELECT 1 FROM categories WHERE name = ?)", name).Scan(&exists)
+	if err != nil {
+		return 0, 0, fmt.Errorf("error querying 'categories' table: %w", err)
+	}
+	if !exists {
+		return 0, 0, errorOf(ErrNotFound, "category '%s' not found", name)
+	}
+
+	var expenses, recurring int
+	err = q.QueryRow("SELECT (SELECT COUNT(*) FROM expenses WHERE category = ?), "+
+		"(SELECT COUNT(*) FROM recurring_expenses WHERE category = ?)", name, name).Scan(&expenses, &recurring)
+	if err != nil {
+		return 0, 0, fmt.Errorf("error querying 'expenses' table: %w", err)
+	}
+	return expenses, recurring, nil
+}
+
+// categoryExpenseIds returns the IDs of the expenses filed under a category
+func categoryExpenseIds(txn *sql.Tx, name string) ([]int, error) {
+	rows, err := txn.Query("SELECT id FROM expenses WHERE category = ? ORDER BY id", name)
+	if err != nil {
+		return nil, fmt.Errorf("error querying 'expenses' table: %w", err)
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("error reading expenses: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// DisplayCategory returns the name of a category for display, where uncategorized expenses have an empty category.
+func DisplayCategory(category string) string {
+	if category == "" {
+		return "uncategorized"
 	}
+	return category
 }
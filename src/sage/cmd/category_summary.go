@@ -0,0 +1,37 @@
+package cmd
+
+import (
+	"database/sql"
+	"fmt"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+type CategorySummaryRequest struct {
+	ExpenseFilter
+}
+
+type CategorySummaryResponse struct {
+	Success bool
+	Error   error
+	Result  *sql.Rows
+}
+
+// SummarizeCategories retrieves the sum and number of the matching expenses in each category, largest total first.
+// Uncategorized expenses are grouped under an empty category name.
+func SummarizeCategories(db *sql.DB, req *CategorySummaryRequest) *CategorySummaryResponse {
+	where, args := req.whereClause()
+	rows, err := db.Query("SELECT COALESCE(category, '') AS category_name, SUM(amt) AS total_spent, COUNT(*) AS count "+
+		"FROM expenses"+where+" GROUP BY category_name ORDER BY total_spent DESC, category_name", args...)
+	if err != nil {
+		return &CategorySummaryResponse{
+			Success: false,
+			Error:   fmt.Errorf("error calculating category summary: %w", err),
+		}
+	}
+
+	return &CategorySummaryResponse{
+		Success: true,
+		Result:  rows,
+	}
+}
@@ -0,0 +1,86 @@
+package cmd
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sage/src/sage/data"
+	"sage/src/sage/dates"
+	"time"
+)
+
+// Types of change made to an expense
+const (
+	CHANGE_CREATED = "expense.created"
+	CHANGE_UPDATED = "expense.updated"
+	CHANGE_DELETED = "expense.deleted"
+)
+
+// How long changes are kept for clients to catch up on
+const CHANGE_RETENTION = 7 * 24 * time.Hour
+
+// RecordChange adds a change to an expense to the sequence of changes, and returns it with its place in the sequence.
+// Changes older than CHANGE_RETENTION are removed.
+func RecordChange(db *sql.DB, changeType string, expense data.Expense) (*data.Change, error) {
+	payload, err := json.Marshal(expense)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding expense: %w", err)
+	}
+	now := dates.Now().UTC().Truncate(time.Second)
+	result, err := db.Exec("INSERT INTO changes (type, expense_id, payload, created_at) VALUES (?, ?, ?, ?)",
+		changeType, expense.Id, string(payload), now.Format(time.RFC3339))
+	if err != nil {
+		return nil, fmt.Errorf("error adding change to 'changes' table: %w", err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving change ID: %w", err)
+	}
+	_, err = db.Exec("DELETE FROM changes WHERE created_at < ?", now.Add(-CHANGE_RETENTION).Format(time.RFC3339))
+	if err != nil {
+		return nil, fmt.Errorf("error deleting old changes: %w", err)
+	}
+
+	return &data.Change{
+		Id:        id,
+		Type:      changeType,
+		ExpenseId: expense.Id,
+		Expense:   &expense,
+		CreatedAt: now,
+	}, nil
+}
+
+// ChangeRange returns the ID of the oldest change still kept and of the latest change made. If no changes are kept,
+// the oldest is the ID the next change will have.
+func ChangeRange(db *sql.DB) (oldest, latest int64, err error) {
+	err = db.QueryRow(`SELECT COALESCE((SELECT MIN(id) FROM changes), (SELECT seq + 1 FROM sqlite_sequence WHERE name = 'changes'), 1),
+		COALESCE((SELECT seq FROM sqlite_sequence WHERE name = 'changes'), 0)`).Scan(&oldest, &latest)
+	if err != nil {
+		return 0, 0, fmt.Errorf("error querying 'changes' table: %w", err)
+	}
+	return oldest, latest, nil
+}
+
+// ChangesSince returns the changes made after the change with the given ID, in the order they were made.
+func ChangesSince(db *sql.DB, after int64) ([]data.Change, error) {
+	rows, err := db.Query("SELECT id, type, expense_id, payload, created_at FROM changes WHERE id > ? ORDER BY id", after)
+	if err != nil {
+		return nil, fmt.Errorf("error querying 'changes' table: %w", err)
+	}
+	defer rows.Close()
+
+	var changes []data.Change
+	for rows.Next() {
+		var change data.Change
+		var payload string
+		if err := rows.Scan(&change.Id, &change.Type, &change.ExpenseId, &payload, &change.CreatedAt); err != nil {
+			return nil, fmt.Errorf("error reading changes: %w", err)
+		}
+		change.Expense = &data.Expense{}
+		if err := json.Unmarshal([]byte(payload), change.Expense); err != nil {
+			return nil, fmt.Errorf("error decoding change %d: %w", change.Id, err)
+		}
+		changes = append(changes, change)
+	}
+	return changes, rows.Err()
+}
@@ -0,0 +1,211 @@
+package cmd
+
+import (
+	"cmp"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"sage/src/sage/data"
+	"sage/src/sage/dates"
+	"slices"
+
+	"cloud.google.com/go/civil"
+	"github.com/Rhymond/go-money"
+)
+
+const (
+	DEFAULT_COMPARE_MOVERS = 5
+	DEFAULT_AVERAGE_MONTHS = 12
+)
+
+type CompareRequest struct {
+	Current        data.Period
+	Baseline       data.Period
+	BaselineMonths int
+	Movers         int
+}
+
+type CompareResponse struct {
+	Success bool
+	Error   error
+	Result  *data.Comparison
+}
+
+// ParseCompareArgs takes a list of args and constructs the appropriate CompareRequest. currentStr is a period accepted
+// by ParsePeriod, and defaults to the current month. baselineStr is either a period, "last-year" for the same period a
+// year earlier, "previous" for the period of the same length immediately before, or "average" for the average spent
+// over a period as long as the current one, taken from the given number of months before it. The average baseline needs
+// a current period of whole months. baselineStr defaults to "last-year", months to 12 and movers to 5.
+func ParseCompareArgs(currentStr, baselineStr string, months, movers int) (*CompareRequest, error) {
+	var current data.Period
+	var err error
+	if currentStr == "" {
+		today := dates.Today()
+		current = monthsPeriod(civil.Date{Year: today.Year, Month: today.Month, Day: 1}, 1)
+	} else {
+		current, err = ParsePeriod(currentStr)
+		if err != nil {
+			return nil, errors.New("error parsing current period: " + err.Error())
+		}
+	}
+
+	if months < 0 {
+		return nil, errors.New("months must be positive")
+	}
+	if months != 0 && baselineStr != "average" {
+		return nil, errors.New("can only provide months with the average baseline")
+	}
+	if movers < 0 {
+		return nil, errors.New("movers must be positive")
+	}
+	if movers == 0 {
+		movers = DEFAULT_COMPARE_MOVERS
+	}
+
+	req := &CompareRequest{Current: current, Movers: movers}
+	switch baselineStr {
+	case "", "last-year":
+		req.Baseline = yearBefore(current)
+	case "previous":
+		req.Baseline = shiftPeriod(current, -1)
+	case "average":
+		if wholeMonths(current) == 0 {
+			return nil, errors.New("the average baseline needs a current period of whole months")
+		}
+		if months == 0 {
+			months = DEFAULT_AVERAGE_MONTHS
+		}
+		monthStart := civil.Date{Year: current.Start.Year, Month: current.Start.Month, Day: 1}
+		req.Baseline = monthsPeriod(addMonths(monthStart, -months), months)
+		req.BaselineMonths = months
+	default:
+		req.Baseline, err = ParsePeriod(baselineStr)
+		if err != nil {
+			return nil, errors.New("error parsing baseline period: " + err.Error())
+		}
+	}
+
+	return req, nil
+}
+
+// CompareExpenses compares the totals spent in each category during the current period against the baseline period.
+func CompareExpenses(db *sql.DB, req *CompareRequest) *CompareResponse {
+	current, err := categoryTotals(db, req.Current)
+	if err != nil {
+		return &CompareResponse{
+			Success: false,
+			Error:   fmt.Errorf("error calculating current period totals: %w", err),
+		}
+	}
+	baseline, err := categoryTotals(db, req.Baseline)
+	if err != nil {
+		return &CompareResponse{
+			Success: false,
+			Error:   fmt.Errorf("error calculating baseline period totals: %w", err),
+		}
+	}
+	if req.BaselineMonths != 0 {
+		// the monthly average, scaled to the length of the current period
+		currentMonths := int64(wholeMonths(req.Current))
+		for category, total := range baseline {
+			baseline[category] = roundedDiv(total*currentMonths, int64(req.BaselineMonths))
+		}
+	}
+
+	categories := make([]string, 0, len(current)+len(baseline))
+	for category := range current {
+		categories = append(categories, category)
+	}
+	for category := range baseline {
+		if _, ok := current[category]; !ok {
+			categories = append(categories, category)
+		}
+	}
+	slices.Sort(categories)
+
+	comparison := &data.Comparison{
+		Current:        req.Current,
+		Baseline:       req.Baseline,
+		BaselineMonths: req.BaselineMonths,
+		Categories:     []data.CategoryComparison{},
+		New:            []string{},
+		Disappeared:    []string{},
+		Movers:         []data.CategoryComparison{},
+	}
+	var currentTotal, baselineTotal int64
+	for _, category := range categories {
+		_, inCurrent := current[category]
+		_, inBaseline := baseline[category]
+		if !inBaseline {
+			comparison.New = append(comparison.New, category)
+		}
+		if !inCurrent {
+			comparison.Disappeared = append(comparison.Disappeared, category)
+		}
+		currentTotal += current[category]
+		baselineTotal += baseline[category]
+		comparison.Categories = append(comparison.Categories, compareTotals(category, current[category], baseline[category]))
+	}
+	comparison.Total = compareTotals("", currentTotal, baselineTotal)
+
+	for _, categoryComparison := range comparison.Categories {
+		if categoryComparison.Change.Amount() != 0 {
+			comparison.Movers = append(comparison.Movers, categoryComparison)
+		}
+	}
+	slices.SortStableFunc(comparison.Movers, func(a, b data.CategoryComparison) int {
+		return cmp.Compare(abs(b.Change.Amount()), abs(a.Change.Amount()))
+	})
+	if len(comparison.Movers) > req.Movers {
+		comparison.Movers = comparison.Movers[:req.Movers]
+	}
+
+	return &CompareResponse{Success: true, Result: comparison}
+}
+
+// categoryTotals returns the total spent in each category during a period.
+func categoryTotals(db *sql.DB, period data.Period) (map[string]int64, error) {
+	sumResp := SummarizeCategories(db, &CategorySummaryRequest{
+		ExpenseFilter: ExpenseFilter{Start: period.Start, End: period.End},
+	})
+	if !sumResp.Success {
+		return nil, sumResp.Error
+	}
+	defer sumResp.Result.Close()
+
+	totals := make(map[string]int64)
+	for sumResp.Result.Next() {
+		var category string
+		var total int64
+		var count int
+		if err := sumResp.Result.Scan(&category, &total, &count); err != nil {
+			return nil, err
+		}
+		totals[category] = total
+	}
+	return totals, sumResp.Result.Err()
+}
+
+// compareTotals builds the comparison of a category's current and baseline totals.
+func compareTotals(category string, current, baseline int64) data.CategoryComparison {
+	comparison := data.CategoryComparison{
+		Category: category,
+		Current:  money.New(current, money.USD),
+		Baseline: money.New(baseline, money.USD),
+		Change:   money.New(current-baseline, money.USD),
+	}
+	if baseline != 0 {
+		percent := math.Round(float64(current-baseline)/float64(baseline)*10000) / 100
+		comparison.ChangePercent = &percent
+	}
+	return comparison
+}
+
+// abs returns the absolute value of n.
+func abs(n int64) int64 {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
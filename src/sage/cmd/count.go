@@ -7,60 +7,60 @@ import (
 
 type CountRequest struct {
 	Type string
+	ExpenseFilter
+	Interval         string
+	FiscalStartMonth int
+	PageSize         int
 }
 
 type CountResponse struct {
 	Success bool
 	Error   error
 	Result  int
+	Pages   int
 }
 
-// CountExpenses retrieves the total number of expenses
+// CountExpenses retrieves the total number of rows a log or summary request with the same filter would return, along
+// with the number of pages of the given page size needed to show them. If no page size is given, all rows are on one
+// page.
 func CountExpenses(db *sql.DB, req *CountRequest) *CountResponse {
-	var count int
+	var countQuery string
+	var args []any
 	if req.Type == "log" {
-		rows, err := db.Query("SELECT COUNT(*) FROM expenses")
-		if err != nil {
-			return &CountResponse{
-				Success: false,
-				Error:   fmt.Errorf("error querying expenses: %w", err),
-			}
-		}
-		defer rows.Close()
-
-		for rows.Next() {
-			err := rows.Scan(&count)
-			if err != nil {
-				return &CountResponse{
-					Success: false,
-					Error:   fmt.Errorf("error scanning count: %w", err),
-				}
-			}
-		}
+		var where string
+		where, args = req.whereClause()
+		countQuery = "SELECT COUNT(*) FROM expenses" + where
 	} else if req.Type == "summary" {
-		rows, err := db.Query("SELECT COUNT(*) FROM expenses GROUP BY strftime('%Y-%m', date_spent)")
-		if err != nil {
-			return &CountResponse{
-				Success: false,
-				Error:   fmt.Errorf("error querying expenses: %w", err),
-			}
-		}
-		defer rows.Close()
-
-		for rows.Next() {
-			err := rows.Scan(&count)
-			if err != nil {
-				return &CountResponse{
-					Success: false,
-					Error:   fmt.Errorf("error scanning count: %w", err),
-				}
-			}
-		}
+		var groupQuery string
+		groupQuery, args = (&SummaryRequest{
+			ExpenseFilter:    req.ExpenseFilter,
+			Interval:         req.Interval,
+			FiscalStartMonth: req.FiscalStartMonth,
+		}).groupQuery()
+		countQuery = "SELECT COUNT(*) FROM (" + groupQuery + ")"
 	} else {
 		return &CountResponse{
 			Success: false,
 			Error:   fmt.Errorf("invalid request type: %s", req.Type),
 		}
 	}
-	return &CountResponse{Success: true, Result: count}
+
+	var count int
+	err := db.QueryRow(countQuery, args...).Scan(&count)
+	if err != nil {
+		return &CountResponse{
+			Success: false,
+			Error:   fmt.Errorf("error counting expenses: %w", err),
+		}
+	}
+
+	pages := 0
+	if count > 0 {
+		pages = 1
+		if req.PageSize > 0 {
+			pages = (count + req.PageSize - 1) / req.PageSize
+		}
+	}
+
+	return &CountResponse{Success: true, Result: count, Pages: pages}
 }
@@ -0,0 +1,106 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+// logCursor marks a position in an ordered list of expenses. Values holds the sort key values of the expense at the
+// position, and Before is set if the cursor selects the expenses before the position rather than after it. Sort is
+// the formatted sort order the list was in, so that a cursor isn't used with a different order.
+type logCursor struct {
+	Values []any  `json:"v"`
+	Before bool   `json:"b,omitempty"`
+	Sort   string `json:"s,omitempty"`
+}
+
+// encodeCursor returns the opaque string form of a cursor at the given sort key values.
+func encodeCursor(values []any, before bool, sort string) string {
+	b, _ := json.Marshal(logCursor{Values: values, Before: before, Sort: sort})
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// decodeCursor parses a cursor returned by encodeCursor.
+func decodeCursor(s string) (*logCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+
+	var cursor logCursor
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.UseNumber()
+	if err := decoder.Decode(&cursor); err != nil || len(cursor.Values) == 0 {
+		return nil, errors.New("invalid cursor")
+	}
+
+	for i, value := range cursor.Values {
+		if number, ok := value.(json.Number); ok {
+			if n, err := number.Int64(); err == nil {
+				cursor.Values[i] = n
+			} else if f, err := number.Float64(); err == nil {
+				cursor.Values[i] = f
+			} else {
+				return nil, errors.New("invalid cursor")
+			}
+		}
+	}
+
+	return &cursor, nil
+}
+
+// keysetCondition builds a condition matching the rows that come after the given sort key values in the order of keys,
+// or before them if before is set. Returns the condition along with the arguments for its placeholders.
+func keysetCondition(keys []sortKey, values []any, before bool) (string, []any) {
+	var terms []string
+	var args []any
+	for i, key := range keys {
+		var term []string
+		for j := 0; j < i; j++ {
+			term = append(term, keys[j].expr+" = ?")
+			args = append(args, values[j])
+		}
+		op := ">"
+		if key.desc != before {
+			op = "<"
+		}
+		term = append(term, key.expr+" "+op+" ?")
+		args = append(args, values[i])
+		terms = append(terms, "("+strings.Join(term, " AND ")+")")
+	}
+	return "(" + strings.Join(terms, " OR ") + ")", args
+}
+
+// orderClause builds the ORDER BY clause for the given sort keys, reversing every direction if reverse is set.
+func orderClause(keys []sortKey, reverse bool) string {
+	var terms []string
+	for _, key := range keys {
+		if key.desc != reverse {
+			terms = append(terms, key.expr+" DESC")
+		} else {
+			terms = append(terms, key.expr)
+		}
+	}
+	return " ORDER BY " + strings.Join(terms, ", ")
+}
+
+// keyColumns returns the sort key expressions as a select list. Text is cast explicitly so that dates are returned as
+// stored rather than converted to times.
+func keyColumns(keys []sortKey) string {
+	var columns []string
+	for _, key := range keys {
+		columns = append(columns, "CAST("+key.expr+" AS TEXT)")
+	}
+	return strings.Join(columns, ", ")
+}
+
+// andWhere adds a condition to a WHERE clause built by whereClause.
+func andWhere(where, cond string) string {
+	if where == "" {
+		return " WHERE " + cond
+	}
+	return where + " AND " + cond
+}
@@ -3,6 +3,7 @@ package cmd
 import (
 	"database/sql"
 	"errors"
+	"fmt"
 	"io/fs"
 	"os"
 )
@@ -21,6 +22,82 @@ const (
 		id INTEGER PRIMARY KEY,
 		name VARCHAR(255) UNIQUE NOT NULL
 		)`
+	CREATE_DATE_INDEX_QUERY      string = `CREATE INDEX IF NOT EXISTS expenses_date_spent_id ON expenses (date_spent, id)`
+	CREATE_RECURRING_TABLE_QUERY string = `CREATE TABLE IF NOT EXISTS recurring_expenses (
+		id INTEGER PRIMARY KEY,
+		location VARCHAR(255) NOT NULL,
+		description VARCHAR(255),
+		category VARCHAR(255),
+		amt INTEGER NOT NULL,
+		cadence VARCHAR(16) NOT NULL,
+		next_date DATE NOT NULL,
+		FOREIGN KEY (category) REFERENCES categories(name)
+		)`
+	CREATE_TOKEN_TABLE_QUERY string = `CREATE TABLE IF NOT EXISTS tokens (
+		id INTEGER PRIMARY KEY,
+		name VARCHAR(255) NOT NULL,
+		prefix VARCHAR(16) NOT NULL,
+		hash CHAR(64) UNIQUE NOT NULL,
+		scopes VARCHAR(255) NOT NULL,
+		created_at DATETIME NOT NULL,
+		last_used_at DATETIME
+		)`
+	CREATE_USER_TABLE_QUERY string = `CREATE TABLE IF NOT EXISTS users (
+		id INTEGER PRIMARY KEY,
+		name VARCHAR(255) UNIQUE NOT NULL,
+		password_hash VARCHAR(60)
+		)`
+	CREATE_SESSION_TABLE_QUERY string = `CREATE TABLE IF NOT EXISTS sessions (
+		id INTEGER PRIMARY KEY,
+		user_name VARCHAR(255) NOT NULL,
+		hash CHAR(64) UNIQUE NOT NULL,
+		created_at DATETIME NOT NULL,
+		expires_at DATETIME NOT NULL,
+		FOREIGN KEY (user_name) REFERENCES users(name) ON DELETE CASCADE
+		)`
+	CREATE_SHARE_TABLE_QUERY string = `CREATE TABLE IF NOT EXISTS expense_shares (
+		expense_id INTEGER NOT NULL,
+		user_name VARCHAR(255) NOT NULL,
+		weight INTEGER NOT NULL,
+		amt INTEGER NOT NULL,
+		PRIMARY KEY (expense_id, user_name),
+		FOREIGN KEY (expense_id) REFERENCES expenses(id) ON DELETE CASCADE,
+		FOREIGN KEY (user_name) REFERENCES users(name) ON DELETE CASCADE
+		)`
+	CREATE_SETTLEMENT_TABLE_QUERY string = `CREATE TABLE IF NOT EXISTS settlements (
+		id INTEGER PRIMARY KEY,
+		date_paid DATE NOT NULL,
+		from_user VARCHAR(255) NOT NULL,
+		to_user VARCHAR(255) NOT NULL,
+		amt INTEGER NOT NULL,
+		FOREIGN KEY (from_user) REFERENCES users(name) ON DELETE CASCADE,
+		FOREIGN KEY (to_user) REFERENCES users(name) ON DELETE CASCADE
+		)`
+	CREATE_CHANGE_TABLE_QUERY string = `CREATE TABLE IF NOT EXISTS changes (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		type VARCHAR(32) NOT NULL,
+		expense_id INTEGER NOT NULL,
+		payload TEXT NOT NULL,
+		created_at DATETIME NOT NULL
+		)`
+	CREATE_WEBHOOK_TABLE_QUERY string = `CREATE TABLE IF NOT EXISTS webhooks (
+		id INTEGER PRIMARY KEY,
+		url TEXT NOT NULL,
+		events TEXT NOT NULL,
+		secret VARCHAR(255) NOT NULL,
+		created_at DATETIME NOT NULL
+		)`
+	CREATE_DELIVERY_TABLE_QUERY string = `CREATE TABLE IF NOT EXISTS webhook_deliveries (
+		id INTEGER PRIMARY KEY,
+		webhook_id INTEGER NOT NULL,
+		change_id INTEGER NOT NULL,
+		event VARCHAR(32) NOT NULL,
+		attempt INTEGER NOT NULL,
+		status INTEGER,
+		error TEXT,
+		delivered_at DATETIME NOT NULL,
+		FOREIGN KEY (webhook_id) REFERENCES webhooks(id) ON DELETE CASCADE
+		)`
 	SAGE_DB_NAME string = "sage.db"
 	TEST_DB_NAME string = "test.db"
 )
@@ -52,9 +129,101 @@ func ConnectDB(db_name string) (*sql.DB, error) {
 		return nil, errors.New("error initializing 'categories' table: " + err.Error())
 	}
 
+	// index `expenses` by date for paging through the log
+	_, err = db.Exec(CREATE_DATE_INDEX_QUERY)
+	if err != nil {
+		return nil, errors.New("error initializing 'expenses' date index: " + err.Error())
+	}
+
+	// create `recurring_expenses` table if it doesn't exist
+	_, err = db.Exec(CREATE_RECURRING_TABLE_QUERY)
+	if err != nil {
+		return nil, errors.New("error initializing 'recurring_expenses' table: " + err.Error())
+	}
+
+	// create `tokens` table if it doesn't exist
+	_, err = db.Exec(CREATE_TOKEN_TABLE_QUERY)
+	if err != nil {
+		return nil, errors.New("error initializing 'tokens' table: " + err.Error())
+	}
+
+	// create `users` table if it doesn't exist
+	_, err = db.Exec(CREATE_USER_TABLE_QUERY)
+	if err != nil {
+		return nil, errors.New("error initializing 'users' table: " + err.Error())
+	}
+
+	// create `sessions` table if it doesn't exist
+	_, err = db.Exec(CREATE_SESSION_TABLE_QUERY)
+	if err != nil {
+		return nil, errors.New("error initializing 'sessions' table: " + err.Error())
+	}
+
+	// add the columns introduced since the tables were first created
+	for _, c := range addedColumns {
+		if err := addColumn(db, c.table, c.column, c.definition); err != nil {
+			return nil, fmt.Errorf("error adding '%s' column to '%s' table: %w", c.column, c.table, err)
+		}
+	}
+
+	// create `expense_shares` table if it doesn't exist
+	_, err = db.Exec(CREATE_SHARE_TABLE_QUERY)
+	if err != nil {
+		return nil, errors.New("error initializing 'expense_shares' table: " + err.Error())
+	}
+
+	// create `settlements` table if it doesn't exist
+	_, err = db.Exec(CREATE_SETTLEMENT_TABLE_QUERY)
+	if err != nil {
+		return nil, errors.New("error initializing 'settlements' table: " + err.Error())
+	}
+
+	// create `changes` table if it doesn't exist
+	_, err = db.Exec(CREATE_CHANGE_TABLE_QUERY)
+	if err != nil {
+		return nil, errors.New("error initializing 'changes' table: " + err.Error())
+	}
+
+	// create `webhooks` table if it doesn't exist
+	_, err = db.Exec(CREATE_WEBHOOK_TABLE_QUERY)
+	if err != nil {
+		return nil, errors.New("error initializing 'webhooks' table: " + err.Error())
+	}
+
+	// create `webhook_deliveries` table if it doesn't exist
+	_, err = db.Exec(CREATE_DELIVERY_TABLE_QUERY)
+	if err != nil {
+		return nil, errors.New("error initializing 'webhook_deliveries' table: " + err.Error())
+	}
+
 	return db, nil
 }
 
+// addedColumns are the columns added to tables after they were first created. They are added to the tables of older
+// databases when connecting, so that every database has them.
+var addedColumns = []struct {
+	table      string
+	column     string
+	definition string
+}{
+	{"expenses", "paid_by", "VARCHAR(255) REFERENCES users(name)"},
+	{"expenses", "created_by", "VARCHAR(255) REFERENCES users(name)"},
+	{"expenses", "shared", "BOOLEAN NOT NULL DEFAULT 0"},
+	{"expenses", "split", "VARCHAR(7)"},
+	{"tokens", "user_name", "VARCHAR(255) REFERENCES users(name) ON DELETE CASCADE"},
+}
+
+// addColumn adds a column to a table unless it already has it.
+func addColumn(db *sql.DB, table, column, definition string) error {
+	var exists bool
+	err := db.QueryRow("SELECT COUNT(*) > 0 FROM pragma_table_info(?) WHERE name = ?", table, column).Scan(&exists)
+	if err != nil || exists {
+		return err
+	}
+	_, err = db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	return err
+}
+
 // verifyDatabase checks if the sage folder and given database exists. Creates the necessary folder and SQLite file
 // if it doesn't. returns the path to the database file.
 func verifyDatabase(db_name string) (string, error) {
@@ -18,28 +18,24 @@ type DeleteResponse struct {
 
 // DeleteExpense removes an expense from the database
 func DeleteExpense(db *sql.DB, req *DeleteRequest) *DeleteResponse {
-	rows, err := db.Query(fmt.Sprintf("SELECT id FROM expenses WHERE id = '%d'", req.Id))
+	result, err := db.Exec("DELETE FROM expenses WHERE id = ?", req.Id)
 	if err != nil {
 		return &DeleteResponse{
 			Success: false,
-			Error:   fmt.Errorf("error querying 'expenses' table: %w", err),
+			Error:   fmt.Errorf("error deleting expense from 'expenses' table: %w", err),
 		}
 	}
-	if !rows.Next() {
-		rows.Close()
+	affected, err := result.RowsAffected()
+	if err != nil {
 		return &DeleteResponse{
 			Success: false,
-			Error:   fmt.Errorf("no expense with ID %d found", req.Id),
+			Error:   fmt.Errorf("error deleting expense from 'expenses' table: %w", err),
 		}
 	}
-
-	rows.Close()
-
-	_, err = db.Exec(fmt.Sprintf("DELETE FROM expenses WHERE id = '%d'", req.Id))
-	if err != nil {
+	if affected == 0 {
 		return &DeleteResponse{
 			Success: false,
-			Error:   fmt.Errorf("error deleting expense from 'expenses' table: %w", err),
+			Error:   errorOf(ErrNotFound, "no expense with ID %d found", req.Id),
 		}
 	}
 
@@ -0,0 +1,239 @@
+package cmd
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sage/src/sage/dates"
+	"strconv"
+	"strings"
+
+	"cloud.google.com/go/civil"
+	"github.com/Rhymond/go-money"
+)
+
+// EditRequest holds the fields to change on an expense. nil fields are left unchanged, an empty Category
+// uncategorizes the expense, and an empty PaidBy leaves it unattributed. Split replaces how the expense is split between
+// participants, and Unsplit shares it between every user instead.
+type EditRequest struct {
+	Id          int
+	Date        *civil.Date
+	Location    *string
+	Description *string
+	Category    *string
+	Amount      *money.Money
+	PaidBy      *string
+	Shared      *bool
+	Split       *Split
+	Unsplit     bool
+}
+
+type EditResponse struct {
+	Success bool
+	Error   error
+}
+
+// EditArgs holds the unparsed fields of an expense to change. nil fields are left unchanged. Amount is in dollars,
+// and Shared is "true" or "false". Split and With are the method and participants of a new split as taken by
+// ParseSplit, or Split is "none" to stop splitting the expense between participants.
+type EditArgs struct {
+	Date        *string
+	Location    *string
+	Description *string
+	Category    *string
+	Amount      *string
+	PaidBy      *string
+	Shared      *string
+	Split       *string
+	With        []string
+}
+
+// ParseEditArgs takes the ID of an expense and the fields to change and constructs the appropriate EditRequest. At
+// least one field must be given.
+func ParseEditArgs(id int, editArgs EditArgs) (*EditRequest, error) {
+	if id <= 0 {
+		return nil, errors.New("ID must be positive")
+	}
+	req := &EditRequest{
+		Id:          id,
+		Location:    editArgs.Location,
+		Description: editArgs.Description,
+		Category:    editArgs.Category,
+		PaidBy:      editArgs.PaidBy,
+	}
+	if editArgs.Date != nil {
+		date, err := dates.Parse(*editArgs.Date)
+		if err != nil {
+			return nil, errors.New("error parsing date: " + err.Error())
+		}
+		req.Date = &date
+	}
+	if editArgs.Amount != nil {
+		amount, err := parseAmount(*editArgs.Amount)
+		if err != nil {
+			return nil, errors.New("error parsing amount: " + err.Error())
+		}
+		req.Amount = amount
+	}
+	if editArgs.Shared != nil {
+		shared, err := strconv.ParseBool(*editArgs.Shared)
+		if err != nil {
+			return nil, errors.New("shared must be true or false")
+		}
+		req.Shared = &shared
+	}
+	if editArgs.Split != nil && *editArgs.Split == "none" {
+		if len(editArgs.With) != 0 {
+			return nil, errors.New("cannot provide participants without a split")
+		}
+		req.Unsplit = true
+	} else if editArgs.Split != nil || len(editArgs.With) != 0 {
+		if req.Shared != nil && !*req.Shared {
+			return nil, errors.New("cannot split an expense that isn't shared")
+		}
+		var method string
+		if editArgs.Split != nil {
+			method = *editArgs.Split
+		}
+		split, err := ParseSplit(method, editArgs.With)
+		if err != nil {
+			return nil, err
+		}
+		req.Split = split
+	}
+	if req.Date == nil && req.Location == nil && req.Description == nil && req.Category == nil && req.Amount == nil &&
+		req.PaidBy == nil && req.Shared == nil && req.Split == nil && !req.Unsplit {
+		return nil, errors.New("must provide at least one field to change")
+	}
+	return req, nil
+}
+
+// EditExpense changes the given fields of an expense. Changing the amount of a split expense divides the new amount
+// between its participants the same way, which fails with ErrInvalid for an exact split that no longer adds up.
+// Unsharing an expense also stops splitting it.
+func EditExpense(db *sql.DB, req *EditRequest) *EditResponse {
+	var sets []string
+	var args []any
+	if req.Date != nil {
+		sets = append(sets, "date_spent = ?")
+		args = append(args, req.Date.String())
+	}
+	if req.Location != nil {
+		sets = append(sets, "location = ?")
+		args = append(args, *req.Location)
+	}
+	if req.Description != nil {
+		sets = append(sets, "description = ?")
+		args = append(args, *req.Description)
+	}
+	if req.Category != nil {
+		sets = append(sets, "category = ?")
+		args = append(args, nullIfEmpty(*req.Category))
+	}
+	if req.Amount != nil {
+		sets = append(sets, "amt = ?")
+		args = append(args, req.Amount.Amount())
+	}
+	if req.PaidBy != nil {
+		sets = append(sets, "paid_by = ?")
+		args = append(args, nullIfEmpty(*req.PaidBy))
+	}
+	if req.Shared != nil {
+		sets = append(sets, "shared = ?")
+		args = append(args, *req.Shared)
+		if !*req.Shared {
+			req.Unsplit = true
+		}
+	}
+	if req.Split != nil {
+		sets = append(sets, "shared = 1", "split = ?")
+		args = append(args, req.Split.Method)
+	} else if req.Unsplit {
+		sets = append(sets, "split = NULL")
+	}
+	if len(sets) == 0 {
+		return &EditResponse{
+			Success: false,
+			Error:   errors.New("no fields to change"),
+		}
+	}
+
+	txn, err := db.Begin()
+	if err != nil {
+		return &EditResponse{
+			Success: false,
+			Error:   fmt.Errorf("error starting transaction: %w", err),
+		}
+	}
+	defer txn.Rollback()
+
+	result, err := txn.Exec("UPDATE expenses SET "+strings.Join(sets, ", ")+" WHERE id = ?", append(args, req.Id)...)
+	if err != nil {
+		if constraintError(err) == errForeignKey {
+			var category, paidBy string
+			if req.Category != nil {
+				category = *req.Category
+			}
+			if req.PaidBy != nil {
+				paidBy = *req.PaidBy
+			}
+			err = referenceError(db, category, paidBy)
+		}
+		return &EditResponse{
+			Success: false,
+			Error:   fmt.Errorf("error editing expense in 'expenses' table: %w", err),
+		}
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return &EditResponse{
+			Success: false,
+			Error:   fmt.Errorf("error editing expense in 'expenses' table: %w", err),
+		}
+	}
+	if affected == 0 {
+		return &EditResponse{
+			Success: false,
+			Error:   errorOf(ErrNotFound, "no expense with ID %d found", req.Id),
+		}
+	}
+
+	if err := resplit(txn, req); err != nil {
+		return &EditResponse{
+			Success: false,
+			Error:   fmt.Errorf("error splitting expense: %w", err),
+		}
+	}
+	if err := txn.Commit(); err != nil {
+		return &EditResponse{
+			Success: false,
+			Error:   fmt.Errorf("error committing transaction: %w", err),
+		}
+	}
+	return &EditResponse{Success: true}
+}
+
+// resplit updates the shares of an edited expense: replacing them with a new split, deleting them if it is no longer
+// split, or dividing a new amount between the participants of its split.
+func resplit(txn *sql.Tx, req *EditRequest) error {
+	if req.Unsplit {
+		_, err := txn.Exec("DELETE FROM expense_shares WHERE expense_id = ?", req.Id)
+		return err
+	}
+	split := req.Split
+	if split == nil && req.Amount != nil {
+		var err error
+		if split, err = loadSplit(txn, req.Id); err != nil {
+			return err
+		}
+	}
+	if split == nil {
+		return nil
+	}
+
+	var amount int64
+	if err := txn.QueryRow("SELECT amt FROM expenses WHERE id = ?", req.Id).Scan(&amount); err != nil {
+		return fmt.Errorf("error retrieving amount: %w", err)
+	}
+	return saveShares(txn, req.Id, split, amount)
+}
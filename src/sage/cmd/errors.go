@@ -0,0 +1,94 @@
+package cmd
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// Kinds of errors returned by operations, which callers can test for with errors.Is to report them appropriately
+var (
+	// ErrNotFound is returned when the expense, category or subscription an operation acts on doesn't exist
+	ErrNotFound = errors.New("not found")
+	// ErrConflict is returned when an operation would create something that already exists
+	ErrConflict = errors.New("conflict")
+	// ErrUnknownCategory is returned when an expense is given a category that doesn't exist
+	ErrUnknownCategory = errors.New("unknown category")
+	// ErrUnknownUser is returned when an expense or token is attributed to a user that doesn't exist
+	ErrUnknownUser = errors.New("unknown user")
+	// ErrInvalid is returned when the fields of an operation don't agree, such as the shares of an expense not adding up
+	// to its amount
+	ErrInvalid = errors.New("invalid")
+	// ErrUnauthorized is returned when a password, token or session doesn't authenticate anyone
+	ErrUnauthorized = errors.New("unauthorized")
+)
+
+// kindError is an error whose message describes a specific failure of one of the kinds above.
+type kindError struct {
+	kind    error
+	message string
+}
+
+func (e *kindError) Error() string {
+	return e.message
+}
+
+func (e *kindError) Is(target error) bool {
+	return target == e.kind
+}
+
+// errorOf returns an error of the given kind with a formatted message.
+func errorOf(kind error, format string, a ...any) error {
+	return &kindError{kind: kind, message: fmt.Sprintf(format, a...)}
+}
+
+// errForeignKey is returned by constraintError for a failed foreign key constraint. Callers look up which of the names
+// they gave doesn't exist, with referenceError or unknownUser, to report the right kind of error.
+var errForeignKey = errors.New("foreign key constraint failed")
+
+// constraintError returns the kind of error for a failed constraint: a unique constraint is a conflict, and a foreign
+// key constraint is errForeignKey. Returns nil for any other error.
+func constraintError(err error) error {
+	var sqliteErr sqlite3.Error
+	if !errors.As(err, &sqliteErr) {
+		return nil
+	}
+	switch sqliteErr.ExtendedCode {
+	case sqlite3.ErrConstraintUnique, sqlite3.ErrConstraintPrimaryKey:
+		return ErrConflict
+	case sqlite3.ErrConstraintForeignKey:
+		return errForeignKey
+	}
+	return nil
+}
+
+// rowQuerier is a database or transaction to look up names in
+type rowQuerier interface {
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// referenceError returns the error for a failed foreign key constraint of an expense, which is an unknown user if one
+// of the given users doesn't exist, or else an unknown category.
+func referenceError(db *sql.DB, category string, users ...string) error {
+	if err := unknownUser(db, users...); err != nil {
+		return err
+	}
+	return errorOf(ErrUnknownCategory, "category '%s' does not exist", category)
+}
+
+// unknownUser returns an ErrUnknownUser error for the first of the given users that doesn't exist, ignoring empty
+// names, or nil if they all do.
+func unknownUser(q rowQuerier, users ...string) error {
+	for _, user := range users {
+		if user == "" {
+			continue
+		}
+		var exists bool
+		if err := q.QueryRow("SELECT COUNT(*) > 0 FROM users WHERE name = ?", user).Scan(&exists); err == nil && !exists {
+			return errorOf(ErrUnknownUser, "user '%s' does not exist", user)
+		}
+	}
+	return nil
+}
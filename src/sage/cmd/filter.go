@@ -0,0 +1,159 @@
+package cmd
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"cloud.google.com/go/civil"
+	"github.com/Rhymond/go-money"
+)
+
+// ExpenseFilter holds the conditions an expense must meet to be included in a request. It is shared by the log and
+// count requests so that both agree on which expenses match. Who only includes the expenses paid by that user.
+type ExpenseFilter struct {
+	Start             civil.Date
+	End               civil.Date
+	Year              int
+	Month             int
+	Query             string
+	MinAmount         *money.Money
+	MaxAmount         *money.Money
+	Categories        []string
+	ExcludeCategories []string
+	Uncategorized     bool
+	Location          string
+	Who               string
+}
+
+// FilterArgs holds the unparsed amount, category, location, and user filters accepted by log requests. Amounts are in
+// dollars.
+type FilterArgs struct {
+	MinAmount         string
+	MaxAmount         string
+	Categories        []string
+	ExcludeCategories []string
+	Uncategorized     bool
+	Location          string
+	Who               string
+}
+
+// whereClause builds the WHERE clause (including the leading keyword) for the filter, along with the arguments for
+// its placeholders. Returns an empty string if the filter matches every expense.
+func (f *ExpenseFilter) whereClause() (string, []any) {
+	var conds []string
+	var args []any
+
+	if !f.Start.IsZero() {
+		conds = append(conds, "date_spent >= ?")
+		args = append(args, f.Start.String())
+	}
+	if !f.End.IsZero() {
+		conds = append(conds, "date_spent <= ?")
+		args = append(args, f.End.String())
+	}
+	if f.Year != 0 {
+		conds = append(conds, "CAST(strftime('%Y', date_spent) AS INTEGER) = ?")
+		args = append(args, f.Year)
+	}
+	if f.Month != 0 {
+		conds = append(conds, "CAST(strftime('%m', date_spent) AS INTEGER) = ?")
+		args = append(args, f.Month)
+	}
+	if f.Query != "" {
+		conds = append(conds, "(date_spent LIKE ? OR location LIKE ? OR description LIKE ? OR amt LIKE ?)")
+		pattern := "%" + f.Query + "%"
+		args = append(args, pattern, pattern, pattern, pattern)
+	}
+	if f.MinAmount != nil {
+		conds = append(conds, "amt >= ?")
+		args = append(args, f.MinAmount.Amount())
+	}
+	if f.MaxAmount != nil {
+		conds = append(conds, "amt <= ?")
+		args = append(args, f.MaxAmount.Amount())
+	}
+	if len(f.Categories) != 0 {
+		cond := "category IN (" + placeholders(len(f.Categories)) + ")"
+		if f.Uncategorized {
+			cond += " OR category IS NULL OR category = ''"
+		}
+		conds = append(conds, "("+cond+")")
+		for _, category := range f.Categories {
+			args = append(args, category)
+		}
+	} else if f.Uncategorized {
+		conds = append(conds, "(category IS NULL OR category = '')")
+	}
+	if len(f.ExcludeCategories) != 0 {
+		conds = append(conds, "(category IS NULL OR category NOT IN ("+placeholders(len(f.ExcludeCategories))+"))")
+		for _, category := range f.ExcludeCategories {
+			args = append(args, category)
+		}
+	}
+	if f.Location != "" {
+		conds = append(conds, "location LIKE ?")
+		args = append(args, "%"+f.Location+"%")
+	}
+	if f.Who != "" {
+		conds = append(conds, "paid_by = ?")
+		args = append(args, f.Who)
+	}
+
+	if len(conds) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(conds, " AND "), args
+}
+
+// parseFilterArgs validates the given FilterArgs and sets the corresponding fields of filter.
+func parseFilterArgs(filterArgs FilterArgs, filter *ExpenseFilter) error {
+	var err error
+
+	if filterArgs.MinAmount != "" {
+		filter.MinAmount, err = parseAmount(filterArgs.MinAmount)
+		if err != nil {
+			return errors.New("error parsing minimum amount: " + err.Error())
+		}
+	}
+	if filterArgs.MaxAmount != "" {
+		filter.MaxAmount, err = parseAmount(filterArgs.MaxAmount)
+		if err != nil {
+			return errors.New("error parsing maximum amount: " + err.Error())
+		}
+	}
+	if filter.MinAmount != nil && filter.MaxAmount != nil {
+		if filter.MinAmount.Amount() > filter.MaxAmount.Amount() {
+			return errors.New("minimum amount is greater than maximum amount")
+		}
+	}
+	for _, category := range filterArgs.Categories {
+		for _, excluded := range filterArgs.ExcludeCategories {
+			if category == excluded {
+				return errors.New("category '" + category + "' cannot be both included and excluded")
+			}
+		}
+	}
+
+	filter.Categories = filterArgs.Categories
+	filter.ExcludeCategories = filterArgs.ExcludeCategories
+	filter.Uncategorized = filterArgs.Uncategorized
+	filter.Location = filterArgs.Location
+	filter.Who = filterArgs.Who
+
+	return nil
+}
+
+// parseAmount parses a dollar amount such as "12.34" into USD.
+func parseAmount(amtStr string) (*money.Money, error) {
+	fl, err := strconv.ParseFloat(amtStr, 64)
+	if err != nil {
+		return nil, err
+	}
+	return money.NewFromFloat(fl, money.USD), nil
+}
+
+// placeholders returns a comma-separated list of n SQL placeholders.
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
@@ -0,0 +1,249 @@
+package cmd
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"sage/src/sage/data"
+	"sage/src/sage/dates"
+	"slices"
+
+	"cloud.google.com/go/civil"
+	"github.com/Rhymond/go-money"
+)
+
+const (
+	DEFAULT_FORECAST_MONTHS = 3
+	DEFAULT_LOOKBACK_MONTHS = 6
+	MAX_FORECAST_MONTHS     = 24
+)
+
+type ForecastRequest struct {
+	Today          civil.Date
+	Months         int
+	LookbackMonths int
+}
+
+type ForecastResponse struct {
+	Success bool
+	Error   error
+	Result  []data.Forecast
+}
+
+// ParseForecastArgs takes a list of args and constructs the appropriate ForecastRequest. months is the number of
+// months to forecast after the current one, where 0 only forecasts the current month. lookbackMonths is the number of
+// months before the current one to base the forecast on, and defaults to 6.
+func ParseForecastArgs(months, lookbackMonths int) (*ForecastRequest, error) {
+	if months < 0 {
+		return nil, errors.New("months must be positive")
+	}
+	if months > MAX_FORECAST_MONTHS {
+		return nil, fmt.Errorf("months must be at most %d", MAX_FORECAST_MONTHS)
+	}
+	if lookbackMonths < 0 {
+		return nil, errors.New("lookback months must be positive")
+	}
+	if lookbackMonths == 0 {
+		lookbackMonths = DEFAULT_LOOKBACK_MONTHS
+	}
+
+	return &ForecastRequest{
+		Today:          dates.Today(),
+		Months:         months,
+		LookbackMonths: lookbackMonths,
+	}, nil
+}
+
+// categoryRate is the daily spending rate of a category, excluding recurring charges, along with its standard
+// deviation across months.
+type categoryRate struct {
+	daily     float64
+	deviation float64
+}
+
+// ForecastExpenses projects the spending of the current month and the following months of the request, by category.
+// Each category's spending is projected from its daily run rate over the lookback months, excluding recurring charges,
+// plus the recurring charges expected to be made. The range is one standard deviation of the monthly run rate either
+// side of the estimate.
+func ForecastExpenses(db *sql.DB, req *ForecastRequest) *ForecastResponse {
+	expenses, err := loadExpenses(db, ExpenseFilter{End: req.Today})
+	if err != nil {
+		return &ForecastResponse{
+			Success: false,
+			Error:   fmt.Errorf("error retrieving expenses: %w", err),
+		}
+	}
+
+	series := detectRecurring(expenses)
+	recurringIds := make(map[int]bool)
+	for _, s := range series {
+		for _, charge := range s.charges {
+			recurringIds[charge.Id] = true
+		}
+	}
+
+	monthStart := civil.Date{Year: req.Today.Year, Month: req.Today.Month, Day: 1}
+	rates := runRates(expenses, recurringIds, addMonths(monthStart, -req.LookbackMonths), monthStart.AddDays(-1))
+
+	// Actual spending so far this month, by category.
+	actuals := make(map[string]int64)
+	for _, expense := range expenses {
+		if !expense.Date.Before(monthStart) {
+			actuals[expense.Category] += expense.Amount.Amount()
+		}
+	}
+
+	// Recurring charges still to come, by month and category. Series that have gone more than one interval without a
+	// charge are assumed to have been cancelled, and charges that are due but not yet made are expected tomorrow.
+	horizon := addMonths(monthStart, req.Months+1).AddDays(-1)
+	upcoming := make(map[civil.Date]map[string]int64)
+	for _, s := range series {
+		last := s.charges[len(s.charges)-1]
+		if req.Today.DaysSince(last.Date) > s.cadence.maxDays {
+			continue
+		}
+		for next := s.cadence.after(last.Date); !next.After(horizon); next = s.cadence.after(next) {
+			date := next
+			if !date.After(req.Today) {
+				date = req.Today.AddDays(1)
+			}
+			month := civil.Date{Year: date.Year, Month: date.Month, Day: 1}
+			if upcoming[month] == nil {
+				upcoming[month] = make(map[string]int64)
+			}
+			upcoming[month][last.Category] += s.amount
+		}
+	}
+
+	forecasts := []data.Forecast{}
+	for i := 0; i <= req.Months; i++ {
+		month := addMonths(monthStart, i)
+		monthEnd := addMonths(month, 1).AddDays(-1)
+		// Only the rest of the current month is left to project.
+		remainingDays := monthEnd.DaysSince(month) + 1
+		if i == 0 {
+			remainingDays = monthEnd.DaysSince(req.Today)
+		}
+
+		categories := make(map[string]bool)
+		for category := range rates {
+			categories[category] = true
+		}
+		for category := range upcoming[month] {
+			categories[category] = true
+		}
+		if i == 0 {
+			for category := range actuals {
+				categories[category] = true
+			}
+		}
+		names := make([]string, 0, len(categories))
+		for category := range categories {
+			names = append(names, category)
+		}
+		slices.Sort(names)
+
+		forecast := data.Forecast{
+			Period:     fmt.Sprintf("%04d-%02d", month.Year, month.Month),
+			Categories: []data.CategoryForecast{},
+		}
+		var total [5]int64
+		for _, category := range names {
+			var actual int64
+			if i == 0 {
+				actual = actuals[category]
+			}
+			recurring := upcoming[month][category]
+			rate := rates[category]
+			days := float64(remainingDays)
+			estimate := actual + recurring + int64(math.Round(rate.daily*days))
+			low := actual + recurring + int64(math.Round(math.Max(rate.daily-rate.deviation, 0)*days))
+			high := actual + recurring + int64(math.Round((rate.daily+rate.deviation)*days))
+
+			forecast.Categories = append(forecast.Categories, data.CategoryForecast{
+				Category:        category,
+				ForecastAmounts: forecastAmounts(actual, recurring, estimate, low, high),
+			})
+			for j, amt := range []int64{actual, recurring, estimate, low, high} {
+				total[j] += amt
+			}
+		}
+		forecast.ForecastAmounts = forecastAmounts(total[0], total[1], total[2], total[3], total[4])
+		forecasts = append(forecasts, forecast)
+	}
+
+	return &ForecastResponse{Success: true, Result: forecasts}
+}
+
+// runRates calculates the daily spending rate of each category between start and end (inclusive), excluding the
+// recurring charges. The range is shortened to begin at the first expense if there is no history before it.
+func runRates(expenses []data.Expense, recurringIds map[int]bool, start, end civil.Date) map[string]categoryRate {
+	rates := make(map[string]categoryRate)
+	if len(expenses) == 0 {
+		return rates
+	}
+	if first := expenses[0].Date; first.After(start) {
+		start = first
+	}
+	if start.After(end) {
+		return rates
+	}
+
+	// Total each month in the range separately, so the run rate's variation between months is known.
+	type monthRange struct {
+		start civil.Date
+		end   civil.Date
+	}
+	var months []monthRange
+	for month := (civil.Date{Year: start.Year, Month: start.Month, Day: 1}); !month.After(end); month = addMonths(month, 1) {
+		r := monthRange{start: month, end: addMonths(month, 1).AddDays(-1)}
+		if r.start.Before(start) {
+			r.start = start
+		}
+		if r.end.After(end) {
+			r.end = end
+		}
+		months = append(months, r)
+	}
+
+	totals := make(map[string][]int64)
+	for _, expense := range expenses {
+		if recurringIds[expense.Id] || expense.Date.Before(start) || expense.Date.After(end) {
+			continue
+		}
+		if totals[expense.Category] == nil {
+			totals[expense.Category] = make([]int64, len(months))
+		}
+		for i, r := range months {
+			if !expense.Date.Before(r.start) && !expense.Date.After(r.end) {
+				totals[expense.Category][i] += expense.Amount.Amount()
+				break
+			}
+		}
+	}
+
+	days := end.DaysSince(start) + 1
+	for category, monthTotals := range totals {
+		var sum int64
+		dailyRates := make([]float64, len(months))
+		for i, r := range months {
+			sum += monthTotals[i]
+			dailyRates[i] = float64(monthTotals[i]) / float64(r.end.DaysSince(r.start)+1)
+		}
+		_, deviation := meanDeviation(dailyRates)
+		rates[category] = categoryRate{daily: float64(sum) / float64(days), deviation: deviation}
+	}
+	return rates
+}
+
+// forecastAmounts builds the ForecastAmounts for the given amounts in cents.
+func forecastAmounts(actual, recurring, estimate, low, high int64) data.ForecastAmounts {
+	return data.ForecastAmounts{
+		Actual:    money.New(actual, money.USD),
+		Recurring: money.New(recurring, money.USD),
+		Estimate:  money.New(estimate, money.USD),
+		Low:       money.New(low, money.USD),
+		High:      money.New(high, money.USD),
+	}
+}
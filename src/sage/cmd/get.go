@@ -0,0 +1,62 @@
+package cmd
+
+import (
+	"database/sql"
+	"fmt"
+	"sage/src/sage/data"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+type GetRequest struct {
+	Id int
+}
+
+type GetResponse struct {
+	Success bool
+	Error   error
+	Result  data.Expense
+}
+
+// GetExpense retrieves a single expense by its ID, along with its shares if it is split
+func GetExpense(db *sql.DB, req *GetRequest) *GetResponse {
+	rows, err := db.Query("SELECT id, "+EXPENSE_COLUMNS+" FROM expenses WHERE id = ?", req.Id)
+	if err != nil {
+		return &GetResponse{
+			Success: false,
+			Error:   fmt.Errorf("error retrieving expense: %w", err),
+		}
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return &GetResponse{
+				Success: false,
+				Error:   fmt.Errorf("error retrieving expense: %w", err),
+			}
+		}
+		return &GetResponse{
+			Success: false,
+			Error:   errorOf(ErrNotFound, "no expense with ID %d found", req.Id),
+		}
+	}
+	expense, err := ScanExpense(rows, true)
+	if err != nil {
+		return &GetResponse{
+			Success: false,
+			Error:   fmt.Errorf("error retrieving expense: %w", err),
+		}
+	}
+	rows.Close()
+	expenses := []data.Expense{expense}
+	if err := LoadShares(db, expenses); err != nil {
+		return &GetResponse{
+			Success: false,
+			Error:   err,
+		}
+	}
+	expense = expenses[0]
+
+	return &GetResponse{Success: true, Result: expense}
+}
@@ -2,63 +2,58 @@ package cmd
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
+	"sage/src/sage/data"
+	"slices"
 	"strings"
+	"time"
 
 	"cloud.google.com/go/civil"
+	"github.com/Rhymond/go-money"
 	_ "github.com/mattn/go-sqlite3"
 )
 
+// EXPENSE_COLUMNS are the columns of an expense read by ScanExpense, which are preceded by its ID if it is shown
+const EXPENSE_COLUMNS = "date_spent, location, description, category, amt, paid_by, created_by, shared, split"
+
 type LogRequest struct {
-	Start    civil.Date
-	End      civil.Date
-	Year     int
-	Month    int
+	ExpenseFilter
 	Limit    int
 	PageSize int
 	Page     int
+	Cursor   string
+	Sort     []SortField
 	ShowId   bool
-	Query    string
 }
 
 type LogResponse struct {
-	Success bool
-	Error   error
-	ShowId  bool
-	Result  *sql.Rows
+	Success    bool
+	Error      error
+	ShowId     bool
+	Result     *sql.Rows
+	NextCursor string
+	PrevCursor string
 }
 
-// LogExpenses retrieves the list of expenses corresponding to the given options and returns the date, location,
-// description, and amount (and optionally the expense ID)
+// LogExpenses retrieves the list of expenses corresponding to the given options and returns their EXPENSE_COLUMNS (and
+// optionally the expense ID), ordered by the request's sort fields or by date if there are none. If a page size is
+// given without a page number, the page starts at the request's cursor (or the first expense if there is none), and the
+// response includes cursors for the adjacent pages.
 func LogExpenses(db *sql.DB, req *LogRequest) *LogResponse {
-	connector := "WHERE"
+	if req.PageSize != 0 && req.Page == 0 {
+		return logExpensePage(db, req)
+	}
+
 	var sb strings.Builder
 	sb.WriteString("SELECT ")
 	if req.ShowId {
 		sb.WriteString("id, ")
 	}
-	sb.WriteString("date_spent, location, description, category, amt FROM expenses")
-	if !req.Start.IsZero() {
-		sb.WriteString(fmt.Sprintf(" %s date_spent >= '%s'", connector, req.Start.String()))
-		connector = "AND"
-	}
-	if !req.End.IsZero() {
-		sb.WriteString(fmt.Sprintf(" %s date_spent <= '%s'", connector, req.End.String()))
-		connector = "AND"
-	}
-	if req.Year != 0 {
-		sb.WriteString(fmt.Sprintf(" %s strftime('%%Y', date_spent) = '%d'", connector, req.Year))
-		connector = "AND"
-	}
-	if req.Month != 0 {
-		sb.WriteString(fmt.Sprintf(" %s strftime('%%m', date_spent) = '%d'", connector, req.Month))
-		connector = "AND"
-	}
-	if req.Query != "" {
-		sb.WriteString(fmt.Sprintf(" %s date_spent LIKE '%%%s%%' OR location LIKE '%%%s%%' OR description LIKE '%%%s%%' OR amt LIKE '%%%s%%'", connector, req.Query, req.Query, req.Query, req.Query))
-		connector = "AND"
-	}
-	sb.WriteString(" ORDER BY date_spent, id")
+	sb.WriteString(EXPENSE_COLUMNS + " FROM expenses")
+	where, args := req.whereClause()
+	sb.WriteString(where)
+	sb.WriteString(orderClause(sortKeys(req.Sort), false))
 	if req.Limit != 0 {
 		sb.WriteString(fmt.Sprintf(" LIMIT %d", req.Limit))
 	}
@@ -70,7 +65,7 @@ func LogExpenses(db *sql.DB, req *LogRequest) *LogResponse {
 	}
 	logQuery := sb.String()
 
-	rows, err := db.Query(logQuery)
+	rows, err := db.Query(logQuery, args...)
 	if err != nil {
 		return &LogResponse{
 			Success: false,
@@ -84,3 +79,160 @@ func LogExpenses(db *sql.DB, req *LogRequest) *LogResponse {
 		ShowId:  req.ShowId,
 	}
 }
+
+// logExpensePage retrieves a page of expenses using keyset pagination. The sort keys of the page are found first, so
+// that the expenses themselves can be selected by key range and the cursors to the adjacent pages can be built.
+func logExpensePage(db *sql.DB, req *LogRequest) *LogResponse {
+	keys := sortKeys(req.Sort)
+	sort := FormatSort(req.Sort)
+
+	var cursor *logCursor
+	if req.Cursor != "" {
+		var err error
+		cursor, err = decodeCursor(req.Cursor)
+		if err != nil {
+			return &LogResponse{Success: false, Error: err}
+		}
+		if cursor.Sort != sort {
+			return &LogResponse{Success: false, Error: errors.New("cursor was created with a different sort order")}
+		}
+		if len(cursor.Values) != len(keys) {
+			return &LogResponse{Success: false, Error: errors.New("invalid cursor")}
+		}
+	}
+
+	// Find the sort keys of the page, plus one extra row to tell whether there is another page in the same direction.
+	where, args := req.whereClause()
+	before := cursor != nil && cursor.Before
+	if cursor != nil {
+		cond, condArgs := keysetCondition(keys, cursor.Values, before)
+		where = andWhere(where, cond)
+		args = append(args, condArgs...)
+	}
+	keyQuery := "SELECT " + keyColumns(keys) + " FROM expenses" + where + orderClause(keys, before) +
+		fmt.Sprintf(" LIMIT %d", req.PageSize+1)
+
+	keyRows, err := db.Query(keyQuery, args...)
+	if err != nil {
+		return &LogResponse{
+			Success: false,
+			Error:   fmt.Errorf("error retrieving expenses: %w", err),
+		}
+	}
+	var pageKeys [][]any
+	for keyRows.Next() {
+		values := make([]any, len(keys))
+		pointers := make([]any, len(keys))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		if err := keyRows.Scan(pointers...); err != nil {
+			keyRows.Close()
+			return &LogResponse{
+				Success: false,
+				Error:   fmt.Errorf("error retrieving expenses: %w", err),
+			}
+		}
+		pageKeys = append(pageKeys, values)
+	}
+	keyRows.Close()
+
+	more := len(pageKeys) > req.PageSize
+	if more {
+		pageKeys = pageKeys[:req.PageSize]
+	}
+	if before {
+		slices.Reverse(pageKeys)
+	}
+
+	resp := &LogResponse{Success: true, ShowId: req.ShowId}
+	if len(pageKeys) != 0 {
+		first := pageKeys[0]
+		last := pageKeys[len(pageKeys)-1]
+		// Expenses in the direction of travel are known to exist from the extra row. Expenses in the other direction
+		// are assumed to exist if a cursor was given, since the cursor was built from one of them.
+		if (before && more) || (!before && cursor != nil) {
+			resp.PrevCursor = encodeCursor(first, true, sort)
+		}
+		if (!before && more) || (before && cursor != nil) {
+			resp.NextCursor = encodeCursor(last, false, sort)
+		}
+	}
+
+	var sb strings.Builder
+	sb.WriteString("SELECT ")
+	if req.ShowId {
+		sb.WriteString("id, ")
+	}
+	sb.WriteString(EXPENSE_COLUMNS + " FROM expenses")
+	where, args = req.whereClause()
+	if len(pageKeys) != 0 {
+		// The page is every matching expense that is neither before its first key nor after its last key.
+		beforeFirst, beforeFirstArgs := keysetCondition(keys, pageKeys[0], true)
+		afterLast, afterLastArgs := keysetCondition(keys, pageKeys[len(pageKeys)-1], false)
+		where = andWhere(where, "NOT "+beforeFirst+" AND NOT "+afterLast)
+		args = append(args, beforeFirstArgs...)
+		args = append(args, afterLastArgs...)
+	} else {
+		where = andWhere(where, "0")
+	}
+	sb.WriteString(where)
+	sb.WriteString(orderClause(keys, false))
+
+	rows, err := db.Query(sb.String(), args...)
+	if err != nil {
+		return &LogResponse{
+			Success: false,
+			Error:   fmt.Errorf("error retrieving expenses: %w", err),
+		}
+	}
+	resp.Result = rows
+
+	return resp
+}
+
+// ScanExpense reads the current row of a LogResponse's result into an Expense. showId must match the ShowId of the
+// response the rows came from.
+func ScanExpense(rows *sql.Rows, showId bool) (data.Expense, error) {
+	var id int
+	var date time.Time
+	var location, description, category, paidBy, createdBy, split sql.NullString
+	var amt money.Amount
+	var shared bool
+
+	dest := []any{&date, &location, &description, &category, &amt, &paidBy, &createdBy, &shared, &split}
+	if showId {
+		dest = append([]any{&id}, dest...)
+	}
+	err := rows.Scan(dest...)
+	if err != nil {
+		return data.Expense{}, err
+	}
+
+	return data.Expense{
+		Id:          id,
+		Date:        civil.DateOf(date),
+		Location:    location.String,
+		Description: description.String,
+		Category:    category.String,
+		Amount:      money.New(amt, money.USD),
+		PaidBy:      paidBy.String,
+		CreatedBy:   createdBy.String,
+		Shared:      shared,
+		Split:       split.String,
+	}, nil
+}
+
+// ScanExpenses reads every remaining row of a LogResponse's result into Expenses. showId must match the ShowId of the
+// response the rows came from.
+func ScanExpenses(rows *sql.Rows, showId bool) ([]data.Expense, error) {
+	var expenses []data.Expense
+	for rows.Next() {
+		expense, err := ScanExpense(rows, showId)
+		if err != nil {
+			return nil, err
+		}
+		expenses = append(expenses, expense)
+	}
+	return expenses, rows.Err()
+}
@@ -0,0 +1,121 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sage/src/sage/data"
+	"sage/src/sage/dates"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/civil"
+)
+
+var (
+	yearPattern    = regexp.MustCompile(`^\d{4}$`)
+	monthPattern   = regexp.MustCompile(`^\d{4}-\d{2}$`)
+	quarterPattern = regexp.MustCompile(`^(\d{4})-[Qq]([1-4])$`)
+)
+
+// ParsePeriod parses a period given as a year ("2026"), month ("2026-10"), quarter ("2026-Q4"), range of dates
+// ("2026-10-01..2026-10-15", where either date may be relative such as "-2w..today"), or named range accepted by
+// dates.ParseRange ("last-quarter").
+func ParsePeriod(periodStr string) (data.Period, error) {
+	switch {
+	case yearPattern.MatchString(periodStr):
+		year, _ := strconv.Atoi(periodStr)
+		return monthsPeriod(civil.Date{Year: year, Month: 1, Day: 1}, 12), nil
+	case monthPattern.MatchString(periodStr):
+		start, err := civil.ParseDate(periodStr + "-01")
+		if err != nil {
+			return data.Period{}, errors.New("invalid month: " + periodStr)
+		}
+		return monthsPeriod(start, 1), nil
+	case quarterPattern.MatchString(periodStr):
+		match := quarterPattern.FindStringSubmatch(periodStr)
+		year, _ := strconv.Atoi(match[1])
+		quarter, _ := strconv.Atoi(match[2])
+		return monthsPeriod(civil.Date{Year: year, Month: time.Month(3*quarter - 2), Day: 1}, 3), nil
+	case strings.Contains(periodStr, ".."):
+		startStr, endStr, _ := strings.Cut(periodStr, "..")
+		start, err := dates.Parse(startStr)
+		if err != nil {
+			return data.Period{}, errors.New("error parsing period start date: " + err.Error())
+		}
+		end, err := dates.Parse(endStr)
+		if err != nil {
+			return data.Period{}, errors.New("error parsing period end date: " + err.Error())
+		}
+		if start.After(end) {
+			return data.Period{}, errors.New("period start date is after end date")
+		}
+		return data.Period{Label: periodStr, Start: start, End: end}, nil
+	}
+	if start, end, err := dates.ParseRange(periodStr); err == nil {
+		period := data.Period{Label: periodStr, Start: start, End: end}
+		if months := wholeMonths(period); months != 0 {
+			return monthsPeriod(start, months), nil
+		}
+		return period, nil
+	}
+	return data.Period{}, errors.New("invalid period '" + periodStr + "', must be a year, month, quarter, date range, or named range such as last-month")
+}
+
+// monthsPeriod returns the period of the given number of whole months from start, which must be the first day of a
+// month, labelled the way ParsePeriod accepts it.
+func monthsPeriod(start civil.Date, months int) data.Period {
+	end := addMonths(start, months).AddDays(-1)
+
+	var label string
+	switch {
+	case months == 12 && start.Month == 1:
+		label = fmt.Sprintf("%04d", start.Year)
+	case months == 3 && start.Month%3 == 1:
+		label = fmt.Sprintf("%04d-Q%d", start.Year, (start.Month+2)/3)
+	case months == 1:
+		label = fmt.Sprintf("%04d-%02d", start.Year, start.Month)
+	default:
+		label = start.String() + ".." + end.String()
+	}
+	return data.Period{Label: label, Start: start, End: end}
+}
+
+// wholeMonths returns the number of whole months a period spans, or 0 if it doesn't start on the first day of a
+// month and end on the last day of one.
+func wholeMonths(period data.Period) int {
+	if period.Start.Day != 1 || period.End.AddDays(1).Day != 1 {
+		return 0
+	}
+	next := period.End.AddDays(1)
+	return (next.Year-period.Start.Year)*12 + int(next.Month) - int(period.Start.Month)
+}
+
+// shiftPeriod returns the period of the same length that starts the given number of periods later (or earlier if n
+// is negative). Periods of whole months are shifted by months, and other periods by days.
+func shiftPeriod(period data.Period, n int) data.Period {
+	if months := wholeMonths(period); months != 0 {
+		return monthsPeriod(addMonths(period.Start, n*months), months)
+	}
+	days := period.End.DaysSince(period.Start) + 1
+	start := period.Start.AddDays(n * days)
+	end := period.End.AddDays(n * days)
+	return data.Period{Label: start.String() + ".." + end.String(), Start: start, End: end}
+}
+
+// yearBefore returns the same period one year earlier.
+func yearBefore(period data.Period) data.Period {
+	if months := wholeMonths(period); months != 0 {
+		return monthsPeriod(addMonths(period.Start, -12), months)
+	}
+	start := addMonths(period.Start, -12)
+	end := addMonths(period.End, -12)
+	return data.Period{Label: start.String() + ".." + end.String(), Start: start, End: end}
+}
+
+// addMonths returns the date the given number of months after d. Days past the end of the resulting month roll over
+// into the next month.
+func addMonths(d civil.Date, n int) civil.Date {
+	return civil.DateOf(time.Date(d.Year, d.Month+time.Month(n), d.Day, 0, 0, 0, 0, time.UTC))
+}
@@ -0,0 +1,136 @@
+package cmd
+
+import (
+	"database/sql"
+	"sage/src/sage/data"
+	"slices"
+	"strings"
+
+	"cloud.google.com/go/civil"
+)
+
+const (
+	// RECURRING_AMOUNT_TOLERANCE is how far, as a fraction of the typical amount, a charge can be from the typical
+	// amount of a recurring series and still belong to it.
+	RECURRING_AMOUNT_TOLERANCE = 0.2
+	// RECURRING_MATCHING_INTERVALS is the fraction of the intervals between charges that must match the cadence.
+	RECURRING_MATCHING_INTERVALS = 0.75
+)
+
+// cadence is a regular interval that recurring charges can be made at. Intervals between charges of minDays to maxDays
+// (inclusive) match the cadence, and a series needs at least minCharges charges to be detected.
+type cadence struct {
+	name       string
+	minDays    int
+	maxDays    int
+	minCharges int
+	days       int
+	months     int
+}
+
+// cadences are the intervals recurring charges are detected at.
+var cadences = []cadence{
+	{name: "weekly", minDays: 5, maxDays: 9, minCharges: 3, days: 7},
+	{name: "monthly", minDays: 26, maxDays: 35, minCharges: 2, months: 1},
+	{name: "annual", minDays: 350, maxDays: 380, minCharges: 2, months: 12},
+}
+
+// after returns the date one interval of the cadence after d.
+func (c cadence) after(d civil.Date) civil.Date {
+	if c.months != 0 {
+		return addMonths(d, c.months)
+	}
+	return d.AddDays(c.days)
+}
+
+// perYear returns the number of charges the cadence makes in a year.
+func (c cadence) perYear() float64 {
+	if c.months != 0 {
+		return 12 / float64(c.months)
+	}
+	return 365.25 / float64(c.days)
+}
+
+// recurringSeries is a set of charges at the same location for similar amounts made at a regular cadence.
+type recurringSeries struct {
+	location string
+	cadence  cadence
+	amount   int64
+	charges  []data.Expense
+}
+
+// detectRecurring finds the recurring series among the given expenses, which must be sorted by date. Locations are
+// compared case-insensitively, and expenses without a location are ignored.
+func detectRecurring(expenses []data.Expense) []recurringSeries {
+	byLocation := make(map[string][]data.Expense)
+	var locations []string
+	for _, expense := range expenses {
+		key := strings.ToLower(strings.TrimSpace(expense.Location))
+		if key == "" {
+			continue
+		}
+		if _, ok := byLocation[key]; !ok {
+			locations = append(locations, key)
+		}
+		byLocation[key] = append(byLocation[key], expense)
+	}
+
+	var series []recurringSeries
+	for _, location := range locations {
+		charges := byLocation[location]
+		if len(charges) < 2 {
+			continue
+		}
+
+		// Only keep the charges close to the typical amount, so one-off purchases at the same place don't break up
+		// the series.
+		amounts := make([]int64, len(charges))
+		for i, charge := range charges {
+			amounts[i] = charge.Amount.Amount()
+		}
+		slices.Sort(amounts)
+		typical := amounts[len(amounts)/2]
+		tolerance := int64(float64(abs(typical)) * RECURRING_AMOUNT_TOLERANCE)
+		var similar []data.Expense
+		for _, charge := range charges {
+			if abs(charge.Amount.Amount()-typical) <= tolerance {
+				similar = append(similar, charge)
+			}
+		}
+
+		for _, c := range cadences {
+			if len(similar) < c.minCharges {
+				continue
+			}
+			matching := 0
+			for i := 1; i < len(similar); i++ {
+				days := similar[i].Date.DaysSince(similar[i-1].Date)
+				if days >= c.minDays && days <= c.maxDays {
+					matching++
+				}
+			}
+			if float64(matching) >= RECURRING_MATCHING_INTERVALS*float64(len(similar)-1) {
+				series = append(series, recurringSeries{
+					location: similar[len(similar)-1].Location,
+					cadence:  c,
+					amount:   typical,
+					charges:  similar,
+				})
+				break
+			}
+		}
+	}
+
+	return series
+}
+
+// loadExpenses retrieves every expense matching the filter, with IDs, ordered by date.
+func loadExpenses(db *sql.DB, filter ExpenseFilter) ([]data.Expense, error) {
+	logResp := LogExpenses(db, &LogRequest{ExpenseFilter: filter, ShowId: true})
+	if !logResp.Success {
+		return nil, logResp.Error
+	}
+	defer logResp.Result.Close()
+
+	return ScanExpenses(logResp.Result, true)
+}
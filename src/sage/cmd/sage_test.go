@@ -1,13 +1,21 @@
 package cmd
 
 import (
+	"database/sql"
 	"database/sql/driver"
+	"errors"
+	"fmt"
 	"sage/src/sage/data"
+	"sage/src/sage/dates"
+	"strings"
 	"testing"
+	"time"
 
 	"cloud.google.com/go/civil"
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/Rhymond/go-money"
+	"github.com/mattn/go-sqlite3"
+	"golang.org/x/crypto/bcrypt"
 	"gotest.tools/v3/assert"
 )
 
@@ -20,7 +28,9 @@ func TestAddExpense(t *testing.T) {
 	}
 	defer db.Close()
 
+	mock.ExpectBegin()
 	mock.ExpectExec("INSERT").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
 
 	addResp := AddExpense(db, &AddRequest{
 		Expense: data.Expense{
@@ -112,3 +122,900 @@ func TestDeleteExpense(t *testing.T) {
 		t.Errorf("there were unfulfilled expectations: %v", err)
 	}
 }
+
+func TestDeleteExpenseNotFound(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Errorf("error creating mock database: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectExec("DELETE").WithArgs(7).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	deleteResp := DeleteExpense(db, &DeleteRequest{Id: 7})
+	assert.Assert(t, !deleteResp.Success)
+	assert.ErrorContains(t, deleteResp.Error, "no expense with ID 7 found")
+	assert.Assert(t, errors.Is(deleteResp.Error, ErrNotFound))
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestGetExpense(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Errorf("error creating mock database: %v", err)
+	}
+	defer db.Close()
+
+	columns := []string{"id", "date_spent", "location", "description", "category", "amt", "paid_by", "created_by", "shared", "split"}
+	mock.ExpectQuery("SELECT id, date_spent, location, description, category, amt, paid_by, created_by, shared, split FROM expenses WHERE id = ?").
+		WithArgs(3).
+		WillReturnRows(sqlmock.NewRows(columns).AddRow(3, time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC), "Market", nil, "food", 1250, "alice", "bob", true, "exact"))
+	mock.ExpectQuery("SELECT expense_id, user_name, amt FROM expense_shares").WithArgs(3).
+		WillReturnRows(sqlmock.NewRows([]string{"expense_id", "user_name", "amt"}).AddRow(3, "alice", 1000).AddRow(3, "bob", 250))
+	mock.ExpectQuery("SELECT").WithArgs(4).WillReturnRows(sqlmock.NewRows(columns))
+
+	getResp := GetExpense(db, &GetRequest{Id: 3})
+	assert.Assert(t, getResp.Success)
+	assert.Equal(t, getResp.Result.Id, 3)
+	assert.Equal(t, getResp.Result.Location, "Market")
+	assert.Equal(t, getResp.Result.Amount.Amount(), int64(1250))
+	assert.Equal(t, getResp.Result.PaidBy, "alice")
+	assert.Equal(t, getResp.Result.CreatedBy, "bob")
+	assert.Assert(t, getResp.Result.Shared)
+	assert.Equal(t, getResp.Result.Split, SPLIT_EXACT)
+	assert.Equal(t, len(getResp.Result.Shares), 2)
+	assert.Equal(t, getResp.Result.Shares[1].User, "bob")
+	assert.Equal(t, getResp.Result.Shares[1].Amount.Amount(), int64(250))
+
+	getResp = GetExpense(db, &GetRequest{Id: 4})
+	assert.Assert(t, !getResp.Success)
+	assert.Assert(t, errors.Is(getResp.Error, ErrNotFound))
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestEditExpense(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Errorf("error creating mock database: %v", err)
+	}
+	defer db.Close()
+
+	category, amount := "", "12.5"
+	editReq, err := ParseEditArgs(3, EditArgs{Category: &category, Amount: &amount})
+	assert.NilError(t, err)
+
+	// an empty category is stored as NULL
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE expenses SET category = \?, amt = \? WHERE id = \?`).WithArgs(nil, 1250, 3).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("SELECT split FROM expenses").WithArgs(3).WillReturnRows(sqlmock.NewRows([]string{"split"}).AddRow(nil))
+	mock.ExpectCommit()
+	editResp := EditExpense(db, editReq)
+	assert.Assert(t, editResp.Success)
+	assert.NilError(t, editResp.Error)
+
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE expenses").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+	editResp = EditExpense(db, editReq)
+	assert.ErrorContains(t, editResp.Error, "no expense with ID 3 found")
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+
+	_, err = ParseEditArgs(3, EditArgs{})
+	assert.ErrorContains(t, err, "at least one field")
+	bogus := "soon"
+	_, err = ParseEditArgs(3, EditArgs{Date: &bogus})
+	assert.ErrorContains(t, err, "error parsing date")
+}
+
+func TestCategoryWithRecurringExpenses(t *testing.T) {
+	// recurring expenses reference their category, so this runs against a real database to check its constraints
+	t.Setenv("HOME", t.TempDir())
+	db, err := ConnectDB(SAGE_TEST_DB_NAME)
+	assert.NilError(t, err)
+	defer db.Close()
+	defer dates.SetClock(func() time.Time { return time.Date(2021, 3, 20, 12, 0, 0, 0, time.UTC) })()
+
+	assert.Assert(t, ExpenseCategory(db, &CategoryRequest{Subcommand: "add", CategoryName: "streaming"}).Success)
+	for _, date := range []string{"2021-01-12", "2021-02-12", "2021-03-12"} {
+		_, err := db.Exec("INSERT INTO expenses (date_spent, location, category, amt) VALUES (?, 'StreamCo', 'streaming', 1599)", date)
+		assert.NilError(t, err)
+	}
+	subReq, err := ParseSubscriptionArgs("adopt", "StreamCo", false)
+	assert.NilError(t, err)
+	subResp := ExpenseSubscriptions(db, subReq)
+	assert.NilError(t, subResp.Error)
+	assert.Equal(t, subResp.Adopted.Category, "streaming")
+	recurringCategory := func() (category sql.NullString) {
+		assert.NilError(t, db.QueryRow("SELECT category FROM recurring_expenses").Scan(&category))
+		return category
+	}
+
+	catResp := ExpenseCategory(db, &CategoryRequest{Subcommand: "edit", CategoryName: "streaming", NewCategoryName: "media",
+		DryRun: true})
+	assert.NilError(t, catResp.Error)
+	assert.Equal(t, catResp.Affected, 3)
+	assert.Equal(t, catResp.AffectedRecurring, 1)
+	catResp = ExpenseCategory(db, &CategoryRequest{Subcommand: "edit", CategoryName: "streaming", NewCategoryName: "media"})
+	assert.NilError(t, catResp.Error)
+	assert.Equal(t, catResp.AffectedRecurring, 1)
+	assert.Equal(t, recurringCategory().String, "media")
+
+	catResp = ExpenseCategory(db, &CategoryRequest{Subcommand: "delete", CategoryName: "media"})
+	assert.NilError(t, catResp.Error)
+	assert.Equal(t, catResp.Affected, 3)
+	assert.Equal(t, catResp.AffectedRecurring, 1)
+	assert.Assert(t, !recurringCategory().Valid)
+}
+
+func TestDeleteCategory(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Errorf("error creating mock database: %v", err)
+	}
+	defer db.Close()
+
+	// a dry run only counts the expenses and recurring expenses, in the same transaction as a delete would be
+	counts := func() {
+		mock.ExpectQuery("SELECT EXISTS").WithArgs("food").WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+		mock.ExpectQuery("SELECT COUNT").WithArgs("food", "food").
+			WillReturnRows(sqlmock.NewRows([]string{"expenses", "recurring"}).AddRow(3, 1))
+	}
+	mock.ExpectBegin()
+	counts()
+	mock.ExpectRollback()
+	mock.ExpectBegin()
+	counts()
+	mock.ExpectQuery("SELECT id FROM expenses").WithArgs("food").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2).AddRow(5).AddRow(9))
+	mock.ExpectExec("UPDATE expenses SET category = NULL").WithArgs("food").WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectExec("UPDATE recurring_expenses SET category = NULL").WithArgs("food").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("DELETE FROM categories").WithArgs("food").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT EXISTS").WithArgs("fun").WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	mock.ExpectRollback()
+
+	catResp := ExpenseCategory(db, &CategoryRequest{Subcommand: "delete", CategoryName: "food", DryRun: true})
+	assert.Assert(t, catResp.Success)
+	assert.Equal(t, catResp.Affected, 3)
+	assert.Equal(t, catResp.AffectedRecurring, 1)
+
+	catResp = ExpenseCategory(db, &CategoryRequest{Subcommand: "delete", CategoryName: "food"})
+	assert.Assert(t, catResp.Success)
+	assert.Equal(t, catResp.Affected, 3)
+	assert.Equal(t, catResp.AffectedRecurring, 1)
+	assert.DeepEqual(t, catResp.ExpenseIds, []int{2, 5, 9})
+
+	catResp = ExpenseCategory(db, &CategoryRequest{Subcommand: "delete", CategoryName: "fun"})
+	assert.Assert(t, !catResp.Success)
+	assert.Assert(t, errors.Is(catResp.Error, ErrNotFound))
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestLogExpensesFilters(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Errorf("error creating mock database: %v", err)
+	}
+	defer db.Close()
+
+	rows := sqlmock.NewRows([]string{"date_spent", "location", "description", "category", "amt", "paid_by", "created_by", "shared"})
+
+	mock.ExpectQuery(`WHERE amt >= \? AND amt <= \? AND \(category IN \(\?, \?\) OR category IS NULL OR category = ''\) AND location LIKE \?`).
+		WithArgs(1000, 5000, "food", "travel", "%Cafe%").
+		WillReturnRows(rows)
+
+	logReq, err := ParseLogArgs("", "", 0, 0, 0, 0, 0, "", "", false, "", FilterArgs{
+		MinAmount:     "10",
+		MaxAmount:     "50.00",
+		Categories:    []string{"food", "travel"},
+		Uncategorized: true,
+		Location:      "Cafe",
+	})
+	assert.NilError(t, err)
+
+	logResp := LogExpenses(db, logReq)
+	assert.Assert(t, logResp.Success)
+	assert.NilError(t, logResp.Error)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestParseLogArgsInvalidFilters(t *testing.T) {
+	_, err := ParseLogArgs("", "", 0, 0, 0, 0, 0, "", "", false, "", FilterArgs{MinAmount: "20", MaxAmount: "10"})
+	assert.Error(t, err, "minimum amount is greater than maximum amount")
+
+	_, err = ParseLogArgs("", "", 0, 0, 0, 0, 0, "", "", false, "", FilterArgs{
+		Categories:        []string{"food"},
+		ExcludeCategories: []string{"food"},
+	})
+	assert.Error(t, err, "category 'food' cannot be both included and excluded")
+}
+
+func TestCountExpenses(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Errorf("error creating mock database: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery(`SELECT COUNT\(\*\) FROM \(WITH RECURSIVE matching AS \(SELECT date_spent, amt FROM expenses WHERE date_spent >= \?\)`).
+		WithArgs("2021-01-01", "2021-01-01", nil).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(25))
+
+	countResp := CountExpenses(db, &CountRequest{
+		Type:          "summary",
+		ExpenseFilter: ExpenseFilter{Start: civil.Date{Year: 2021, Month: 1, Day: 1}},
+		PageSize:      10,
+	})
+	assert.Assert(t, countResp.Success)
+	assert.NilError(t, countResp.Error)
+	assert.Equal(t, countResp.Result, 25)
+	assert.Equal(t, countResp.Pages, 3)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestKeysetCondition(t *testing.T) {
+	keys := []sortKey{{expr: "amt", desc: true}, {expr: "id"}}
+
+	cond, args := keysetCondition(keys, []any{int64(500), int64(3)}, false)
+	assert.Equal(t, cond, "((amt < ?) OR (amt = ? AND id > ?))")
+	assert.DeepEqual(t, args, []any{int64(500), int64(500), int64(3)})
+
+	cursor, err := decodeCursor(encodeCursor([]any{"2021-01-01", int64(3)}, true, ""))
+	assert.NilError(t, err)
+	assert.DeepEqual(t, cursor.Values, []any{"2021-01-01", int64(3)})
+	assert.Assert(t, cursor.Before)
+
+	_, err = decodeCursor("not a cursor")
+	assert.Error(t, err, "invalid cursor")
+}
+
+func TestParseSort(t *testing.T) {
+	sort, err := ParseSort("-amount, date")
+	assert.NilError(t, err)
+	assert.DeepEqual(t, sort, []SortField{{Key: "amount", Desc: true}, {Key: "date"}})
+	assert.Equal(t, FormatSort(sort), "-amount,date")
+	assert.Equal(t, orderClause(sortKeys(sort), false), " ORDER BY amt DESC, date_spent, id")
+
+	_, err = ParseSort("amount,-amount")
+	assert.Error(t, err, "sort key 'amount' given more than once")
+
+	_, err = ParseSort("amt; DROP TABLE expenses")
+	assert.ErrorContains(t, err, "invalid sort key")
+}
+
+func TestCalculateStats(t *testing.T) {
+	stats := calculateStats([]int64{100, 200, 300, 400, 500, 600, 700, 800, 900, 5000})
+	assert.Equal(t, stats.Count, 10)
+	assert.Equal(t, stats.Average.Amount(), int64(950))
+	assert.Equal(t, stats.Median.Amount(), int64(550))
+	assert.Equal(t, stats.Min.Amount(), int64(100))
+	assert.Equal(t, stats.Max.Amount(), int64(5000))
+	assert.Equal(t, stats.P90.Amount(), int64(900))
+
+	stats = calculateStats(nil)
+	assert.Equal(t, stats.Count, 0)
+	assert.Assert(t, stats.Average == nil)
+}
+
+func TestParsePeriod(t *testing.T) {
+	period, err := ParsePeriod("2026-Q4")
+	assert.NilError(t, err)
+	assert.Equal(t, period.Start, civil.Date{Year: 2026, Month: 10, Day: 1})
+	assert.Equal(t, period.End, civil.Date{Year: 2026, Month: 12, Day: 31})
+
+	assert.Equal(t, shiftPeriod(period, -1).Label, "2026-Q3")
+	assert.Equal(t, yearBefore(period).Label, "2025-Q4")
+
+	period, err = ParsePeriod("2024-02")
+	assert.NilError(t, err)
+	assert.Equal(t, period.End, civil.Date{Year: 2024, Month: 2, Day: 29})
+
+	period, err = ParsePeriod("2026-10-01..2026-10-10")
+	assert.NilError(t, err)
+	assert.Equal(t, shiftPeriod(period, -1).Label, "2026-09-21..2026-09-30")
+
+	defer dates.SetClock(func() time.Time { return time.Date(2026, 10, 19, 0, 0, 0, 0, time.Local) })()
+	period, err = ParsePeriod("last-quarter")
+	assert.NilError(t, err)
+	assert.Equal(t, period.Label, "2026-Q3")
+
+	period, err = ParsePeriod("-1w..yesterday")
+	assert.NilError(t, err)
+	assert.Equal(t, period.Label, "-1w..yesterday")
+	assert.Equal(t, period.Start, civil.Date{Year: 2026, Month: 10, Day: 12})
+	assert.Equal(t, period.End, civil.Date{Year: 2026, Month: 10, Day: 18})
+
+	_, err = ParsePeriod("October")
+	assert.ErrorContains(t, err, "invalid period")
+}
+
+func TestParseLogArgsRange(t *testing.T) {
+	defer dates.SetClock(func() time.Time { return time.Date(2026, 10, 19, 0, 0, 0, 0, time.Local) })()
+
+	start, end, err := RangeArgs("last-month", "", "")
+	assert.NilError(t, err)
+	logReq, err := ParseLogArgs(start, end, 0, 0, 0, 0, 0, "", "", false, "", FilterArgs{})
+	assert.NilError(t, err)
+	assert.Equal(t, logReq.Start, civil.Date{Year: 2026, Month: 9, Day: 1})
+	assert.Equal(t, logReq.End, civil.Date{Year: 2026, Month: 9, Day: 30})
+
+	logReq, err = ParseLogArgs("ytd", "yesterday", 0, 0, 0, 0, 0, "", "", false, "", FilterArgs{})
+	assert.NilError(t, err)
+	assert.Equal(t, logReq.Start, civil.Date{Year: 2026, Month: 1, Day: 1})
+	assert.Equal(t, logReq.End, civil.Date{Year: 2026, Month: 10, Day: 18})
+
+	_, _, err = RangeArgs("ytd", "2026-01-01", "")
+	assert.ErrorContains(t, err, "cannot provide range")
+}
+
+func TestParseCompareArgs(t *testing.T) {
+	compareReq, err := ParseCompareArgs("2026-10", "average", 3, 0)
+	assert.NilError(t, err)
+	assert.Equal(t, compareReq.Baseline.Label, "2026-Q3")
+	assert.Equal(t, compareReq.BaselineMonths, 3)
+	assert.Equal(t, compareReq.Movers, DEFAULT_COMPARE_MOVERS)
+
+	_, err = ParseCompareArgs("2026-10", "last-year", 3, 0)
+	assert.Error(t, err, "can only provide months with the average baseline")
+
+	_, err = ParseCompareArgs("2026-10-01..2026-10-15", "average", 0, 0)
+	assert.Error(t, err, "the average baseline needs a current period of whole months")
+}
+
+func TestCompareQuarterAverage(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Errorf("error creating mock database: %v", err)
+	}
+	defer db.Close()
+
+	compareReq, err := ParseCompareArgs("2026-Q3", "average", 12, 0)
+	assert.NilError(t, err)
+	assert.Equal(t, compareReq.Baseline.Label, "2025-07-01..2026-06-30")
+
+	columns := []string{"category_name", "total_spent", "count"}
+	mock.ExpectQuery("SELECT COALESCE").WillReturnRows(sqlmock.NewRows(columns).AddRow("food", 90000, 30))
+	mock.ExpectQuery("SELECT COALESCE").WillReturnRows(sqlmock.NewRows(columns).AddRow("food", 240000, 100))
+
+	// the 12-month average of $200 a month is scaled to $600 for the quarter
+	compareResp := CompareExpenses(db, compareReq)
+	assert.NilError(t, compareResp.Error)
+	assert.Equal(t, compareResp.Result.Total.Baseline.Amount(), int64(60000))
+	assert.Equal(t, compareResp.Result.Total.Change.Amount(), int64(30000))
+	assert.Equal(t, *compareResp.Result.Total.ChangePercent, 50.0)
+}
+
+func TestDetectAnomalies(t *testing.T) {
+	expense := func(date, location, category string, amt int64) data.Expense {
+		d, _ := civil.ParseDate(date)
+		return data.Expense{Date: d, Location: location, Category: category, Amount: money.New(amt, money.USD)}
+	}
+	expenses := []data.Expense{
+		expense("2026-05-03", "Cafe", "food", 450),
+		expense("2026-05-20", "Grocer", "food", 8000),
+		expense("2026-06-02", "Cafe", "food", 500),
+		expense("2026-06-18", "Grocer", "food", 7500),
+		expense("2026-07-05", "Cafe", "food", 475),
+		expense("2026-07-21", "Grocer", "food", 8200),
+		expense("2026-08-15", "StreamCo", "", 1599),
+		expense("2026-09-01", "Cafe", "food", 5000),
+		expense("2026-09-15", "StreamCo", "", 1599),
+		expense("2026-09-22", "Grocer", "food", 9000),
+	}
+	since := civil.Date{Year: 2026, Month: 9, Day: 1}
+	thresholds := anomalySensitivities[DEFAULT_ANOMALY_SENSITIVITY]
+
+	payee := payeeAnomalies(expenses, since, thresholds)
+	assert.Equal(t, len(payee), 1)
+	assert.Equal(t, payee[0].Expense.Location, "Cafe")
+	assert.Equal(t, payee[0].Expected.Amount(), int64(475))
+
+	category := categoryAnomalies(expenses, since, thresholds)
+	assert.Equal(t, len(category), 1)
+	assert.Equal(t, category[0].Category, "food")
+	assert.Equal(t, category[0].Period, "2026-09")
+
+	recurring := recurringAnomalies(expenses, since)
+	assert.Equal(t, len(recurring), 1)
+	assert.Equal(t, recurring[0].Expense.Location, "StreamCo")
+	assert.Assert(t, strings.HasPrefix(recurring[0].Reason, "new monthly charge"))
+}
+
+func TestForecastExpenses(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Errorf("error creating mock database: %v", err)
+	}
+	defer db.Close()
+
+	date := func(s string) time.Time {
+		d, _ := time.Parse("2006-01-02", s)
+		return d
+	}
+	values := [][]driver.Value{
+		{1, date("2026-08-01"), "Market A", "Groceries", "food", 3100, nil, nil, false, nil},
+		{2, date("2026-08-15"), "StreamCo", "Subscription", nil, 1599, nil, nil, false, nil},
+		{3, date("2026-09-10"), "Market B", "Groceries", "food", 3000, nil, nil, false, nil},
+		{4, date("2026-09-15"), "StreamCo", "Subscription", nil, 1599, nil, nil, false, nil},
+		{5, date("2026-10-05"), "Market C", "Groceries", "food", 1000, nil, nil, false, nil},
+	}
+	rows := sqlmock.NewRows([]string{"id", "date_spent", "location", "description", "category", "amt", "paid_by", "created_by", "shared", "split"}).AddRows(values...)
+	mock.ExpectQuery("SELECT id, date_spent").WithArgs("2026-10-10").WillReturnRows(rows)
+
+	forecastResp := ForecastExpenses(db, &ForecastRequest{
+		Today:          civil.Date{Year: 2026, Month: 10, Day: 10},
+		Months:         1,
+		LookbackMonths: 2,
+	})
+	assert.Assert(t, forecastResp.Success)
+	assert.NilError(t, forecastResp.Error)
+	assert.Equal(t, len(forecastResp.Result), 2)
+
+	october := forecastResp.Result[0]
+	assert.Equal(t, october.Period, "2026-10")
+	assert.Equal(t, october.Actual.Amount(), int64(1000))
+	assert.Equal(t, october.Recurring.Amount(), int64(1599))
+	assert.Equal(t, october.Estimate.Amount(), int64(1000+21*100+1599))
+	assert.Equal(t, october.Categories[0].Category, "")
+	assert.Equal(t, october.Categories[1].Category, "food")
+	assert.Equal(t, october.Categories[1].Estimate.Amount(), int64(3100))
+	assert.Equal(t, october.Categories[1].Low.Amount(), int64(3100))
+
+	november := forecastResp.Result[1]
+	assert.Equal(t, november.Period, "2026-11")
+	assert.Equal(t, november.Actual.Amount(), int64(0))
+	assert.Equal(t, november.Estimate.Amount(), int64(30*100+1599))
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestExpenseSubscriptions(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Errorf("error creating mock database: %v", err)
+	}
+	defer db.Close()
+
+	date := func(s string) time.Time {
+		d, _ := time.Parse("2006-01-02", s)
+		return d
+	}
+	values := [][]driver.Value{
+		{1, date("2026-06-03"), "Gym", "Membership", "health", 4000, nil, nil, false, nil},
+		{2, date("2026-07-01"), "Market", "Groceries", "food", 5200, nil, nil, false, nil},
+		{3, date("2026-07-03"), "Gym", "Membership", "health", 4000, nil, nil, false, nil},
+		{4, date("2026-08-03"), "Gym", "Membership", "health", 4000, nil, nil, false, nil},
+		{5, date("2026-08-12"), "StreamCo", "Subscription", nil, 1599, nil, nil, false, nil},
+		{6, date("2026-09-12"), "StreamCo", "Subscription", nil, 1599, nil, nil, false, nil},
+		{7, date("2026-10-12"), "StreamCo", "Subscription", nil, 1649, nil, nil, false, nil},
+	}
+	rows := sqlmock.NewRows([]string{"id", "date_spent", "location", "description", "category", "amt", "paid_by", "created_by", "shared", "split"}).AddRows(values...)
+	mock.ExpectQuery("SELECT id, date_spent").WithArgs("2026-10-19").WillReturnRows(rows)
+	templateRows := sqlmock.NewRows([]string{"id", "location", "description", "category", "amt", "cadence", "next_date"})
+	mock.ExpectQuery("SELECT id, location, description, category, amt, cadence, next_date FROM recurring_expenses").WillReturnRows(templateRows)
+
+	subResp := ExpenseSubscriptions(db, &SubscriptionRequest{
+		Subcommand: "list",
+		All:        true,
+		Today:      civil.Date{Year: 2026, Month: 10, Day: 19},
+	})
+	assert.Assert(t, subResp.Success)
+	assert.NilError(t, subResp.Error)
+	assert.Equal(t, len(subResp.Result), 2)
+
+	// the gym hasn't charged since August, so it sorts first by annual cost but is no longer active
+	gym := subResp.Result[0]
+	assert.Equal(t, gym.Location, "Gym")
+	assert.Equal(t, gym.Cadence, "monthly")
+	assert.Equal(t, gym.AnnualCost.Amount(), int64(48000))
+	assert.Equal(t, gym.NextExpected, civil.Date{Year: 2026, Month: 9, Day: 3})
+	assert.Assert(t, !gym.Active)
+
+	stream := subResp.Result[1]
+	assert.Equal(t, stream.Location, "StreamCo")
+	assert.Equal(t, stream.Charges, 3)
+	assert.Equal(t, stream.LastCharge, civil.Date{Year: 2026, Month: 10, Day: 12})
+	assert.Equal(t, stream.NextExpected, civil.Date{Year: 2026, Month: 11, Day: 12})
+	assert.Assert(t, stream.Active)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestExpenseSuggestions(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Errorf("error creating mock database: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT location FROM expenses").WillReturnRows(sqlmock.NewRows([]string{"location"}).AddRow("Market").AddRow("Landlord"))
+	mock.ExpectQuery("SELECT name FROM categories").WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("food").AddRow("rent"))
+	mock.ExpectQuery("SELECT category FROM expenses").WithArgs("market").WillReturnRows(sqlmock.NewRows([]string{"category"}).AddRow("food"))
+
+	suggestResp := ExpenseSuggestions(db, &SuggestionRequest{Location: "market"})
+	assert.Assert(t, suggestResp.Success)
+	assert.NilError(t, suggestResp.Error)
+	assert.DeepEqual(t, suggestResp.Locations, []string{"Market", "Landlord"})
+	assert.DeepEqual(t, suggestResp.Categories, []string{"food", "rent"})
+	assert.Equal(t, suggestResp.Category, "food")
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestAPITokens(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Errorf("error creating mock database: %v", err)
+	}
+	defer db.Close()
+	defer dates.SetClock(func() time.Time { return time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC) })()
+
+	mock.ExpectExec("INSERT INTO tokens").
+		WithArgs("laptop", "alice", sqlmock.AnyArg(), sqlmock.AnyArg(), "read,write", "2026-10-19T12:00:00Z").
+		WillReturnResult(sqlmock.NewResult(4, 1))
+
+	req, err := ParseTokenArgs("create", "laptop", "read, write,read", "alice", 0)
+	assert.NilError(t, err)
+	tokenResp := APITokens(db, req)
+	assert.Assert(t, tokenResp.Success)
+	assert.Assert(t, strings.HasPrefix(tokenResp.Token, TOKEN_PREFIX))
+	assert.Equal(t, len(tokenResp.Token), len(TOKEN_PREFIX)+2*TOKEN_BYTES)
+	assert.Equal(t, tokenResp.Created.Id, 4)
+	assert.Equal(t, tokenResp.Created.Prefix, tokenResp.Token[:TOKEN_DISPLAY_LENGTH])
+
+	hash := hashToken(tokenResp.Token)
+	assert.Assert(t, hash != tokenResp.Token)
+	mock.ExpectQuery("SELECT user_name, scopes FROM tokens").WithArgs(hash).
+		WillReturnRows(sqlmock.NewRows([]string{"user_name", "scopes"}).AddRow("alice", "read,write"))
+	mock.ExpectExec("UPDATE tokens SET last_used_at").WithArgs("2026-10-19T12:00:00Z", hash).WillReturnResult(sqlmock.NewResult(0, 1))
+	user, scopes, err := AuthenticateToken(db, tokenResp.Token)
+	assert.NilError(t, err)
+	assert.Equal(t, user, "alice")
+	assert.DeepEqual(t, scopes, []string{"read", "write"})
+
+	mock.ExpectQuery("SELECT user_name, scopes FROM tokens").WillReturnRows(sqlmock.NewRows([]string{"user_name", "scopes"}))
+	_, _, err = AuthenticateToken(db, "sage_bogus")
+	assert.Assert(t, errors.Is(err, ErrUnauthorized))
+
+	mock.ExpectExec("DELETE FROM tokens").WithArgs(9).WillReturnResult(sqlmock.NewResult(0, 0))
+	tokenResp = APITokens(db, &TokenRequest{Subcommand: "revoke", Id: 9})
+	assert.Assert(t, errors.Is(tokenResp.Error, ErrNotFound))
+
+	// a foreign key failure is only reported as an unknown user once the user is looked up
+	mock.ExpectExec("INSERT INTO tokens").WillReturnError(sqlite3.Error{Code: sqlite3.ErrConstraint,
+		ExtendedCode: sqlite3.ErrConstraintForeignKey})
+	mock.ExpectQuery("SELECT COUNT").WithArgs("eve").WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	tokenResp = APITokens(db, &TokenRequest{Subcommand: "create", Name: "ci", User: "eve", Scopes: []string{SCOPE_READ}})
+	assert.Assert(t, errors.Is(tokenResp.Error, ErrUnknownUser))
+	assert.Assert(t, !errors.Is(tokenResp.Error, ErrUnknownCategory))
+
+	_, err = ParseTokenArgs("create", "ci", "read,owner", "", 0)
+	assert.Error(t, err, "scope must be read, write or admin, not 'owner'")
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestHasScope(t *testing.T) {
+	assert.Assert(t, HasScope([]string{SCOPE_READ}, SCOPE_READ))
+	assert.Assert(t, !HasScope([]string{SCOPE_READ}, SCOPE_WRITE))
+	assert.Assert(t, HasScope([]string{SCOPE_WRITE}, SCOPE_READ))
+	assert.Assert(t, HasScope([]string{SCOPE_ADMIN}, SCOPE_WRITE))
+	assert.Assert(t, !HasScope([]string{"owner"}, SCOPE_READ))
+	assert.Assert(t, !HasScope(nil, SCOPE_READ))
+}
+
+func TestSettleUp(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Errorf("error creating mock database: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT name FROM users").
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("alice").AddRow("bob").AddRow("carol"))
+	mock.ExpectQuery(`SELECT paid_by, SUM\(amt\) FROM expenses WHERE date_spent >= \? AND shared AND split IS NULL AND paid_by IS NOT NULL GROUP BY paid_by`).
+		WithArgs("2026-10-01").
+		WillReturnRows(sqlmock.NewRows([]string{"paid_by", "sum"}).AddRow("alice", 9000).AddRow("bob", 1001))
+	mock.ExpectQuery(`FROM expense_shares s JOIN expenses e ON e.id = s.expense_id WHERE s.expense_id IN \(SELECT id FROM expenses WHERE date_spent >= \? AND split IS NOT NULL`).
+		WithArgs("2026-10-01").
+		WillReturnRows(sqlmock.NewRows([]string{"paid_by", "user_name", "amt"}).AddRow("alice", "bob", 500).AddRow("alice", "carol", 500))
+	mock.ExpectQuery(`SELECT from_user, to_user, amt FROM settlements WHERE date_paid >= \?`).
+		WithArgs("2026-10-01").
+		WillReturnRows(sqlmock.NewRows([]string{"from_user", "to_user", "amt"}).AddRow("carol", "alice", 1000))
+
+	settleReq, err := ParseSettleUpArgs("2026-10-01", "", 0)
+	assert.NilError(t, err)
+	settleResp := SettleUp(db, settleReq)
+	assert.NilError(t, settleResp.Error)
+	assert.Assert(t, settleResp.Success)
+
+	result := settleResp.Result
+	assert.Equal(t, result.Total.Amount(), int64(11001))
+	// the leftover cent of the expenses shared by everyone goes to the first user
+	alice, bob, carol := result.Balances[0], result.Balances[1], result.Balances[2]
+	assert.Equal(t, alice.Paid.Amount(), int64(10000))
+	assert.Equal(t, alice.Share.Amount(), int64(3334))
+	assert.Equal(t, alice.Settled.Amount(), int64(-1000))
+	assert.Equal(t, alice.Net.Amount(), int64(5666))
+	assert.Equal(t, bob.Share.Amount(), int64(3834))
+	assert.Equal(t, bob.Net.Amount(), int64(-2833))
+	assert.Equal(t, carol.Paid.Amount(), int64(0))
+	assert.Equal(t, carol.Settled.Amount(), int64(1000))
+	assert.Equal(t, carol.Net.Amount(), int64(-2833))
+
+	assert.Equal(t, len(result.Transfers), 2)
+	assert.Equal(t, result.Transfers[0].From, "bob")
+	assert.Equal(t, result.Transfers[0].To, "alice")
+	assert.Equal(t, result.Transfers[0].Amount.Amount(), int64(2833))
+	assert.Equal(t, result.Transfers[1].From, "carol")
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestDeleteUserKeepsBalances(t *testing.T) {
+	// shares and settlements reference their users, so this runs against a real database to check its constraints
+	t.Setenv("HOME", t.TempDir())
+	db, err := ConnectDB(SAGE_TEST_DB_NAME)
+	assert.NilError(t, err)
+	defer db.Close()
+
+	for _, name := range []string{"alice", "bob", "carol", "dan"} {
+		assert.Assert(t, Users(db, &UserRequest{Subcommand: "add", Name: name}).Success)
+	}
+	split, err := ParseSplit(SPLIT_EQUAL, []string{"alice", "bob"})
+	assert.NilError(t, err)
+	addReq, err := ParseAddArgs("2026-10-01", "Market", "", "", "30")
+	assert.NilError(t, err)
+	addReq.Expense.PaidBy = "alice"
+	addReq.Expense.Shared = true
+	addReq.Split = split
+	assert.NilError(t, AddExpense(db, addReq).Error)
+	settlementReq, err := ParseSettlementArgs("carol", "alice", "10", "2026-10-02")
+	assert.NilError(t, err)
+	assert.NilError(t, Settlements(db, settlementReq).Error)
+
+	aliceBalance := func() data.Balance {
+		settleReq, err := ParseSettleUpArgs("", "", 0)
+		assert.NilError(t, err)
+		settleResp := SettleUp(db, settleReq)
+		assert.NilError(t, settleResp.Error)
+		for _, balance := range settleResp.Result.Balances {
+			if balance.User == "alice" {
+				return balance
+			}
+		}
+		t.Fatal("alice has no balance")
+		return data.Balance{}
+	}
+	before := aliceBalance()
+
+	userResp := Users(db, &UserRequest{Subcommand: "delete", Name: "bob"})
+	assert.Assert(t, errors.Is(userResp.Error, ErrConflict))
+	assert.Error(t, userResp.Error, "user 'bob' has shares in 1 expenses and 0 settlements, so cannot be deleted")
+	userResp = Users(db, &UserRequest{Subcommand: "delete", Name: "carol"})
+	assert.Assert(t, errors.Is(userResp.Error, ErrConflict))
+	assert.NilError(t, Users(db, &UserRequest{Subcommand: "delete", Name: "dan"}).Error)
+
+	after := aliceBalance()
+	assert.Equal(t, after.Share.Amount(), before.Share.Amount())
+	assert.Equal(t, after.Settled.Amount(), before.Settled.Amount())
+	assert.Equal(t, after.Net.Amount(), before.Net.Amount())
+}
+
+func TestUsers(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Errorf("error creating mock database: %v", err)
+	}
+	defer db.Close()
+
+	// deleting a user unattributes the expenses they paid for or recorded
+	historyRows := func(shares, settlements int) *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"shares", "settlements"}).AddRow(shares, settlements)
+	}
+	mock.ExpectBegin()
+	mock.ExpectQuery("FROM expense_shares").WithArgs("bob", "bob", "bob").WillReturnRows(historyRows(0, 0))
+	mock.ExpectExec("UPDATE expenses SET paid_by = NULL").WithArgs("bob").WillReturnResult(sqlmock.NewResult(0, 4))
+	mock.ExpectExec("UPDATE expenses SET created_by = NULL").WithArgs("bob").WillReturnResult(sqlmock.NewResult(0, 6))
+	mock.ExpectExec("DELETE FROM users").WithArgs("bob").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	req, err := ParseUserArgs("delete", " bob ")
+	assert.NilError(t, err)
+	userResp := Users(db, req)
+	assert.Assert(t, userResp.Success)
+	assert.Equal(t, userResp.Affected, 4)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("FROM expense_shares").WithArgs("eve", "eve", "eve").WillReturnRows(historyRows(0, 0))
+	mock.ExpectExec("UPDATE expenses SET paid_by = NULL").WithArgs("eve").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("UPDATE expenses SET created_by = NULL").WithArgs("eve").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("DELETE FROM users").WithArgs("eve").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+	userResp = Users(db, &UserRequest{Subcommand: "delete", Name: "eve"})
+	assert.Assert(t, errors.Is(userResp.Error, ErrNotFound))
+
+	// a user with a share in an expense or a settlement keeps their financial history
+	mock.ExpectBegin()
+	mock.ExpectQuery("FROM expense_shares").WithArgs("dan", "dan", "dan").WillReturnRows(historyRows(2, 1))
+	mock.ExpectRollback()
+	userResp = Users(db, &UserRequest{Subcommand: "delete", Name: "dan"})
+	assert.Assert(t, errors.Is(userResp.Error, ErrConflict))
+	assert.Error(t, userResp.Error, "user 'dan' has shares in 2 expenses and 1 settlements, so cannot be deleted")
+
+	// an empty password leaves the user unable to log in
+	mock.ExpectExec("INSERT INTO users").WithArgs("carol", nil).WillReturnResult(sqlmock.NewResult(3, 1))
+	userResp = Users(db, &UserRequest{Subcommand: "add", Name: "carol"})
+	assert.Assert(t, userResp.Success)
+
+	_, err = ParseUserArgs("passwd", "")
+	assert.Error(t, err, "must provide the name of the user")
+	_, err = ParseUserArgs("rename", "bob")
+	assert.Error(t, err, "subcommand must be add, list, delete or passwd")
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestSettleTransfers(t *testing.T) {
+	// paying the largest balances first would take four transfers, since alice and bob cancel out
+	users := []string{"alice", "bob", "carol", "dave", "erin"}
+	transfers := settleTransfers(users, []int64{-700, 700, -400, -400, 800})
+	var got []string
+	for _, transfer := range transfers {
+		got = append(got, fmt.Sprintf("%s %s %d", transfer.From, transfer.To, transfer.Amount.Amount()))
+	}
+	assert.DeepEqual(t, got, []string{"alice bob 700", "carol erin 400", "dave erin 400"})
+
+	assert.DeepEqual(t, settleTransfers(users, make([]int64, 5)), []data.Transfer{})
+}
+
+func TestParseSplit(t *testing.T) {
+	split, err := ParseSplit("", []string{"alice", " bob"})
+	assert.NilError(t, err)
+	assert.Equal(t, split.Method, SPLIT_EQUAL)
+	shares, err := split.Shares(1001)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, shares, []int64{501, 500})
+
+	split, err = ParseSplit(SPLIT_PERCENT, []string{"alice:33.33", "bob:33.33", "carol:33.34"})
+	assert.NilError(t, err)
+	shares, err = split.Shares(10000)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, shares, []int64{3333, 3333, 3334})
+	assert.DeepEqual(t, split.Args(), []string{"alice:33.33", "bob:33.33", "carol:33.34"})
+	// refunds are split the same way
+	shares, err = split.Shares(-101)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, shares, []int64{-34, -34, -33})
+
+	split, err = ParseSplit(SPLIT_EXACT, []string{"alice:12.50", "bob:7.50"})
+	assert.NilError(t, err)
+	_, err = split.Shares(2500)
+	assert.Assert(t, errors.Is(err, ErrInvalid))
+	assert.Error(t, err, "exact shares add up to $20.00, not the amount of $25.00")
+
+	_, err = ParseSplit(SPLIT_PERCENT, []string{"alice:60", "bob:30"})
+	assert.Error(t, err, "percentages must add up to 100, not 90")
+	_, err = ParseSplit(SPLIT_EXACT, []string{"alice:5", "bob"})
+	assert.Error(t, err, "participant 'bob' needs a share, such as bob:12.50")
+	_, err = ParseSplit(SPLIT_EQUAL, []string{"alice", "alice"})
+	assert.Error(t, err, "participant 'alice' is given more than once")
+	_, err = ParseSplit("halves", []string{"alice"})
+	assert.Error(t, err, "split must be equal, percent or exact")
+}
+
+func TestChanges(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Errorf("error creating mock database: %v", err)
+	}
+	defer db.Close()
+
+	expense := data.Expense{Id: 7, Date: civil.Date{Year: 2026, Month: 10, Day: 1}, Location: "Market",
+		Amount: money.New(1250, money.USD)}
+	mock.ExpectExec(`INSERT INTO changes \(type, expense_id, payload, created_at\)`).
+		WithArgs(CHANGE_CREATED, 7, sqlmock.AnyArg(), sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(3, 1))
+	mock.ExpectExec(`DELETE FROM changes WHERE created_at < \?`).WillReturnResult(sqlmock.NewResult(0, 0))
+	change, err := RecordChange(db, CHANGE_CREATED, expense)
+	assert.NilError(t, err)
+	assert.Equal(t, change.Id, int64(3))
+	assert.Equal(t, change.ExpenseId, 7)
+
+	payload := `{"id":7,"date":"2026-10-01","location":"Market","amount":{"amount":1250,"currency":"USD"}}`
+	mock.ExpectQuery(`SELECT id, type, expense_id, payload, created_at FROM changes WHERE id > \? ORDER BY id`).
+		WithArgs(2).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "type", "expense_id", "payload", "created_at"}).
+			AddRow(3, CHANGE_DELETED, 7, payload, change.CreatedAt))
+	changes, err := ChangesSince(db, 2)
+	assert.NilError(t, err)
+	assert.Equal(t, len(changes), 1)
+	assert.Equal(t, changes[0].Type, CHANGE_DELETED)
+	assert.Equal(t, changes[0].Expense.Location, "Market")
+	assert.Equal(t, changes[0].Expense.Amount.Amount(), int64(1250))
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
+
+func TestParseWebhookArgs(t *testing.T) {
+	req, err := ParseWebhookArgs("add", "https://example.com/hook", "", "", 0)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, req.Webhook.Events, WebhookEvents)
+	req, err = ParseWebhookArgs("add", "http://localhost:9000/sage", "expense.deleted, expense.deleted", "s3cret", 0)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, req.Webhook.Events, []string{CHANGE_DELETED})
+	assert.Equal(t, req.Webhook.Secret, "s3cret")
+
+	_, err = ParseWebhookArgs("add", "ftp://example.com", "", "", 0)
+	assert.Error(t, err, "URL must be an http or https address")
+	_, err = ParseWebhookArgs("add", "https://example.com", "budget.exceeded", "", 0)
+	assert.Error(t, err, "event must be one of expense.created, expense.updated, expense.deleted, not 'budget.exceeded'")
+	_, err = ParseWebhookArgs("delete", "", "", "", 0)
+	assert.Error(t, err, "must provide the ID of the webhook to delete")
+	_, err = ParseWebhookArgs("list", "", "expense.created", "", 0)
+	assert.Error(t, err, "can only provide events and a secret when adding a webhook")
+}
+
+func TestSignWebhook(t *testing.T) {
+	// computed with: printf '1700000000.{}' | openssl dgst -sha256 -hmac whsec_test
+	assert.Equal(t, SignWebhook("whsec_test", 1700000000, []byte("{}")),
+		"sha256=35495024f4ef3f94e5a93e22221544c4b75e9a42300cd965ab81cb85cd994e91")
+}
+
+func TestLoginUnknownUser(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Errorf("error creating mock database: %v", err)
+	}
+	defer db.Close()
+
+	// users that don't exist or can't log in cost a bcrypt comparison like a wrong password
+	cost, err := bcrypt.Cost([]byte(dummyPasswordHash))
+	assert.NilError(t, err)
+	assert.Equal(t, cost, bcrypt.DefaultCost)
+
+	mock.ExpectQuery("SELECT password_hash FROM users").WithArgs("eve").WillReturnRows(sqlmock.NewRows([]string{"password_hash"}))
+	mock.ExpectQuery("SELECT password_hash FROM users").WithArgs("bob").
+		WillReturnRows(sqlmock.NewRows([]string{"password_hash"}).AddRow(nil))
+	for _, name := range []string{"eve", "bob"} {
+		_, err = Login(db, name, "hunter2")
+		assert.Assert(t, errors.Is(err, ErrUnauthorized))
+		assert.Error(t, err, "incorrect name or password")
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %v", err)
+	}
+}
@@ -0,0 +1,98 @@
+package cmd
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sage/src/sage/data"
+	"sage/src/sage/dates"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// Prefix of every session token, which tells them apart from personal access tokens
+	SESSION_PREFIX = TOKEN_PREFIX + "session_"
+	// How long a session lasts after logging in
+	SESSION_DURATION = 30 * 24 * time.Hour
+)
+
+// dummyPasswordHash is compared against when logging in as a user that doesn't exist or can't log in, so that the
+// response takes as long as for a wrong password and doesn't reveal which names exist. It has the same cost as the
+// hashes of users' passwords.
+const dummyPasswordHash = "$2a$10$YQQJ4lgSw7.03mS6urhP9u8SpHMcfcE9QzV2YXm4x0aePodCGekg6"
+
+// IsSessionToken reports whether token is a session token rather than a personal access token.
+func IsSessionToken(token string) bool {
+	return strings.HasPrefix(token, SESSION_PREFIX)
+}
+
+// Login checks a user's password and starts a session for them. Returns ErrUnauthorized if the user doesn't exist,
+// can't log in, or gave the wrong password.
+func Login(db *sql.DB, name, password string) (*data.Session, error) {
+	var hash sql.NullString
+	err := db.QueryRow("SELECT password_hash FROM users WHERE name = ?", name).Scan(&hash)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("error querying 'users' table: %w", err)
+	}
+	if !hash.Valid {
+		_ = bcrypt.CompareHashAndPassword([]byte(dummyPasswordHash), []byte(password))
+		return nil, errorOf(ErrUnauthorized, "incorrect name or password")
+	}
+	if bcrypt.CompareHashAndPassword([]byte(hash.String), []byte(password)) != nil {
+		return nil, errorOf(ErrUnauthorized, "incorrect name or password")
+	}
+
+	secret := make([]byte, TOKEN_BYTES)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, fmt.Errorf("error generating session token: %w", err)
+	}
+	now := dates.Now().UTC().Truncate(time.Second)
+	session := &data.Session{
+		Token:   SESSION_PREFIX + hex.EncodeToString(secret),
+		User:    name,
+		Expires: now.Add(SESSION_DURATION),
+	}
+	_, err = db.Exec("INSERT INTO sessions (user_name, hash, created_at, expires_at) VALUES (?, ?, ?, ?)",
+		name, hashToken(session.Token), now.Format(time.RFC3339), session.Expires.Format(time.RFC3339))
+	if err != nil {
+		return nil, fmt.Errorf("error adding session to 'sessions' table: %w", err)
+	}
+	return session, nil
+}
+
+// AuthenticateSession returns the user a session token was issued to. Returns ErrUnauthorized if the session doesn't
+// exist, has been logged out of, or has expired.
+func AuthenticateSession(db *sql.DB, token string) (string, error) {
+	var user string
+	err := db.QueryRow("SELECT user_name FROM sessions WHERE hash = ? AND expires_at > ?",
+		hashToken(token), dates.Now().UTC().Format(time.RFC3339)).Scan(&user)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", errorOf(ErrUnauthorized, "session is invalid or has expired")
+	}
+	if err != nil {
+		return "", fmt.Errorf("error querying 'sessions' table: %w", err)
+	}
+	return user, nil
+}
+
+// Logout ends a session, along with any of the user's sessions that have expired.
+func Logout(db *sql.DB, token string) error {
+	var user string
+	err := db.QueryRow("DELETE FROM sessions WHERE hash = ? RETURNING user_name", hashToken(token)).Scan(&user)
+	if errors.Is(err, sql.ErrNoRows) {
+		return errorOf(ErrNotFound, "no such session")
+	}
+	if err != nil {
+		return fmt.Errorf("error deleting session from 'sessions' table: %w", err)
+	}
+	_, err = db.Exec("DELETE FROM sessions WHERE user_name = ? AND expires_at <= ?", user, dates.Now().UTC().Format(time.RFC3339))
+	if err != nil {
+		return fmt.Errorf("error deleting expired sessions: %w", err)
+	}
+	return nil
+}
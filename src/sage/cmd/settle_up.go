@@ -0,0 +1,328 @@
+package cmd
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"math/bits"
+	"sage/src/sage/data"
+	"sage/src/sage/dates"
+	"slices"
+	"sort"
+	"strings"
+
+	"cloud.google.com/go/civil"
+	"github.com/Rhymond/go-money"
+)
+
+type SettleUpRequest struct {
+	ExpenseFilter
+}
+
+type SettleUpResponse struct {
+	Success bool
+	Error   error
+	Result  *data.SettleUp
+}
+
+// ParseSettleUpArgs takes a list of args and constructs the appropriate SettleUpRequest. The dates are parsed as in
+// ParseSummaryArgs, and every shared expense is included if none are given.
+func ParseSettleUpArgs(startStr, endStr string, year int) (*SettleUpRequest, error) {
+	var err error
+
+	start := civil.Date{}
+	if startStr != "" {
+		start, err = dates.ParseStart(startStr)
+		if err != nil {
+			return nil, errors.New("error parsing start date: " + err.Error())
+		}
+	}
+	end := civil.Date{}
+	if endStr != "" {
+		end, err = dates.ParseEnd(endStr)
+		if err != nil {
+			return nil, errors.New("error parsing end date: " + err.Error())
+		}
+	}
+	if !start.IsZero() && !end.IsZero() && start.After(end) {
+		return nil, errors.New("start date is after end date")
+	}
+
+	return &SettleUpRequest{
+		ExpenseFilter: ExpenseFilter{
+			Start: start,
+			End:   end,
+			Year:  year,
+		},
+	}, nil
+}
+
+// SettleUp works out how much each user owes the others for the shared expenses matching the request. Shared expenses
+// with a payer are divided between their participants if they are split, or else equally between every user, with any
+// leftover cents going to the users first in order of name. Each user's balance is what they paid less their share,
+// adjusted by the settlements paid between the same dates, and the transfers settle every balance.
+func SettleUp(db *sql.DB, req *SettleUpRequest) *SettleUpResponse {
+	users, err := userNames(db)
+	if err != nil {
+		return &SettleUpResponse{
+			Success: false,
+			Error:   err,
+		}
+	}
+	paid := map[string]int64{}
+	owed := map[string]int64{}
+	settled := map[string]int64{}
+	var total int64
+
+	// expenses shared between every user
+	where, args := req.whereClause()
+	where = andWhere(where, "shared AND split IS NULL AND paid_by IS NOT NULL")
+	var equalTotal int64
+	err = eachRow(db, "SELECT paid_by, SUM(amt) FROM expenses"+where+" GROUP BY paid_by", args, func(rows *sql.Rows) error {
+		var user string
+		var amt int64
+		if err := rows.Scan(&user, &amt); err != nil {
+			return err
+		}
+		paid[user] += amt
+		equalTotal += amt
+		return nil
+	})
+	if err != nil {
+		return &SettleUpResponse{
+			Success: false,
+			Error:   fmt.Errorf("error totalling shared expenses: %w", err),
+		}
+	}
+	for i, share := range splitEqually(equalTotal, len(users)) {
+		owed[users[i]] += share
+	}
+	total += equalTotal
+
+	// expenses split between participants, whose payers are credited with the shares of participants that still exist
+	where, args = req.whereClause()
+	where = andWhere(where, "split IS NOT NULL AND paid_by IS NOT NULL")
+	err = eachRow(db, "SELECT e.paid_by, s.user_name, s.amt FROM expense_shares s JOIN expenses e ON e.id = s.expense_id "+
+		"WHERE s.expense_id IN (SELECT id FROM expenses"+where+")", args, func(rows *sql.Rows) error {
+		var payer, user string
+		var amt int64
+		if err := rows.Scan(&payer, &user, &amt); err != nil {
+			return err
+		}
+		paid[payer] += amt
+		owed[user] += amt
+		total += amt
+		return nil
+	})
+	if err != nil {
+		return &SettleUpResponse{
+			Success: false,
+			Error:   fmt.Errorf("error totalling split expenses: %w", err),
+		}
+	}
+
+	where, args = req.settlementWhereClause()
+	err = eachRow(db, "SELECT from_user, to_user, amt FROM settlements"+where, args, func(rows *sql.Rows) error {
+		var from, to string
+		var amt int64
+		if err := rows.Scan(&from, &to, &amt); err != nil {
+			return err
+		}
+		settled[from] += amt
+		settled[to] -= amt
+		return nil
+	})
+	if err != nil {
+		return &SettleUpResponse{
+			Success: false,
+			Error:   fmt.Errorf("error totalling settlements: %w", err),
+		}
+	}
+
+	nets := make([]int64, len(users))
+	result := &data.SettleUp{Total: money.New(total, money.USD), Balances: []data.Balance{}}
+	for i, user := range users {
+		nets[i] = paid[user] - owed[user] + settled[user]
+		result.Balances = append(result.Balances, data.Balance{
+			User:    user,
+			Paid:    money.New(paid[user], money.USD),
+			Share:   money.New(owed[user], money.USD),
+			Settled: money.New(settled[user], money.USD),
+			Net:     money.New(nets[i], money.USD),
+		})
+	}
+	result.Transfers = settleTransfers(users, nets)
+
+	return &SettleUpResponse{
+		Success: true,
+		Result:  result,
+	}
+}
+
+// settlementWhereClause builds the WHERE clause selecting the settlements paid between the request's dates.
+func (req *SettleUpRequest) settlementWhereClause() (string, []any) {
+	var conds []string
+	var args []any
+	if !req.Start.IsZero() {
+		conds = append(conds, "date_paid >= ?")
+		args = append(args, req.Start.String())
+	}
+	if !req.End.IsZero() {
+		conds = append(conds, "date_paid <= ?")
+		args = append(args, req.End.String())
+	}
+	if req.Year != 0 {
+		conds = append(conds, "CAST(strftime('%Y', date_paid) AS INTEGER) = ?")
+		args = append(args, req.Year)
+	}
+	if len(conds) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(conds, " AND "), args
+}
+
+// eachRow runs a query and calls scan for each row of its result.
+func eachRow(db *sql.DB, query string, args []any, scan func(rows *sql.Rows) error) error {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		if err := scan(rows); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// userNames returns the name of every user in order.
+func userNames(db *sql.DB) ([]string, error) {
+	rows, err := db.Query("SELECT name FROM users ORDER BY name")
+	if err != nil {
+		return nil, fmt.Errorf("error querying 'users' table: %w", err)
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("error reading users: %w", err)
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
+
+// splitEqually divides total cents into n shares that differ by at most a cent, with the larger shares first.
+func splitEqually(total int64, n int) []int64 {
+	weights := make([]int64, n)
+	for i := range weights {
+		weights[i] = 1
+	}
+	return allocate(total, weights)
+}
+
+// Largest number of users with a balance whose transfers are minimized exactly. Beyond it, transfers are chosen
+// greedily, which may take a few more.
+const MAX_EXACT_SETTLE_USERS = 16
+
+// settleTransfers returns the fewest transfers that bring every user's net balance to zero. nets must sum to zero.
+// The users are partitioned into as many groups whose balances sum to zero as possible, since a group of n users can
+// always be settled with n-1 transfers and never with fewer. Within each group, the user who owes the most repeatedly
+// pays the user who is owed the most.
+func settleTransfers(users []string, nets []int64) []data.Transfer {
+	var owing []int
+	for i, net := range nets {
+		if net != 0 {
+			owing = append(owing, i)
+		}
+	}
+	transfers := []data.Transfer{}
+	if len(owing) > MAX_EXACT_SETTLE_USERS {
+		return append(transfers, settleGroup(users, nets, owing)...)
+	}
+	for _, group := range zeroSumGroups(nets, owing) {
+		transfers = append(transfers, settleGroup(users, nets, group)...)
+	}
+	return transfers
+}
+
+// zeroSumGroups partitions the users at indices into the most groups whose nets each sum to zero. best[mask] is the
+// most zero-sum groups that the users in mask can be split into when they are removed one at a time, and every mask
+// along the way that sums to zero ends a group.
+func zeroSumGroups(nets []int64, indices []int) [][]int {
+	n := len(indices)
+	full := 1<<n - 1
+	sums := make([]int64, full+1)
+	best := make([]int, full+1)
+	last := make([]int, full+1)
+	for mask := 1; mask <= full; mask++ {
+		low := bits.TrailingZeros(uint(mask))
+		sums[mask] = sums[mask&(mask-1)] + nets[indices[low]]
+		best[mask] = -1
+		for i := 0; i < n; i++ {
+			if mask&(1<<i) != 0 && best[mask^(1<<i)] > best[mask] {
+				best[mask] = best[mask^(1<<i)]
+				last[mask] = i
+			}
+		}
+		if sums[mask] == 0 {
+			best[mask]++
+		}
+	}
+
+	var groups [][]int
+	var group []int
+	for mask := full; mask != 0; {
+		i := last[mask]
+		group = append(group, indices[i])
+		mask ^= 1 << i
+		if sums[mask] == 0 {
+			slices.Sort(group)
+			groups = append(groups, group)
+			group = nil
+		}
+	}
+	// settle the groups in order of the first user in each
+	slices.SortFunc(groups, func(a, b []int) int { return a[0] - b[0] })
+	return groups
+}
+
+// settleGroup returns the transfers that settle the balances of the users at indices, whose nets must sum to zero, by
+// repeatedly having the user who owes the most pay the user who is owed the most.
+func settleGroup(users []string, nets []int64, indices []int) []data.Transfer {
+	type balance struct {
+		user string
+		net  int64
+	}
+	var debtors, creditors []balance
+	for _, i := range indices {
+		if nets[i] < 0 {
+			debtors = append(debtors, balance{users[i], -nets[i]})
+		} else if nets[i] > 0 {
+			creditors = append(creditors, balance{users[i], nets[i]})
+		}
+	}
+	largestFirst := func(b []balance) {
+		sort.SliceStable(b, func(i, j int) bool { return b[i].net > b[j].net })
+	}
+
+	var transfers []data.Transfer
+	for len(debtors) != 0 && len(creditors) != 0 {
+		largestFirst(debtors)
+		largestFirst(creditors)
+		amount := min(debtors[0].net, creditors[0].net)
+		transfers = append(transfers, data.Transfer{From: debtors[0].user, To: creditors[0].user, Amount: money.New(amount, money.USD)})
+		debtors[0].net -= amount
+		creditors[0].net -= amount
+		if debtors[0].net == 0 {
+			debtors = debtors[1:]
+		}
+		if creditors[0].net == 0 {
+			creditors = creditors[1:]
+		}
+	}
+	return transfers
+}
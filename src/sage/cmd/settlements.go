@@ -0,0 +1,129 @@
+package cmd
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sage/src/sage/data"
+	"sage/src/sage/dates"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/civil"
+	"github.com/Rhymond/go-money"
+)
+
+// SettlementRequest records Settlement if Subcommand is "record", or lists every settlement if it is "list".
+type SettlementRequest struct {
+	Subcommand string
+	Settlement data.Settlement
+}
+
+// SettlementResponse holds the ID of a recorded settlement, or the settlements for a list.
+type SettlementResponse struct {
+	Success    bool
+	Error      error
+	Subcommand string
+	Id         int
+	Result     []data.Settlement
+}
+
+// ParseSettlementArgs takes a list of args and constructs the appropriate SettlementRequest for a payment of amtStr
+// dollars from one user to another. dateStr defaults to today.
+func ParseSettlementArgs(from, to, amtStr, dateStr string) (*SettlementRequest, error) {
+	from, to = strings.TrimSpace(from), strings.TrimSpace(to)
+	if from == "" || to == "" {
+		return nil, errors.New("must provide who paid and who was paid")
+	}
+	if from == to {
+		return nil, errors.New("a user can't settle up with themselves")
+	}
+	amt, err := parseAmount(amtStr)
+	if err != nil {
+		return nil, errors.New("error parsing amount: " + err.Error())
+	}
+	if amt.Amount() <= 0 {
+		return nil, errors.New("amount must be positive")
+	}
+	date := dates.Today()
+	if dateStr != "" {
+		if date, err = dates.Parse(dateStr); err != nil {
+			return nil, errors.New("error parsing date: " + err.Error())
+		}
+	}
+
+	return &SettlementRequest{
+		Subcommand: "record",
+		Settlement: data.Settlement{
+			Date:   date,
+			From:   from,
+			To:     to,
+			Amount: amt,
+		},
+	}, nil
+}
+
+// Settlements records a payment made to settle up, or lists every settlement with the most recent first
+func Settlements(db *sql.DB, req *SettlementRequest) *SettlementResponse {
+	if req.Subcommand == "list" {
+		return listSettlements(db)
+	}
+
+	settlement := req.Settlement
+	result, err := db.Exec("INSERT INTO settlements (date_paid, from_user, to_user, amt) VALUES (?, ?, ?, ?)",
+		settlement.Date.String(), settlement.From, settlement.To, settlement.Amount.Amount())
+	if constraintError(err) == errForeignKey {
+		if userErr := unknownUser(db, settlement.From, settlement.To); userErr != nil {
+			err = userErr
+		}
+	}
+	if err != nil {
+		return &SettlementResponse{
+			Success: false,
+			Error:   fmt.Errorf("error adding settlement to 'settlements' table: %w", err),
+		}
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return &SettlementResponse{
+			Success: false,
+			Error:   fmt.Errorf("error retrieving settlement ID: %w", err),
+		}
+	}
+
+	return &SettlementResponse{
+		Success:    true,
+		Subcommand: "record",
+		Id:         int(id),
+	}
+}
+
+// listSettlements retrieves every settlement, most recent first
+func listSettlements(db *sql.DB) *SettlementResponse {
+	settlements := []data.Settlement{}
+	err := eachRow(db, "SELECT id, date_paid, from_user, to_user, amt FROM settlements ORDER BY date_paid DESC, id DESC", nil,
+		func(rows *sql.Rows) error {
+			var settlement data.Settlement
+			var date time.Time
+			var amt int64
+			if err := rows.Scan(&settlement.Id, &date, &settlement.From, &settlement.To, &amt); err != nil {
+				return err
+			}
+			settlement.Date = civil.DateOf(date)
+			settlement.Amount = money.New(amt, money.USD)
+			settlements = append(settlements, settlement)
+			return nil
+		})
+	if err != nil {
+		return &SettlementResponse{
+			Success: false,
+			Error:   fmt.Errorf("error querying 'settlements' table: %w", err),
+		}
+	}
+
+	return &SettlementResponse{
+		Success:    true,
+		Subcommand: "list",
+		Result:     settlements,
+	}
+}
@@ -0,0 +1,91 @@
+package cmd
+
+import (
+	"errors"
+	"slices"
+	"strings"
+)
+
+// SortField is a key that log results can be ordered by. Desc reverses the order of the key.
+type SortField struct {
+	Key  string
+	Desc bool
+}
+
+// sortKey is an expression that log results are ordered by.
+type sortKey struct {
+	expr string
+	desc bool
+}
+
+// sortColumns maps the allowed sort keys to the expressions they order by. Expenses are created in ID order.
+var sortColumns = map[string]string{
+	"date":     "date_spent",
+	"amount":   "amt",
+	"location": "COALESCE(location, '')",
+	"category": "COALESCE(category, '')",
+	"created":  "id",
+}
+
+// defaultSortKeys orders expenses by the date they were spent, oldest first. The ID breaks ties so the order is total.
+var defaultSortKeys = []sortKey{{expr: "date_spent"}, {expr: "id"}}
+
+// ParseSort parses a comma-separated list of sort keys, each optionally prefixed with "-" for descending order, such
+// as "-amount,date". Returns nil for an empty string.
+func ParseSort(sortStr string) ([]SortField, error) {
+	var fields []SortField
+	for _, key := range strings.Split(sortStr, ",") {
+		key = strings.TrimSpace(key)
+		if key == "" {
+			continue
+		}
+
+		field := SortField{Key: strings.TrimPrefix(key, "-"), Desc: strings.HasPrefix(key, "-")}
+		if _, ok := sortColumns[field.Key]; !ok {
+			allowed := make([]string, 0, len(sortColumns))
+			for column := range sortColumns {
+				allowed = append(allowed, column)
+			}
+			slices.Sort(allowed)
+			return nil, errors.New("invalid sort key '" + field.Key + "', must be one of: " + strings.Join(allowed, ", "))
+		}
+		for _, existing := range fields {
+			if existing.Key == field.Key {
+				return nil, errors.New("sort key '" + field.Key + "' given more than once")
+			}
+		}
+		fields = append(fields, field)
+	}
+	return fields, nil
+}
+
+// FormatSort returns the string form of a list of sort fields accepted by ParseSort.
+func FormatSort(fields []SortField) string {
+	var keys []string
+	for _, field := range fields {
+		if field.Desc {
+			keys = append(keys, "-"+field.Key)
+		} else {
+			keys = append(keys, field.Key)
+		}
+	}
+	return strings.Join(keys, ",")
+}
+
+// sortKeys returns the expressions to order by for the given sort fields. The ID is added to break ties in the
+// direction of the last field, unless the fields already order by creation.
+func sortKeys(fields []SortField) []sortKey {
+	if len(fields) == 0 {
+		return defaultSortKeys
+	}
+
+	var keys []sortKey
+	for _, field := range fields {
+		keys = append(keys, sortKey{expr: sortColumns[field.Key], desc: field.Desc})
+	}
+	last := keys[len(keys)-1]
+	if !slices.ContainsFunc(keys, func(key sortKey) bool { return key.expr == "id" }) {
+		keys = append(keys, sortKey{expr: "id", desc: last.desc})
+	}
+	return keys
+}
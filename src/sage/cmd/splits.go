@@ -0,0 +1,251 @@
+package cmd
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"sage/src/sage/data"
+	"strconv"
+	"strings"
+
+	"github.com/Rhymond/go-money"
+)
+
+// Ways of splitting an expense between its participants
+const (
+	SPLIT_EQUAL   = "equal"
+	SPLIT_PERCENT = "percent"
+	SPLIT_EXACT   = "exact"
+)
+
+// Split is how an expense is divided between its participants. Each participant's Weight is 1 for an equal split, their
+// share in hundredths of a percent for a percentage split, or their share in cents for an exact split.
+type Split struct {
+	Method       string
+	Participants []Participant
+}
+
+// Participant is a user that owes part of a split expense.
+type Participant struct {
+	User   string
+	Weight int64
+}
+
+// ParseSplit takes a split method and its participants and constructs the appropriate Split. method defaults to equal.
+// Participants of an equal split are user names, and those of a percentage or exact split are a name and a share
+// separated by a colon, such as "alice:60" or "alice:12.50".
+func ParseSplit(method string, participants []string) (*Split, error) {
+	if method == "" {
+		method = SPLIT_EQUAL
+	}
+	if method != SPLIT_EQUAL && method != SPLIT_PERCENT && method != SPLIT_EXACT {
+		return nil, errors.New("split must be equal, percent or exact")
+	}
+	if len(participants) == 0 {
+		return nil, errors.New("must provide the participants of the split")
+	}
+
+	split := &Split{Method: method}
+	var total int64
+	for _, arg := range participants {
+		name, shareStr, hasShare := strings.Cut(arg, ":")
+		name = strings.TrimSpace(name)
+		if name == "" {
+			return nil, fmt.Errorf("missing name of participant '%s'", arg)
+		}
+		for _, p := range split.Participants {
+			if p.User == name {
+				return nil, fmt.Errorf("participant '%s' is given more than once", name)
+			}
+		}
+		if hasShare == (method == SPLIT_EQUAL) {
+			if hasShare {
+				return nil, fmt.Errorf("participants of an equal split take no share, but got '%s'", arg)
+			}
+			return nil, fmt.Errorf("participant '%s' needs a share, such as %s:%s", name, name, exampleShare(method))
+		}
+
+		weight := int64(1)
+		switch method {
+		case SPLIT_PERCENT:
+			percent, err := strconv.ParseFloat(strings.TrimSpace(shareStr), 64)
+			if err != nil || percent <= 0 {
+				return nil, fmt.Errorf("invalid percentage for '%s': %s", name, shareStr)
+			}
+			weight = int64(math.Round(percent * 100))
+		case SPLIT_EXACT:
+			amount, err := parseAmount(strings.TrimSpace(shareStr))
+			if err != nil {
+				return nil, fmt.Errorf("invalid amount for '%s': %s", name, shareStr)
+			}
+			weight = amount.Amount()
+		}
+		total += weight
+		split.Participants = append(split.Participants, Participant{User: name, Weight: weight})
+	}
+	if method == SPLIT_PERCENT && total != 10000 {
+		return nil, fmt.Errorf("percentages must add up to 100, not %s", formatPercent(total))
+	}
+	return split, nil
+}
+
+// exampleShare returns an example of a participant's share for the given method.
+func exampleShare(method string) string {
+	if method == SPLIT_PERCENT {
+		return "50"
+	}
+	return "12.50"
+}
+
+// formatPercent formats hundredths of a percent without trailing zeros.
+func formatPercent(hundredths int64) string {
+	return strconv.FormatFloat(float64(hundredths)/100, 'f', -1, 64)
+}
+
+// Shares returns the cents each participant owes of an expense of the given amount, in the order of the participants.
+// Equal and percentage splits give any leftover cents to the first participants. Returns ErrInvalid if the shares of
+// an exact split don't add up to the amount.
+func (s *Split) Shares(amount int64) ([]int64, error) {
+	weights := make([]int64, len(s.Participants))
+	var total int64
+	for i, p := range s.Participants {
+		weights[i] = p.Weight
+		total += p.Weight
+	}
+	if s.Method == SPLIT_EXACT {
+		if total != amount {
+			return nil, errorOf(ErrInvalid, "exact shares add up to %s, not the amount of %s",
+				money.New(total, money.USD).Display(), money.New(amount, money.USD).Display())
+		}
+		return weights, nil
+	}
+	return allocate(amount, weights), nil
+}
+
+// Args returns the participants in the form accepted by ParseSplit.
+func (s *Split) Args() []string {
+	args := make([]string, len(s.Participants))
+	for i, p := range s.Participants {
+		switch s.Method {
+		case SPLIT_PERCENT:
+			args[i] = p.User + ":" + formatPercent(p.Weight)
+		case SPLIT_EXACT:
+			args[i] = p.User + ":" + strconv.FormatFloat(money.New(p.Weight, money.USD).AsMajorUnits(), 'f', 2, 64)
+		default:
+			args[i] = p.User
+		}
+	}
+	return args
+}
+
+// allocate divides amount cents in proportion to weights, rounding each part towards zero and then giving the
+// leftover cents one at a time to the first parts.
+func allocate(amount int64, weights []int64) []int64 {
+	var total int64
+	for _, w := range weights {
+		total += w
+	}
+	parts := make([]int64, len(weights))
+	if total == 0 {
+		return parts
+	}
+	left := amount
+	for i, w := range weights {
+		parts[i] = amount * w / total
+		left -= parts[i]
+	}
+	step := int64(1)
+	if left < 0 {
+		step = -1
+	}
+	for i := 0; left != 0; i = (i + 1) % len(parts) {
+		parts[i] += step
+		left -= step
+	}
+	return parts
+}
+
+// saveShares replaces the shares of an expense with those of split, which must add up to amount.
+func saveShares(txn *sql.Tx, id int, split *Split, amount int64) error {
+	shares, err := split.Shares(amount)
+	if err != nil {
+		return err
+	}
+	if _, err := txn.Exec("DELETE FROM expense_shares WHERE expense_id = ?", id); err != nil {
+		return fmt.Errorf("error deleting shares: %w", err)
+	}
+	for i, p := range split.Participants {
+		_, err := txn.Exec("INSERT INTO expense_shares (expense_id, user_name, weight, amt) VALUES (?, ?, ?, ?)",
+			id, p.User, p.Weight, shares[i])
+		if err != nil {
+			if constraintError(err) == errForeignKey {
+				if userErr := unknownUser(txn, p.User); userErr != nil {
+					return userErr
+				}
+			}
+			return fmt.Errorf("error adding shares to 'expense_shares' table: %w", err)
+		}
+	}
+	return nil
+}
+
+// loadSplit returns the split of an expense, or nil if it isn't split between participants.
+func loadSplit(txn *sql.Tx, id int) (*Split, error) {
+	var method sql.NullString
+	err := txn.QueryRow("SELECT split FROM expenses WHERE id = ?", id).Scan(&method)
+	if errors.Is(err, sql.ErrNoRows) || !method.Valid {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving split: %w", err)
+	}
+
+	rows, err := txn.Query("SELECT user_name, weight FROM expense_shares WHERE expense_id = ? ORDER BY rowid", id)
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving shares: %w", err)
+	}
+	defer rows.Close()
+	split := &Split{Method: method.String}
+	for rows.Next() {
+		var p Participant
+		if err := rows.Scan(&p.User, &p.Weight); err != nil {
+			return nil, fmt.Errorf("error reading shares: %w", err)
+		}
+		split.Participants = append(split.Participants, p)
+	}
+	return split, rows.Err()
+}
+
+// LoadShares fills in the shares of the split expenses among expenses, which must have their IDs.
+func LoadShares(db *sql.DB, expenses []data.Expense) error {
+	index := map[int]int{}
+	var ids []any
+	for i, expense := range expenses {
+		if expense.Split != "" {
+			index[expense.Id] = i
+			ids = append(ids, expense.Id)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	rows, err := db.Query("SELECT expense_id, user_name, amt FROM expense_shares WHERE expense_id IN ("+placeholders(len(ids))+") ORDER BY rowid", ids...)
+	if err != nil {
+		return fmt.Errorf("error retrieving shares: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int
+		var share data.Share
+		var amt int64
+		if err := rows.Scan(&id, &share.User, &amt); err != nil {
+			return fmt.Errorf("error reading shares: %w", err)
+		}
+		share.Amount = money.New(amt, money.USD)
+		expense := &expenses[index[id]]
+		expense.Shares = append(expense.Shares, share)
+	}
+	return rows.Err()
+}
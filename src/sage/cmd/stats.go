@@ -0,0 +1,100 @@
+package cmd
+
+import (
+	"database/sql"
+	"math"
+	"sage/src/sage/data"
+
+	"github.com/Rhymond/go-money"
+)
+
+// summaryStats calculates the statistics of every period of a summary request, ignoring its pagination so that the
+// change from the period before the first one on a page is still known.
+func summaryStats(db *sql.DB, req *SummaryRequest) (map[string]*data.SummaryStats, error) {
+	groupQuery, args := req.groupQuery()
+	rows, err := db.Query(groupQuery+" ORDER BY period", args...)
+	if err != nil {
+		return nil, err
+	}
+	var periods []string
+	totals := make(map[string]int64)
+	for rows.Next() {
+		var period string
+		var total int64
+		if err := rows.Scan(&period, &total); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		periods = append(periods, period)
+		totals[period] = total
+	}
+	rows.Close()
+
+	where, args := req.whereClause()
+	rows, err = db.Query("SELECT "+req.periodOf("date_spent")+" AS period, amt FROM expenses"+where+
+		" ORDER BY period, amt", args...)
+	if err != nil {
+		return nil, err
+	}
+	amounts := make(map[string][]int64)
+	for rows.Next() {
+		var period string
+		var amt int64
+		if err := rows.Scan(&period, &amt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		amounts[period] = append(amounts[period], amt)
+	}
+	rows.Close()
+
+	stats := make(map[string]*data.SummaryStats)
+	for i, period := range periods {
+		periodStats := calculateStats(amounts[period])
+		if i > 0 {
+			previous := totals[periods[i-1]]
+			change := totals[period] - previous
+			periodStats.Change = money.New(change, money.USD)
+			if previous != 0 {
+				percent := math.Round(float64(change)/float64(previous)*10000) / 100
+				periodStats.ChangePercent = &percent
+			}
+		}
+		stats[period] = periodStats
+	}
+
+	return stats, nil
+}
+
+// calculateStats returns the count, average, median, minimum, maximum, and 90th percentile of the given amounts,
+// which must be sorted in ascending order. Only the count is set if there are no amounts.
+func calculateStats(amounts []int64) *data.SummaryStats {
+	stats := &data.SummaryStats{Count: len(amounts)}
+	if len(amounts) == 0 {
+		return stats
+	}
+
+	var total int64
+	for _, amt := range amounts {
+		total += amt
+	}
+	n := len(amounts)
+	median := amounts[n/2]
+	if n%2 == 0 {
+		median = roundedDiv(amounts[n/2-1]+amounts[n/2], 2)
+	}
+	// nearest-rank percentile
+	p90 := amounts[int(math.Ceil(0.9*float64(n)))-1]
+
+	stats.Average = money.New(roundedDiv(total, int64(n)), money.USD)
+	stats.Median = money.New(median, money.USD)
+	stats.Min = money.New(amounts[0], money.USD)
+	stats.Max = money.New(amounts[n-1], money.USD)
+	stats.P90 = money.New(p90, money.USD)
+	return stats
+}
+
+// roundedDiv divides a by b, rounding half away from zero.
+func roundedDiv(a, b int64) int64 {
+	return int64(math.Round(float64(a) / float64(b)))
+}
@@ -0,0 +1,215 @@
+package cmd
+
+import (
+	"cmp"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"sage/src/sage/data"
+	"sage/src/sage/dates"
+	"slices"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/civil"
+	"github.com/Rhymond/go-money"
+)
+
+type SubscriptionRequest struct {
+	Subcommand string
+	Location   string
+	All        bool
+	Today      civil.Date
+}
+
+type SubscriptionResponse struct {
+	Success    bool
+	Error      error
+	Subcommand string
+	Result     []data.Subscription
+	Adopted    *data.RecurringExpense
+}
+
+// ParseSubscriptionArgs takes a list of args and constructs the appropriate SubscriptionRequest. subcommand is either
+// "list" or "adopt", and location is the location of the subscription to adopt. all includes subscriptions that are
+// no longer active in the list, and defaults to false.
+func ParseSubscriptionArgs(subcommand, location string, all bool) (*SubscriptionRequest, error) {
+	if subcommand != "list" && subcommand != "adopt" {
+		return nil, errors.New("subcommand must be list or adopt")
+	}
+	if subcommand == "adopt" && location == "" {
+		return nil, errors.New("must provide the location of the subscription to adopt")
+	}
+	if subcommand == "list" && location != "" {
+		return nil, errors.New("cannot provide a location to list")
+	}
+
+	return &SubscriptionRequest{
+		Subcommand: subcommand,
+		Location:   location,
+		All:        all,
+		Today:      dates.Today(),
+	}, nil
+}
+
+// ExpenseSubscriptions lists the subscriptions detected in the expense history, or adopts one as a recurring expense
+func ExpenseSubscriptions(db *sql.DB, req *SubscriptionRequest) *SubscriptionResponse {
+	subscriptions, err := detectSubscriptions(db, req.Today)
+	if err != nil {
+		return &SubscriptionResponse{
+			Success: false,
+			Error:   fmt.Errorf("error detecting subscriptions: %w", err),
+		}
+	}
+
+	if req.Subcommand == "adopt" {
+		return adoptSubscription(db, req, subscriptions)
+	}
+
+	result := []data.Subscription{}
+	for _, subscription := range subscriptions {
+		if subscription.Active || req.All {
+			result = append(result, subscription)
+		}
+	}
+	return &SubscriptionResponse{
+		Success:    true,
+		Subcommand: req.Subcommand,
+		Result:     result,
+	}
+}
+
+// adoptSubscription creates a recurring expense from the detected subscription at the request's location
+func adoptSubscription(db *sql.DB, req *SubscriptionRequest, subscriptions []data.Subscription) *SubscriptionResponse {
+	var subscription *data.Subscription
+	for i := range subscriptions {
+		if strings.EqualFold(subscriptions[i].Location, strings.TrimSpace(req.Location)) {
+			subscription = &subscriptions[i]
+			break
+		}
+	}
+	if subscription == nil {
+		return &SubscriptionResponse{
+			Success: false,
+			Error:   errorOf(ErrNotFound, "no subscription at '%s' found", req.Location),
+		}
+	}
+	if subscription.Adopted {
+		return &SubscriptionResponse{
+			Success: false,
+			Error:   errorOf(ErrConflict, "subscription at '%s' has already been adopted", subscription.Location),
+		}
+	}
+
+	var category any
+	if subscription.Category != "" {
+		category = subscription.Category
+	}
+	result, err := db.Exec("INSERT INTO recurring_expenses (location, description, category, amt, cadence, next_date) VALUES (?, ?, ?, ?, ?, ?)",
+		subscription.Location,
+		subscription.Cadence+" subscription",
+		category,
+		subscription.Amount.Amount(),
+		subscription.Cadence,
+		subscription.NextExpected.String())
+	if err != nil {
+		return &SubscriptionResponse{
+			Success: false,
+			Error:   fmt.Errorf("error adding subscription to 'recurring_expenses' table: %w", err),
+		}
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return &SubscriptionResponse{
+			Success: false,
+			Error:   fmt.Errorf("error retrieving recurring expense ID: %w", err),
+		}
+	}
+
+	return &SubscriptionResponse{
+		Success:    true,
+		Subcommand: req.Subcommand,
+		Adopted: &data.RecurringExpense{
+			Id:          int(id),
+			Location:    subscription.Location,
+			Description: subscription.Cadence + " subscription",
+			Category:    subscription.Category,
+			Amount:      subscription.Amount,
+			Cadence:     subscription.Cadence,
+			NextDate:    subscription.NextExpected,
+		},
+	}
+}
+
+// detectSubscriptions finds the recurring charges in the expense history up to today, largest annual cost first
+func detectSubscriptions(db *sql.DB, today civil.Date) ([]data.Subscription, error) {
+	expenses, err := loadExpenses(db, ExpenseFilter{End: today})
+	if err != nil {
+		return nil, err
+	}
+	templates, err := loadRecurringExpenses(db)
+	if err != nil {
+		return nil, err
+	}
+	adopted := make(map[string]bool)
+	for _, template := range templates {
+		adopted[strings.ToLower(template.Location)] = true
+	}
+
+	var subscriptions []data.Subscription
+	for _, series := range detectRecurring(expenses) {
+		first := series.charges[0]
+		last := series.charges[len(series.charges)-1]
+		annualCost := int64(math.Round(float64(series.amount) * series.cadence.perYear()))
+		subscriptions = append(subscriptions, data.Subscription{
+			Location:     series.location,
+			Category:     last.Category,
+			Cadence:      series.cadence.name,
+			Amount:       money.New(series.amount, money.USD),
+			Charges:      len(series.charges),
+			FirstCharge:  first.Date,
+			LastCharge:   last.Date,
+			NextExpected: series.cadence.after(last.Date),
+			AnnualCost:   money.New(annualCost, money.USD),
+			Active:       today.DaysSince(last.Date) <= series.cadence.maxDays,
+			Adopted:      adopted[strings.ToLower(series.location)],
+		})
+	}
+	sortByAnnualCost(subscriptions)
+	return subscriptions, nil
+}
+
+// sortByAnnualCost sorts subscriptions from the largest annual cost to the smallest
+func sortByAnnualCost(subscriptions []data.Subscription) {
+	slices.SortStableFunc(subscriptions, func(a, b data.Subscription) int {
+		return cmp.Compare(b.AnnualCost.Amount(), a.AnnualCost.Amount())
+	})
+}
+
+// loadRecurringExpenses retrieves every recurring expense
+func loadRecurringExpenses(db *sql.DB) ([]data.RecurringExpense, error) {
+	rows, err := db.Query("SELECT id, location, description, category, amt, cadence, next_date FROM recurring_expenses ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var templates []data.RecurringExpense
+	for rows.Next() {
+		var template data.RecurringExpense
+		var description, category sql.NullString
+		var amt money.Amount
+		var nextDate time.Time
+		err := rows.Scan(&template.Id, &template.Location, &description, &category, &amt, &template.Cadence, &nextDate)
+		if err != nil {
+			return nil, err
+		}
+		template.Description = description.String
+		template.Category = category.String
+		template.Amount = money.New(amt, money.USD)
+		template.NextDate = civil.DateOf(nextDate)
+		templates = append(templates, template)
+	}
+	return templates, rows.Err()
+}
@@ -0,0 +1,83 @@
+package cmd
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+type SuggestionRequest struct {
+	Location string
+}
+
+type SuggestionResponse struct {
+	Success    bool
+	Error      error
+	Locations  []string
+	Categories []string
+	Category   string
+}
+
+// ExpenseSuggestions retrieves the values to suggest when adding an expense: the locations of past expenses and every
+// category, both ordered by how often they have been used, and the category most often used at the request's location
+// if it has been seen before
+func ExpenseSuggestions(db *sql.DB, req *SuggestionRequest) *SuggestionResponse {
+	locations, err := queryStrings(db, `SELECT location FROM expenses WHERE location != ''
+		GROUP BY location ORDER BY COUNT(*) DESC, MAX(date_spent) DESC, location`)
+	if err != nil {
+		return &SuggestionResponse{
+			Success: false,
+			Error:   fmt.Errorf("error querying locations: %w", err),
+		}
+	}
+
+	categories, err := queryStrings(db, `SELECT name FROM categories LEFT JOIN expenses ON category = name
+		GROUP BY name ORDER BY COUNT(expenses.id) DESC, name`)
+	if err != nil {
+		return &SuggestionResponse{
+			Success: false,
+			Error:   fmt.Errorf("error querying categories: %w", err),
+		}
+	}
+
+	category := ""
+	if req.Location != "" {
+		used, err := queryStrings(db, `SELECT category FROM expenses
+			WHERE location = ? COLLATE NOCASE AND category IS NOT NULL AND category != ''
+			GROUP BY category ORDER BY COUNT(*) DESC, MAX(date_spent) DESC LIMIT 1`, req.Location)
+		if err != nil {
+			return &SuggestionResponse{
+				Success: false,
+				Error:   fmt.Errorf("error querying category for location: %w", err),
+			}
+		}
+		if len(used) != 0 {
+			category = used[0]
+		}
+	}
+
+	return &SuggestionResponse{
+		Success:    true,
+		Locations:  locations,
+		Categories: categories,
+		Category:   category,
+	}
+}
+
+// queryStrings runs a query selecting a single text column and returns its values
+func queryStrings(db *sql.DB, query string, args ...any) ([]string, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	values := []string{}
+	for rows.Next() {
+		var value string
+		if err := rows.Scan(&value); err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, rows.Err()
+}
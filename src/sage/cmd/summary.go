@@ -3,45 +3,78 @@ package cmd
 import (
 	"database/sql"
 	"fmt"
+	"sage/src/sage/data"
 	"strings"
 
-	"cloud.google.com/go/civil"
+	"github.com/Rhymond/go-money"
 	_ "github.com/mattn/go-sqlite3"
 )
 
+const DEFAULT_SUMMARY_INTERVAL = "month"
+
+// summaryIntervals maps each summary interval to a function that returns the SQL expression labelling the period a
+// date falls in. Weeks are ISO weeks, which belong to the year of their Thursday. Fiscal years are labelled by the
+// calendar year they end in.
+var summaryIntervals = map[string]func(date string, fiscalStartMonth int) string{
+	"day": func(date string, _ int) string {
+		return "date(" + date + ")"
+	},
+	"week": func(date string, _ int) string {
+		thursday := date + ", '-3 days', 'weekday 4'"
+		return "printf('%04d-W%02d', CAST(strftime('%Y', " + thursday + ") AS INTEGER), " +
+			"(CAST(strftime('%j', " + thursday + ") AS INTEGER) - 1) / 7 + 1)"
+	},
+	"month": func(date string, _ int) string {
+		return "strftime('%Y-%m', " + date + ")"
+	},
+	"quarter": func(date string, _ int) string {
+		return "strftime('%Y', " + date + ") || '-Q' || ((CAST(strftime('%m', " + date + ") AS INTEGER) + 2) / 3)"
+	},
+	"year": func(date string, _ int) string {
+		return "strftime('%Y', " + date + ")"
+	},
+	"fiscal-year": func(date string, fiscalStartMonth int) string {
+		return fmt.Sprintf("'FY' || strftime('%%Y', %s, 'start of month', '+%d months')", date, (13-fiscalStartMonth)%12)
+	},
+}
+
 type SummaryRequest struct {
-	Start    civil.Date
-	End      civil.Date
-	Year     int
-	Limit    int
-	PageSize int
-	Page     int
+	ExpenseFilter
+	Interval         string
+	FiscalStartMonth int
+	Limit            int
+	PageSize         int
+	Page             int
+	Stats            bool
 }
 
 type SummaryResponse struct {
 	Success bool
 	Error   error
 	Result  *sql.Rows
+	Stats   map[string]*data.SummaryStats
 }
 
-// SummarizeExpenses retrieves the sum of expenses each month
+// SummarizeExpenses retrieves the sum of expenses in each period of the request's interval, or each month if no
+// interval is given. Periods without any expenses between the first and last period are included with a total of 0.
+// If stats are requested, the response also holds the statistics of every period, keyed by period.
 func SummarizeExpenses(db *sql.DB, req *SummaryRequest) *SummaryResponse {
-	connector := "WHERE"
-	var sb strings.Builder
-	sb.WriteString("SELECT strftime('%Y-%m', date_spent) AS month, sum(amt) AS total_spent FROM expenses")
-	if !req.Start.IsZero() {
-		sb.WriteString(fmt.Sprintf(" %s date_spent >= '%s'", connector, req.Start.String()))
-		connector = "AND"
-	}
-	if !req.End.IsZero() {
-		sb.WriteString(fmt.Sprintf(" %s date_spent <= '%s'", connector, req.End.String()))
-		connector = "AND"
-	}
-	if req.Year != 0 {
-		sb.WriteString(fmt.Sprintf(" %s strftime('%%Y', date_spent) = '%d'", connector, req.Year))
-		connector = "AND"
+	var stats map[string]*data.SummaryStats
+	if req.Stats {
+		var err error
+		stats, err = summaryStats(db, req)
+		if err != nil {
+			return &SummaryResponse{
+				Success: false,
+				Error:   fmt.Errorf("error calculating summary statistics: %w", err),
+			}
+		}
 	}
-	sb.WriteString(" GROUP BY month ORDER BY month")
+
+	var sb strings.Builder
+	groupQuery, args := req.groupQuery()
+	sb.WriteString(groupQuery)
+	sb.WriteString(" ORDER BY period")
 	if req.Limit != 0 {
 		sb.WriteString(fmt.Sprintf(" LIMIT %d", req.Limit))
 	}
@@ -54,7 +87,7 @@ func SummarizeExpenses(db *sql.DB, req *SummaryRequest) *SummaryResponse {
 
 	sumQuery := sb.String()
 
-	rows, err := db.Query(sumQuery)
+	rows, err := db.Query(sumQuery, args...)
 	if err != nil {
 		return &SummaryResponse{
 			Success: false,
@@ -65,5 +98,79 @@ func SummarizeExpenses(db *sql.DB, req *SummaryRequest) *SummaryResponse {
 	return &SummaryResponse{
 		Success: true,
 		Result:  rows,
+		Stats:   stats,
+	}
+}
+
+// groupQuery builds the query that groups the matching expenses into periods, without any ordering or pagination.
+// Every day from the start of the range (or the first matching expense) to the end of the range (or the last matching
+// expense) is generated so that empty periods are included. Returns the query along with the arguments for its
+// placeholders.
+func (req *SummaryRequest) groupQuery() (string, []any) {
+	periodOf := req.periodOf
+	first, last := req.bounds()
+	where, args := req.whereClause()
+	args = append(args, first, last)
+
+	query := "WITH RECURSIVE matching AS (SELECT date_spent, amt FROM expenses" + where + "), " +
+		"bounds AS (SELECT COALESCE(?, MIN(date(date_spent))) AS first, COALESCE(?, MAX(date(date_spent))) AS last FROM matching), " +
+		"days(day) AS (SELECT first FROM bounds WHERE first IS NOT NULL " +
+		"UNION ALL SELECT date(day, '+1 day') FROM days, bounds WHERE day < last), " +
+		"periods AS (SELECT DISTINCT " + periodOf("day") + " AS period FROM days), " +
+		"totals AS (SELECT " + periodOf("date_spent") + " AS period, SUM(amt) AS total FROM matching GROUP BY period) " +
+		"SELECT periods.period AS period, COALESCE(totals.total, 0) AS total_spent FROM periods " +
+		"LEFT JOIN totals ON totals.period = periods.period"
+	return query, args
+}
+
+// ScanSummaries reads every remaining row of a SummaryResponse's result into Summaries, along with the statistics of
+// each period if they were requested.
+func (req *SummaryRequest) ScanSummaries(resp *SummaryResponse) ([]data.Summary, error) {
+	var summaries []data.Summary
+	var period string
+	var totalSpent money.Amount
+
+	for resp.Result.Next() {
+		err := resp.Result.Scan(&period, &totalSpent)
+		if err != nil {
+			return nil, err
+		}
+		summary := data.Summary{
+			Period: period,
+			Total:  money.New(totalSpent, money.USD),
+		}
+		if req.Interval == "" || req.Interval == "month" {
+			summary.Month = period
+		}
+		if req.Stats {
+			summary.Stats = resp.Stats[period]
+		}
+		summaries = append(summaries, summary)
+	}
+	return summaries, resp.Result.Err()
+}
+
+// periodOf returns the SQL expression labelling the period of the request's interval that the given date falls in.
+func (req *SummaryRequest) periodOf(date string) string {
+	interval := req.Interval
+	if interval == "" {
+		interval = DEFAULT_SUMMARY_INTERVAL
+	}
+	fiscalStartMonth := req.FiscalStartMonth
+	if fiscalStartMonth == 0 {
+		fiscalStartMonth = 1
+	}
+	return summaryIntervals[interval](date, fiscalStartMonth)
+}
+
+// bounds returns the start and end dates of the request, or nil for either if it is not given.
+func (req *SummaryRequest) bounds() (any, any) {
+	var first, last any
+	if !req.Start.IsZero() {
+		first = req.Start.String()
+	}
+	if !req.End.IsZero() {
+		last = req.End.String()
 	}
+	return first, last
 }
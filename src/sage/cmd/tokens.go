@@ -0,0 +1,267 @@
+package cmd
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sage/src/sage/data"
+	"sage/src/sage/dates"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Scopes a token can be granted. Each scope also grants the ones before it: write allows reading, and admin allows
+// everything.
+const (
+	SCOPE_READ  = "read"
+	SCOPE_WRITE = "write"
+	SCOPE_ADMIN = "admin"
+)
+
+var tokenScopes = []string{SCOPE_READ, SCOPE_WRITE, SCOPE_ADMIN}
+
+const (
+	// Prefix of every token, which makes them easy to recognize, such as by secret scanners
+	TOKEN_PREFIX = "sage_"
+	// Number of random bytes in a token
+	TOKEN_BYTES = 32
+	// Number of characters of a token that are stored to tell it apart
+	TOKEN_DISPLAY_LENGTH = len(TOKEN_PREFIX) + 8
+)
+
+// TokenRequest creates, lists or revokes tokens. A created token is attributed to User, if it is set.
+type TokenRequest struct {
+	Subcommand string
+	Name       string
+	Scopes     []string
+	User       string
+	Id         int
+}
+
+// TokenResponse holds the tokens for a list. Token is the created token itself, which can't be retrieved later, and
+// Created describes it.
+type TokenResponse struct {
+	Success    bool
+	Error      error
+	Subcommand string
+	Token      string
+	Created    *data.Token
+	Result     []data.Token
+}
+
+// ParseTokenArgs takes a list of args and constructs the appropriate TokenRequest. subcommand is "create", "list" or
+// "revoke". create takes the name of the token, a comma-separated list of scopes and optionally the user to attribute
+// requests to, and revoke takes the ID of the token to revoke.
+func ParseTokenArgs(subcommand, name, scopes, user string, id int) (*TokenRequest, error) {
+	req := &TokenRequest{Subcommand: subcommand}
+	if user != "" && subcommand != "create" {
+		return nil, errors.New("can only provide a user when creating a token")
+	}
+	switch subcommand {
+	case "create":
+		if strings.TrimSpace(name) == "" {
+			return nil, errors.New("must provide a name for the token")
+		}
+		req.Name = strings.TrimSpace(name)
+		req.User = user
+		for _, scope := range strings.Split(scopes, ",") {
+			scope = strings.TrimSpace(scope)
+			if !slices.Contains(tokenScopes, scope) {
+				return nil, fmt.Errorf("scope must be read, write or admin, not '%s'", scope)
+			}
+			if !slices.Contains(req.Scopes, scope) {
+				req.Scopes = append(req.Scopes, scope)
+			}
+		}
+	case "list":
+	case "revoke":
+		if id <= 0 {
+			return nil, errors.New("must provide the ID of the token to revoke")
+		}
+		req.Id = id
+	default:
+		return nil, errors.New("subcommand must be create, list or revoke")
+	}
+	return req, nil
+}
+
+// APITokens creates, lists or revokes the tokens that authenticate requests to the server
+func APITokens(db *sql.DB, req *TokenRequest) *TokenResponse {
+	switch req.Subcommand {
+	case "create":
+		return createToken(db, req)
+	case "revoke":
+		return revokeToken(db, req)
+	default:
+		return listTokens(db)
+	}
+}
+
+// createToken generates a random token and stores its hash
+func createToken(db *sql.DB, req *TokenRequest) *TokenResponse {
+	secret := make([]byte, TOKEN_BYTES)
+	if _, err := rand.Read(secret); err != nil {
+		return &TokenResponse{
+			Success: false,
+			Error:   fmt.Errorf("error generating token: %w", err),
+		}
+	}
+	token := TOKEN_PREFIX + hex.EncodeToString(secret)
+	created := &data.Token{
+		Name:    req.Name,
+		User:    req.User,
+		Prefix:  token[:TOKEN_DISPLAY_LENGTH],
+		Scopes:  req.Scopes,
+		Created: dates.Now().UTC().Truncate(time.Second),
+	}
+
+	result, err := db.Exec("INSERT INTO tokens (name, user_name, prefix, hash, scopes, created_at) VALUES (?, ?, ?, ?, ?, ?)",
+		created.Name, nullIfEmpty(created.User), created.Prefix, hashToken(token), strings.Join(created.Scopes, ","),
+		created.Created.Format(time.RFC3339))
+	if constraintError(err) == errForeignKey {
+		if userErr := unknownUser(db, created.User); userErr != nil {
+			err = userErr
+		}
+	}
+	if err != nil {
+		return &TokenResponse{
+			Success: false,
+			Error:   fmt.Errorf("error adding token to 'tokens' table: %w", err),
+		}
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return &TokenResponse{
+			Success: false,
+			Error:   fmt.Errorf("error retrieving ID of token: %w", err),
+		}
+	}
+	created.Id = int(id)
+
+	return &TokenResponse{
+		Success:    true,
+		Subcommand: req.Subcommand,
+		Token:      token,
+		Created:    created,
+	}
+}
+
+// listTokens retrieves every token, oldest first
+func listTokens(db *sql.DB) *TokenResponse {
+	rows, err := db.Query("SELECT id, name, user_name, prefix, scopes, created_at, last_used_at FROM tokens ORDER BY id")
+	if err != nil {
+		return &TokenResponse{
+			Success: false,
+			Error:   fmt.Errorf("error querying 'tokens' table: %w", err),
+		}
+	}
+	defer rows.Close()
+
+	tokens := []data.Token{}
+	for rows.Next() {
+		var token data.Token
+		var scopes, created string
+		var user, lastUsed sql.NullString
+		if err := rows.Scan(&token.Id, &token.Name, &user, &token.Prefix, &scopes, &created, &lastUsed); err != nil {
+			return &TokenResponse{
+				Success: false,
+				Error:   fmt.Errorf("error reading tokens: %w", err),
+			}
+		}
+		token.User = user.String
+		token.Scopes = strings.Split(scopes, ",")
+		token.Created, err = time.Parse(time.RFC3339, created)
+		if err == nil && lastUsed.Valid {
+			var t time.Time
+			t, err = time.Parse(time.RFC3339, lastUsed.String)
+			token.LastUsed = &t
+		}
+		if err != nil {
+			return &TokenResponse{
+				Success: false,
+				Error:   fmt.Errorf("error reading tokens: %w", err),
+			}
+		}
+		tokens = append(tokens, token)
+	}
+	if err := rows.Err(); err != nil {
+		return &TokenResponse{
+			Success: false,
+			Error:   fmt.Errorf("error reading tokens: %w", err),
+		}
+	}
+
+	return &TokenResponse{
+		Success:    true,
+		Subcommand: "list",
+		Result:     tokens,
+	}
+}
+
+// revokeToken deletes a token, so it can no longer be used
+func revokeToken(db *sql.DB, req *TokenRequest) *TokenResponse {
+	result, err := db.Exec("DELETE FROM tokens WHERE id = ?", req.Id)
+	if err != nil {
+		return &TokenResponse{
+			Success: false,
+			Error:   fmt.Errorf("error deleting token from 'tokens' table: %w", err),
+		}
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return &TokenResponse{
+			Success: false,
+			Error:   errorOf(ErrNotFound, "no token with ID %d found", req.Id),
+		}
+	}
+
+	return &TokenResponse{
+		Success:    true,
+		Subcommand: req.Subcommand,
+	}
+}
+
+// AuthenticateToken returns the user a token is attributed to, which may be empty, and the scopes granted to it,
+// recording that it was used. Returns ErrUnauthorized if the token doesn't exist or was revoked.
+func AuthenticateToken(db *sql.DB, token string) (string, []string, error) {
+	hash := hashToken(token)
+	var user sql.NullString
+	var scopes string
+	err := db.QueryRow("SELECT user_name, scopes FROM tokens WHERE hash = ?", hash).Scan(&user, &scopes)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil, errorOf(ErrUnauthorized, "token is invalid or has been revoked")
+	}
+	if err != nil {
+		return "", nil, fmt.Errorf("error querying 'tokens' table: %w", err)
+	}
+
+	_, err = db.Exec("UPDATE tokens SET last_used_at = ? WHERE hash = ?", dates.Now().UTC().Format(time.RFC3339), hash)
+	if err != nil {
+		return "", nil, fmt.Errorf("error recording use of token: %w", err)
+	}
+	return user.String, strings.Split(scopes, ","), nil
+}
+
+// HasScope reports whether scopes grant the given scope, either directly or through a scope that includes it.
+func HasScope(scopes []string, scope string) bool {
+	need := slices.Index(tokenScopes, scope)
+	if need == -1 {
+		return false
+	}
+	for _, granted := range scopes {
+		if i := slices.Index(tokenScopes, granted); i != -1 && i >= need {
+			return true
+		}
+	}
+	return false
+}
+
+// hashToken returns the hex-encoded SHA-256 hash of a token. Tokens are random enough that they don't need a salt or
+// a slow hash.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,248 @@
+package cmd
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sage/src/sage/data"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// UserRequest lists users if Subcommand is "list", or adds, deletes or sets the password of ("passwd") the named
+// user. An empty Password leaves the user unable to log in.
+type UserRequest struct {
+	Subcommand string
+	Name       string
+	Password   string
+}
+
+// UserResponse holds the users for a list. Affected is the number of expenses a delete left unattributed.
+type UserResponse struct {
+	Success    bool
+	Error      error
+	Subcommand string
+	Result     []data.User
+	Affected   int
+}
+
+// ParseUserArgs takes a list of args and constructs the appropriate UserRequest. subcommand is "add", "list",
+// "delete" or "passwd", and every subcommand but list takes the name of a user.
+func ParseUserArgs(subcommand, name string) (*UserRequest, error) {
+	switch subcommand {
+	case "list":
+		if name != "" {
+			return nil, errors.New("cannot provide a name to list")
+		}
+	case "add", "delete", "passwd":
+		if strings.TrimSpace(name) == "" {
+			return nil, errors.New("must provide the name of the user")
+		}
+	default:
+		return nil, errors.New("subcommand must be add, list, delete or passwd")
+	}
+	return &UserRequest{Subcommand: subcommand, Name: strings.TrimSpace(name)}, nil
+}
+
+// Users lists, adds, deletes or sets the password of the users sharing the ledger
+func Users(db *sql.DB, req *UserRequest) *UserResponse {
+	switch req.Subcommand {
+	case "add":
+		return addUser(db, req)
+	case "delete":
+		return deleteUser(db, req)
+	case "passwd":
+		return setPassword(db, req)
+	default:
+		return listUsers(db)
+	}
+}
+
+// addUser adds a new user to the database
+func addUser(db *sql.DB, req *UserRequest) *UserResponse {
+	hash, err := hashPassword(req.Password)
+	if err != nil {
+		return &UserResponse{
+			Success: false,
+			Error:   err,
+		}
+	}
+	_, err = db.Exec("INSERT INTO users (name, password_hash) VALUES (?, ?)", req.Name, hash)
+	if constraintError(err) == ErrConflict {
+		err = errorOf(ErrConflict, "user '%s' already exists", req.Name)
+	}
+	if err != nil {
+		return &UserResponse{
+			Success: false,
+			Error:   fmt.Errorf("error adding user to 'users' table: %w", err),
+		}
+	}
+
+	return &UserResponse{
+		Success:    true,
+		Subcommand: req.Subcommand,
+	}
+}
+
+// listUsers retrieves every user in order of name
+func listUsers(db *sql.DB) *UserResponse {
+	rows, err := db.Query("SELECT name, password_hash IS NOT NULL FROM users ORDER BY name")
+	if err != nil {
+		return &UserResponse{
+			Success: false,
+			Error:   fmt.Errorf("error querying 'users' table: %w", err),
+		}
+	}
+	defer rows.Close()
+
+	users := []data.User{}
+	for rows.Next() {
+		var user data.User
+		if err := rows.Scan(&user.Name, &user.CanLogIn); err != nil {
+			return &UserResponse{
+				Success: false,
+				Error:   fmt.Errorf("error reading users: %w", err),
+			}
+		}
+		users = append(users, user)
+	}
+	if err := rows.Err(); err != nil {
+		return &UserResponse{
+			Success: false,
+			Error:   fmt.Errorf("error reading users: %w", err),
+		}
+	}
+
+	return &UserResponse{
+		Success:    true,
+		Subcommand: "list",
+		Result:     users,
+	}
+}
+
+// deleteUser removes a user from the database, leaving the expenses they paid for or recorded unattributed. Their
+// sessions and tokens are deleted along with them. A user who has a share in any expense or took part in any
+// settlement can't be deleted, since that would change what everyone else owes.
+func deleteUser(db *sql.DB, req *UserRequest) *UserResponse {
+	txn, err := db.Begin()
+	if err != nil {
+		return &UserResponse{
+			Success: false,
+			Error:   fmt.Errorf("error starting transaction: %w", err),
+		}
+	}
+	defer txn.Rollback()
+
+	var shares, settlements int
+	err = txn.QueryRow("SELECT (SELECT COUNT(*) FROM expense_shares WHERE user_name = ?), "+
+		"(SELECT COUNT(*) FROM settlements WHERE from_user = ? OR to_user = ?)", req.Name, req.Name, req.Name).
+		Scan(&shares, &settlements)
+	if err != nil {
+		return &UserResponse{
+			Success: false,
+			Error:   fmt.Errorf("error counting the user's shares and settlements: %w", err),
+		}
+	}
+	if shares != 0 || settlements != 0 {
+		return &UserResponse{
+			Success: false,
+			Error: errorOf(ErrConflict, "user '%s' has shares in %d expenses and %d settlements, so cannot be deleted",
+				req.Name, shares, settlements),
+		}
+	}
+
+	result, err := txn.Exec("UPDATE expenses SET paid_by = NULL WHERE paid_by = ?", req.Name)
+	if err != nil {
+		return &UserResponse{
+			Success: false,
+			Error:   fmt.Errorf("error unattributing expenses: %w", err),
+		}
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return &UserResponse{
+			Success: false,
+			Error:   fmt.Errorf("error unattributing expenses: %w", err),
+		}
+	}
+	if _, err := txn.Exec("UPDATE expenses SET created_by = NULL WHERE created_by = ?", req.Name); err != nil {
+		return &UserResponse{
+			Success: false,
+			Error:   fmt.Errorf("error unattributing expenses: %w", err),
+		}
+	}
+
+	result, err = txn.Exec("DELETE FROM users WHERE name = ?", req.Name)
+	if err != nil {
+		return &UserResponse{
+			Success: false,
+			Error:   fmt.Errorf("error deleting user from 'users' table: %w", err),
+		}
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return &UserResponse{
+			Success: false,
+			Error:   errorOf(ErrNotFound, "user '%s' does not exist", req.Name),
+		}
+	}
+
+	if err := txn.Commit(); err != nil {
+		return &UserResponse{
+			Success: false,
+			Error:   fmt.Errorf("error committing transaction: %w", err),
+		}
+	}
+	return &UserResponse{
+		Success:    true,
+		Subcommand: req.Subcommand,
+		Affected:   int(affected),
+	}
+}
+
+// setPassword changes the password of a user, logging them out of every session
+func setPassword(db *sql.DB, req *UserRequest) *UserResponse {
+	hash, err := hashPassword(req.Password)
+	if err != nil {
+		return &UserResponse{
+			Success: false,
+			Error:   err,
+		}
+	}
+	result, err := db.Exec("UPDATE users SET password_hash = ? WHERE name = ?", hash, req.Name)
+	if err != nil {
+		return &UserResponse{
+			Success: false,
+			Error:   fmt.Errorf("error setting password in 'users' table: %w", err),
+		}
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return &UserResponse{
+			Success: false,
+			Error:   errorOf(ErrNotFound, "user '%s' does not exist", req.Name),
+		}
+	}
+	if _, err := db.Exec("DELETE FROM sessions WHERE user_name = ?", req.Name); err != nil {
+		return &UserResponse{
+			Success: false,
+			Error:   fmt.Errorf("error ending sessions: %w", err),
+		}
+	}
+
+	return &UserResponse{
+		Success:    true,
+		Subcommand: req.Subcommand,
+	}
+}
+
+// hashPassword returns the bcrypt hash of a password, or nil for an empty password, which can't be logged in with.
+func hashPassword(password string) (any, error) {
+	if password == "" {
+		return nil, nil
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, fmt.Errorf("error hashing password: %w", err)
+	}
+	return string(hash), nil
+}
@@ -3,27 +3,42 @@ package cmd
 import (
 	"errors"
 	"fmt"
+	"sage/src/sage/dates"
 
 	"cloud.google.com/go/civil"
 )
 
 const MAX_PAGE_SIZE = 100
 
+// RangeArgs resolves a named range, such as "last-month", into the start and end arguments of ParseLogArgs or
+// ParseSummaryArgs, which take its first and last days respectively. Returns startStr and endStr unchanged if
+// rangeStr is empty.
+func RangeArgs(rangeStr, startStr, endStr string) (string, string, error) {
+	if rangeStr == "" {
+		return startStr, endStr, nil
+	}
+	if startStr != "" || endStr != "" {
+		return "", "", errors.New("cannot provide range with start or end date")
+	}
+	return rangeStr, rangeStr, nil
+}
+
 // ParseLogArgs takes a list of args and constructs the appropriate LogRequest. year, month, limit, pageSize, and page
-// default to 0. showId defaults to false.
-func ParseLogArgs(startStr, endStr string, year, month, limit, pageSize, page int, showId bool, query string) (*LogRequest, error) {
+// default to 0. cursor and sortStr default to "" and showId defaults to false. Empty fields of filterArgs are not
+// applied.
+func ParseLogArgs(startStr, endStr string, year, month, limit, pageSize, page int, cursor, sortStr string, showId bool, query string, filterArgs FilterArgs) (*LogRequest, error) {
 	var err error
 
 	start := civil.Date{}
 	if startStr != "" {
-		start, err = civil.ParseDate(startStr)
+		start, err = dates.ParseStart(startStr)
 		if err != nil {
 			return nil, errors.New("error parsing start date: " + err.Error())
 		}
 	}
 	end := civil.Date{}
 	if endStr != "" {
-		end, err = civil.ParseDate(endStr)
+		end, err = dates.ParseEnd(endStr)
 		if err != nil {
 			return nil, errors.New("error parsing end date: " + err.Error())
 		}
@@ -58,35 +73,62 @@ func ParseLogArgs(startStr, endStr string, year, month, limit, pageSize, page in
 	if page < 0 {
 		return nil, errors.New("page must be positive")
 	}
+	if cursor != "" {
+		if pageSize == 0 {
+			return nil, errors.New("must provide page size with cursor")
+		}
+		if page != 0 {
+			return nil, errors.New("cannot provide cursor with page")
+		}
+		if _, err := decodeCursor(cursor); err != nil {
+			return nil, err
+		}
+	}
+
+	sort, err := ParseSort(sortStr)
+	if err != nil {
+		return nil, err
+	}
+
+	filter := ExpenseFilter{
+		Start: start,
+		End:   end,
+		Year:  year,
+		Month: month,
+		Query: query,
+	}
+	err = parseFilterArgs(filterArgs, &filter)
+	if err != nil {
+		return nil, err
+	}
 
 	return &LogRequest{
-		Start:    start,
-		End:      end,
-		Year:     year,
-		Month:    month,
-		Limit:    limit,
-		PageSize: pageSize,
-		Page:     page,
-		ShowId:   showId,
-		Query:    query,
+		ExpenseFilter: filter,
+		Limit:         limit,
+		PageSize:      pageSize,
+		Page:          page,
+		Cursor:        cursor,
+		Sort:          sort,
+		ShowId:        showId,
 	}, nil
 }
 
 // ParseSummaryArgs takes a list of args and constructs the appropriate SummaryRequest. year, limit, and page default to
-// 0. pageSize defaults to 100.
-func ParseSummaryArgs(startStr, endStr string, year, limit, pageSize, page int) (*SummaryRequest, error) {
+// 0. pageSize defaults to 100. interval defaults to "month", and fiscalStartMonth defaults to 0, which starts fiscal
+// years in January. stats defaults to false.
+func ParseSummaryArgs(startStr, endStr string, year, limit, pageSize, page int, interval string, fiscalStartMonth int, stats bool) (*SummaryRequest, error) {
 	var err error
 
 	start := civil.Date{}
 	if startStr != "" {
-		start, err = civil.ParseDate(startStr)
+		start, err = dates.ParseStart(startStr)
 		if err != nil {
 			return nil, errors.New("error parsing start date: " + err.Error())
 		}
 	}
 	end := civil.Date{}
 	if endStr != "" {
-		end, err = civil.ParseDate(endStr)
+		end, err = dates.ParseEnd(endStr)
 		if err != nil {
 			return nil, errors.New("error parsing end date: " + err.Error())
 		}
@@ -116,12 +158,39 @@ func ParseSummaryArgs(startStr, endStr string, year, limit, pageSize, page int)
 		return nil, errors.New("page must be positive")
 	}
 
+	if interval == "" {
+		interval = DEFAULT_SUMMARY_INTERVAL
+	}
+	if _, ok := summaryIntervals[interval]; !ok {
+		return nil, errors.New("interval must be one of day, week, month, quarter, year, or fiscal-year")
+	}
+	if fiscalStartMonth < 0 || fiscalStartMonth > 12 {
+		return nil, errors.New("fiscal year start month must be between 1 and 12")
+	}
+	if fiscalStartMonth != 0 && interval != "fiscal-year" {
+		return nil, errors.New("fiscal year start month can only be provided with the fiscal-year interval")
+	}
+
 	return &SummaryRequest{
-		Start:    start,
-		End:      end,
-		Year:     year,
-		Limit:    limit,
-		PageSize: pageSize,
-		Page:     page,
+		ExpenseFilter: ExpenseFilter{
+			Start: start,
+			End:   end,
+			Year:  year,
+		},
+		Interval:         interval,
+		FiscalStartMonth: fiscalStartMonth,
+		Limit:            limit,
+		PageSize:         pageSize,
+		Page:             page,
+		Stats:            stats,
 	}, nil
 }
+
+// nullIfEmpty returns nil for an empty string, which stores NULL in a column that references another table, and s
+// otherwise.
+func nullIfEmpty(s string) any {
+	if s == "" {
+		return nil
+	}
+	return s
+}
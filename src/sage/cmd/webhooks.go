@@ -0,0 +1,385 @@
+package cmd
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sage/src/sage/data"
+	"sage/src/sage/dates"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Event sent by 'sage webhook test' to check that a webhook is reachable. Every webhook can be sent it, whatever events
+// it subscribes to.
+const EVENT_WEBHOOK_TEST = "webhook.test"
+
+// Events a webhook can subscribe to
+var WebhookEvents = []string{CHANGE_CREATED, CHANGE_UPDATED, CHANGE_DELETED}
+
+const (
+	// Prefix of every generated webhook secret
+	WEBHOOK_SECRET_PREFIX = "whsec_"
+	// Number of random bytes in a generated webhook secret
+	WEBHOOK_SECRET_BYTES = 24
+	// How long a webhook has to respond to a delivery
+	WEBHOOK_TIMEOUT = 10 * time.Second
+	// Number of deliveries shown by 'sage webhook log'
+	DELIVERY_LOG_LIMIT = 50
+)
+
+// Headers sent with every delivery. The signature is "sha256=" followed by the hex-encoded HMAC-SHA256 of the
+// timestamp, a period and the body, keyed with the webhook's secret.
+const (
+	EVENT_HEADER     = "X-Sage-Event"
+	DELIVERY_HEADER  = "X-Sage-Delivery"
+	TIMESTAMP_HEADER = "X-Sage-Timestamp"
+	SIGNATURE_HEADER = "X-Sage-Signature"
+)
+
+// WebhookRequest adds, lists or deletes webhooks, or lists the deliveries to one for "log". Webhook is the webhook to
+// add, and Id the webhook to delete or show the log of.
+type WebhookRequest struct {
+	Subcommand string
+	Webhook    data.Webhook
+	Id         int
+}
+
+// WebhookResponse holds the webhooks for a list, or the most recent deliveries first for a log. Created is the added
+// webhook, along with its secret.
+type WebhookResponse struct {
+	Success    bool
+	Error      error
+	Subcommand string
+	Created    *data.Webhook
+	Result     []data.Webhook
+	Deliveries []data.Delivery
+}
+
+// ParseWebhookArgs takes a list of args and constructs the appropriate WebhookRequest. subcommand is "add", "list",
+// "delete" or "log". add takes the URL to send events to, a comma-separated list of events that defaults to every
+// event, and a secret that is generated if it is empty. delete and log take the ID of the webhook.
+func ParseWebhookArgs(subcommand, rawURL, events, secret string, id int) (*WebhookRequest, error) {
+	req := &WebhookRequest{Subcommand: subcommand}
+	if (events != "" || secret != "") && subcommand != "add" {
+		return nil, errors.New("can only provide events and a secret when adding a webhook")
+	}
+	switch subcommand {
+	case "add":
+		u, err := url.Parse(strings.TrimSpace(rawURL))
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, errors.New("URL must be an http or https address")
+		}
+		req.Webhook.URL = u.String()
+		req.Webhook.Events = WebhookEvents
+		if strings.TrimSpace(events) != "" {
+			req.Webhook.Events = nil
+			for _, event := range strings.Split(events, ",") {
+				event = strings.TrimSpace(event)
+				if !slices.Contains(WebhookEvents, event) {
+					return nil, fmt.Errorf("event must be one of %s, not '%s'", strings.Join(WebhookEvents, ", "), event)
+				}
+				if !slices.Contains(req.Webhook.Events, event) {
+					req.Webhook.Events = append(req.Webhook.Events, event)
+				}
+			}
+		}
+		req.Webhook.Secret = secret
+	case "list":
+	case "delete", "log":
+		if id <= 0 {
+			return nil, fmt.Errorf("must provide the ID of the webhook to %s", subcommand)
+		}
+		req.Id = id
+	default:
+		return nil, errors.New("subcommand must be add, list, delete or log")
+	}
+	return req, nil
+}
+
+// Webhooks adds, lists or deletes the webhooks that events are sent to, or lists the deliveries to one
+func Webhooks(db *sql.DB, req *WebhookRequest) *WebhookResponse {
+	switch req.Subcommand {
+	case "add":
+		return addWebhook(db, req)
+	case "delete":
+		return deleteWebhook(db, req)
+	case "log":
+		return webhookLog(db, req)
+	default:
+		return listWebhooks(db)
+	}
+}
+
+// addWebhook stores a webhook, generating its secret if it wasn't given one
+func addWebhook(db *sql.DB, req *WebhookRequest) *WebhookResponse {
+	created := req.Webhook
+	if created.Secret == "" {
+		secret := make([]byte, WEBHOOK_SECRET_BYTES)
+		if _, err := rand.Read(secret); err != nil {
+			return &WebhookResponse{
+				Success: false,
+				Error:   fmt.Errorf("error generating secret: %w", err),
+			}
+		}
+		created.Secret = WEBHOOK_SECRET_PREFIX + hex.EncodeToString(secret)
+	}
+	created.Created = dates.Now().UTC().Truncate(time.Second)
+
+	result, err := db.Exec("INSERT INTO webhooks (url, events, secret, created_at) VALUES (?, ?, ?, ?)",
+		created.URL, strings.Join(created.Events, ","), created.Secret, created.Created.Format(time.RFC3339))
+	if err != nil {
+		return &WebhookResponse{
+			Success: false,
+			Error:   fmt.Errorf("error adding webhook to 'webhooks' table: %w", err),
+		}
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return &WebhookResponse{
+			Success: false,
+			Error:   fmt.Errorf("error retrieving ID of webhook: %w", err),
+		}
+	}
+	created.Id = int(id)
+
+	return &WebhookResponse{
+		Success:    true,
+		Subcommand: req.Subcommand,
+		Created:    &created,
+	}
+}
+
+// listWebhooks retrieves every webhook, oldest first, along with its most recent delivery
+func listWebhooks(db *sql.DB) *WebhookResponse {
+	webhooks, err := queryWebhooks(db, "")
+	if err != nil {
+		return &WebhookResponse{
+			Success: false,
+			Error:   err,
+		}
+	}
+	deliveries, err := queryDeliveries(db, "WHERE id IN (SELECT MAX(id) FROM webhook_deliveries GROUP BY webhook_id)")
+	if err != nil {
+		return &WebhookResponse{
+			Success: false,
+			Error:   err,
+		}
+	}
+	for i := range webhooks {
+		webhooks[i].Secret = ""
+		for j := range deliveries {
+			if deliveries[j].WebhookId == webhooks[i].Id {
+				webhooks[i].LastDelivery = &deliveries[j]
+			}
+		}
+	}
+
+	return &WebhookResponse{
+		Success:    true,
+		Subcommand: "list",
+		Result:     webhooks,
+	}
+}
+
+// deleteWebhook deletes a webhook along with its deliveries, so it is no longer sent events
+func deleteWebhook(db *sql.DB, req *WebhookRequest) *WebhookResponse {
+	result, err := db.Exec("DELETE FROM webhooks WHERE id = ?", req.Id)
+	if err != nil {
+		return &WebhookResponse{
+			Success: false,
+			Error:   fmt.Errorf("error deleting webhook from 'webhooks' table: %w", err),
+		}
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return &WebhookResponse{
+			Success: false,
+			Error:   errorOf(ErrNotFound, "no webhook with ID %d found", req.Id),
+		}
+	}
+
+	return &WebhookResponse{
+		Success:    true,
+		Subcommand: req.Subcommand,
+	}
+}
+
+// webhookLog retrieves the most recent deliveries to a webhook, most recent first
+func webhookLog(db *sql.DB, req *WebhookRequest) *WebhookResponse {
+	if _, err := GetWebhook(db, req.Id); err != nil {
+		return &WebhookResponse{
+			Success: false,
+			Error:   err,
+		}
+	}
+	deliveries, err := queryDeliveries(db, "WHERE webhook_id = ? ORDER BY id DESC LIMIT ?", req.Id, DELIVERY_LOG_LIMIT)
+	if err != nil {
+		return &WebhookResponse{
+			Success: false,
+			Error:   err,
+		}
+	}
+
+	return &WebhookResponse{
+		Success:    true,
+		Subcommand: req.Subcommand,
+		Deliveries: deliveries,
+	}
+}
+
+// GetWebhook retrieves a webhook along with its secret. Returns ErrNotFound if there is no webhook with the given ID.
+func GetWebhook(db *sql.DB, id int) (*data.Webhook, error) {
+	webhooks, err := queryWebhooks(db, "WHERE id = ?", id)
+	if err != nil {
+		return nil, err
+	}
+	if len(webhooks) == 0 {
+		return nil, errorOf(ErrNotFound, "no webhook with ID %d found", id)
+	}
+	return &webhooks[0], nil
+}
+
+// WebhooksFor retrieves the webhooks that subscribe to an event, along with their secrets.
+func WebhooksFor(db *sql.DB, event string) ([]data.Webhook, error) {
+	webhooks, err := queryWebhooks(db, "")
+	if err != nil {
+		return nil, err
+	}
+	return slices.DeleteFunc(webhooks, func(w data.Webhook) bool { return !slices.Contains(w.Events, event) }), nil
+}
+
+// queryWebhooks retrieves the webhooks matching a WHERE clause, which may be empty, in the order they were added
+func queryWebhooks(db *sql.DB, where string, args ...any) ([]data.Webhook, error) {
+	webhooks := []data.Webhook{}
+	err := eachRow(db, "SELECT id, url, events, secret, created_at FROM webhooks "+where+" ORDER BY id", args,
+		func(rows *sql.Rows) error {
+			var webhook data.Webhook
+			var events string
+			if err := rows.Scan(&webhook.Id, &webhook.URL, &events, &webhook.Secret, &webhook.Created); err != nil {
+				return err
+			}
+			webhook.Events = strings.Split(events, ",")
+			webhooks = append(webhooks, webhook)
+			return nil
+		})
+	if err != nil {
+		return nil, fmt.Errorf("error querying 'webhooks' table: %w", err)
+	}
+	return webhooks, nil
+}
+
+// queryDeliveries retrieves the deliveries matching the end of a query, such as a WHERE clause
+func queryDeliveries(db *sql.DB, clause string, args ...any) ([]data.Delivery, error) {
+	deliveries := []data.Delivery{}
+	err := eachRow(db, "SELECT id, webhook_id, change_id, event, attempt, status, error, delivered_at FROM webhook_deliveries "+clause, args,
+		func(rows *sql.Rows) error {
+			var delivery data.Delivery
+			var status sql.NullInt64
+			var deliveryErr sql.NullString
+			if err := rows.Scan(&delivery.Id, &delivery.WebhookId, &delivery.ChangeId, &delivery.Event, &delivery.Attempt,
+				&status, &deliveryErr, &delivery.Delivered); err != nil {
+				return err
+			}
+			delivery.Status = int(status.Int64)
+			delivery.Error = deliveryErr.String
+			delivery.Succeeded = succeeded(delivery.Status)
+			deliveries = append(deliveries, delivery)
+			return nil
+		})
+	if err != nil {
+		return nil, fmt.Errorf("error querying 'webhook_deliveries' table: %w", err)
+	}
+	return deliveries, nil
+}
+
+// succeeded reports whether a webhook's response status means it received a delivery
+func succeeded(status int) bool {
+	return status >= 200 && status < 300
+}
+
+// SignWebhook returns the signature of a delivery sent with the given timestamp, in seconds since the Unix epoch, and
+// body. Receivers can compute it to check that a delivery came from sage.
+func SignWebhook(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// WebhookTestChange returns the change sent by 'sage webhook test', which has no expense.
+func WebhookTestChange() data.Change {
+	return data.Change{Type: EVENT_WEBHOOK_TEST, CreatedAt: dates.Now().UTC().Truncate(time.Second)}
+}
+
+// DeliverWebhook makes one attempt to send a change to a webhook as a signed JSON POST request, and records it in the
+// webhook's delivery log. attempt counts the attempts made to send the change, starting from 1. A webhook that doesn't
+// respond with a 2xx status hasn't received the change, which the returned delivery reports rather than an error.
+func DeliverWebhook(db *sql.DB, client *http.Client, webhook data.Webhook, change data.Change, attempt int) (*data.Delivery, error) {
+	body, err := json.Marshal(change)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding change: %w", err)
+	}
+	now := dates.Now().UTC()
+	delivery := &data.Delivery{
+		WebhookId: webhook.Id,
+		ChangeId:  change.Id,
+		Event:     change.Type,
+		Attempt:   attempt,
+		Delivered: now.Truncate(time.Second),
+	}
+
+	req, err := http.NewRequest(http.MethodPost, webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "sage-webhook")
+	req.Header.Set(EVENT_HEADER, change.Type)
+	req.Header.Set(DELIVERY_HEADER, strconv.FormatInt(change.Id, 10))
+	req.Header.Set(TIMESTAMP_HEADER, strconv.FormatInt(now.Unix(), 10))
+	req.Header.Set(SIGNATURE_HEADER, SignWebhook(webhook.Secret, now.Unix(), body))
+	resp, err := client.Do(req)
+	if err != nil {
+		delivery.Error = err.Error()
+	} else {
+		// read some of the body so that the connection can be reused
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+		resp.Body.Close()
+		delivery.Status = resp.StatusCode
+		delivery.Succeeded = succeeded(resp.StatusCode)
+		if !delivery.Succeeded {
+			delivery.Error = "webhook responded with " + resp.Status
+		}
+	}
+
+	var status, deliveryErr any
+	if delivery.Status != 0 {
+		status = delivery.Status
+	}
+	if delivery.Error != "" {
+		deliveryErr = delivery.Error
+	}
+	result, err := db.Exec("INSERT INTO webhook_deliveries (webhook_id, change_id, event, attempt, status, error, delivered_at) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		delivery.WebhookId, delivery.ChangeId, delivery.Event, delivery.Attempt, status, deliveryErr,
+		delivery.Delivered.Format(time.RFC3339))
+	if err != nil {
+		return nil, fmt.Errorf("error adding delivery to 'webhook_deliveries' table: %w", err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving ID of delivery: %w", err)
+	}
+	delivery.Id = int(id)
+	return delivery, nil
+}
@@ -1,307 +1,404 @@
 package controller
 
 import (
+	"database/sql"
 	"errors"
 	"flag"
 	"fmt"
-	"log"
+	"io"
+	"net/url"
 	"os"
+	"sage/src/sage/client"
 	"sage/src/sage/cmd"
-	"sage/src/sage/data"
-	"sage/src/sage/server"
+	"sage/src/sage/dates"
+	"sage/src/sage/output"
 	"strconv"
 	"strings"
-	"time"
+)
 
-	"cloud.google.com/go/civil"
-	"github.com/Rhymond/go-money"
+// Exit codes returned by RunCLIController
+const (
+	EXIT_OK    = 0
+	EXIT_ERROR = 1
+	EXIT_USAGE = 2
 )
 
-func RunCLIController() int {
-	args := os.Args[1:]
-	if len(args) == 0 {
-		fmt.Println(`Valid sage commands:
-		add <date> <location> <description> <amount>
-		log [--start <date>] [--end <date>] [--year <year>] [--month <month>] [-n <limit>] [--page-size <size>] [--page <page>] [--show-id]
-		summary [--start <date>] [--end <date>] [--year <year>] [-n <limit>] [--page-size <size>] [--page <page>]
-		delete <id>
-		category
-		category add <category>
-		category delete <category>
-		category edit <category> <new-category>`)
-		return 0
-	}
+// Environment variables holding the default of --remote, the token to authenticate to the server with, and the user
+// that expenses added to the local database are attributed to
+const (
+	REMOTE_ENV = "SAGE_REMOTE"
+	TOKEN_ENV  = "SAGE_TOKEN"
+	USER_ENV   = "SAGE_USER"
+)
 
-	var err error
-	command := args[0]
-	switch command {
-	case "add":
-		if len(args) < 6 {
-			log.Println("not enough fields provided")
-			return 1
-		}
+// command is a CLI command. usage lists the arguments that follow the command's name, and subcommands lists the
+// subcommands it accepts as its first argument, if any. define adds the command's flags to a FlagSet and returns the
+// function that runs it with the positional arguments left after parsing them. define is also called without running
+// the command to show its help and complete its flags. rawArgs passes every argument to the command as is, including
+// global flags.
+type command struct {
+	name        string
+	usage       string
+	description string
+	subcommands []string
+	hidden      bool
+	rawArgs     bool
+	define      func(fs *flag.FlagSet) runFunc
+}
 
-		addReq, err := parseAddRequest(args[1:])
-		if err != nil {
-			log.Println("error parsing add request", err)
-			return 1
-		}
+// runFunc runs a command with its positional arguments, returning the result to print. A nil view prints nothing.
+type runFunc func(ctx *cliContext, args []string) (*output.View, error)
+
+// usageError is returned by a command when it is invoked incorrectly, as opposed to failing while it runs.
+type usageError struct {
+	err error
+}
 
-		db, err := cmd.ConnectDB("sage.db")
+func (e *usageError) Error() string {
+	return e.err.Error()
+}
+
+func (e *usageError) Unwrap() error {
+	return e.err
+}
+
+// usageErrorf returns a usageError with the given formatted message.
+func usageErrorf(format string, a ...any) error {
+	return &usageError{err: fmt.Errorf(format, a...)}
+}
+
+// globalFlags holds the flags accepted by every command.
+type globalFlags struct {
+	output string
+	dbName string
+	// remote is the address of a sage server to run commands on instead of the database, if set
+	remote string
+}
+
+// cliContext holds the state shared by a command while it runs. The database is connected to the first time it is
+// needed.
+type cliContext struct {
+	globalFlags
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+	db     *sql.DB
+}
+
+// DB returns the connection to the database named by the --db flag, connecting to it if needed. Fails if --remote is
+// set, since the command then has no database to use.
+func (ctx *cliContext) DB() (*sql.DB, error) {
+	if ctx.remote != "" {
+		return nil, errors.New("command is not supported with --remote")
+	}
+	if ctx.db == nil {
+		db, err := cmd.ConnectDB(ctx.dbName)
 		if err != nil {
-			log.Println("error connecting to database: ", err)
-			return 1
+			return nil, fmt.Errorf("error connecting to database: %w", err)
 		}
-		addResp := cmd.AddExpense(db, addReq)
-		if addResp.Success {
-			fmt.Println("Expense added successfully")
-		} else {
-			if strings.Contains(addResp.Error.Error(), "FOREIGN KEY constraint failed") {
-				fmt.Println("Error adding expense: category does not exist")
-			} else {
-				fmt.Println("Error adding expense: ", addResp.Error)
-			}
-			return 1
+		ctx.db = db
+	}
+	return ctx.db, nil
+}
+
+// backend returns the backend commands run on: the server named by --remote if it is set, or else the database.
+func (ctx *cliContext) backend() (backend, error) {
+	if ctx.remote != "" {
+		c := client.New(ctx.remote)
+		c.Token = os.Getenv(TOKEN_ENV)
+		return &remoteBackend{client: c}, nil
+	}
+	db, err := ctx.DB()
+	if err != nil {
+		return nil, err
+	}
+	return &localBackend{db: db, user: os.Getenv(USER_ENV)}, nil
+}
+
+func RunCLIController() int {
+	return runCLI(os.Args[1:], os.Stdin, os.Stdout, os.Stderr)
+}
+
+// runCLI runs the command named by the first of args, reading any answers to prompts from stdin and writing results
+// to stdout and errors to stderr. Returns the process exit code: EXIT_USAGE if the command was invoked incorrectly, or
+// EXIT_ERROR if it failed while running.
+func runCLI(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	globals, rest, err := parseGlobalFlags(args)
+	if len(args) != 0 && findCommand(args[0]) != nil && findCommand(args[0]).rawArgs {
+		globals, err = defaultGlobalFlags(), nil
+	} else {
+		args = rest
+	}
+	if err != nil {
+		fmt.Fprintln(stderr, "sage:", err)
+		fmt.Fprintln(stderr, "Run 'sage help' for usage.")
+		return EXIT_USAGE
+	}
+	ctx := &cliContext{globalFlags: globals, stdin: stdin, stdout: stdout, stderr: stderr}
+	defer func() {
+		if ctx.db != nil {
+			ctx.db.Close()
 		}
-	case "log":
-		logReq := &cmd.LogRequest{ShowId: false}
-		if len(args) != 1 {
-			logReq, err = parseLogRequest(args[1:])
-			if err != nil {
-				log.Println("error parsing log request: ", err)
-				return 1
+	}()
+
+	if len(args) == 0 || args[0] == "help" || args[0] == "-h" || args[0] == "--help" {
+		if len(args) > 1 {
+			if c := findCommand(args[1]); c != nil && !c.hidden {
+				printCommandHelp(stdout, c)
+				return EXIT_OK
 			}
+			fmt.Fprintf(stderr, "sage: unknown command '%s'\n", args[1])
+			return EXIT_USAGE
 		}
+		printHelp(stdout)
+		return EXIT_OK
+	}
 
-		db, err := cmd.ConnectDB("sage.db")
-		if err != nil {
-			log.Println("error connecting to database: ", err)
-			return 1
-		}
-		logResp := cmd.LogExpenses(db, logReq)
-		if logResp.Success {
-			defer logResp.Result.Close()
-
-			var date time.Time
-			var location string
-			var description string
-			var category string
-			var amt money.Amount
-			for logResp.Result.Next() {
-				if logResp.ShowId {
-					var id int
-					err := logResp.Result.Scan(&id, &date, &location, &description, &category, &amt)
-					if err != nil {
-						log.Println("error reading retrieved expenses: " + err.Error())
-						return 1
-					}
-					if category == "" {
-						category = "uncategorized"
-					}
-					fmt.Printf("%d | %s | %s | %s | %s | $%.2f\n", id, date.Format("2006-01-02"), location, description, category, float64(amt)/100)
-				} else {
-					err := logResp.Result.Scan(&date, &location, &description, &category, &amt)
-					if err != nil {
-						log.Println("error reading retrieved expenses: " + err.Error())
-						return 1
-					}
-					if category == "" {
-						category = "uncategorized"
-					}
-					fmt.Printf("%s | %s | %s | %s | $%.2f\n", date.Format("2006-01-02"), location, description, category, float64(amt)/100)
-				}
-			}
-		} else {
-			fmt.Println("Error logging expenses: ", logResp.Error)
-			return 1
+	c := findCommand(args[0])
+	if c == nil {
+		fmt.Fprintf(stderr, "sage: unknown command '%s'\n", args[0])
+		fmt.Fprintln(stderr, "Run 'sage help' for usage.")
+		return EXIT_USAGE
+	}
+
+	fs := newFlagSet(c)
+	run := c.define(fs)
+	positional, err := args[1:], error(nil)
+	if !c.rawArgs {
+		positional, err = parseArgs(fs, args[1:])
+	}
+	if errors.Is(err, flag.ErrHelp) {
+		printCommandHelp(stdout, c)
+		return EXIT_OK
+	}
+	if err != nil {
+		err = &usageError{err: err}
+	} else {
+		var view *output.View
+		view, err = run(ctx, positional)
+		if err == nil && view != nil {
+			err = output.Render(stdout, ctx.output, view)
 		}
-	case "summary":
-		sumReq := &cmd.SummaryRequest{}
-		if len(args) != 1 {
-			sumReq, err = parseSummaryRequest(args[1:])
-			if err != nil {
-				log.Println("error parsing summary request: ", err)
-				return 1
-			}
+		if err == nil {
+			return EXIT_OK
 		}
+	}
 
-		db, err := cmd.ConnectDB("sage.db")
-		if err != nil {
-			log.Println("error connecting to database: ", err)
-			return 1
+	var usageErr *usageError
+	if errors.As(err, &usageErr) {
+		fmt.Fprintf(stderr, "sage %s: %v\n", c.name, err)
+		fmt.Fprintf(stderr, "Run 'sage %s --help' for usage.\n", c.name)
+		return EXIT_USAGE
+	}
+	if ctx.output == output.JSON {
+		output.Render(stdout, ctx.output, output.Error(err))
+	} else {
+		fmt.Fprintf(stderr, "sage %s: %v\n", c.name, err)
+	}
+	return EXIT_ERROR
+}
+
+// newFlagSet returns an empty FlagSet for a command that returns errors instead of printing them and exiting.
+func newFlagSet(c *command) *flag.FlagSet {
+	fs := flag.NewFlagSet(c.name, flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	return fs
+}
+
+// parseArgs parses the flags in args wherever they appear, so that flags may follow positional arguments and
+// subcommands. Returns the positional arguments in order. Everything after "--" is positional, as are negative
+// numbers such as refunded amounts and relative dates such as "-3d" that aren't the name of a flag.
+func parseArgs(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for len(args) > 0 {
+		arg := args[0]
+		if arg == "--" {
+			return append(positional, args[1:]...), nil
 		}
-		sumResp := cmd.SummarizeExpenses(db, sumReq)
-		if sumResp.Success {
-			defer sumResp.Result.Close()
-
-			var month string
-			var totalSpent money.Amount
-			for sumResp.Result.Next() {
-				err = sumResp.Result.Scan(&month, &totalSpent)
-				if err != nil {
-					log.Println("error reading calculated summary: " + err.Error())
-				}
-				fmt.Printf("%s: $%.2f\n", month, float64(totalSpent)/100)
+		if _, err := strconv.ParseFloat(arg, 64); err == nil || !strings.HasPrefix(arg, "-") || arg == "-" {
+			positional = append(positional, arg)
+			args = args[1:]
+			continue
+		}
+		if f, _ := lookupFlag(fs, arg); f == nil {
+			if _, err := dates.Parse(arg); err == nil {
+				positional = append(positional, arg)
+				args = args[1:]
+				continue
 			}
-		} else {
-			fmt.Println("Error summarizing expenses: ", sumResp.Error)
-			return 1
 		}
-	case "delete":
-		if len(args) < 2 {
-			log.Println("need to provide an ID to delete")
-			return 1
+
+		// parse one flag at a time so that positional arguments can follow it
+		n := 1
+		if _, takesValue := lookupFlag(fs, arg); takesValue && len(args) > 1 {
+			n = 2
 		}
-		if len(args) > 2 {
-			log.Println("can only delete one ID at a time")
-			return 1
+		if err := fs.Parse(args[:n]); err != nil {
+			return nil, err
 		}
+		args = args[n:]
+	}
+	return positional, nil
+}
 
-		id, err := strconv.Atoi(args[1])
-		if err != nil {
-			log.Println("invalid ID provided: ", err)
-			return 1
-		}
+// globalFlagDef describes a flag accepted by every command. values lists the values it accepts for completion, if
+// they are fixed.
+type globalFlagDef struct {
+	names  []string
+	usage  string
+	values []string
+	set    func(globals *globalFlags, value string) error
+}
 
-		db, err := cmd.ConnectDB("sage.db")
-		if err != nil {
-			log.Println("error connecting to database: ", err)
-			return 1
-		}
-		deleteResp := cmd.DeleteExpense(db, &cmd.DeleteRequest{
-			Id: id,
-		})
-		if deleteResp.Success {
-			fmt.Println("Expense deleted successfully")
-		} else {
-			fmt.Println("Error deleting expense: ", deleteResp.Error)
-			return 1
-		}
-	case "category":
-		if len(args) == 2 || len(args) > 4 {
-			log.Println("incorrect number of fields provided")
-			return 1
-		}
-		catReq := &cmd.CategoryRequest{}
-		if len(args) == 3 {
-			if args[1] == "add" {
-				catReq.Subcommand = args[1]
-				catReq.CategoryName = args[2]
-			} else if args[1] == "delete" {
-				catReq.Subcommand = args[1]
-				catReq.CategoryName = args[2]
-			} else {
-				log.Println("invalid subcommand or number of fields provided")
-				return 1
+var globalFlagDefs = []globalFlagDef{
+	{
+		names:  []string{"output", "o"},
+		usage:  "output format (table, plain, json, csv, tsv)",
+		values: []string{output.TABLE, output.PLAIN, output.JSON, output.CSV, output.TSV},
+		set: func(globals *globalFlags, value string) error {
+			format, err := output.ParseFormat(value)
+			globals.output = format
+			return err
+		},
+	},
+	{
+		names: []string{"db"},
+		usage: "name of the database file in ~/sage to use (default \"" + cmd.SAGE_DB_NAME + "\")",
+		set: func(globals *globalFlags, value string) error {
+			if value == "" || strings.ContainsAny(value, `/\`) {
+				return errors.New("database must be a file name")
 			}
-		} else if len(args) == 4 {
-			if args[1] == "edit" {
-				catReq.Subcommand = args[1]
-				catReq.CategoryName = args[2]
-				catReq.NewCategoryName = args[3]
-			} else {
-				log.Println("invalid subcommand or number of fields provided")
-				return 1
+			globals.dbName = value
+			return nil
+		},
+	},
+	{
+		names: []string{"remote"},
+		usage: "address of a sage server to run add, log, summary, delete and category on, such as http://localhost:8080 (default $" + REMOTE_ENV + ")",
+		set: func(globals *globalFlags, value string) error {
+			if value != "" {
+				u, err := url.Parse(value)
+				if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+					return errors.New("remote must be an http or https address")
+				}
 			}
-		}
+			globals.remote = value
+			return nil
+		},
+	},
+}
 
-		db, err := cmd.ConnectDB("sage.db")
-		if err != nil {
-			log.Println("error connecting to database: ", err)
-			return 1
+// defaultGlobalFlags returns the values of the global flags when they aren't given.
+func defaultGlobalFlags() globalFlags {
+	return globalFlags{
+		output: output.DEFAULT_FORMAT,
+		dbName: cmd.SAGE_DB_NAME,
+		remote: os.Getenv(REMOTE_ENV),
+	}
+}
+
+// parseGlobalFlags removes the global flags from anywhere before "--" in args, returning their values along with the
+// remaining args.
+func parseGlobalFlags(args []string) (globalFlags, []string, error) {
+	globals := defaultGlobalFlags()
+
+	var rest []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			rest = append(rest, args[i:]...)
+			break
 		}
-		catResp := cmd.ExpenseCategory(db, catReq)
-		if catResp.Success {
-			if catResp.Subcommand == "add" {
-				fmt.Println("Category successfully added")
-			} else if catResp.Subcommand == "delete" {
-				fmt.Println("Category successfully deleted")
-			} else if catResp.Subcommand == "edit" {
-				fmt.Printf("Category successfully changed from %s to %s\n", catReq.CategoryName, catReq.NewCategoryName)
-			} else {
-				defer catResp.Result.Close()
-
-				var category string
-				for catResp.Result.Next() {
-					err := catResp.Result.Scan(&category)
-					if err != nil {
-						log.Println("error reading retrieved categories: " + err.Error())
-						return 1
-					}
-					fmt.Println(category)
-				}
+		name, value, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		def := findGlobalFlag(name)
+		if !strings.HasPrefix(arg, "-") || def == nil {
+			rest = append(rest, arg)
+			continue
+		}
+		if !hasValue {
+			if i+1 == len(args) {
+				return globals, nil, errors.New("flag needs an argument: " + arg)
 			}
-		} else {
-			fmt.Println("Error retrieving categories: ", catResp.Error)
-			return 1
+			i++
+			value = args[i]
 		}
-	case "server":
-		err := server.RunServer()
-		if err != nil {
-			log.Println("error running server: ", err)
-			return 1
+		if err := def.set(&globals, value); err != nil {
+			return globals, nil, err
 		}
-	default:
-		fmt.Println("Invalid command")
-		return 1
 	}
-
-	return 0
+	return globals, rest, nil
 }
 
-// parseAddRequest takes a list of provided fields and constructs the appropriate AddRequest. Assumes 4 fields are provided.
-func parseAddRequest(args []string) (*cmd.AddRequest, error) {
-	date, err := civil.ParseDate(args[0])
-	if err != nil {
-		return nil, errors.New("error parsing date: " + err.Error())
+// findGlobalFlag returns the global flag with the given name, or nil if there is none.
+func findGlobalFlag(name string) *globalFlagDef {
+	for i, def := range globalFlagDefs {
+		for _, n := range def.names {
+			if n == name {
+				return &globalFlagDefs[i]
+			}
+		}
 	}
+	return nil
+}
 
-	fl, err := strconv.ParseFloat(args[4], 64)
-	if err != nil {
-		return nil, errors.New("error parsing amount: " + err.Error())
+// findCommand returns the command with the given name, or nil if there is none.
+func findCommand(name string) *command {
+	for _, c := range commands {
+		if c.name == name {
+			return c
+		}
 	}
-	amt := money.NewFromFloat(fl, money.USD)
-
-	return &cmd.AddRequest{
-		Expense: data.Expense{
-			Date:        date,
-			Location:    args[1],
-			Description: args[2],
-			Category:    args[3],
-			Amount:      amt,
-		},
-	}, nil
+	return nil
 }
 
-// parseLogRequest takes a list of args and constructs the appropriate LogRequest.
-func parseLogRequest(args []string) (*cmd.LogRequest, error) {
-	logCmd := flag.NewFlagSet("log", flag.ExitOnError)
-	startStr := logCmd.String("start", "", "start date")
-	endStr := logCmd.String("end", "", "end date")
-	year := logCmd.Int("year", 0, "year")
-	month := logCmd.Int("month", 0, "month")
-	limit := logCmd.Int("n", 0, "limit")
-	pageSize := logCmd.Int("page-size", 0, "page size")
-	page := logCmd.Int("page", 0, "page")
-	showId := logCmd.Bool("show-id", false, "show the expense ID")
-	query := logCmd.String("query", "", "search query")
-
-	logCmd.Parse(args)
-
-	return cmd.ParseLogArgs(*startStr, *endStr, *year, *month, *limit, *pageSize, *page, *showId, *query)
+// printHelp prints the list of commands along with the global flags.
+func printHelp(w io.Writer) {
+	fmt.Fprintln(w, "Usage: sage [--output <format>] [--db <name>] [--remote <url>] <command> [<args>]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Commands:")
+	width := 0
+	for _, c := range commands {
+		width = max(width, len(c.name))
+	}
+	for _, c := range commands {
+		if !c.hidden {
+			fmt.Fprintf(w, "  %-*s  %s\n", width, c.name, c.description)
+		}
+	}
+	fmt.Fprintln(w)
+	printGlobalFlags(w)
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Run 'sage <command> --help' for more information on a command.")
 }
 
-// parseSummaryRequest takes a list of args and constructs the appropriate SummaryRequest.
-func parseSummaryRequest(args []string) (*cmd.SummaryRequest, error) {
-	summCmd := flag.NewFlagSet("log", flag.ExitOnError)
-	startStr := summCmd.String("start", "", "start date")
-	endStr := summCmd.String("end", "", "end date")
-	year := summCmd.Int("year", 0, "year")
-	limit := summCmd.Int("n", 0, "limit")
-	pageSize := summCmd.Int("page-size", 0, "page size")
-	page := summCmd.Int("page", 0, "page")
+// printCommandHelp prints the usage and flags of a command.
+func printCommandHelp(w io.Writer, c *command) {
+	fmt.Fprintf(w, "Usage: sage %s %s\n", c.name, c.usage)
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, c.description)
 
-	summCmd.Parse(args)
+	fs := newFlagSet(c)
+	c.define(fs)
+	hasFlags := false
+	fs.VisitAll(func(*flag.Flag) { hasFlags = true })
+	if hasFlags {
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, "Flags:")
+		fs.SetOutput(w)
+		fs.PrintDefaults()
+	}
+	fmt.Fprintln(w)
+	printGlobalFlags(w)
+}
 
-	return cmd.ParseSummaryArgs(*startStr, *endStr, *year, *limit, *pageSize, *page)
+// printGlobalFlags prints the flags accepted by every command.
+func printGlobalFlags(w io.Writer) {
+	fmt.Fprintln(w, "Global flags:")
+	for _, def := range globalFlagDefs {
+		var names []string
+		for _, name := range def.names {
+			names = append(names, "-"+name)
+		}
+		fmt.Fprintf(w, "  %s\n    \t%s\n", strings.Join(names, ", "), def.usage)
+	}
 }
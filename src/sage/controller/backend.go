@@ -0,0 +1,361 @@
+package controller
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sage/src/sage/client"
+	"sage/src/sage/cmd"
+	"sage/src/sage/data"
+	"strconv"
+	"strings"
+)
+
+// backend performs the operations of the commands that can run either on the local database or, with --remote, on a
+// sage server. Both return the same results and the same kinds of errors, so that the commands print the same output.
+type backend interface {
+	addExpense(req *cmd.AddRequest) error
+	// logExpenses returns the expenses matching a log request, with the cursors of the adjacent pages if it is paged
+	// by cursor
+	logExpenses(req *cmd.LogRequest) (*expensePage, error)
+	summarizeExpenses(req *cmd.SummaryRequest) ([]data.Summary, error)
+	deleteExpense(id int) error
+	// categories returns the names of every category
+	categories() ([]string, error)
+	// changeCategory adds, deletes or renames a category, returning the number of expenses and recurring expenses
+	// affected
+	changeCategory(req *cmd.CategoryRequest) (int, int, error)
+	// suggestions returns the locations and categories to complete when adding an expense at location
+	suggestions(location string) *cmd.SuggestionResponse
+}
+
+// expensePage is the expenses retrieved by a log request, with the cursors of the adjacent pages if there are any.
+type expensePage struct {
+	expenses   []data.Expense
+	nextCursor string
+	prevCursor string
+}
+
+// localBackend performs operations on a database. Expenses are added by user, who also paid for them unless the
+// request says otherwise, as the server does for the user a request is authenticated as.
+type localBackend struct {
+	db   *sql.DB
+	user string
+}
+
+func (b *localBackend) addExpense(req *cmd.AddRequest) error {
+	req.Expense.CreatedBy = b.user
+	if req.Expense.PaidBy == "" {
+		req.Expense.PaidBy = b.user
+	}
+	addResp := cmd.AddExpense(b.db, req)
+	if !addResp.Success {
+		return addResp.Error
+	}
+	return nil
+}
+
+func (b *localBackend) logExpenses(req *cmd.LogRequest) (*expensePage, error) {
+	logResp := cmd.LogExpenses(b.db, req)
+	if !logResp.Success {
+		return nil, logResp.Error
+	}
+	defer logResp.Result.Close()
+
+	expenses, err := cmd.ScanExpenses(logResp.Result, logResp.ShowId)
+	if err != nil {
+		return nil, fmt.Errorf("error reading retrieved expenses: %w", err)
+	}
+	return &expensePage{expenses: expenses, nextCursor: logResp.NextCursor, prevCursor: logResp.PrevCursor}, nil
+}
+
+func (b *localBackend) summarizeExpenses(req *cmd.SummaryRequest) ([]data.Summary, error) {
+	sumResp := cmd.SummarizeExpenses(b.db, req)
+	if !sumResp.Success {
+		return nil, sumResp.Error
+	}
+	defer sumResp.Result.Close()
+
+	summaries, err := req.ScanSummaries(sumResp)
+	if err != nil {
+		return nil, fmt.Errorf("error reading calculated summary: %w", err)
+	}
+	return summaries, nil
+}
+
+func (b *localBackend) deleteExpense(id int) error {
+	deleteResp := cmd.DeleteExpense(b.db, &cmd.DeleteRequest{Id: id})
+	if !deleteResp.Success {
+		return deleteResp.Error
+	}
+	return nil
+}
+
+func (b *localBackend) categories() ([]string, error) {
+	catResp := cmd.ExpenseCategory(b.db, &cmd.CategoryRequest{})
+	if !catResp.Success {
+		return nil, catResp.Error
+	}
+	defer catResp.Result.Close()
+
+	categories, err := scanCategories(catResp.Result)
+	if err != nil {
+		return nil, fmt.Errorf("error reading retrieved categories: %w", err)
+	}
+	return categories, nil
+}
+
+func (b *localBackend) changeCategory(req *cmd.CategoryRequest) (int, int, error) {
+	catResp := cmd.ExpenseCategory(b.db, req)
+	if !catResp.Success {
+		return 0, 0, catResp.Error
+	}
+	return catResp.Affected, catResp.AffectedRecurring, nil
+}
+
+func (b *localBackend) suggestions(location string) *cmd.SuggestionResponse {
+	return cmd.ExpenseSuggestions(b.db, &cmd.SuggestionRequest{Location: location})
+}
+
+// remoteBackend performs operations through the v2 API of a sage server.
+type remoteBackend struct {
+	client *client.Client
+}
+
+func (b *remoteBackend) addExpense(req *cmd.AddRequest) error {
+	date := req.Expense.Date.String()
+	amount := json.Number(strconv.FormatFloat(req.Expense.Amount.AsMajorUnits(), 'f', 2, 64))
+	// split expenses are always shared, as they are when added locally
+	shared := req.Expense.Shared || req.Split != nil
+	input := &client.ExpenseInput{
+		Date:        &date,
+		Location:    &req.Expense.Location,
+		Description: &req.Expense.Description,
+		Category:    &req.Expense.Category,
+		Amount:      &amount,
+		Shared:      &shared,
+	}
+	if req.Expense.PaidBy != "" {
+		input.PaidBy = &req.Expense.PaidBy
+	}
+	if req.Split != nil {
+		input.Split = &req.Split.Method
+		input.Participants = req.Split.Args()
+	}
+	_, err := b.client.CreateExpense(input)
+	return remoteError(err)
+}
+
+// logExpenses retrieves a single page if the request has a page size. Otherwise it follows the cursors through every
+// page, stopping at the request's limit, since the server pages expenses when no page size is given.
+func (b *remoteBackend) logExpenses(req *cmd.LogRequest) (*expensePage, error) {
+	q := expenseQuery(req)
+	if req.PageSize != 0 {
+		page, err := b.client.ListExpenses(q)
+		if err != nil {
+			return nil, remoteError(err)
+		}
+		return &expensePage{expenses: hideIds(page.Data, req.ShowId), nextCursor: page.NextCursor, prevCursor: page.PrevCursor}, nil
+	}
+
+	q.PageSize = cmd.MAX_PAGE_SIZE
+	var expenses []data.Expense
+	for {
+		page, err := b.client.ListExpenses(q)
+		if err != nil {
+			return nil, remoteError(err)
+		}
+		expenses = append(expenses, page.Data...)
+		if req.Limit != 0 && len(expenses) >= req.Limit {
+			expenses = expenses[:req.Limit]
+			break
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		q.Cursor = page.NextCursor
+	}
+	return &expensePage{expenses: hideIds(expenses, req.ShowId)}, nil
+}
+
+// summarizeExpenses retrieves a single page if the request has a page size. Otherwise it retrieves every page, stopping
+// at the request's limit, since the server pages summaries when no page size is given.
+func (b *remoteBackend) summarizeExpenses(req *cmd.SummaryRequest) ([]data.Summary, error) {
+	q := summaryQuery(req)
+	if req.PageSize != 0 {
+		summaries, err := b.client.ListSummaries(q)
+		return summaries, remoteError(err)
+	}
+
+	q.PageSize = cmd.MAX_PAGE_SIZE
+	var summaries []data.Summary
+	for q.Page = 1; ; q.Page++ {
+		page, err := b.client.ListSummaries(q)
+		if err != nil {
+			return nil, remoteError(err)
+		}
+		summaries = append(summaries, page...)
+		if req.Limit != 0 && len(summaries) >= req.Limit {
+			return summaries[:req.Limit], nil
+		}
+		if len(page) < q.PageSize {
+			return summaries, nil
+		}
+	}
+}
+
+func (b *remoteBackend) deleteExpense(id int) error {
+	return remoteError(b.client.DeleteExpense(id))
+}
+
+func (b *remoteBackend) categories() ([]string, error) {
+	categories, err := b.client.ListCategories()
+	if err != nil {
+		return nil, remoteError(err)
+	}
+	names := []string{}
+	for _, category := range categories {
+		names = append(names, category.Name)
+	}
+	return names, nil
+}
+
+func (b *remoteBackend) changeCategory(req *cmd.CategoryRequest) (int, int, error) {
+	var change *client.CategoryChange
+	var err error
+	switch req.Subcommand {
+	case "add":
+		_, err = b.client.CreateCategory(req.CategoryName)
+		return 0, 0, remoteError(err)
+	case "delete":
+		change, err = b.client.DeleteCategory(req.CategoryName, req.DryRun)
+	case "edit":
+		change, err = b.client.RenameCategory(req.CategoryName, req.NewCategoryName, req.DryRun)
+	default:
+		return 0, 0, fmt.Errorf("unknown category subcommand '%s'", req.Subcommand)
+	}
+	if err != nil {
+		return 0, 0, remoteError(err)
+	}
+	return change.Affected, change.AffectedRecurring, nil
+}
+
+// suggestions only completes categories, since the server doesn't provide the expense history's locations.
+func (b *remoteBackend) suggestions(location string) *cmd.SuggestionResponse {
+	categories, err := b.categories()
+	if err != nil {
+		return &cmd.SuggestionResponse{Success: false, Error: err}
+	}
+	return &cmd.SuggestionResponse{Success: true, Categories: categories}
+}
+
+// expenseQuery converts a log request into the query of the same expenses.
+func expenseQuery(req *cmd.LogRequest) *client.ExpenseQuery {
+	q := &client.ExpenseQuery{
+		Year:              req.Year,
+		Month:             req.Month,
+		PageSize:          req.PageSize,
+		Page:              req.Page,
+		Cursor:            req.Cursor,
+		Query:             req.Query,
+		Categories:        req.Categories,
+		ExcludeCategories: req.ExcludeCategories,
+		Uncategorized:     req.Uncategorized,
+		Location:          req.Location,
+		Who:               req.Who,
+	}
+	if !req.Start.IsZero() {
+		q.Start = req.Start.String()
+	}
+	if !req.End.IsZero() {
+		q.End = req.End.String()
+	}
+	if req.MinAmount != nil {
+		q.MinAmount = strconv.FormatFloat(req.MinAmount.AsMajorUnits(), 'f', 2, 64)
+	}
+	if req.MaxAmount != nil {
+		q.MaxAmount = strconv.FormatFloat(req.MaxAmount.AsMajorUnits(), 'f', 2, 64)
+	}
+	var sort []string
+	for _, field := range req.Sort {
+		if field.Desc {
+			sort = append(sort, "-"+field.Key)
+		} else {
+			sort = append(sort, field.Key)
+		}
+	}
+	q.Sort = strings.Join(sort, ",")
+	return q
+}
+
+// summaryQuery converts a summary request into the query of the same periods.
+func summaryQuery(req *cmd.SummaryRequest) *client.SummaryQuery {
+	q := &client.SummaryQuery{
+		Year:        req.Year,
+		PageSize:    req.PageSize,
+		Page:        req.Page,
+		Interval:    req.Interval,
+		FiscalStart: req.FiscalStartMonth,
+		Stats:       req.Stats,
+		Who:         req.Who,
+	}
+	if !req.Start.IsZero() {
+		q.Start = req.Start.String()
+	}
+	if !req.End.IsZero() {
+		q.End = req.End.String()
+	}
+	return q
+}
+
+// hideIds clears the IDs of expenses unless showId is set, as they are when retrieved from the database.
+func hideIds(expenses []data.Expense, showId bool) []data.Expense {
+	if !showId {
+		for i := range expenses {
+			expenses[i].Id = 0
+		}
+	}
+	return expenses
+}
+
+// remoteKindError is an error response from the server, which matches the cmd error of the same kind with errors.Is.
+type remoteKindError struct {
+	err *client.Error
+}
+
+func (e *remoteKindError) Error() string {
+	return e.err.Error()
+}
+
+func (e *remoteKindError) Unwrap() error {
+	return e.err
+}
+
+func (e *remoteKindError) Is(target error) bool {
+	switch e.err.Code {
+	case client.CODE_NOT_FOUND:
+		return target == cmd.ErrNotFound
+	case client.CODE_CONFLICT:
+		return target == cmd.ErrConflict
+	case client.CODE_UNKNOWN_CATEGORY:
+		return target == cmd.ErrUnknownCategory
+	case client.CODE_UNKNOWN_USER:
+		return target == cmd.ErrUnknownUser
+	case client.CODE_UNAUTHORIZED:
+		return target == cmd.ErrUnauthorized
+	case client.CODE_VALIDATION_FAILED:
+		return target == cmd.ErrInvalid
+	}
+	return false
+}
+
+// remoteError returns an error from the client that can be tested for the kinds of errors cmd returns. Returns nil if
+// err is nil.
+func remoteError(err error) error {
+	var apiErr *client.Error
+	if errors.As(err, &apiErr) {
+		return &remoteKindError{err: apiErr}
+	}
+	return err
+}
@@ -0,0 +1,1107 @@
+package controller
+
+import (
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"sage/src/sage/chart"
+	"sage/src/sage/cmd"
+	"sage/src/sage/data"
+	"sage/src/sage/dates"
+	"sage/src/sage/output"
+	"sage/src/sage/server"
+	"sage/src/sage/tui"
+	"slices"
+	"strconv"
+	"strings"
+
+	"cloud.google.com/go/civil"
+	"github.com/Rhymond/go-money"
+)
+
+// commands lists every CLI command in the order they are shown in help.
+var commands []*command
+
+func init() {
+	commands = []*command{
+		{
+			name:        "add",
+			usage:       "<date> <location> <description> <category> <amount> | -i",
+			description: "Add an expense. Pass an empty category to leave it uncategorized.",
+			define:      defineAdd,
+		},
+		{
+			name:        "log",
+			usage:       "[flags]",
+			description: "List expenses, oldest first unless sorted otherwise.",
+			define:      defineLog,
+		},
+		{
+			name:        "summary",
+			usage:       "[flags]",
+			description: "Total expenses over each period of an interval, or chart them.",
+			define:      defineSummary,
+		},
+		{
+			name:        "compare",
+			usage:       "[flags]",
+			description: "Compare spending by category between two periods.",
+			define:      defineCompare,
+		},
+		{
+			name:        "anomalies",
+			usage:       "[flags]",
+			description: "Flag unusual expenses and category totals.",
+			define:      defineAnomalies,
+		},
+		{
+			name:        "forecast",
+			usage:       "[flags]",
+			description: "Project spending for the rest of this month and the coming months.",
+			define:      defineForecast,
+		},
+		{
+			name:        "subscriptions",
+			usage:       "list [--all] | adopt <location>",
+			description: "List subscriptions detected in the expense history, or adopt one as a recurring expense.",
+			subcommands: []string{"list", "adopt"},
+			define:      defineSubscriptions,
+		},
+		{
+			name:        "edit",
+			usage:       "<id> [flags]",
+			description: "Change the fields of an expense by its ID, as shown by 'sage log --show-id'.",
+			define:      defineEdit,
+		},
+		{
+			name:        "tui",
+			usage:       "",
+			description: "Browse, filter, edit and delete expenses in a full-screen terminal UI.",
+			define:      defineTUI,
+		},
+		{
+			name:        "delete",
+			usage:       "<id>",
+			description: "Delete an expense by its ID, as shown by 'sage log --show-id'.",
+			define:      defineDelete,
+		},
+		{
+			name:        "category",
+			usage:       "[add <category> | delete [--dry-run] <category> | edit [--dry-run] <category> <new-category>]",
+			description: "List, add, delete, or rename categories. Deleting a category uncategorizes its expenses.",
+			subcommands: []string{"add", "delete", "edit"},
+			define:      defineCategory,
+		},
+		{
+			name:        "server",
+			usage:       "[--addr <address>] [--no-auth] [--allow-origin <origin>]",
+			description: "Run the HTTP server used by sage-ui. Requests need a token from 'sage token create' unless --no-auth is given.",
+			define:      defineServer,
+		},
+		{
+			name:        "token",
+			usage:       "create <name> [--scope <scopes>] [--user <name>] | list | revoke <id>",
+			description: "Create, list or revoke the personal access tokens that authenticate requests to the server.",
+			subcommands: []string{"create", "list", "revoke"},
+			define:      defineToken,
+		},
+		{
+			name:        "webhook",
+			usage:       "add <url> [--events <events>] [--secret <secret>] | list | test <id> | log <id> | delete <id>",
+			description: "Add, list or delete the webhooks sent changes to expenses made through the server, send one a test event, or show its deliveries.",
+			subcommands: []string{"add", "list", "test", "log", "delete"},
+			define:      defineWebhook,
+		},
+		{
+			name:        "user",
+			usage:       "add [--no-login] <name> | list | delete <name> | passwd <name>",
+			description: "Add, list or delete the users sharing the ledger, or set the password they log in to the server with.",
+			subcommands: []string{"add", "list", "delete", "passwd"},
+			define:      defineUser,
+		},
+		{
+			name:        "settle-up",
+			usage:       "[flags]",
+			description: "Show what each user paid towards shared expenses, and who owes whom to settle up.",
+			define:      defineSettleUp,
+		},
+		{
+			name:        "settle",
+			usage:       "<from> <to> <amount> [--date <date>] | list",
+			description: "Record a payment from one user to another that settles up shared expenses, or list the payments recorded.",
+			subcommands: []string{"list"},
+			define:      defineSettle,
+		},
+		{
+			name:        "completion",
+			usage:       "bash|zsh|fish",
+			description: "Print a shell completion script. For example, add 'source <(sage completion bash)' to ~/.bashrc.",
+			subcommands: completionShells,
+			define:      defineCompletion,
+		},
+		{
+			name:        COMPLETE_COMMAND,
+			usage:       "<words>...",
+			description: "Print the completions for a partial command line.",
+			hidden:      true,
+			rawArgs:     true,
+			define:      defineComplete,
+		},
+	}
+}
+
+func defineAdd(fs *flag.FlagSet) runFunc {
+	interactive := fs.Bool("i", false, "prompt for each field, which is also the default when no fields are given")
+	paidBy := fs.String("paid-by", "", "user who paid for the expense (default $"+USER_ENV+", or the user authenticated with --remote)")
+	shared := fs.Bool("shared", false, "split the expense between every user when settling up")
+	split := fs.String("split", "", "split the expense between the --with participants: equal, percent or exact")
+	var with listFlag
+	fs.Var(&with, "with", "participants of the split, such as alice,bob or alice:60,bob:40 (repeatable)")
+	return func(ctx *cliContext, args []string) (*output.View, error) {
+		if *interactive && len(args) != 0 {
+			return nil, usageErrorf("cannot provide fields with -i")
+		}
+		if !*interactive && len(args) != 0 && len(args) != 5 {
+			return nil, usageErrorf("expected 5 fields but got %d", len(args))
+		}
+
+		b, err := ctx.backend()
+		if err != nil {
+			return nil, err
+		}
+
+		var addReq *cmd.AddRequest
+		if len(args) == 0 {
+			p := newPrompter(ctx.stdin, ctx.stderr)
+			addReq, err = promptAddRequest(p, dates.Today(), b.suggestions)
+			if err != nil {
+				return nil, err
+			}
+			if addReq == nil {
+				return output.Message("Expense not added"), nil
+			}
+		} else {
+			addReq, err = parseAddRequest(args)
+			if err != nil {
+				return nil, &usageError{err: err}
+			}
+		}
+		addReq.Expense.PaidBy = strings.TrimSpace(*paidBy)
+		addReq.Expense.Shared = *shared
+		if *split != "" || len(with) != 0 {
+			if addReq.Split, err = cmd.ParseSplit(*split, with); err != nil {
+				return nil, &usageError{err: err}
+			}
+		}
+
+		if err := b.addExpense(addReq); err != nil {
+			if errors.Is(err, cmd.ErrUnknownCategory) {
+				return nil, errors.New("error adding expense: category does not exist")
+			}
+			if errors.Is(err, cmd.ErrUnknownUser) {
+				return nil, errors.New("error adding expense: user does not exist")
+			}
+			return nil, fmt.Errorf("error adding expense: %w", err)
+		}
+		return output.Message("Expense added successfully"), nil
+	}
+}
+
+// promptAddRequest asks for each field of an expense in turn, asking again whenever an answer is invalid. The date
+// defaults to today, and the location and category are completed from the suggestions for the expense history, with
+// the category defaulting to the one most often used at the location. Returns nil without an error if the expense
+// isn't confirmed.
+func promptAddRequest(p *prompter, today civil.Date, suggest func(location string) *cmd.SuggestionResponse) (*cmd.AddRequest, error) {
+	suggestions := suggest("")
+	if !suggestions.Success {
+		return nil, fmt.Errorf("error retrieving suggestions: %w", suggestions.Error)
+	}
+
+	var expense data.Expense
+	for {
+		answer, err := p.ask("Date", today.String(), nil)
+		if err != nil {
+			return nil, err
+		}
+		if expense.Date, err = dates.Parse(answer); err == nil {
+			break
+		}
+		fmt.Fprintln(p.out, err)
+	}
+
+	for expense.Location == "" {
+		answer, err := p.ask("Location", "", suggestions.Locations)
+		if err != nil {
+			return nil, err
+		}
+		// spell a known location the way it appears in the history
+		if i := slices.IndexFunc(suggestions.Locations, func(l string) bool { return strings.EqualFold(l, answer) }); i != -1 {
+			answer = suggestions.Locations[i]
+		}
+		expense.Location = answer
+	}
+
+	answer, err := p.ask("Description", "", nil)
+	if err != nil {
+		return nil, err
+	}
+	expense.Description = answer
+
+	suggestions = suggest(expense.Location)
+	if !suggestions.Success {
+		return nil, fmt.Errorf("error retrieving suggestions: %w", suggestions.Error)
+	}
+	categories := append(suggestions.Categories, cmd.DisplayCategory(""))
+	for {
+		answer, err := p.ask("Category", cmd.DisplayCategory(suggestions.Category), categories)
+		if err != nil {
+			return nil, err
+		}
+		if i := slices.IndexFunc(categories, func(c string) bool { return strings.EqualFold(c, answer) }); i != -1 {
+			expense.Category = categories[i]
+			if i == len(categories)-1 {
+				expense.Category = ""
+			}
+			break
+		}
+		fmt.Fprintf(p.out, "Category '%s' does not exist\n", answer)
+	}
+
+	for {
+		answer, err := p.ask("Amount", "", nil)
+		if err != nil {
+			return nil, err
+		}
+		fl, err := strconv.ParseFloat(strings.TrimPrefix(answer, "$"), 64)
+		if err == nil {
+			expense.Amount = money.NewFromFloat(fl, money.USD)
+			break
+		}
+		fmt.Fprintln(p.out, "Amount must be a number")
+	}
+
+	fmt.Fprintf(p.out, "%s | %s | %s | %s | $%.2f\n", expense.Date, expense.Location, expense.Description,
+		cmd.DisplayCategory(expense.Category), expense.Amount.AsMajorUnits())
+	ok, err := p.confirm("Add this expense?")
+	if err != nil || !ok {
+		return nil, err
+	}
+	return &cmd.AddRequest{Expense: expense}, nil
+}
+
+// parseAddRequest takes a list of provided fields and constructs the appropriate AddRequest. Assumes 5 fields are provided.
+func parseAddRequest(args []string) (*cmd.AddRequest, error) {
+	return cmd.ParseAddArgs(args[0], args[1], args[2], args[3], args[4])
+}
+
+func defineLog(fs *flag.FlagSet) runFunc {
+	startStr := fs.String("start", "", "start date, such as 2026-10-01, yesterday, -2w, or the first day of a range such as last-month")
+	endStr := fs.String("end", "", "end date, or the last day of a range")
+	rangeStr := fs.String("range", "", "named range of dates, such as this-month, last-quarter, ytd or last-30-days")
+	year := fs.Int("year", 0, "year")
+	month := fs.Int("month", 0, "month")
+	limit := fs.Int("n", 0, "limit")
+	pageSize := fs.Int("page-size", 0, "page size")
+	page := fs.Int("page", 0, "page")
+	cursor := fs.String("cursor", "", "cursor of the page to show")
+	sort := fs.String("sort", "", "comma-separated sort keys (date, amount, location, category, created), prefixed with - for descending")
+	showId := fs.Bool("show-id", false, "show the expense ID")
+	query := fs.String("query", "", "search query")
+	minAmount := fs.String("min-amount", "", "minimum amount")
+	maxAmount := fs.String("max-amount", "", "maximum amount")
+	var categories, excludeCategories listFlag
+	fs.Var(&categories, "category", "only include these categories (comma-separated or repeated)")
+	fs.Var(&excludeCategories, "exclude-category", "exclude these categories (comma-separated or repeated)")
+	uncategorized := fs.Bool("uncategorized", false, "include uncategorized expenses")
+	location := fs.String("location", "", "location")
+	who := fs.String("who", "", "only include expenses paid for by this user")
+
+	return func(ctx *cliContext, args []string) (*output.View, error) {
+		if len(args) != 0 {
+			return nil, usageErrorf("unexpected argument '%s'", args[0])
+		}
+		start, end, err := cmd.RangeArgs(*rangeStr, *startStr, *endStr)
+		if err != nil {
+			return nil, &usageError{err: err}
+		}
+		logReq, err := cmd.ParseLogArgs(start, end, *year, *month, *limit, *pageSize, *page, *cursor, *sort, *showId, *query, cmd.FilterArgs{
+			MinAmount:         *minAmount,
+			MaxAmount:         *maxAmount,
+			Categories:        categories,
+			ExcludeCategories: excludeCategories,
+			Uncategorized:     *uncategorized,
+			Location:          *location,
+			Who:               *who,
+		})
+		if err != nil {
+			return nil, &usageError{err: err}
+		}
+
+		b, err := ctx.backend()
+		if err != nil {
+			return nil, err
+		}
+		page, err := b.logExpenses(logReq)
+		if err != nil {
+			return nil, fmt.Errorf("error logging expenses: %w", err)
+		}
+		if ctx.output != output.JSON {
+			if page.prevCursor != "" {
+				fmt.Fprintln(ctx.stderr, "Previous page: --cursor", page.prevCursor)
+			}
+			if page.nextCursor != "" {
+				fmt.Fprintln(ctx.stderr, "Next page: --cursor", page.nextCursor)
+			}
+		}
+		return expenseView(page, logReq.ShowId), nil
+	}
+}
+
+// listFlag is a flag.Value that collects a list of strings from comma-separated and/or repeated flags.
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listFlag) Set(value string) error {
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			*l = append(*l, item)
+		}
+	}
+	return nil
+}
+
+func defineSummary(fs *flag.FlagSet) runFunc {
+	startStr := fs.String("start", "", "start date, such as 2026-10-01, yesterday, -2w, or the first day of a range such as last-month")
+	endStr := fs.String("end", "", "end date, or the last day of a range")
+	rangeStr := fs.String("range", "", "named range of dates, such as this-month, last-quarter, ytd or last-30-days")
+	year := fs.Int("year", 0, "year")
+	limit := fs.Int("n", 0, "limit")
+	pageSize := fs.Int("page-size", 0, "page size")
+	page := fs.Int("page", 0, "page")
+	interval := fs.String("interval", cmd.DEFAULT_SUMMARY_INTERVAL, "interval to total expenses over (day, week, month, quarter, year, fiscal-year)")
+	fiscalStart := fs.Int("fiscal-start", 0, "month fiscal years start in")
+	stats := fs.Bool("stats", false, "show statistics for each period")
+	chartType := fs.String("chart", "", "chart to draw instead of listing totals (bars, sparkline, heatmap), which needs the local database")
+	who := fs.String("who", "", "only include expenses paid for by this user")
+
+	return func(ctx *cliContext, args []string) (*output.View, error) {
+		if len(args) != 0 {
+			return nil, usageErrorf("unexpected argument '%s'", args[0])
+		}
+		if *chartType != "" && !slices.Contains(chartTypes, *chartType) {
+			return nil, usageErrorf("chart must be bars, sparkline or heatmap")
+		}
+		if *chartType != "" && *stats {
+			return nil, usageErrorf("cannot show statistics in a chart")
+		}
+		if *chartType != "" && ctx.output != output.PLAIN && ctx.output != output.TABLE {
+			return nil, usageErrorf("cannot draw a chart as %s", ctx.output)
+		}
+		if *chartType != "" && ctx.remote != "" {
+			return nil, usageErrorf("cannot draw a chart with --remote")
+		}
+		start, end, err := cmd.RangeArgs(*rangeStr, *startStr, *endStr)
+		if err != nil {
+			return nil, &usageError{err: err}
+		}
+		sumReq, err := cmd.ParseSummaryArgs(start, end, *year, *limit, *pageSize, *page, *interval, *fiscalStart, *stats)
+		if err != nil {
+			return nil, &usageError{err: err}
+		}
+		sumReq.Who = *who
+
+		if *chartType != "" {
+			db, err := ctx.DB()
+			if err != nil {
+				return nil, err
+			}
+			lines, err := chartLines(db, sumReq, *chartType)
+			if err != nil {
+				return nil, fmt.Errorf("error charting expenses: %w", err)
+			}
+			return &output.View{Lines: lines}, nil
+		}
+
+		b, err := ctx.backend()
+		if err != nil {
+			return nil, err
+		}
+		summaries, err := b.summarizeExpenses(sumReq)
+		if err != nil {
+			return nil, fmt.Errorf("error summarizing expenses: %w", err)
+		}
+		return summaryView(summaries, sumReq.Stats), nil
+	}
+}
+
+var chartTypes = []string{"bars", "sparkline", "heatmap"}
+
+// chartLines draws a chart of the expenses matching a summary request. bars breaks spending down by category,
+// sparkline shows the trend across the summary's periods, and heatmap shows a calendar of daily spending.
+func chartLines(db *sql.DB, sumReq *cmd.SummaryRequest, chartType string) ([]string, error) {
+	c := chart.New()
+
+	switch chartType {
+	case "bars":
+		catResp := cmd.SummarizeCategories(db, &cmd.CategorySummaryRequest{ExpenseFilter: sumReq.ExpenseFilter})
+		if !catResp.Success {
+			return nil, catResp.Error
+		}
+		defer catResp.Result.Close()
+
+		var labels []string
+		var totals []int64
+		for catResp.Result.Next() {
+			var category string
+			var total money.Amount
+			var count int
+			if err := catResp.Result.Scan(&category, &total, &count); err != nil {
+				return nil, err
+			}
+			labels = append(labels, cmd.DisplayCategory(category))
+			totals = append(totals, total)
+		}
+		if err := catResp.Result.Err(); err != nil {
+			return nil, err
+		}
+		return c.Bars(labels, totals), nil
+	case "sparkline":
+		periods, totals, err := summaryTotals(db, sumReq)
+		if err != nil || len(periods) == 0 {
+			return nil, err
+		}
+		lowest, highest := slices.Min(totals), slices.Max(totals)
+		// leave room for the period labels and range on either side of the sparkline
+		label := fmt.Sprintf(" %s (low $%.2f, high $%.2f)", periods[len(periods)-1], float64(lowest)/100, float64(highest)/100)
+		c.Width = max(c.Width-len(periods[0])-len(label)-1, 1)
+		return []string{fmt.Sprintf("%s %s%s", periods[max(len(periods)-c.Width, 0)], c.Sparkline(totals), label)}, nil
+	default:
+		dayReq := *sumReq
+		dayReq.Interval = "day"
+		dayReq.FiscalStartMonth = 0
+		dayReq.Limit, dayReq.PageSize, dayReq.Page = 0, 0, 0
+		days, totals, err := summaryTotals(db, &dayReq)
+		if err != nil || len(days) == 0 {
+			return nil, err
+		}
+		start, err := civil.ParseDate(days[0])
+		if err != nil {
+			return nil, err
+		}
+		return c.Heatmap(start, totals), nil
+	}
+}
+
+// summaryTotals returns the periods of a summary in order, along with the total spent in each.
+func summaryTotals(db *sql.DB, sumReq *cmd.SummaryRequest) ([]string, []int64, error) {
+	sumResp := cmd.SummarizeExpenses(db, sumReq)
+	if !sumResp.Success {
+		return nil, nil, sumResp.Error
+	}
+	defer sumResp.Result.Close()
+
+	var periods []string
+	var totals []int64
+	for sumResp.Result.Next() {
+		var period string
+		var total money.Amount
+		if err := sumResp.Result.Scan(&period, &total); err != nil {
+			return nil, nil, err
+		}
+		periods = append(periods, period)
+		totals = append(totals, total)
+	}
+	return periods, totals, sumResp.Result.Err()
+}
+
+func defineCompare(fs *flag.FlagSet) runFunc {
+	current := fs.String("current", "", "period to compare (year, month, quarter, or <date>..<date>)")
+	baseline := fs.String("baseline", "", "period to compare against, or last-year, previous, or average")
+	months := fs.Int("months", 0, "number of months to average over for the average baseline")
+	movers := fs.Int("movers", 0, "number of biggest movers to show")
+
+	return func(ctx *cliContext, args []string) (*output.View, error) {
+		if len(args) != 0 {
+			return nil, usageErrorf("unexpected argument '%s'", args[0])
+		}
+		compareReq, err := cmd.ParseCompareArgs(*current, *baseline, *months, *movers)
+		if err != nil {
+			return nil, &usageError{err: err}
+		}
+
+		db, err := ctx.DB()
+		if err != nil {
+			return nil, err
+		}
+		compareResp := cmd.CompareExpenses(db, compareReq)
+		if !compareResp.Success {
+			return nil, fmt.Errorf("error comparing expenses: %w", compareResp.Error)
+		}
+		return comparisonView(compareResp.Result), nil
+	}
+}
+
+func defineAnomalies(fs *flag.FlagSet) runFunc {
+	since := fs.String("since", "", "only flag expenses on or after this date (defaults to 30 days ago)")
+	sensitivity := fs.String("sensitivity", cmd.DEFAULT_ANOMALY_SENSITIVITY, "how readily to flag anomalies (low, medium, high)")
+
+	return func(ctx *cliContext, args []string) (*output.View, error) {
+		if len(args) != 0 {
+			return nil, usageErrorf("unexpected argument '%s'", args[0])
+		}
+		anomalyReq, err := cmd.ParseAnomalyArgs(*since, *sensitivity)
+		if err != nil {
+			return nil, &usageError{err: err}
+		}
+
+		db, err := ctx.DB()
+		if err != nil {
+			return nil, err
+		}
+		anomalyResp := cmd.DetectAnomalies(db, anomalyReq)
+		if !anomalyResp.Success {
+			return nil, fmt.Errorf("error detecting anomalies: %w", anomalyResp.Error)
+		}
+		return anomalyView(anomalyResp.Result), nil
+	}
+}
+
+func defineForecast(fs *flag.FlagSet) runFunc {
+	months := fs.Int("months", cmd.DEFAULT_FORECAST_MONTHS, "number of months to forecast after the current one")
+	lookback := fs.Int("lookback", cmd.DEFAULT_LOOKBACK_MONTHS, "number of previous months to base the forecast on")
+
+	return func(ctx *cliContext, args []string) (*output.View, error) {
+		if len(args) != 0 {
+			return nil, usageErrorf("unexpected argument '%s'", args[0])
+		}
+		forecastReq, err := cmd.ParseForecastArgs(*months, *lookback)
+		if err != nil {
+			return nil, &usageError{err: err}
+		}
+
+		db, err := ctx.DB()
+		if err != nil {
+			return nil, err
+		}
+		forecastResp := cmd.ForecastExpenses(db, forecastReq)
+		if !forecastResp.Success {
+			return nil, fmt.Errorf("error forecasting expenses: %w", forecastResp.Error)
+		}
+		return forecastView(forecastResp.Result), nil
+	}
+}
+
+func defineSubscriptions(fs *flag.FlagSet) runFunc {
+	all := fs.Bool("all", false, "include subscriptions that are no longer charged")
+
+	return func(ctx *cliContext, args []string) (*output.View, error) {
+		if len(args) == 0 {
+			return nil, usageErrorf("must provide a subcommand")
+		}
+		if len(args) > 2 {
+			return nil, usageErrorf("too many fields provided")
+		}
+		location := ""
+		if len(args) == 2 {
+			location = args[1]
+		}
+		subReq, err := cmd.ParseSubscriptionArgs(args[0], location, *all)
+		if err != nil {
+			return nil, &usageError{err: err}
+		}
+
+		db, err := ctx.DB()
+		if err != nil {
+			return nil, err
+		}
+		subResp := cmd.ExpenseSubscriptions(db, subReq)
+		if !subResp.Success {
+			return nil, fmt.Errorf("error with subscriptions request: %w", subResp.Error)
+		}
+		if subResp.Subcommand == "adopt" {
+			return &output.View{
+				Lines: []string{fmt.Sprintf("Subscription at %s adopted as a recurring expense, next on %s", subResp.Adopted.Location, subResp.Adopted.NextDate)},
+				JSON:  map[string]any{"result": subResp.Adopted},
+			}, nil
+		}
+		return subscriptionView(subResp.Result), nil
+	}
+}
+
+func defineEdit(fs *flag.FlagSet) runFunc {
+	var editArgs cmd.EditArgs
+	stringVar := func(field **string, name, usage string) {
+		fs.Func(name, usage, func(value string) error {
+			*field = &value
+			return nil
+		})
+	}
+	stringVar(&editArgs.Date, "date", "new date")
+	stringVar(&editArgs.Location, "location", "new location")
+	stringVar(&editArgs.Description, "description", "new description")
+	stringVar(&editArgs.Category, "category", "new category, or \"\" to uncategorize")
+	stringVar(&editArgs.Amount, "amount", "new amount")
+	stringVar(&editArgs.PaidBy, "paid-by", "new user who paid, or \"\" to unattribute")
+	stringVar(&editArgs.Shared, "shared", "whether to split the expense between every user (true or false)")
+	stringVar(&editArgs.Split, "split", "new split between the --with participants: equal, percent or exact, or none to split between every user")
+	fs.Var((*listFlag)(&editArgs.With), "with", "new participants of the split, such as alice,bob or alice:60,bob:40 (repeatable)")
+	return func(ctx *cliContext, args []string) (*output.View, error) {
+		if len(args) != 1 {
+			return nil, usageErrorf("must provide exactly one ID to edit")
+		}
+		id, err := strconv.Atoi(args[0])
+		if err != nil {
+			return nil, usageErrorf("invalid ID provided: %s", args[0])
+		}
+		editReq, err := cmd.ParseEditArgs(id, editArgs)
+		if err != nil {
+			return nil, &usageError{err: err}
+		}
+
+		db, err := ctx.DB()
+		if err != nil {
+			return nil, err
+		}
+		editResp := cmd.EditExpense(db, editReq)
+		if !editResp.Success {
+			if errors.Is(editResp.Error, cmd.ErrUnknownCategory) {
+				return nil, errors.New("error editing expense: category does not exist")
+			}
+			if errors.Is(editResp.Error, cmd.ErrUnknownUser) {
+				return nil, errors.New("error editing expense: user does not exist")
+			}
+			return nil, fmt.Errorf("error editing expense: %w", editResp.Error)
+		}
+		return output.Message("Expense edited successfully"), nil
+	}
+}
+
+func defineDelete(fs *flag.FlagSet) runFunc {
+	return func(ctx *cliContext, args []string) (*output.View, error) {
+		if len(args) == 0 {
+			return nil, usageErrorf("need to provide an ID to delete")
+		}
+		if len(args) > 1 {
+			return nil, usageErrorf("can only delete one ID at a time")
+		}
+		id, err := strconv.Atoi(args[0])
+		if err != nil {
+			return nil, usageErrorf("invalid ID provided: %s", args[0])
+		}
+
+		b, err := ctx.backend()
+		if err != nil {
+			return nil, err
+		}
+		if err := b.deleteExpense(id); err != nil {
+			return nil, fmt.Errorf("error deleting expense: %w", err)
+		}
+		return output.Message("Expense deleted successfully"), nil
+	}
+}
+
+func defineCategory(fs *flag.FlagSet) runFunc {
+	dryRun := fs.Bool("dry-run", false, "show how many expenses a delete or edit would affect without changing anything")
+
+	return func(ctx *cliContext, args []string) (*output.View, error) {
+		catReq := &cmd.CategoryRequest{DryRun: *dryRun}
+		switch {
+		case len(args) == 0:
+		case len(args) == 2 && (args[0] == "add" || args[0] == "delete"):
+			catReq.Subcommand = args[0]
+			catReq.CategoryName = args[1]
+		case len(args) == 3 && args[0] == "edit":
+			catReq.Subcommand = args[0]
+			catReq.CategoryName = args[1]
+			catReq.NewCategoryName = args[2]
+		default:
+			return nil, usageErrorf("invalid subcommand or number of fields provided")
+		}
+		if catReq.DryRun && catReq.Subcommand != "delete" && catReq.Subcommand != "edit" {
+			return nil, usageErrorf("--dry-run only applies to delete and edit")
+		}
+
+		b, err := ctx.backend()
+		if err != nil {
+			return nil, err
+		}
+		if catReq.Subcommand == "" {
+			categories, err := b.categories()
+			if err != nil {
+				return nil, fmt.Errorf("error with category request: %w", err)
+			}
+			return categoryView(categories), nil
+		}
+
+		affected, affectedRecurring, err := b.changeCategory(catReq)
+		if err != nil {
+			return nil, fmt.Errorf("error with category request: %w", err)
+		}
+		counts := expenseCount(affected)
+		if affectedRecurring != 0 {
+			counts += " and " + recurringCount(affectedRecurring)
+		}
+		switch {
+		case catReq.Subcommand == "delete" && catReq.DryRun:
+			return output.Message(fmt.Sprintf("Deleting %s would uncategorize %s", catReq.CategoryName, counts)), nil
+		case catReq.Subcommand == "delete":
+			return output.Message(fmt.Sprintf("Category successfully deleted, uncategorizing %s", counts)), nil
+		case catReq.Subcommand == "edit" && catReq.DryRun:
+			return output.Message(fmt.Sprintf("Changing %s to %s would move %s", catReq.CategoryName, catReq.NewCategoryName, counts)), nil
+		case catReq.Subcommand == "edit":
+			return output.Message(fmt.Sprintf("Category successfully changed from %s to %s, moving %s", catReq.CategoryName, catReq.NewCategoryName, counts)), nil
+		}
+		return output.Message("Category successfully added"), nil
+	}
+}
+
+// expenseCount describes a number of expenses, such as "1 expense" or "3 expenses".
+func expenseCount(n int) string {
+	if n == 1 {
+		return "1 expense"
+	}
+	return fmt.Sprintf("%d expenses", n)
+}
+
+// recurringCount describes a number of recurring expenses, such as "1 recurring expense".
+func recurringCount(n int) string {
+	if n == 1 {
+		return "1 recurring expense"
+	}
+	return fmt.Sprintf("%d recurring expenses", n)
+}
+
+// scanCategories reads every category name from the rows of a CategoryResponse.
+func scanCategories(rows *sql.Rows) ([]string, error) {
+	categories := []string{}
+	var category string
+	for rows.Next() {
+		if err := rows.Scan(&category); err != nil {
+			return nil, err
+		}
+		categories = append(categories, category)
+	}
+	return categories, rows.Err()
+}
+
+func defineServer(fs *flag.FlagSet) runFunc {
+	addr := fs.String("addr", "", "address to listen on (default \""+server.DEFAULT_ADDR+"\", or \""+server.DEFAULT_NO_AUTH_ADDR+"\" with --no-auth)")
+	noAuth := fs.Bool("no-auth", false, "serve without requiring a token, for development; only allowed on localhost")
+	var origins listFlag
+	fs.Var(&origins, "allow-origin", "origins browsers may call the server from (comma-separated or repeated)")
+
+	return func(ctx *cliContext, args []string) (*output.View, error) {
+		if len(args) != 0 {
+			return nil, usageErrorf("unexpected argument '%s'", args[0])
+		}
+		cfg := server.Config{Addr: *addr, NoAuth: *noAuth, AllowedOrigins: origins}
+		if err := server.RunServer(cfg); err != nil {
+			return nil, fmt.Errorf("error running server: %w", err)
+		}
+		return nil, nil
+	}
+}
+
+func defineToken(fs *flag.FlagSet) runFunc {
+	scopes := fs.String("scope", cmd.SCOPE_READ, "comma-separated scopes to grant a created token (read, write, admin)")
+	user := fs.String("user", "", "user that requests made with a created token act as")
+
+	return func(ctx *cliContext, args []string) (*output.View, error) {
+		if len(args) == 0 {
+			return nil, usageErrorf("must provide a subcommand")
+		}
+		var name string
+		var id int
+		switch {
+		case args[0] == "create" && len(args) == 2:
+			name = args[1]
+		case args[0] == "revoke" && len(args) == 2:
+			var err error
+			if id, err = strconv.Atoi(args[1]); err != nil {
+				return nil, usageErrorf("invalid ID provided: %s", args[1])
+			}
+		case args[0] == "list" && len(args) == 1:
+		case len(args) > 2:
+			return nil, usageErrorf("too many fields provided")
+		}
+		tokenReq, err := cmd.ParseTokenArgs(args[0], name, *scopes, *user, id)
+		if err != nil {
+			return nil, &usageError{err: err}
+		}
+
+		db, err := ctx.DB()
+		if err != nil {
+			return nil, err
+		}
+		tokenResp := cmd.APITokens(db, tokenReq)
+		if !tokenResp.Success {
+			return nil, fmt.Errorf("error with token request: %w", tokenResp.Error)
+		}
+		switch tokenResp.Subcommand {
+		case "create":
+			return &output.View{
+				Lines: []string{
+					tokenResp.Token,
+					fmt.Sprintf("Token %d created with %s access. Store it now, since it can't be shown again.", tokenResp.Created.Id, strings.Join(tokenResp.Created.Scopes, ", ")),
+				},
+				JSON: map[string]any{"token": tokenResp.Token, "result": tokenResp.Created},
+			}, nil
+		case "revoke":
+			return output.Message("Token successfully revoked"), nil
+		}
+		return tokenView(tokenResp.Result), nil
+	}
+}
+
+func defineWebhook(fs *flag.FlagSet) runFunc {
+	events := fs.String("events", "", "comma-separated events to send an added webhook (default "+strings.Join(cmd.WebhookEvents, ",")+")")
+	secret := fs.String("secret", "", "secret to sign deliveries to an added webhook with (default a random secret)")
+
+	return func(ctx *cliContext, args []string) (*output.View, error) {
+		if len(args) == 0 {
+			args = []string{"list"}
+		}
+		var rawURL string
+		var id int
+		switch {
+		case len(args) > 2:
+			return nil, usageErrorf("too many fields provided")
+		case args[0] == "add" && len(args) == 2:
+			rawURL = args[1]
+		case args[0] != "add" && len(args) == 2:
+			var err error
+			if id, err = strconv.Atoi(args[1]); err != nil {
+				return nil, usageErrorf("invalid ID provided: %s", args[1])
+			}
+		}
+		if args[0] == "test" {
+			if *events != "" || *secret != "" {
+				return nil, usageErrorf("can only provide events and a secret when adding a webhook")
+			}
+			if id <= 0 {
+				return nil, usageErrorf("must provide the ID of the webhook to test")
+			}
+			db, err := ctx.DB()
+			if err != nil {
+				return nil, err
+			}
+			return testWebhook(db, id)
+		}
+		webhookReq, err := cmd.ParseWebhookArgs(args[0], rawURL, *events, *secret, id)
+		if err != nil {
+			return nil, &usageError{err: err}
+		}
+
+		db, err := ctx.DB()
+		if err != nil {
+			return nil, err
+		}
+		webhookResp := cmd.Webhooks(db, webhookReq)
+		if !webhookResp.Success {
+			return nil, fmt.Errorf("error with webhook request: %w", webhookResp.Error)
+		}
+		switch webhookResp.Subcommand {
+		case "add":
+			return &output.View{
+				Lines: []string{
+					webhookResp.Created.Secret,
+					fmt.Sprintf("Webhook %d added for %s. Deliveries are signed with the secret above.", webhookResp.Created.Id,
+						strings.Join(webhookResp.Created.Events, ", ")),
+				},
+				JSON: map[string]any{"result": webhookResp.Created},
+			}, nil
+		case "delete":
+			return output.Message("Webhook successfully deleted"), nil
+		case "log":
+			return deliveryView(webhookResp.Deliveries), nil
+		}
+		return webhookView(webhookResp.Result), nil
+	}
+}
+
+// testWebhook sends a test event to a webhook, failing if it isn't received.
+func testWebhook(db *sql.DB, id int) (*output.View, error) {
+	webhook, err := cmd.GetWebhook(db, id)
+	if err != nil {
+		return nil, fmt.Errorf("error testing webhook: %w", err)
+	}
+	delivery, err := cmd.DeliverWebhook(db, &http.Client{Timeout: cmd.WEBHOOK_TIMEOUT}, *webhook, cmd.WebhookTestChange(), 1)
+	if err != nil {
+		return nil, fmt.Errorf("error testing webhook: %w", err)
+	}
+	if !delivery.Succeeded {
+		return nil, fmt.Errorf("error testing webhook: %s", delivery.Error)
+	}
+	return &output.View{
+		Lines: []string{fmt.Sprintf("Test event delivered, and the webhook responded with %d %s", delivery.Status,
+			http.StatusText(delivery.Status))},
+		JSON: map[string]any{"result": delivery},
+	}, nil
+}
+
+func defineUser(fs *flag.FlagSet) runFunc {
+	noLogin := fs.Bool("no-login", false, "add the user without a password, so that they can't log in to the server")
+
+	return func(ctx *cliContext, args []string) (*output.View, error) {
+		if len(args) == 0 {
+			args = []string{"list"}
+		}
+		if len(args) > 2 {
+			return nil, usageErrorf("too many fields provided")
+		}
+		var name string
+		if len(args) == 2 {
+			name = args[1]
+		}
+		if *noLogin && args[0] != "add" {
+			return nil, usageErrorf("--no-login can only be used with add")
+		}
+		userReq, err := cmd.ParseUserArgs(args[0], name)
+		if err != nil {
+			return nil, &usageError{err: err}
+		}
+
+		db, err := ctx.DB()
+		if err != nil {
+			return nil, err
+		}
+		if (userReq.Subcommand == "add" && !*noLogin) || userReq.Subcommand == "passwd" {
+			userReq.Password, err = promptPassword(newPrompter(ctx.stdin, ctx.stderr))
+			if err != nil {
+				return nil, err
+			}
+		}
+		userResp := cmd.Users(db, userReq)
+		if !userResp.Success {
+			return nil, fmt.Errorf("error with user request: %w", userResp.Error)
+		}
+		switch userResp.Subcommand {
+		case "add":
+			return output.Message("User successfully added"), nil
+		case "delete":
+			return output.Message(fmt.Sprintf("User successfully deleted, unattributing %s", expenseCount(userResp.Affected))), nil
+		case "passwd":
+			if userReq.Password == "" {
+				return output.Message("Password removed, so the user can no longer log in"), nil
+			}
+			return output.Message("Password successfully changed"), nil
+		}
+		return userView(userResp.Result), nil
+	}
+}
+
+// promptPassword asks for a password, and asks for it again to confirm it when typed at a terminal. An empty password
+// leaves the user unable to log in.
+func promptPassword(p *prompter) (string, error) {
+	for {
+		password, err := p.askSecret("Password (empty to disallow logging in)")
+		if err != nil {
+			return "", err
+		}
+		if p.terminal == nil || password == "" {
+			return password, nil
+		}
+		repeated, err := p.askSecret("Repeat password")
+		if err != nil {
+			return "", err
+		}
+		if repeated == password {
+			return password, nil
+		}
+		fmt.Fprintln(p.out, "Passwords don't match")
+	}
+}
+
+func defineSettleUp(fs *flag.FlagSet) runFunc {
+	startStr := fs.String("start", "", "start date, such as 2026-10-01, yesterday, -2w, or the first day of a range such as last-month")
+	endStr := fs.String("end", "", "end date, or the last day of a range")
+	rangeStr := fs.String("range", "", "named range of dates, such as this-month, last-quarter, ytd or last-30-days")
+	year := fs.Int("year", 0, "year")
+
+	return func(ctx *cliContext, args []string) (*output.View, error) {
+		if len(args) != 0 {
+			return nil, usageErrorf("unexpected argument '%s'", args[0])
+		}
+		start, end, err := cmd.RangeArgs(*rangeStr, *startStr, *endStr)
+		if err != nil {
+			return nil, &usageError{err: err}
+		}
+		settleReq, err := cmd.ParseSettleUpArgs(start, end, *year)
+		if err != nil {
+			return nil, &usageError{err: err}
+		}
+
+		db, err := ctx.DB()
+		if err != nil {
+			return nil, err
+		}
+		settleResp := cmd.SettleUp(db, settleReq)
+		if !settleResp.Success {
+			return nil, fmt.Errorf("error settling up: %w", settleResp.Error)
+		}
+		return settleUpView(settleResp.Result), nil
+	}
+}
+
+func defineSettle(fs *flag.FlagSet) runFunc {
+	dateStr := fs.String("date", "", "date of the payment (default today)")
+
+	return func(ctx *cliContext, args []string) (*output.View, error) {
+		var settleReq *cmd.SettlementRequest
+		if len(args) == 1 && args[0] == "list" {
+			if *dateStr != "" {
+				return nil, usageErrorf("--date cannot be used with list")
+			}
+			settleReq = &cmd.SettlementRequest{Subcommand: "list"}
+		} else if len(args) != 3 {
+			return nil, usageErrorf("expected who paid, who was paid and the amount but got %d fields", len(args))
+		} else {
+			var err error
+			settleReq, err = cmd.ParseSettlementArgs(args[0], args[1], args[2], *dateStr)
+			if err != nil {
+				return nil, &usageError{err: err}
+			}
+		}
+
+		db, err := ctx.DB()
+		if err != nil {
+			return nil, err
+		}
+		settleResp := cmd.Settlements(db, settleReq)
+		if !settleResp.Success {
+			if errors.Is(settleResp.Error, cmd.ErrUnknownUser) {
+				return nil, errors.New("error recording settlement: user does not exist")
+			}
+			return nil, fmt.Errorf("error with settlement request: %w", settleResp.Error)
+		}
+		if settleResp.Subcommand == "record" {
+			return output.Message("Settlement successfully recorded"), nil
+		}
+		return settlementView(settleResp.Result), nil
+	}
+}
+
+func defineTUI(fs *flag.FlagSet) runFunc {
+	return func(ctx *cliContext, args []string) (*output.View, error) {
+		if len(args) != 0 {
+			return nil, usageErrorf("unexpected argument '%s'", args[0])
+		}
+		in, ok := ctx.stdin.(*os.File)
+		if !ok {
+			return nil, errors.New("must be run in a terminal")
+		}
+
+		db, err := ctx.DB()
+		if err != nil {
+			return nil, err
+		}
+		return nil, tui.Run(db, in, ctx.stdout)
+	}
+}
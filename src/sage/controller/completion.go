@@ -0,0 +1,246 @@
+package controller
+
+import (
+	"flag"
+	"sage/src/sage/cmd"
+	"sage/src/sage/output"
+	"strings"
+)
+
+// COMPLETE_COMMAND is the hidden command that completion scripts run to complete a partial command line.
+const COMPLETE_COMMAND = "__complete"
+
+var completionShells = []string{"bash", "zsh", "fish"}
+
+// completionScripts holds the completion script for each shell. Each passes the words of the command line after
+// "sage" to COMPLETE_COMMAND, with the word being completed last, and offers the lines it prints.
+var completionScripts = map[string]string{
+	"bash": `# bash completion for sage
+_sage() {
+	local IFS=$'\n'
+	COMPREPLY=($(sage ` + COMPLETE_COMMAND + ` "${COMP_WORDS[@]:1:COMP_CWORD}" 2>/dev/null))
+}
+complete -o default -F _sage sage
+`,
+	"zsh": `#compdef sage
+# zsh completion for sage
+_sage() {
+	local -a completions
+	completions=("${(@f)$(sage ` + COMPLETE_COMMAND + ` "${(@)words[2,CURRENT]}" 2>/dev/null)}")
+	compadd -a completions
+}
+if [ "$funcstack[1]" = "_sage" ]; then
+	_sage "$@"
+else
+	compdef _sage sage
+fi
+`,
+	"fish": `# fish completion for sage
+function __sage_complete
+	set -l words (commandline -opc) (commandline -ct)
+	sage ` + COMPLETE_COMMAND + ` $words[2..-1] 2>/dev/null
+end
+complete -c sage -f -a '(__sage_complete)'
+`,
+}
+
+// Values offered for flags that accept a fixed set of values, by command and flag name
+var flagValues = map[string]map[string][]string{
+	"summary": {
+		"interval": {"day", "week", "month", "quarter", "year", "fiscal-year"},
+		"chart":    chartTypes,
+	},
+	"compare": {
+		"baseline": {"last-year", "previous", "average"},
+	},
+	"anomalies": {
+		"sensitivity": {"low", "medium", "high"},
+	},
+}
+
+// Flags whose values are category names, by command
+var categoryFlags = map[string][]string{
+	"log":  {"category", "exclude-category"},
+	"edit": {"category"},
+}
+
+func defineCompletion(fs *flag.FlagSet) runFunc {
+	return func(ctx *cliContext, args []string) (*output.View, error) {
+		if len(args) != 1 {
+			return nil, usageErrorf("must provide exactly one shell")
+		}
+		script, ok := completionScripts[args[0]]
+		if !ok {
+			return nil, usageErrorf("shell must be bash, zsh or fish")
+		}
+		ctx.stdout.Write([]byte(script))
+		return nil, nil
+	}
+}
+
+func defineComplete(fs *flag.FlagSet) runFunc {
+	return func(ctx *cliContext, args []string) (*output.View, error) {
+		// use the database given on the command line being completed, if any
+		if len(args) != 0 {
+			if globals, _, err := parseGlobalFlags(args[:len(args)-1]); err == nil {
+				ctx.globalFlags = globals
+			}
+		}
+		for _, completion := range complete(ctx, args) {
+			ctx.stdout.Write([]byte(completion + "\n"))
+		}
+		return nil, nil
+	}
+}
+
+// complete returns the completions of the last of words, given the words before it. words holds the command line
+// after "sage", and may end with an empty word to complete a new argument.
+func complete(ctx *cliContext, words []string) []string {
+	if len(words) == 0 {
+		words = []string{""}
+	}
+	current := words[len(words)-1]
+	prior := words[:len(words)-1]
+
+	// complete the values of global flags, then remove them so that they don't count as arguments
+	if len(prior) != 0 {
+		if def := findGlobalFlag(strings.TrimLeft(prior[len(prior)-1], "-")); def != nil && strings.HasPrefix(prior[len(prior)-1], "-") {
+			return withPrefix(def.values, current)
+		}
+	}
+	prior = removeGlobalFlags(prior)
+
+	if len(prior) == 0 {
+		if strings.HasPrefix(current, "-") {
+			return withPrefix(globalFlagNames(), current)
+		}
+		var names []string
+		for _, c := range commands {
+			if !c.hidden {
+				names = append(names, c.name)
+			}
+		}
+		return withPrefix(append(names, "help"), current)
+	}
+	if prior[0] == "help" {
+		if len(prior) > 1 {
+			return nil
+		}
+		return complete(ctx, []string{current})
+	}
+
+	c := findCommand(prior[0])
+	if c == nil || c.hidden {
+		return nil
+	}
+	fs := newFlagSet(c)
+	c.define(fs)
+
+	// complete the value of the flag before the current word
+	var positional []string
+	for i := 1; i < len(prior); i++ {
+		f, takesValue := lookupFlag(fs, prior[i])
+		if f == nil {
+			positional = append(positional, prior[i])
+			continue
+		}
+		if takesValue {
+			if i == len(prior)-1 {
+				return withPrefix(flagCompletions(ctx, c.name, f.Name), current)
+			}
+			i++
+		}
+	}
+
+	if strings.HasPrefix(current, "-") {
+		var names []string
+		fs.VisitAll(func(f *flag.Flag) {
+			names = append(names, "--"+f.Name)
+		})
+		return withPrefix(append(names, globalFlagNames()...), current)
+	}
+	if len(positional) == 0 && c.subcommands != nil {
+		return withPrefix(c.subcommands, current)
+	}
+	if (c.name == "category" && len(positional) == 1 && (positional[0] == "delete" || positional[0] == "edit")) ||
+		(c.name == "add" && len(positional) == 3) {
+		return withPrefix(categoryNamesFromDB(ctx), current)
+	}
+	return nil
+}
+
+// lookupFlag returns the flag of fs that word sets, if any, and whether the flag's value is the next word.
+func lookupFlag(fs *flag.FlagSet, word string) (*flag.Flag, bool) {
+	if !strings.HasPrefix(word, "-") || word == "-" || word == "--" {
+		return nil, false
+	}
+	name, _, hasValue := strings.Cut(strings.TrimLeft(word, "-"), "=")
+	f := fs.Lookup(name)
+	if f == nil {
+		return nil, false
+	}
+	if boolFlag, ok := f.Value.(interface{ IsBoolFlag() bool }); ok && boolFlag.IsBoolFlag() {
+		return f, false
+	}
+	return f, !hasValue
+}
+
+// flagCompletions returns the values to offer for a command's flag.
+func flagCompletions(ctx *cliContext, command, name string) []string {
+	for _, categoryFlag := range categoryFlags[command] {
+		if categoryFlag == name {
+			return categoryNamesFromDB(ctx)
+		}
+	}
+	return flagValues[command][name]
+}
+
+// categoryNamesFromDB returns the names of every category, or nothing if they can't be retrieved.
+func categoryNamesFromDB(ctx *cliContext) []string {
+	db, err := ctx.DB()
+	if err != nil {
+		return nil
+	}
+	catResp := cmd.ExpenseCategory(db, &cmd.CategoryRequest{})
+	if !catResp.Success {
+		return nil
+	}
+	defer catResp.Result.Close()
+
+	categories, _ := scanCategories(catResp.Result)
+	return categories
+}
+
+// removeGlobalFlags returns words without the global flags and their values.
+func removeGlobalFlags(words []string) []string {
+	var rest []string
+	for i := 0; i < len(words); i++ {
+		name, _, hasValue := strings.Cut(strings.TrimLeft(words[i], "-"), "=")
+		if !strings.HasPrefix(words[i], "-") || findGlobalFlag(name) == nil {
+			rest = append(rest, words[i])
+		} else if !hasValue {
+			i++
+		}
+	}
+	return rest
+}
+
+// globalFlagNames returns the names of the global flags as they are typed.
+func globalFlagNames() []string {
+	var names []string
+	for _, def := range globalFlagDefs {
+		names = append(names, "--"+def.names[0])
+	}
+	return names
+}
+
+// withPrefix returns the candidates that start with prefix.
+func withPrefix(candidates []string, prefix string) []string {
+	var matches []string
+	for _, candidate := range candidates {
+		if strings.HasPrefix(candidate, prefix) {
+			matches = append(matches, candidate)
+		}
+	}
+	return matches
+}
@@ -0,0 +1,273 @@
+package controller
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sage/src/sage/client"
+	"sage/src/sage/cmd"
+	"sage/src/sage/data"
+	"sage/src/sage/output"
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/civil"
+	"github.com/Rhymond/go-money"
+	"gotest.tools/v3/assert"
+)
+
+func TestParseArgs(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	all := fs.Bool("all", false, "")
+	name := fs.String("name", "", "")
+
+	args, err := parseArgs(fs, []string{"adopt", "--all", "Stream Co", "--name=x", "-5", "--", "--all"})
+	assert.NilError(t, err)
+	assert.DeepEqual(t, args, []string{"adopt", "Stream Co", "-5", "--all"})
+	assert.Assert(t, *all)
+	assert.Equal(t, *name, "x")
+
+	// relative dates are positional unless they name a flag
+	fs.Bool("d", false, "")
+	args, err = parseArgs(fs, []string{"-3d", "Cafe Bagel", "", "3.25", "-d"})
+	assert.NilError(t, err)
+	assert.DeepEqual(t, args, []string{"-3d", "Cafe Bagel", "", "3.25"})
+
+	_, err = parseArgs(fs, []string{"--bogus"})
+	assert.ErrorContains(t, err, "flag provided but not defined")
+}
+
+func TestParseGlobalFlags(t *testing.T) {
+	globals, args, err := parseGlobalFlags([]string{"log", "-o", "json", "--year", "2026", "--db=other.db"})
+	assert.NilError(t, err)
+	assert.Equal(t, globals.output, "json")
+	assert.Equal(t, globals.dbName, "other.db")
+	assert.DeepEqual(t, args, []string{"log", "--year", "2026"})
+
+	_, _, err = parseGlobalFlags([]string{"log", "--output", "yaml"})
+	assert.Error(t, err, "output must be table, plain, json, csv or tsv")
+
+	_, _, err = parseGlobalFlags([]string{"log", "--db", "../sage.db"})
+	assert.Error(t, err, "database must be a file name")
+}
+
+func TestRunCLIUsage(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	assert.Equal(t, runCLI([]string{"summary", "--help"}, strings.NewReader(""), &stdout, &stderr), EXIT_OK)
+	assert.Assert(t, strings.HasPrefix(stdout.String(), "Usage: sage summary [flags]"))
+	assert.Assert(t, strings.Contains(stdout.String(), "-interval string"))
+
+	stdout.Reset()
+	assert.Equal(t, runCLI([]string{"log", "--bogus"}, strings.NewReader(""), &stdout, &stderr), EXIT_USAGE)
+	assert.Equal(t, stderr.String(), "sage log: flag provided but not defined: -bogus\nRun 'sage log --help' for usage.\n")
+
+	stderr.Reset()
+	assert.Equal(t, runCLI([]string{"summary", "--chart", "pie"}, strings.NewReader(""), &stdout, &stderr), EXIT_USAGE)
+	assert.Equal(t, stderr.String(), "sage summary: chart must be bars, sparkline or heatmap\nRun 'sage summary --help' for usage.\n")
+
+	stderr.Reset()
+	assert.Equal(t, runCLI([]string{"frobnicate"}, strings.NewReader(""), &stdout, &stderr), EXIT_USAGE)
+	assert.Equal(t, runCLI([]string{"add", "2026-01-01"}, strings.NewReader(""), &stdout, &stderr), EXIT_USAGE)
+	assert.Equal(t, stdout.String(), "")
+}
+
+func TestComplete(t *testing.T) {
+	ctx := &cliContext{globalFlags: defaultGlobalFlags()}
+
+	assert.DeepEqual(t, complete(ctx, []string{"su"}), []string{"summary", "subscriptions"})
+	assert.DeepEqual(t, complete(ctx, []string{"--output", "j"}), []string{"json"})
+	assert.DeepEqual(t, complete(ctx, []string{"-o", "csv", "subscriptions", ""}), []string{"list", "adopt"})
+	assert.DeepEqual(t, complete(ctx, []string{"summary", "--stats", "--interval", "q"}), []string{"quarter"})
+	assert.DeepEqual(t, complete(ctx, []string{"log", "--show"}), []string{"--show-id"})
+	assert.DeepEqual(t, complete(ctx, []string{"help", "fo"}), []string{"forecast"})
+	assert.Assert(t, complete(ctx, []string{"compare", "--months", ""}) == nil)
+}
+
+func TestCompleteAnswer(t *testing.T) {
+	candidates := []string{"Market", "Marketplace", "Landlord"}
+
+	completed, matches := completeAnswer(candidates, "ma")
+	assert.Equal(t, completed, "Market")
+	assert.DeepEqual(t, matches, []string{"Market", "Marketplace"})
+
+	completed, _ = completeAnswer(candidates, "l")
+	assert.Equal(t, completed, "Landlord")
+
+	completed, matches = completeAnswer(candidates, "x")
+	assert.Equal(t, completed, "x")
+	assert.Assert(t, matches == nil)
+}
+
+func TestPromptAddRequest(t *testing.T) {
+	suggest := func(location string) *cmd.SuggestionResponse {
+		resp := &cmd.SuggestionResponse{
+			Success:    true,
+			Locations:  []string{"Market", "Landlord"},
+			Categories: []string{"food", "rent"},
+		}
+		if location == "Market" {
+			resp.Category = "food"
+		}
+		return resp
+	}
+	today := civil.Date{Year: 2026, Month: 10, Day: 19}
+
+	// an invalid date and amount are asked for again, and the category defaults to the one used at the location
+	var out bytes.Buffer
+	p := newPrompter(strings.NewReader("someday\n\nmarket\nBread\n\nabc\n3.50\n\n"), &out)
+	addReq, err := promptAddRequest(p, today, suggest)
+	assert.NilError(t, err)
+	assert.Equal(t, addReq.Expense.Date, today)
+	assert.Equal(t, addReq.Expense.Location, "Market")
+	assert.Equal(t, addReq.Expense.Description, "Bread")
+	assert.Equal(t, addReq.Expense.Category, "food")
+	assert.Equal(t, addReq.Expense.Amount.Amount(), int64(350))
+	assert.Assert(t, strings.Contains(out.String(), "Category [food]: "))
+
+	p = newPrompter(strings.NewReader("2026-10-01\nKiosk\n\nbogus\nuncategorized\n2\nn\n"), &out)
+	addReq, err = promptAddRequest(p, today, suggest)
+	assert.NilError(t, err)
+	assert.Assert(t, addReq == nil)
+
+	p = newPrompter(strings.NewReader("2026-10-01\n"), &out)
+	_, err = promptAddRequest(p, today, suggest)
+	assert.ErrorIs(t, err, errCancelled)
+}
+
+func TestRemote(t *testing.T) {
+	var requests []string
+	var added string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v2/expenses", func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.RequestURI())
+		if r.Method == http.MethodPost {
+			body, _ := io.ReadAll(r.Body)
+			added = string(body)
+			w.WriteHeader(http.StatusCreated)
+			io.WriteString(w, `{"data":{"id":3,"date":"2026-10-01","location":"Cafe","description":"","category":"","amount":{"amount":450,"currency":"USD"}}}`)
+			return
+		}
+		if r.URL.Query().Get("cursor") == "" {
+			io.WriteString(w, `{"data":[{"id":1,"date":"2026-10-02","location":"Market","description":"fruit","category":"Food","amount":{"amount":1225,"currency":"USD"}}],"next_cursor":"abc"}`)
+			return
+		}
+		io.WriteString(w, `{"data":[{"id":2,"date":"2026-10-01","location":"Cafe","description":"","category":"","amount":{"amount":450,"currency":"USD"}}]}`)
+	})
+	mux.HandleFunc("/api/v2/expenses/9", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		io.WriteString(w, `{"error":{"code":"not_found","message":"expense with id 9 does not exist"}}`)
+	})
+	mux.HandleFunc("/api/v2/categories/Food", func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.RequestURI())
+		io.WriteString(w, `{"data":{"name":"Food"},"affected":2}`)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	run := func(args ...string) (int, string, string) {
+		var stdout, stderr bytes.Buffer
+		code := runCLI(append([]string{"--remote", srv.URL}, args...), strings.NewReader(""), &stdout, &stderr)
+		return code, stdout.String(), stderr.String()
+	}
+
+	code, stdout, _ := run("log", "--sort", "-date", "--category", "Food,Bills", "-o", "csv")
+	assert.Equal(t, code, EXIT_OK)
+	assert.Equal(t, stdout, "Date,Location,Description,Category,Amount\n2026-10-02,Market,fruit,Food,12.25\n2026-10-01,Cafe,,uncategorized,4.50\n")
+	assert.DeepEqual(t, requests, []string{
+		"GET /api/v2/expenses?category=Food%2CBills&page-size=100&sort=-date",
+		"GET /api/v2/expenses?category=Food%2CBills&cursor=abc&page-size=100&sort=-date",
+	})
+
+	requests = nil
+	code, stdout, _ = run("add", "2026-10-01", "Cafe", "", "", "4.50")
+	assert.Equal(t, code, EXIT_OK)
+	assert.Equal(t, stdout, "Expense added successfully\n")
+	assert.DeepEqual(t, requests, []string{"POST /api/v2/expenses"})
+	// without --paid-by, the server attributes the expense to the user the token belongs to
+	assert.Assert(t, !strings.Contains(added, "paid_by"))
+
+	code, _, _ = run("add", "--paid-by", "bob", "--shared", "2026-10-01", "Cafe", "", "", "4.50")
+	assert.Equal(t, code, EXIT_OK)
+	assert.Assert(t, strings.Contains(added, `"paid_by":"bob","shared":true`))
+
+	// a split expense is shared, as it is when added locally, since the server rejects splitting one that isn't
+	code, _, _ = run("add", "--with", "alice,bob", "2026-10-01", "Cafe", "", "", "4.50")
+	assert.Equal(t, code, EXIT_OK)
+	assert.Assert(t, strings.Contains(added, `"shared":true,"split":"equal","participants":["alice","bob"]`), added)
+
+	requests = nil
+	code, stdout, _ = run("category", "delete", "Food", "--dry-run")
+	assert.Equal(t, code, EXIT_OK)
+	assert.Equal(t, stdout, "Deleting Food would uncategorize 2 expenses\n")
+	assert.DeepEqual(t, requests, []string{"DELETE /api/v2/categories/Food?dry-run=true"})
+
+	code, _, stderr := run("delete", "9")
+	assert.Equal(t, code, EXIT_ERROR)
+	assert.Equal(t, stderr, "sage delete: error deleting expense: expense with id 9 does not exist\n")
+
+	code, _, stderr = run("summary", "--chart", "bars")
+	assert.Equal(t, code, EXIT_USAGE)
+	assert.Equal(t, stderr, "sage summary: cannot draw a chart with --remote\nRun 'sage summary --help' for usage.\n")
+
+	_, _, err := parseGlobalFlags([]string{"log", "--remote", "localhost:8080"})
+	assert.Error(t, err, "remote must be an http or https address")
+}
+
+func TestRemoteError(t *testing.T) {
+	err := remoteError(&client.Error{Status: 409, Code: client.CODE_CONFLICT, Message: "category 'Food' already exists"})
+	assert.Assert(t, errors.Is(err, cmd.ErrConflict))
+	assert.Assert(t, !errors.Is(err, cmd.ErrNotFound))
+	assert.Error(t, err, "category 'Food' already exists")
+	assert.NilError(t, remoteError(nil))
+
+	err = remoteError(&client.Error{Status: 422, Code: client.CODE_UNKNOWN_USER, Message: "user 'eve' does not exist"})
+	assert.Assert(t, errors.Is(err, cmd.ErrUnknownUser))
+
+	err = remoteError(&client.Error{Status: 422, Code: client.CODE_VALIDATION_FAILED, Message: "exact shares add up to $5.00, not the amount of $6.00"})
+	assert.Assert(t, errors.Is(err, cmd.ErrInvalid))
+}
+
+func TestSettleUpView(t *testing.T) {
+	usd := func(cents int64) *money.Money { return money.New(cents, money.USD) }
+	settle := &data.SettleUp{
+		Total: usd(12000),
+		Balances: []data.Balance{
+			{User: "alice", Paid: usd(9000), Share: usd(6000), Settled: usd(0), Net: usd(3000)},
+			{User: "bob", Paid: usd(3000), Share: usd(6000), Settled: usd(0), Net: usd(-3000)},
+		},
+		Transfers: []data.Transfer{{From: "bob", To: "alice", Amount: usd(3000)}},
+	}
+	var out bytes.Buffer
+	assert.NilError(t, output.Render(&out, output.PLAIN, settleUpView(settle)))
+	assert.Equal(t, out.String(), "alice paid $90.00 of their $60.00 share\nbob paid $30.00 of their $60.00 share\nbob owes alice $30.00\n")
+
+	settle.Transfers = nil
+	out.Reset()
+	assert.NilError(t, output.Render(&out, output.PLAIN, settleUpView(settle)))
+	assert.Assert(t, strings.HasSuffix(out.String(), "Everyone is settled up\n"))
+
+	settle.Balances[0].Settled, settle.Balances[0].Net = usd(-3000), usd(0)
+	settle.Balances[1].Settled, settle.Balances[1].Net = usd(3000), usd(0)
+	out.Reset()
+	assert.NilError(t, output.Render(&out, output.PLAIN, settleUpView(settle)))
+	assert.Equal(t, out.String(), "alice paid $90.00 of their $60.00 share, and has been paid back $30.00\n"+
+		"bob paid $30.00 of their $60.00 share, and has paid back $30.00\nEveryone is settled up\n")
+}
+
+func TestWebhookView(t *testing.T) {
+	created := time.Date(2026, 10, 1, 12, 0, 0, 0, time.Local)
+	webhooks := []data.Webhook{
+		{Id: 1, URL: "https://example.com/hook", Events: cmd.WebhookEvents, Created: created,
+			LastDelivery: &data.Delivery{Status: 500, Error: "webhook responded with 500 Internal Server Error", Delivered: created}},
+		{Id: 2, URL: "https://example.com/deletes", Events: []string{cmd.CHANGE_DELETED}, Created: created},
+	}
+	var out bytes.Buffer
+	assert.NilError(t, output.Render(&out, output.PLAIN, webhookView(webhooks)))
+	assert.Equal(t, out.String(), "1 | https://example.com/hook | expense.created,expense.updated,expense.deleted | 2026-10-01 12:00:00 | "+
+		"2026-10-01 12:00:00 failed: webhook responded with 500 Internal Server Error\n"+
+		"2 | https://example.com/deletes | expense.deleted | 2026-10-01 12:00:00 | never\n")
+}
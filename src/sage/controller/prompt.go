@@ -0,0 +1,211 @@
+package controller
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode"
+
+	"golang.org/x/term"
+)
+
+// errCancelled is returned by a prompter when input ends or is interrupted before an answer is given.
+var errCancelled = errors.New("cancelled")
+
+// Maximum number of completions listed when Tab matches more than one candidate
+const MAX_LISTED_COMPLETIONS = 10
+
+// prompter asks questions on out and reads the answers from in. When in is a terminal, answers are edited in raw
+// mode so that Tab completes them from a list of candidates. Otherwise each answer is a line of input, so that answers
+// can be piped in.
+type prompter struct {
+	in       *bufio.Reader
+	out      io.Writer
+	terminal *os.File
+}
+
+// newPrompter returns a prompter reading from in and writing to out.
+func newPrompter(in io.Reader, out io.Writer) *prompter {
+	p := &prompter{in: bufio.NewReader(in), out: out}
+	if f, ok := in.(*os.File); ok && term.IsTerminal(int(f.Fd())) {
+		p.terminal = f
+	}
+	return p
+}
+
+// ask prompts for a value, returning def if the answer is empty. def is shown in brackets after the label, and
+// candidates are offered when Tab is pressed.
+func (p *prompter) ask(label, def string, candidates []string) (string, error) {
+	prompt := label
+	if def != "" {
+		prompt += " [" + def + "]"
+	}
+	prompt += ": "
+
+	var answer string
+	var err error
+	if p.terminal != nil {
+		answer, err = p.readEdited(prompt, candidates)
+	} else {
+		answer, err = p.readLine(prompt)
+	}
+	if err != nil {
+		return "", err
+	}
+	answer = strings.TrimSpace(answer)
+	if answer == "" {
+		return def, nil
+	}
+	return answer, nil
+}
+
+// confirm asks a yes or no question, defaulting to yes.
+func (p *prompter) confirm(question string) (bool, error) {
+	for {
+		answer, err := p.ask(question+" [Y/n]", "", nil)
+		if err != nil {
+			return false, err
+		}
+		switch strings.ToLower(answer) {
+		case "", "y", "yes":
+			return true, nil
+		case "n", "no":
+			return false, nil
+		}
+	}
+}
+
+// askSecret prompts for a value without echoing it when in is a terminal, such as a password. The answer isn't
+// trimmed.
+func (p *prompter) askSecret(label string) (string, error) {
+	if p.terminal == nil {
+		return p.readLine(label + ": ")
+	}
+	fmt.Fprint(p.out, label+": ")
+	secret, err := term.ReadPassword(int(p.terminal.Fd()))
+	fmt.Fprintln(p.out)
+	if err != nil {
+		return "", errCancelled
+	}
+	return string(secret), nil
+}
+
+// readLine writes the prompt and reads a line of input.
+func (p *prompter) readLine(prompt string) (string, error) {
+	fmt.Fprint(p.out, prompt)
+	line, err := p.in.ReadString('\n')
+	if err == io.EOF && line != "" {
+		err = nil
+	}
+	if err != nil {
+		fmt.Fprintln(p.out)
+		return "", errCancelled
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// readEdited writes the prompt and reads a line from the terminal in raw mode, handling backspace, Ctrl-U to clear
+// the line, Tab to complete it, and Ctrl-C or Ctrl-D to cancel.
+func (p *prompter) readEdited(prompt string, candidates []string) (string, error) {
+	state, err := term.MakeRaw(int(p.terminal.Fd()))
+	if err != nil {
+		return p.readLine(prompt)
+	}
+	defer term.Restore(int(p.terminal.Fd()), state)
+
+	var line []rune
+	redraw := func() {
+		fmt.Fprint(p.out, "\r\033[K"+prompt+string(line))
+	}
+	redraw()
+	for {
+		r, _, err := p.in.ReadRune()
+		if err != nil {
+			fmt.Fprint(p.out, "\r\n")
+			return "", errCancelled
+		}
+		switch {
+		case r == '\r' || r == '\n':
+			fmt.Fprint(p.out, "\r\n")
+			return string(line), nil
+		case r == 3 || (r == 4 && len(line) == 0):
+			fmt.Fprint(p.out, "^C\r\n")
+			return "", errCancelled
+		case r == 127 || r == '\b':
+			if len(line) != 0 {
+				line = line[:len(line)-1]
+				redraw()
+			}
+		case r == 21:
+			line = nil
+			redraw()
+		case r == '\t':
+			completed, matches := completeAnswer(candidates, string(line))
+			if completed == string(line) && len(matches) > 1 {
+				fmt.Fprint(p.out, "\r\n"+formatMatches(matches)+"\r\n")
+			}
+			line = []rune(completed)
+			redraw()
+		case r == 27:
+			p.skipEscapeSequence()
+		case unicode.IsPrint(r):
+			line = append(line, r)
+			fmt.Fprint(p.out, string(r))
+		}
+	}
+}
+
+// skipEscapeSequence discards the rest of an escape sequence, such as the one sent by an arrow key, after its escape
+// character has been read.
+func (p *prompter) skipEscapeSequence() {
+	b, err := p.in.ReadByte()
+	if err != nil || (b != '[' && b != 'O') {
+		return
+	}
+	for {
+		b, err := p.in.ReadByte()
+		if err != nil || (b >= 0x40 && b <= 0x7e) {
+			return
+		}
+	}
+}
+
+// completeAnswer completes a partial answer from the candidates that start with it, ignoring case. Returns the answer
+// extended to the longest prefix the matches share, or to the match itself if there is only one, along with the
+// matches. The answer is returned unchanged if nothing matches.
+func completeAnswer(candidates []string, answer string) (string, []string) {
+	var matches []string
+	for _, candidate := range candidates {
+		if strings.HasPrefix(strings.ToLower(candidate), strings.ToLower(answer)) {
+			matches = append(matches, candidate)
+		}
+	}
+	if len(matches) == 0 {
+		return answer, nil
+	}
+
+	prefix := []rune(matches[0])
+	for _, match := range matches[1:] {
+		runes := []rune(match)
+		n := 0
+		for n < len(prefix) && n < len(runes) && unicode.ToLower(prefix[n]) == unicode.ToLower(runes[n]) {
+			n++
+		}
+		prefix = prefix[:n]
+	}
+	if len(prefix) < len([]rune(answer)) {
+		return answer, matches
+	}
+	return string(prefix), matches
+}
+
+// formatMatches lists the matches of a completion on one line, truncated to MAX_LISTED_COMPLETIONS.
+func formatMatches(matches []string) string {
+	if len(matches) > MAX_LISTED_COMPLETIONS {
+		return strings.Join(matches[:MAX_LISTED_COMPLETIONS], "  ") + fmt.Sprintf("  (%d more)", len(matches)-MAX_LISTED_COMPLETIONS)
+	}
+	return strings.Join(matches, "  ")
+}
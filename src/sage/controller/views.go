@@ -0,0 +1,457 @@
+package controller
+
+import (
+	"fmt"
+	"sage/src/sage/cmd"
+	"sage/src/sage/data"
+	"sage/src/sage/output"
+	"slices"
+	"strings"
+	"time"
+)
+
+// expenseView builds the view of the expenses retrieved by a log request. The JSON form matches the /log response.
+func expenseView(page *expensePage, showId bool) *output.View {
+	view := &output.View{
+		Columns: []output.Column{
+			{Name: "Date"},
+			{Name: "Location"},
+			{Name: "Description"},
+			{Name: "Category"},
+			{Name: "Amount", Right: true, Total: true},
+		},
+	}
+	if showId {
+		view.Columns = append([]output.Column{{Name: "ID", Right: true}}, view.Columns...)
+	}
+	// only show who paid once expenses are attributed to users, so that a ledger kept by one person looks as before
+	attributed := slices.ContainsFunc(page.expenses, func(e data.Expense) bool { return e.PaidBy != "" || e.Shared })
+	if attributed {
+		amount := view.Columns[len(view.Columns)-1]
+		view.Columns = append(view.Columns[:len(view.Columns)-1], output.Column{Name: "Paid By"}, output.Column{Name: "Shared"}, amount)
+	}
+	for _, expense := range page.expenses {
+		row := []any{expense.Date, expense.Location, expense.Description, cmd.DisplayCategory(expense.Category)}
+		if attributed {
+			shared := ""
+			if expense.Split != "" {
+				shared = expense.Split
+			} else if expense.Shared {
+				shared = "yes"
+			}
+			row = append(row, expense.PaidBy, shared)
+		}
+		row = append(row, expense.Amount)
+		if showId {
+			row = append([]any{expense.Id}, row...)
+		}
+		view.Rows = append(view.Rows, row)
+	}
+
+	body := map[string]any{"show_id": showId, "result": page.expenses}
+	if page.nextCursor != "" {
+		body["next_cursor"] = page.nextCursor
+	}
+	if page.prevCursor != "" {
+		body["prev_cursor"] = page.prevCursor
+	}
+	view.JSON = body
+	return view
+}
+
+// summaryView builds the view of the periods of a summary, along with their statistics if stats is set. The JSON
+// form matches the /summary response.
+func summaryView(summaries []data.Summary, stats bool) *output.View {
+	view := &output.View{
+		Columns: []output.Column{
+			{Name: "Period"},
+			{Name: "Total", Right: true, Total: true},
+		},
+		Lines: []string{},
+		JSON:  map[string]any{"result": summaries},
+	}
+	if stats {
+		for _, name := range []string{"Count", "Average", "Median", "Min", "Max", "P90", "Change", "Change %"} {
+			view.Columns = append(view.Columns, output.Column{Name: name, Right: true})
+		}
+	}
+
+	for _, summary := range summaries {
+		row := []any{summary.Period, summary.Total}
+		line := fmt.Sprintf("%s: $%.2f", summary.Period, summary.Total.AsMajorUnits())
+		if stats {
+			s := summary.Stats
+			if s == nil {
+				s = &data.SummaryStats{}
+			}
+			row = append(row, s.Count, s.Average, s.Median, s.Min, s.Max, s.P90, s.Change, s.ChangePercent)
+			line += " " + formatStats(summary.Stats)
+		}
+		view.Rows = append(view.Rows, row)
+		view.Lines = append(view.Lines, line)
+	}
+	return view
+}
+
+// categoryView builds the view of a list of category names.
+func categoryView(categories []string) *output.View {
+	view := &output.View{
+		Columns: []output.Column{{Name: "Category"}},
+		JSON:    map[string]any{"result": categories},
+	}
+	for _, category := range categories {
+		view.Rows = append(view.Rows, []any{category})
+	}
+	return view
+}
+
+// comparisonView builds the view of the category totals of a comparison. The plain form also lists the new and
+// disappeared categories and biggest movers, and the JSON form matches the /compare response.
+func comparisonView(comparison *data.Comparison) *output.View {
+	view := &output.View{
+		Columns: []output.Column{
+			{Name: "Category"},
+			{Name: comparison.Current.Label, Right: true, Total: true},
+			{Name: comparison.Baseline.Label, Right: true, Total: true},
+			{Name: "Change", Right: true, Total: true},
+			{Name: "Change %", Right: true},
+		},
+		Lines: comparisonLines(comparison),
+		JSON:  map[string]any{"result": comparison},
+	}
+	for _, category := range comparison.Categories {
+		view.Rows = append(view.Rows, []any{cmd.DisplayCategory(category.Category), category.Current, category.Baseline,
+			category.Change, category.ChangePercent})
+	}
+	return view
+}
+
+// anomalyView builds the view of detected anomalies. The JSON form matches the /insights/anomalies response.
+func anomalyView(anomalies []data.Anomaly) *output.View {
+	view := &output.View{
+		Columns: []output.Column{
+			{Name: "Date"},
+			{Name: "Kind"},
+			{Name: "Amount", Right: true},
+			{Name: "Expected", Right: true},
+			{Name: "Reason"},
+		},
+		Lines: []string{},
+		JSON:  map[string]any{"result": anomalies},
+	}
+	if len(anomalies) == 0 {
+		view.Lines = append(view.Lines, "No anomalies found")
+	}
+	for _, anomaly := range anomalies {
+		view.Rows = append(view.Rows, []any{anomaly.Date, anomaly.Kind, anomaly.Amount, anomaly.Expected, anomaly.Reason})
+		view.Lines = append(view.Lines, fmt.Sprintf("%s | %s | %s", anomaly.Date, anomaly.Kind, anomaly.Reason))
+	}
+	return view
+}
+
+// forecastView builds the view of a forecast, with a row for each month's total followed by its categories. The JSON
+// form matches the /forecast response.
+func forecastView(forecasts []data.Forecast) *output.View {
+	view := &output.View{
+		Columns: []output.Column{
+			{Name: "Period"},
+			{Name: "Category"},
+			{Name: "Spent", Right: true},
+			{Name: "Recurring", Right: true},
+			{Name: "Estimate", Right: true},
+			{Name: "Low", Right: true},
+			{Name: "High", Right: true},
+		},
+		Lines: []string{},
+		JSON:  map[string]any{"result": forecasts},
+	}
+	for _, forecast := range forecasts {
+		view.Rows = append(view.Rows, forecastRow(forecast.Period, "total", forecast.ForecastAmounts))
+		view.Lines = append(view.Lines, fmt.Sprintf("%s: %s", forecast.Period, formatForecast(forecast.ForecastAmounts)))
+		for _, category := range forecast.Categories {
+			view.Rows = append(view.Rows, forecastRow(forecast.Period, cmd.DisplayCategory(category.Category), category.ForecastAmounts))
+			view.Lines = append(view.Lines, fmt.Sprintf("  %s: %s", cmd.DisplayCategory(category.Category), formatForecast(category.ForecastAmounts)))
+		}
+	}
+	return view
+}
+
+// forecastRow returns the row of a forecastView for a month's projected spending.
+func forecastRow(period, category string, amounts data.ForecastAmounts) []any {
+	return []any{period, category, amounts.Actual, amounts.Recurring, amounts.Estimate, amounts.Low, amounts.High}
+}
+
+// subscriptionView builds the view of detected subscriptions. The JSON form matches the /subscriptions response.
+func subscriptionView(subscriptions []data.Subscription) *output.View {
+	view := &output.View{
+		Columns: []output.Column{
+			{Name: "Location"},
+			{Name: "Cadence"},
+			{Name: "Amount", Right: true},
+			{Name: "Last Charge"},
+			{Name: "Next Expected"},
+			{Name: "Annual Cost", Right: true, Total: true},
+			{Name: "Status"},
+		},
+		Lines: []string{},
+		JSON:  map[string]any{"result": subscriptions},
+	}
+	for _, subscription := range subscriptions {
+		status := "active"
+		if !subscription.Active {
+			status = "inactive"
+		}
+		if subscription.Adopted {
+			status += ", adopted"
+		}
+		view.Rows = append(view.Rows, []any{subscription.Location, subscription.Cadence, subscription.Amount,
+			subscription.LastCharge, subscription.NextExpected, subscription.AnnualCost, status})
+		view.Lines = append(view.Lines, formatSubscription(subscription))
+	}
+	return view
+}
+
+// tokenView builds the view of the personal access tokens. The tokens themselves can't be shown, only their prefixes.
+func tokenView(tokens []data.Token) *output.View {
+	view := &output.View{
+		Columns: []output.Column{
+			{Name: "ID", Right: true},
+			{Name: "Name"},
+			{Name: "User"},
+			{Name: "Token"},
+			{Name: "Scopes"},
+			{Name: "Created"},
+			{Name: "Last Used"},
+		},
+		JSON: map[string]any{"result": tokens},
+	}
+	for _, token := range tokens {
+		lastUsed := "never"
+		if token.LastUsed != nil {
+			lastUsed = token.LastUsed.Local().Format(time.DateTime)
+		}
+		view.Rows = append(view.Rows, []any{token.Id, token.Name, token.User, token.Prefix + "...", strings.Join(token.Scopes, ","),
+			token.Created.Local().Format(time.DateTime), lastUsed})
+	}
+	return view
+}
+
+// webhookView builds the view of the webhooks events are sent to, with how their last delivery went.
+func webhookView(webhooks []data.Webhook) *output.View {
+	view := &output.View{
+		Columns: []output.Column{
+			{Name: "ID", Right: true},
+			{Name: "URL"},
+			{Name: "Events"},
+			{Name: "Created"},
+			{Name: "Last Delivery"},
+		},
+		JSON: map[string]any{"result": webhooks},
+	}
+	for _, webhook := range webhooks {
+		lastDelivery := "never"
+		if delivery := webhook.LastDelivery; delivery != nil {
+			lastDelivery = delivery.Delivered.Local().Format(time.DateTime) + " " + deliveryStatus(delivery)
+		}
+		view.Rows = append(view.Rows, []any{webhook.Id, webhook.URL, strings.Join(webhook.Events, ","),
+			webhook.Created.Local().Format(time.DateTime), lastDelivery})
+	}
+	return view
+}
+
+// deliveryView builds the view of the attempts to deliver events to a webhook.
+func deliveryView(deliveries []data.Delivery) *output.View {
+	view := &output.View{
+		Columns: []output.Column{
+			{Name: "ID", Right: true},
+			{Name: "Delivered"},
+			{Name: "Event"},
+			{Name: "Change", Right: true},
+			{Name: "Attempt", Right: true},
+			{Name: "Result"},
+		},
+		JSON: map[string]any{"result": deliveries},
+	}
+	for _, delivery := range deliveries {
+		view.Rows = append(view.Rows, []any{delivery.Id, delivery.Delivered.Local().Format(time.DateTime), delivery.Event,
+			delivery.ChangeId, delivery.Attempt, deliveryStatus(&delivery)})
+	}
+	return view
+}
+
+// deliveryStatus describes whether a delivery was received.
+func deliveryStatus(delivery *data.Delivery) string {
+	if delivery.Succeeded {
+		return fmt.Sprintf("ok (%d)", delivery.Status)
+	}
+	return "failed: " + delivery.Error
+}
+
+// userView builds the view of the users sharing the ledger.
+func userView(users []data.User) *output.View {
+	view := &output.View{
+		Columns: []output.Column{
+			{Name: "Name"},
+			{Name: "Can Log In"},
+		},
+		JSON: map[string]any{"result": users},
+	}
+	for _, user := range users {
+		canLogIn := "no"
+		if user.CanLogIn {
+			canLogIn = "yes"
+		}
+		view.Rows = append(view.Rows, []any{user.Name, canLogIn})
+	}
+	return view
+}
+
+// settlementView builds the view of the payments recorded to settle up.
+func settlementView(settlements []data.Settlement) *output.View {
+	view := &output.View{
+		Columns: []output.Column{
+			{Name: "ID", Right: true},
+			{Name: "Date"},
+			{Name: "From"},
+			{Name: "To"},
+			{Name: "Amount", Right: true, Total: true},
+		},
+		JSON: map[string]any{"result": settlements},
+	}
+	for _, settlement := range settlements {
+		view.Rows = append(view.Rows, []any{settlement.Id, settlement.Date, settlement.From, settlement.To, settlement.Amount})
+	}
+	return view
+}
+
+// settleUpView builds the view of each user's balance for shared expenses. The plain form follows the balances with
+// the transfers that settle them.
+func settleUpView(settle *data.SettleUp) *output.View {
+	view := &output.View{
+		Columns: []output.Column{
+			{Name: "User"},
+			{Name: "Paid", Right: true, Total: true},
+			{Name: "Share", Right: true, Total: true},
+			{Name: "Settled", Right: true},
+			{Name: "Net", Right: true},
+		},
+		Lines: []string{},
+		JSON:  map[string]any{"result": settle},
+	}
+	for _, balance := range settle.Balances {
+		view.Rows = append(view.Rows, []any{balance.User, balance.Paid, balance.Share, balance.Settled, balance.Net})
+		line := fmt.Sprintf("%s paid %s of their %s share", balance.User, balance.Paid.Display(), balance.Share.Display())
+		if settled := balance.Settled.Amount(); settled > 0 {
+			line += fmt.Sprintf(", and has paid back %s", balance.Settled.Display())
+		} else if settled < 0 {
+			line += fmt.Sprintf(", and has been paid back %s", balance.Settled.Absolute().Display())
+		}
+		view.Lines = append(view.Lines, line)
+	}
+	for _, transfer := range settle.Transfers {
+		view.Lines = append(view.Lines, fmt.Sprintf("%s owes %s %s", transfer.From, transfer.To, transfer.Amount.Display()))
+	}
+	if len(settle.Transfers) == 0 {
+		view.Lines = append(view.Lines, "Everyone is settled up")
+	}
+	return view
+}
+
+// formatStats formats the statistics of a summary period for display after its total.
+func formatStats(stats *data.SummaryStats) string {
+	if stats == nil {
+		return ""
+	}
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("(count %d", stats.Count))
+	if stats.Count != 0 {
+		sb.WriteString(fmt.Sprintf(", avg $%.2f, median $%.2f, min $%.2f, max $%.2f, p90 $%.2f",
+			stats.Average.AsMajorUnits(), stats.Median.AsMajorUnits(), stats.Min.AsMajorUnits(),
+			stats.Max.AsMajorUnits(), stats.P90.AsMajorUnits()))
+	}
+	if stats.Change != nil {
+		sb.WriteString(fmt.Sprintf(", change %+.2f", stats.Change.AsMajorUnits()))
+		if stats.ChangePercent != nil {
+			sb.WriteString(fmt.Sprintf(" (%+.1f%%)", *stats.ChangePercent))
+		}
+	}
+	sb.WriteString(")")
+	return sb.String()
+}
+
+// formatSubscription formats a detected subscription on a single line.
+func formatSubscription(subscription data.Subscription) string {
+	line := fmt.Sprintf("%s: $%.2f %s, last charged %s, next expected %s ($%.2f/year)", subscription.Location,
+		subscription.Amount.AsMajorUnits(), subscription.Cadence, subscription.LastCharge, subscription.NextExpected,
+		subscription.AnnualCost.AsMajorUnits())
+	if !subscription.Active {
+		line += " [inactive]"
+	}
+	if subscription.Adopted {
+		line += " [adopted]"
+	}
+	return line
+}
+
+// formatForecast formats a month's projected spending, along with what has been spent so far if anything.
+func formatForecast(amounts data.ForecastAmounts) string {
+	line := fmt.Sprintf("$%.2f (range $%.2f - $%.2f", amounts.Estimate.AsMajorUnits(), amounts.Low.AsMajorUnits(), amounts.High.AsMajorUnits())
+	if amounts.Actual.Amount() != 0 {
+		line += fmt.Sprintf(", spent $%.2f so far", amounts.Actual.AsMajorUnits())
+	}
+	if amounts.Recurring.Amount() != 0 {
+		line += fmt.Sprintf(", $%.2f recurring", amounts.Recurring.AsMajorUnits())
+	}
+	return line + ")"
+}
+
+// comparisonLines formats the category totals of a comparison along with its new and disappeared categories and
+// biggest movers.
+func comparisonLines(comparison *data.Comparison) []string {
+	baselineLabel := comparison.Baseline.Label
+	if comparison.BaselineMonths != 0 {
+		baselineLabel = fmt.Sprintf("%d-month average (%s)", comparison.BaselineMonths, comparison.Baseline.Label)
+	}
+	lines := []string{fmt.Sprintf("%s vs %s", comparison.Current.Label, baselineLabel)}
+	for _, category := range comparison.Categories {
+		lines = append(lines, formatCategoryComparison(category))
+	}
+	total := comparison.Total
+	total.Category = "total"
+	lines = append(lines, formatCategoryComparison(total))
+
+	if len(comparison.New) != 0 {
+		lines = append(lines, "New categories: "+strings.Join(categoryNames(comparison.New), ", "))
+	}
+	if len(comparison.Disappeared) != 0 {
+		lines = append(lines, "Disappeared categories: "+strings.Join(categoryNames(comparison.Disappeared), ", "))
+	}
+	if len(comparison.Movers) != 0 {
+		var movers []string
+		for _, mover := range comparison.Movers {
+			movers = append(movers, fmt.Sprintf("%s (%+.2f)", cmd.DisplayCategory(mover.Category), mover.Change.AsMajorUnits()))
+		}
+		lines = append(lines, "Biggest movers: "+strings.Join(movers, ", "))
+	}
+	return lines
+}
+
+// formatCategoryComparison formats a category's current and baseline totals and the change between them.
+func formatCategoryComparison(comparison data.CategoryComparison) string {
+	line := fmt.Sprintf("%s: $%.2f vs $%.2f (%+.2f", cmd.DisplayCategory(comparison.Category),
+		comparison.Current.AsMajorUnits(), comparison.Baseline.AsMajorUnits(), comparison.Change.AsMajorUnits())
+	if comparison.ChangePercent != nil {
+		line += fmt.Sprintf(", %+.1f%%", *comparison.ChangePercent)
+	}
+	return line + ")"
+}
+
+// categoryNames returns the display names of the given categories.
+func categoryNames(categories []string) []string {
+	names := make([]string, len(categories))
+	for i, category := range categories {
+		names[i] = cmd.DisplayCategory(category)
+	}
+	return names
+}
@@ -1,10 +1,15 @@
 package data
 
 import (
+	"time"
+
 	"cloud.google.com/go/civil"
 	"github.com/Rhymond/go-money"
 )
 
+// Expense is a single purchase. PaidBy is the user who paid for it and CreatedBy the user who recorded it, which are
+// unset if it isn't attributed to anyone. Shared expenses are split between every user when settling up, unless Split
+// names how they are divided between the participants in Shares instead.
 type Expense struct {
 	Id          int          `json:"id"`
 	Date        civil.Date   `json:"date"`
@@ -12,9 +17,243 @@ type Expense struct {
 	Description string       `json:"description,omitempty"`
 	Category    string       `json:"category,omitempty"`
 	Amount      *money.Money `json:"amount"`
+	PaidBy      string       `json:"paid_by,omitempty"`
+	CreatedBy   string       `json:"created_by,omitempty"`
+	Shared      bool         `json:"shared,omitempty"`
+	Split       string       `json:"split,omitempty"`
+	Shares      []Share      `json:"shares,omitempty"`
+}
+
+// Share is the part of a split expense that a participant owes.
+type Share struct {
+	User   string       `json:"user"`
+	Amount *money.Money `json:"amount"`
 }
 
+// Category is a name that expenses can be filed under.
+type Category struct {
+	Name string `json:"name"`
+}
+
+// Summary is the total spent in a period. Month is only set for monthly summaries, and holds the same label as Period.
+// Stats is only set if requested.
 type Summary struct {
-	Month string       `json:"month"`
-	Total *money.Money `json:"total"`
+	Period string        `json:"period"`
+	Month  string        `json:"month,omitempty"`
+	Total  *money.Money  `json:"total"`
+	Stats  *SummaryStats `json:"stats,omitempty"`
+}
+
+// SummaryStats describes the expenses in a summary period. The amounts are unset if the period has no expenses, and
+// the changes from the previous period are unset for the first period. ChangePercent is also unset if nothing was
+// spent in the previous period.
+type SummaryStats struct {
+	Count         int          `json:"count"`
+	Average       *money.Money `json:"average,omitempty"`
+	Median        *money.Money `json:"median,omitempty"`
+	Min           *money.Money `json:"min,omitempty"`
+	Max           *money.Money `json:"max,omitempty"`
+	P90           *money.Money `json:"p90,omitempty"`
+	Change        *money.Money `json:"change,omitempty"`
+	ChangePercent *float64     `json:"change_percent,omitempty"`
+}
+
+// Period is a labelled range of dates, inclusive of both ends.
+type Period struct {
+	Label string     `json:"label"`
+	Start civil.Date `json:"start"`
+	End   civil.Date `json:"end"`
+}
+
+// CategoryComparison compares the total spent in a category between two periods. ChangePercent is unset if nothing
+// was spent in the category in the baseline period.
+type CategoryComparison struct {
+	Category      string       `json:"category"`
+	Current       *money.Money `json:"current"`
+	Baseline      *money.Money `json:"baseline"`
+	Change        *money.Money `json:"change"`
+	ChangePercent *float64     `json:"change_percent,omitempty"`
+}
+
+// Comparison compares spending by category in a current period against a baseline period. If BaselineMonths is set,
+// the baseline amounts are averages over that many months, scaled to the length of the current period. New and
+// Disappeared list the categories only spent in during the current and baseline periods respectively, and Movers holds
+// the categories that changed the most.
+type Comparison struct {
+	Current        Period               `json:"current"`
+	Baseline       Period               `json:"baseline"`
+	BaselineMonths int                  `json:"baseline_months,omitempty"`
+	Total          CategoryComparison   `json:"total"`
+	Categories     []CategoryComparison `json:"categories"`
+	New            []string             `json:"new"`
+	Disappeared    []string             `json:"disappeared"`
+	Movers         []CategoryComparison `json:"movers"`
+}
+
+// Anomaly is an unusual expense or category total. Kind is "payee" for an expense far above the usual amount at its
+// location, "category" for a category's monthly total far above its recent average, or "recurring" for a newly
+// started recurring charge. Expense is unset for category anomalies, and Category and Period are only set for them.
+// Expected is the usual amount, and Score is how many times the usual amount was spent, when they apply. Reason
+// explains why the anomaly was flagged.
+type Anomaly struct {
+	Kind     string       `json:"kind"`
+	Date     civil.Date   `json:"date"`
+	Expense  *Expense     `json:"expense,omitempty"`
+	Category string       `json:"category,omitempty"`
+	Period   string       `json:"period,omitempty"`
+	Amount   *money.Money `json:"amount"`
+	Expected *money.Money `json:"expected,omitempty"`
+	Score    float64      `json:"score,omitempty"`
+	Reason   string       `json:"reason"`
+}
+
+// ForecastAmounts are the projected spending for a month. Actual is what has already been spent, and Recurring is the
+// expected recurring charges still to come. Estimate is the projected total for the month, likely to fall between
+// Low and High.
+type ForecastAmounts struct {
+	Actual    *money.Money `json:"actual"`
+	Recurring *money.Money `json:"recurring"`
+	Estimate  *money.Money `json:"estimate"`
+	Low       *money.Money `json:"low"`
+	High      *money.Money `json:"high"`
+}
+
+// CategoryForecast is the projected spending in a category for a month.
+type CategoryForecast struct {
+	Category string `json:"category"`
+	ForecastAmounts
+}
+
+// Forecast is the projected spending for a month, in total and by category.
+type Forecast struct {
+	Period string `json:"period"`
+	ForecastAmounts
+	Categories []CategoryForecast `json:"categories"`
+}
+
+// Subscription is a recurring charge detected in the expense history. Amount is the typical charge, and Active is set
+// if the subscription hasn't gone more than one interval without a charge. Adopted is set if a recurring expense has
+// been created for it.
+type Subscription struct {
+	Location     string       `json:"location"`
+	Category     string       `json:"category,omitempty"`
+	Cadence      string       `json:"cadence"`
+	Amount       *money.Money `json:"amount"`
+	Charges      int          `json:"charges"`
+	FirstCharge  civil.Date   `json:"first_charge"`
+	LastCharge   civil.Date   `json:"last_charge"`
+	NextExpected civil.Date   `json:"next_expected"`
+	AnnualCost   *money.Money `json:"annual_cost"`
+	Active       bool         `json:"active"`
+	Adopted      bool         `json:"adopted"`
+}
+
+// RecurringExpense is a template for an expense that is charged at a regular cadence, next on NextDate.
+type RecurringExpense struct {
+	Id          int          `json:"id"`
+	Location    string       `json:"location"`
+	Description string       `json:"description,omitempty"`
+	Category    string       `json:"category,omitempty"`
+	Amount      *money.Money `json:"amount"`
+	Cadence     string       `json:"cadence"`
+	NextDate    civil.Date   `json:"next_date"`
+}
+
+// User is a member of the household sharing the ledger. CanLogIn is set if the user has a password to log in to the
+// server with.
+type User struct {
+	Name     string `json:"name"`
+	CanLogIn bool   `json:"can_log_in"`
+}
+
+// Session is a login session on the server. Token authenticates requests as User until Expires, and is only known
+// when the session is created.
+type Session struct {
+	Token   string    `json:"token"`
+	User    string    `json:"user"`
+	Expires time.Time `json:"expires_at"`
+}
+
+// Change is a change made to an expense, numbered in the order changes were made. Expense is the expense after it was
+// created or updated, or as it was before being deleted.
+type Change struct {
+	Id        int64     `json:"id"`
+	Type      string    `json:"type"`
+	ExpenseId int       `json:"expense_id"`
+	Expense   *Expense  `json:"expense,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Webhook is a URL that is sent the events it subscribes to. Secret signs each delivery so that the receiver can check
+// that it came from sage, and is only set when the webhook is added. LastDelivery is the most recent attempt to send it
+// an event, unset if there hasn't been one.
+type Webhook struct {
+	Id           int       `json:"id"`
+	URL          string    `json:"url"`
+	Events       []string  `json:"events"`
+	Secret       string    `json:"secret,omitempty"`
+	Created      time.Time `json:"created"`
+	LastDelivery *Delivery `json:"last_delivery,omitempty"`
+}
+
+// Delivery is an attempt to send an event to a webhook. Status is the HTTP status the webhook responded with, which is
+// 0 if the request failed, in which case Error says why.
+type Delivery struct {
+	Id        int       `json:"id"`
+	WebhookId int       `json:"webhook_id"`
+	ChangeId  int64     `json:"change_id"`
+	Event     string    `json:"event"`
+	Attempt   int       `json:"attempt"`
+	Status    int       `json:"status,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	Succeeded bool      `json:"succeeded"`
+	Delivered time.Time `json:"delivered_at"`
+}
+
+// Balance is what a user paid towards shared expenses compared to their share of them. Settled is what they have paid
+// other users in settlements less what they have been paid. Net is positive if the user is owed money, and negative
+// if they owe it.
+type Balance struct {
+	User    string       `json:"user"`
+	Paid    *money.Money `json:"paid"`
+	Share   *money.Money `json:"share"`
+	Settled *money.Money `json:"settled"`
+	Net     *money.Money `json:"net"`
+}
+
+// Transfer is a payment from one user to another that settles part of their balances.
+type Transfer struct {
+	From   string       `json:"from"`
+	To     string       `json:"to"`
+	Amount *money.Money `json:"amount"`
+}
+
+// Settlement is a payment from one user to another that has been made to settle their balances.
+type Settlement struct {
+	Id     int          `json:"id"`
+	Date   civil.Date   `json:"date"`
+	From   string       `json:"from"`
+	To     string       `json:"to"`
+	Amount *money.Money `json:"amount"`
+}
+
+// SettleUp is the state of shared expenses between users: the total shared, each user's balance, and the transfers
+// that would settle every balance.
+type SettleUp struct {
+	Total     *money.Money `json:"total"`
+	Balances  []Balance    `json:"balances"`
+	Transfers []Transfer   `json:"transfers"`
+}
+
+// Token is a personal access token for the server's API. Only a hash of the token is stored, so the token itself is
+// only known when it is created. Prefix is the start of the token, to tell tokens apart, and LastUsed is unset if the
+// token hasn't been used. Requests made with a token are attributed to User, if it is set.
+type Token struct {
+	Id       int        `json:"id"`
+	Name     string     `json:"name"`
+	User     string     `json:"user,omitempty"`
+	Prefix   string     `json:"prefix"`
+	Scopes   []string   `json:"scopes"`
+	Created  time.Time  `json:"created"`
+	LastUsed *time.Time `json:"last_used,omitempty"`
 }
@@ -0,0 +1,222 @@
+package dates
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/civil"
+)
+
+// Clock returns the current time.
+type Clock func() time.Time
+
+// clock is the source of today's date for relative dates
+var clock Clock = time.Now
+
+// SetClock replaces the clock used to resolve relative dates, such as to fix today's date in tests. Returns a function
+// that restores the previous clock.
+func SetClock(c Clock) func() {
+	previous := clock
+	clock = c
+	return func() {
+		clock = previous
+	}
+}
+
+// Now returns the current time according to the clock.
+func Now() time.Time {
+	return clock()
+}
+
+// Today returns today's date according to the clock.
+func Today() civil.Date {
+	return civil.DateOf(clock())
+}
+
+var (
+	offsetPattern   = regexp.MustCompile(`^([+-])(\d+)([dwmy])$`)
+	agoPattern      = regexp.MustCompile(`^(\d+)-(day|week|month|year)s?-ago$`)
+	weekdayPattern  = regexp.MustCompile(`^(?:(last|this|next)-)?([a-z]+)$`)
+	lastDaysPattern = regexp.MustCompile(`^last-(\d+)-days$`)
+)
+
+var weekdays = map[string]time.Weekday{
+	"sunday": time.Sunday, "sun": time.Sunday,
+	"monday": time.Monday, "mon": time.Monday,
+	"tuesday": time.Tuesday, "tue": time.Tuesday,
+	"wednesday": time.Wednesday, "wed": time.Wednesday,
+	"thursday": time.Thursday, "thu": time.Thursday,
+	"friday": time.Friday, "fri": time.Friday,
+	"saturday": time.Saturday, "sat": time.Saturday,
+}
+
+// Parse parses a date given as YYYY-MM-DD or relative to today:
+//   - "today", "yesterday" or "tomorrow"
+//   - an offset such as "-3d", "+2w", "-1m" or "-1y"
+//   - a number of days, weeks, months or years ago, such as "3 days ago"
+//   - a weekday, such as "friday" for the most recent Friday up to today, "last friday" for the one before today,
+//     "next friday" for the one after today, or "this friday" for the one in the current week, which starts on Monday
+//
+// Words may be separated by spaces, hyphens or underscores, and case is ignored.
+func Parse(s string) (civil.Date, error) {
+	if date, err := civil.ParseDate(s); err == nil {
+		return date, nil
+	}
+
+	today := Today()
+	word := normalize(s)
+	switch word {
+	case "today":
+		return today, nil
+	case "yesterday":
+		return today.AddDays(-1), nil
+	case "tomorrow":
+		return today.AddDays(1), nil
+	}
+
+	if match := offsetPattern.FindStringSubmatch(word); match != nil {
+		n, _ := strconv.Atoi(match[2])
+		if match[1] == "-" {
+			n = -n
+		}
+		return addUnits(today, n, match[3]), nil
+	}
+	if match := agoPattern.FindStringSubmatch(word); match != nil {
+		n, _ := strconv.Atoi(match[1])
+		return addUnits(today, -n, match[2][:1]), nil
+	}
+	if match := weekdayPattern.FindStringSubmatch(word); match != nil {
+		if weekday, ok := weekdays[match[2]]; ok {
+			return resolveWeekday(today, match[1], weekday), nil
+		}
+	}
+	return civil.Date{}, fmt.Errorf("invalid date '%s', must be YYYY-MM-DD, today, yesterday, a weekday, or an offset such as -3d", s)
+}
+
+// ParseRange parses a named range of dates relative to today, returning its first and last days. The ranges are
+// this-week, last-week, this-month, last-month, this-quarter, last-quarter, this-year and last-year, ytd, qtd and mtd
+// for the current year, quarter or month up to today, and last-N-days for the N days up to today. Anything accepted by
+// Parse is also a range of one day. Weeks start on Monday.
+func ParseRange(s string) (civil.Date, civil.Date, error) {
+	today := Today()
+	monthStart := civil.Date{Year: today.Year, Month: today.Month, Day: 1}
+	quarterStart := civil.Date{Year: today.Year, Month: (today.Month-1)/3*3 + 1, Day: 1}
+	yearStart := civil.Date{Year: today.Year, Month: 1, Day: 1}
+	weekStart := today.AddDays(-((int(weekdayOf(today)) + 6) % 7))
+
+	word := normalize(s)
+	switch word {
+	case "this-week":
+		return weekStart, weekStart.AddDays(6), nil
+	case "last-week":
+		return weekStart.AddDays(-7), weekStart.AddDays(-1), nil
+	case "this-month":
+		return monthStart, addMonths(monthStart, 1).AddDays(-1), nil
+	case "last-month":
+		return addMonths(monthStart, -1), monthStart.AddDays(-1), nil
+	case "this-quarter":
+		return quarterStart, addMonths(quarterStart, 3).AddDays(-1), nil
+	case "last-quarter":
+		return addMonths(quarterStart, -3), quarterStart.AddDays(-1), nil
+	case "this-year":
+		return yearStart, addMonths(yearStart, 12).AddDays(-1), nil
+	case "last-year":
+		return addMonths(yearStart, -12), yearStart.AddDays(-1), nil
+	case "ytd":
+		return yearStart, today, nil
+	case "qtd":
+		return quarterStart, today, nil
+	case "mtd":
+		return monthStart, today, nil
+	}
+	if match := lastDaysPattern.FindStringSubmatch(word); match != nil {
+		n, _ := strconv.Atoi(match[1])
+		if n == 0 {
+			return civil.Date{}, civil.Date{}, errors.New("range must include at least one day")
+		}
+		return today.AddDays(1 - n), today, nil
+	}
+
+	date, err := Parse(s)
+	if err != nil {
+		return civil.Date{}, civil.Date{}, fmt.Errorf("invalid date or range '%s', must be a date or a range such as this-month, last-quarter or ytd", s)
+	}
+	return date, date, nil
+}
+
+// ParseStart parses the start of a span of dates, which is either a date accepted by Parse or the first day of a range
+// accepted by ParseRange.
+func ParseStart(s string) (civil.Date, error) {
+	start, _, err := ParseRange(s)
+	return start, err
+}
+
+// ParseEnd parses the end of a span of dates, which is either a date accepted by Parse or the last day of a range
+// accepted by ParseRange.
+func ParseEnd(s string) (civil.Date, error) {
+	_, end, err := ParseRange(s)
+	return end, err
+}
+
+// normalize lowercases s and joins its words with hyphens.
+func normalize(s string) string {
+	s = strings.ToLower(strings.TrimSpace(s))
+	s = strings.ReplaceAll(s, "_", "-")
+	return strings.Join(strings.Fields(s), "-")
+}
+
+// addUnits returns the date n days, weeks, months or years after d, given the unit's first letter.
+func addUnits(d civil.Date, n int, unit string) civil.Date {
+	switch unit {
+	case "w":
+		return d.AddDays(7 * n)
+	case "m":
+		return addMonths(d, n)
+	case "y":
+		return addMonths(d, 12*n)
+	default:
+		return d.AddDays(n)
+	}
+}
+
+// resolveWeekday returns the date of a weekday relative to today. which is "last", "this", "next", or "" for the most
+// recent one up to today.
+func resolveWeekday(today civil.Date, which string, weekday time.Weekday) civil.Date {
+	daysBack := (int(weekdayOf(today)) - int(weekday) + 7) % 7
+	switch which {
+	case "last":
+		if daysBack == 0 {
+			daysBack = 7
+		}
+		return today.AddDays(-daysBack)
+	case "next":
+		daysAhead := (int(weekday) - int(weekdayOf(today)) + 7) % 7
+		if daysAhead == 0 {
+			daysAhead = 7
+		}
+		return today.AddDays(daysAhead)
+	case "this":
+		monday := today.AddDays(-((int(weekdayOf(today)) + 6) % 7))
+		return monday.AddDays((int(weekday) + 6) % 7)
+	default:
+		return today.AddDays(-daysBack)
+	}
+}
+
+// weekdayOf returns the day of the week of d.
+func weekdayOf(d civil.Date) time.Weekday {
+	return d.In(time.UTC).Weekday()
+}
+
+// addMonths returns the date the given number of months after d, clamping the day to the end of the resulting month
+// so that a month after January 31st is the end of February.
+func addMonths(d civil.Date, n int) civil.Date {
+	first := civil.DateOf(time.Date(d.Year, d.Month+time.Month(n), 1, 0, 0, 0, 0, time.UTC))
+	last := civil.DateOf(time.Date(first.Year, first.Month+1, 0, 0, 0, 0, 0, time.UTC))
+	first.Day = min(d.Day, last.Day)
+	return first
+}
@@ -0,0 +1,85 @@
+package dates
+
+import (
+	"testing"
+	"time"
+
+	"cloud.google.com/go/civil"
+	"gotest.tools/v3/assert"
+)
+
+// fixClock fixes today's date for the rest of a test.
+func fixClock(t *testing.T, year int, month time.Month, day int) {
+	restore := SetClock(func() time.Time {
+		return time.Date(year, month, day, 12, 0, 0, 0, time.Local)
+	})
+	t.Cleanup(restore)
+}
+
+func TestParse(t *testing.T) {
+	// a Sunday
+	fixClock(t, 2026, time.October, 18)
+
+	tests := map[string]civil.Date{
+		"2026-01-31":    {Year: 2026, Month: 1, Day: 31},
+		"today":         {Year: 2026, Month: 10, Day: 18},
+		"Yesterday":     {Year: 2026, Month: 10, Day: 17},
+		"tomorrow":      {Year: 2026, Month: 10, Day: 19},
+		"-3d":           {Year: 2026, Month: 10, Day: 15},
+		"+2w":           {Year: 2026, Month: 11, Day: 1},
+		"-1m":           {Year: 2026, Month: 9, Day: 18},
+		"-1y":           {Year: 2025, Month: 10, Day: 18},
+		"3 days ago":    {Year: 2026, Month: 10, Day: 15},
+		"1_week_ago":    {Year: 2026, Month: 10, Day: 11},
+		"friday":        {Year: 2026, Month: 10, Day: 16},
+		"sunday":        {Year: 2026, Month: 10, Day: 18},
+		"last friday":   {Year: 2026, Month: 10, Day: 16},
+		"last sunday":   {Year: 2026, Month: 10, Day: 11},
+		"next-mon":      {Year: 2026, Month: 10, Day: 19},
+		"this tuesday":  {Year: 2026, Month: 10, Day: 13},
+		"  LAST  FRI  ": {Year: 2026, Month: 10, Day: 16},
+	}
+	for input, expected := range tests {
+		date, err := Parse(input)
+		assert.NilError(t, err, input)
+		assert.Equal(t, date, expected, input)
+	}
+
+	for _, input := range []string{"", "someday", "2026-13-01", "-3x", "last-month"} {
+		_, err := Parse(input)
+		assert.ErrorContains(t, err, "invalid date", input)
+	}
+}
+
+func TestParseRange(t *testing.T) {
+	fixClock(t, 2026, time.May, 31)
+
+	tests := map[string][2]civil.Date{
+		"this-week":    {{Year: 2026, Month: 5, Day: 25}, {Year: 2026, Month: 5, Day: 31}},
+		"last week":    {{Year: 2026, Month: 5, Day: 18}, {Year: 2026, Month: 5, Day: 24}},
+		"this-month":   {{Year: 2026, Month: 5, Day: 1}, {Year: 2026, Month: 5, Day: 31}},
+		"last-month":   {{Year: 2026, Month: 4, Day: 1}, {Year: 2026, Month: 4, Day: 30}},
+		"this-quarter": {{Year: 2026, Month: 4, Day: 1}, {Year: 2026, Month: 6, Day: 30}},
+		"last-quarter": {{Year: 2026, Month: 1, Day: 1}, {Year: 2026, Month: 3, Day: 31}},
+		"last-year":    {{Year: 2025, Month: 1, Day: 1}, {Year: 2025, Month: 12, Day: 31}},
+		"ytd":          {{Year: 2026, Month: 1, Day: 1}, {Year: 2026, Month: 5, Day: 31}},
+		"last-30-days": {{Year: 2026, Month: 5, Day: 2}, {Year: 2026, Month: 5, Day: 31}},
+		"yesterday":    {{Year: 2026, Month: 5, Day: 30}, {Year: 2026, Month: 5, Day: 30}},
+	}
+	for input, expected := range tests {
+		start, end, err := ParseRange(input)
+		assert.NilError(t, err, input)
+		assert.Equal(t, start, expected[0], input)
+		assert.Equal(t, end, expected[1], input)
+	}
+
+	// a month before the 31st clamps to the end of the shorter month
+	date, err := Parse("-1m")
+	assert.NilError(t, err)
+	assert.Equal(t, date, civil.Date{Year: 2026, Month: 4, Day: 30})
+
+	_, _, err = ParseRange("last-0-days")
+	assert.ErrorContains(t, err, "at least one day")
+	_, _, err = ParseRange("next-decade")
+	assert.ErrorContains(t, err, "invalid date or range")
+}
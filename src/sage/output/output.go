@@ -0,0 +1,256 @@
+package output
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+
+	"cloud.google.com/go/civil"
+	"github.com/Rhymond/go-money"
+)
+
+const (
+	TABLE = "table"
+	PLAIN = "plain"
+	JSON  = "json"
+	CSV   = "csv"
+	TSV   = "tsv"
+)
+
+const DEFAULT_FORMAT = PLAIN
+
+// ParseFormat validates the name of an output format.
+func ParseFormat(format string) (string, error) {
+	switch format {
+	case TABLE, PLAIN, JSON, CSV, TSV:
+		return format, nil
+	}
+	return "", errors.New("output must be table, plain, json, csv or tsv")
+}
+
+// Column describes a column of a View. Right aligns the column in tables, and Total sums its amounts in the table
+// footer. Total should only be set on columns of *money.Money values.
+type Column struct {
+	Name  string
+	Right bool
+	Total bool
+}
+
+// View is the result of a command in a form that can be rendered in every output format. Rows holds one value per
+// column, which may be a string, int, *money.Money, *float64 percentage, civil.Date, or nil for an empty cell. Lines
+// is the plain form of the result, and defaults to the cells of each row separated by " | " if unset. JSON is the
+// value encoded for json output, and should have the same shape as the HTTP API's response.
+type View struct {
+	Columns []Column
+	Rows    [][]any
+	Lines   []string
+	JSON    any
+}
+
+// Message returns a View of a message, such as the confirmation of a change.
+func Message(message string) *View {
+	return &View{
+		Lines: []string{message},
+		JSON:  map[string]any{"message": message},
+	}
+}
+
+// Error returns a View of an error, with the same shape as the HTTP API's error responses for json output.
+func Error(err error) *View {
+	return &View{
+		Lines: []string{err.Error()},
+		JSON:  map[string]any{"message": err.Error()},
+	}
+}
+
+// Render writes view to w in the given format.
+func Render(w io.Writer, format string, view *View) error {
+	switch format {
+	case JSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(view.JSON)
+	case CSV, TSV:
+		if view.Columns == nil {
+			return renderLines(w, view.Lines)
+		}
+		return renderDelimited(w, format, view)
+	case TABLE:
+		if view.Columns == nil {
+			return renderLines(w, view.Lines)
+		}
+		return renderTable(w, view)
+	default:
+		if view.Lines == nil {
+			lines := make([]string, len(view.Rows))
+			for i, row := range view.Rows {
+				cells := make([]string, len(row))
+				for j, value := range row {
+					cells[j] = formatCell(value, true)
+				}
+				lines[i] = strings.Join(cells, " | ")
+			}
+			return renderLines(w, lines)
+		}
+		return renderLines(w, view.Lines)
+	}
+}
+
+// renderLines writes each line followed by a newline.
+func renderLines(w io.Writer, lines []string) error {
+	for _, line := range lines {
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// renderDelimited writes the view as comma or tab separated values with a header row. Amounts are written without a
+// currency symbol so that they can be read as numbers.
+func renderDelimited(w io.Writer, format string, view *View) error {
+	writer := csv.NewWriter(w)
+	if format == TSV {
+		writer.Comma = '\t'
+	}
+
+	header := make([]string, len(view.Columns))
+	for i, column := range view.Columns {
+		header[i] = column.Name
+	}
+	if err := writer.Write(header); err != nil {
+		return err
+	}
+	for _, row := range view.Rows {
+		record := make([]string, len(row))
+		for i, value := range row {
+			record[i] = formatCell(value, false)
+		}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// renderTable writes the view as a table with aligned columns, followed by a footer with the totals of the Total
+// columns if there are any.
+func renderTable(w io.Writer, view *View) error {
+	var rows [][]string
+	header := make([]string, len(view.Columns))
+	for i, column := range view.Columns {
+		header[i] = column.Name
+	}
+	rows = append(rows, header)
+	for _, row := range view.Rows {
+		cells := make([]string, len(row))
+		for i, value := range row {
+			cells[i] = formatCell(value, true)
+		}
+		rows = append(rows, cells)
+	}
+
+	footer := tableFooter(view)
+	if footer != nil {
+		rows = append(rows, footer)
+	}
+
+	widths := make([]int, len(view.Columns))
+	for _, row := range rows {
+		for i, cell := range row {
+			widths[i] = max(widths[i], utf8.RuneCountInString(cell))
+		}
+	}
+	rule := make([]string, len(widths))
+	for i, width := range widths {
+		rule[i] = strings.Repeat("-", width)
+	}
+
+	lines := []string{formatRow(view.Columns, widths, header), strings.Join(rule, "  ")}
+	for _, row := range rows[1 : len(view.Rows)+1] {
+		lines = append(lines, formatRow(view.Columns, widths, row))
+	}
+	if footer != nil {
+		lines = append(lines, strings.Join(rule, "  "), formatRow(view.Columns, widths, footer))
+	}
+	return renderLines(w, lines)
+}
+
+// tableFooter returns the totals of the view's Total columns, labelled in the first column. Returns nil if no column
+// is totalled or there are no rows.
+func tableFooter(view *View) []string {
+	if len(view.Rows) == 0 {
+		return nil
+	}
+
+	footer := make([]string, len(view.Columns))
+	hasTotal := false
+	for i, column := range view.Columns {
+		if !column.Total {
+			continue
+		}
+		hasTotal = true
+		total := money.New(0, money.USD)
+		for _, row := range view.Rows {
+			if amount, ok := row[i].(*money.Money); ok && amount != nil {
+				total = money.New(total.Amount()+amount.Amount(), money.USD)
+			}
+		}
+		footer[i] = formatCell(total, true)
+	}
+	if !hasTotal {
+		return nil
+	}
+	if footer[0] == "" {
+		footer[0] = "Total"
+	}
+	return footer
+}
+
+// formatRow pads each cell to the width of its column, aligned according to the column.
+func formatRow(columns []Column, widths []int, cells []string) string {
+	padded := make([]string, len(cells))
+	for i, cell := range cells {
+		padding := strings.Repeat(" ", widths[i]-utf8.RuneCountInString(cell))
+		if columns[i].Right {
+			padded[i] = padding + cell
+		} else {
+			padded[i] = cell + padding
+		}
+	}
+	return strings.TrimRight(strings.Join(padded, "  "), " ")
+}
+
+// formatCell formats a value of a View's row. Amounts are prefixed with a dollar sign if symbol is set.
+func formatCell(value any, symbol bool) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case int:
+		return fmt.Sprint(v)
+	case civil.Date:
+		return v.String()
+	case *money.Money:
+		if v == nil {
+			return ""
+		}
+		if symbol {
+			return fmt.Sprintf("$%.2f", v.AsMajorUnits())
+		}
+		return fmt.Sprintf("%.2f", v.AsMajorUnits())
+	case *float64:
+		if v == nil {
+			return ""
+		}
+		return fmt.Sprintf("%+.1f%%", *v)
+	default:
+		return fmt.Sprint(v)
+	}
+}
@@ -0,0 +1,90 @@
+package output
+
+import (
+	"bytes"
+	"testing"
+
+	"cloud.google.com/go/civil"
+	"github.com/Rhymond/go-money"
+	"gotest.tools/v3/assert"
+)
+
+func testView() *View {
+	change := -12.5
+	return &View{
+		Columns: []Column{
+			{Name: "Date"},
+			{Name: "Location"},
+			{Name: "Amount", Right: true, Total: true},
+			{Name: "Change", Right: true},
+		},
+		Rows: [][]any{
+			{civil.Date{Year: 2026, Month: 9, Day: 1}, "Cafe, Downtown", money.New(450, money.USD), &change},
+			{civil.Date{Year: 2026, Month: 9, Day: 2}, "Landlord", money.New(150000, money.USD), nil},
+		},
+		JSON: map[string]any{"result": []int{1, 2}},
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	for _, format := range []string{TABLE, PLAIN, JSON, CSV, TSV} {
+		parsed, err := ParseFormat(format)
+		assert.NilError(t, err)
+		assert.Equal(t, parsed, format)
+	}
+
+	_, err := ParseFormat("yaml")
+	assert.Error(t, err, "output must be table, plain, json, csv or tsv")
+}
+
+func TestRenderTable(t *testing.T) {
+	var b bytes.Buffer
+	assert.NilError(t, Render(&b, TABLE, testView()))
+	assert.Equal(t, b.String(), ""+
+		"Date        Location          Amount  Change\n"+
+		"----------  --------------  --------  ------\n"+
+		"2026-09-01  Cafe, Downtown     $4.50  -12.5%\n"+
+		"2026-09-02  Landlord        $1500.00\n"+
+		"----------  --------------  --------  ------\n"+
+		"Total                       $1504.50\n")
+}
+
+func TestRenderPlain(t *testing.T) {
+	var b bytes.Buffer
+	assert.NilError(t, Render(&b, PLAIN, testView()))
+	assert.Equal(t, b.String(), ""+
+		"2026-09-01 | Cafe, Downtown | $4.50 | -12.5%\n"+
+		"2026-09-02 | Landlord | $1500.00 | \n")
+
+	view := testView()
+	view.Lines = []string{"custom"}
+	b.Reset()
+	assert.NilError(t, Render(&b, PLAIN, view))
+	assert.Equal(t, b.String(), "custom\n")
+}
+
+func TestRenderDelimited(t *testing.T) {
+	var b bytes.Buffer
+	assert.NilError(t, Render(&b, CSV, testView()))
+	assert.Equal(t, b.String(), ""+
+		"Date,Location,Amount,Change\n"+
+		"2026-09-01,\"Cafe, Downtown\",4.50,-12.5%\n"+
+		"2026-09-02,Landlord,1500.00,\n")
+
+	b.Reset()
+	assert.NilError(t, Render(&b, TSV, testView()))
+	assert.Equal(t, b.String(), ""+
+		"Date\tLocation\tAmount\tChange\n"+
+		"2026-09-01\tCafe, Downtown\t4.50\t-12.5%\n"+
+		"2026-09-02\tLandlord\t1500.00\t\n")
+}
+
+func TestRenderJSON(t *testing.T) {
+	var b bytes.Buffer
+	assert.NilError(t, Render(&b, JSON, testView()))
+	assert.Equal(t, b.String(), "{\n  \"result\": [\n    1,\n    2\n  ]\n}\n")
+
+	b.Reset()
+	assert.NilError(t, Render(&b, JSON, Message("Expense added successfully")))
+	assert.Equal(t, b.String(), "{\n  \"message\": \"Expense added successfully\"\n}\n")
+}
@@ -0,0 +1,172 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"sage/src/sage/cmd"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Error codes returned when a request isn't authenticated, or its token lacks the scope for the route
+const (
+	CODE_UNAUTHORIZED = "unauthorized"
+	CODE_FORBIDDEN    = "forbidden"
+)
+
+// Key of the request context holding the name of the user a request is authenticated as, which is empty if the
+// token isn't attributed to a user
+const USER_KEY = "user"
+
+// Key of the request context holding the scopes granted to a request's token, which is unset when authentication is
+// disabled
+const SCOPES_KEY = "scopes"
+
+// Query string parameter holding the token for /events, since browsers can't send headers with an EventSource
+const TOKEN_PARAM = "token"
+
+// queryTokenRoutes also take their token from the TOKEN_PARAM query string parameter
+var queryTokenRoutes = []string{"GET /events"}
+
+// publicRoutes are served without a token
+var publicRoutes = []string{"GET /openapi.json", "POST " + V2_PREFIX + "/sessions"}
+
+// adminRoutes need the admin scope, since they change every user's expenses or balances, or list the users
+var adminRoutes = []string{
+	"PUT /categories/:name",
+	"DELETE /categories/:name",
+	"PATCH " + V2_PREFIX + "/categories/:name",
+	"DELETE " + V2_PREFIX + "/categories/:name",
+	"POST " + V2_PREFIX + "/settlements",
+	"GET " + V2_PREFIX + "/users",
+}
+
+// sessionScopes are the scopes granted to a login session
+var sessionScopes = []string{cmd.SCOPE_WRITE}
+
+// authMiddleware requires a personal access token or session token in an "Authorization: Bearer" header, or for the
+// routes in queryTokenRoutes, optionally the TOKEN_PARAM query string parameter instead. Requests
+// that only read need the read scope, the routes in adminRoutes need the admin scope, and any others need the write
+// scope.
+func authMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if slices.Contains(publicRoutes, c.Request.Method+" "+c.Request.URL.Path) {
+			c.Next()
+			return
+		}
+
+		token := bearerToken(c)
+		if token == "" && slices.Contains(queryTokenRoutes, c.Request.Method+" "+c.FullPath()) {
+			token = c.Query(TOKEN_PARAM)
+		}
+		if token == "" {
+			c.Header("WWW-Authenticate", `Bearer realm="sage"`)
+			abortAuth(c, http.StatusUnauthorized, CODE_UNAUTHORIZED, "missing bearer token")
+			return
+		}
+		var user string
+		var scopes []string
+		var err error
+		if cmd.IsSessionToken(token) {
+			user, err = cmd.AuthenticateSession(db, token)
+			scopes = sessionScopes
+		} else {
+			user, scopes, err = cmd.AuthenticateToken(db, token)
+		}
+		if errors.Is(err, cmd.ErrUnauthorized) {
+			c.Header("WWW-Authenticate", `Bearer realm="sage", error="invalid_token"`)
+			abortAuth(c, http.StatusUnauthorized, CODE_UNAUTHORIZED, kindMessage(err))
+			return
+		}
+		if err != nil {
+			abortAuth(c, http.StatusInternalServerError, CODE_INTERNAL, err.Error())
+			return
+		}
+
+		scope := requiredScope(c.Request.Method, c.FullPath())
+		if !cmd.HasScope(scopes, scope) {
+			abortAuth(c, http.StatusForbidden, CODE_FORBIDDEN, "token does not have the '"+scope+"' scope")
+			return
+		}
+		c.Set(USER_KEY, user)
+		c.Set(SCOPES_KEY, scopes)
+		c.Next()
+	}
+}
+
+// requestUser returns the name of the user a request is authenticated as, or an empty string if there is none.
+func requestUser(c *gin.Context) string {
+	return c.GetString(USER_KEY)
+}
+
+// actsFor reports whether a request may record expenses paid by, or settlements from, the given user. Requests may only
+// act for the user they are authenticated as, unless their token has the admin scope or authentication is disabled.
+func actsFor(c *gin.Context, user string) bool {
+	scopes, ok := c.Get(SCOPES_KEY)
+	if !ok || user == requestUser(c) {
+		return true
+	}
+	return cmd.HasScope(scopes.([]string), cmd.SCOPE_ADMIN)
+}
+
+// bearerToken returns the token in a request's "Authorization: Bearer" header, or an empty string if there is none.
+func bearerToken(c *gin.Context) string {
+	token, _ := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	return token
+}
+
+// requiredScope returns the scope a token needs to make a request with the given method to the given route.
+func requiredScope(method, route string) string {
+	if slices.Contains(adminRoutes, method+" "+route) {
+		return cmd.SCOPE_ADMIN
+	}
+	if method == http.MethodGet || method == http.MethodHead {
+		return cmd.SCOPE_READ
+	}
+	return cmd.SCOPE_WRITE
+}
+
+// abortAuth responds with the error envelope for v2 routes, or a message for the v1 routes.
+func abortAuth(c *gin.Context, status int, code, message string) {
+	if strings.HasPrefix(c.Request.URL.Path, V2_PREFIX+"/") {
+		abortV2(c, status, code, message)
+		return
+	}
+	c.AbortWithStatusJSON(status, gin.H{"message": message})
+}
+
+// loopbackHostMiddleware rejects requests whose Host header doesn't name the local machine. Without authentication,
+// this stops a page on another site from reaching the server by pointing its own domain at 127.0.0.1 (DNS rebinding).
+func loopbackHostMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		host := c.Request.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+		if !isLoopbackHost(strings.ToLower(host)) {
+			abortAuth(c, http.StatusForbidden, CODE_FORBIDDEN, "host '"+c.Request.Host+"' is not allowed without authentication")
+			return
+		}
+		c.Next()
+	}
+}
+
+// isLoopbackHost reports whether host names the local machine, such as localhost or 127.0.0.1.
+func isLoopbackHost(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// isLoopbackOrigin reports whether a browser origin, such as http://localhost:3000, is served from the local machine.
+func isLoopbackOrigin(origin string) bool {
+	u, err := url.Parse(origin)
+	return err == nil && isLoopbackHost(u.Hostname())
+}
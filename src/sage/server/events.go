@@ -0,0 +1,193 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sage/src/sage/cmd"
+	"sage/src/sage/data"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// Number of changes buffered for a client streaming /events before it is disconnected for falling behind
+	EVENT_BUFFER = 64
+	// How often a comment is sent to clients streaming /events, so that idle connections aren't closed
+	KEEPALIVE_INTERVAL = 30 * time.Second
+	// Event sent to a client whose Last-Event-ID is older than the changes kept, so it should reload every expense
+	RESET_EVENT = "reset"
+)
+
+// changeHub records the changes made to expenses through the server and sends them to the clients streaming /events
+type changeHub struct {
+	mu          sync.Mutex
+	subscribers map[chan data.Change]bool
+}
+
+var hub = &changeHub{subscribers: map[chan data.Change]bool{}}
+
+// publish records a change to an expense, sends it to every subscriber and delivers it to the webhooks that subscribe
+// to it. Subscribers that have fallen behind are unsubscribed instead, closing their channel, so that they can resume
+// from the recorded changes. Changes are recorded and sent one at a time, so subscribers receive them in order.
+func (h *changeHub) publish(changeType string, expense data.Expense) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	change, err := cmd.RecordChange(db, changeType, expense)
+	if err != nil {
+		return err
+	}
+	for sub := range h.subscribers {
+		select {
+		case sub <- *change:
+		default:
+			delete(h.subscribers, sub)
+			close(sub)
+		}
+	}
+	webhooks.dispatch(*change)
+	return nil
+}
+
+// subscribe returns a channel that receives every change published from now on.
+func (h *changeHub) subscribe() chan data.Change {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	sub := make(chan data.Change, EVENT_BUFFER)
+	h.subscribers[sub] = true
+	return sub
+}
+
+// unsubscribe stops sending changes to a subscriber, unless it was already unsubscribed for falling behind.
+func (h *changeHub) unsubscribe(sub chan data.Change) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.subscribers[sub] {
+		delete(h.subscribers, sub)
+		close(sub)
+	}
+}
+
+// publishExpense publishes a change to the expense with the given ID as it is now. Failures are attached to the
+// request rather than failing it, since the expense has already changed.
+func publishExpense(c *gin.Context, changeType string, id int) {
+	getResp := cmd.GetExpense(db, &cmd.GetRequest{Id: id})
+	if !getResp.Success {
+		c.Error(fmt.Errorf("error publishing change to expense %d: %w", id, getResp.Error))
+		return
+	}
+	publishChange(c, changeType, getResp.Result)
+}
+
+// publishExpenses publishes the same type of change to each of the expenses with the given IDs.
+func publishExpenses(c *gin.Context, changeType string, ids []int) {
+	for _, id := range ids {
+		publishExpense(c, changeType, id)
+	}
+}
+
+// publishChange publishes a change to an expense, attaching any failure to the request.
+func publishChange(c *gin.Context, changeType string, expense data.Expense) {
+	if err := hub.publish(changeType, expense); err != nil {
+		c.Error(fmt.Errorf("error publishing change to expense %d: %w", expense.Id, err))
+	}
+}
+
+// eventsHandler streams changes to expenses as server-sent events, whose ID is the change's place in the sequence of
+// changes, whose event is the type of change and whose data is the change as JSON. A client that reconnects with a
+// Last-Event-ID header is first sent the changes it missed, as long as they are recent enough to have been kept. If
+// some have been removed, it is sent a reset event instead, with the ID of the latest change, and should reload every
+// expense.
+func eventsHandler(c *gin.Context) {
+	lastId := int64(-1)
+	if header := c.GetHeader("Last-Event-ID"); header != "" {
+		id, err := strconv.ParseInt(header, 10, 64)
+		if err != nil || id < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"message": "invalid Last-Event-ID"})
+			return
+		}
+		lastId = id
+	}
+
+	// subscribe before looking up missed changes, so that none are lost in between
+	sub := hub.subscribe()
+	defer hub.unsubscribe(sub)
+	var missed []data.Change
+	reset := false
+	if lastId >= 0 {
+		oldest, latest, err := cmd.ChangeRange(db)
+		if err == nil {
+			// changes after the client's last one have been removed, or it was sent changes from a different database
+			if lastId+1 < oldest || lastId > latest {
+				reset, lastId = true, latest
+			} else {
+				missed, err = cmd.ChangesSince(db, lastId)
+			}
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+			return
+		}
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	if reset {
+		_, err := fmt.Fprintf(c.Writer, "id: %d\nevent: %s\ndata: {}\n\n", lastId, RESET_EVENT)
+		if err != nil {
+			return
+		}
+	}
+	for _, change := range missed {
+		if writeEvent(c.Writer, change) != nil {
+			return
+		}
+		lastId = change.Id
+	}
+	c.Writer.Flush()
+
+	keepalive := time.NewTicker(KEEPALIVE_INTERVAL)
+	defer keepalive.Stop()
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case change, ok := <-sub:
+			if !ok {
+				// fell behind, so the client should reconnect to resume from the recorded changes
+				return
+			}
+			if change.Id <= lastId {
+				continue
+			}
+			if writeEvent(c.Writer, change) != nil {
+				return
+			}
+			lastId = change.Id
+		case <-keepalive.C:
+			if _, err := io.WriteString(c.Writer, ": keepalive\n\n"); err != nil {
+				return
+			}
+		}
+		c.Writer.Flush()
+	}
+}
+
+// writeEvent writes a change as a server-sent event.
+func writeEvent(w io.Writer, change data.Change) error {
+	payload, err := json.Marshal(change)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", change.Id, change.Type, payload)
+	return err
+}
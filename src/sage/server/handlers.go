@@ -1,13 +1,14 @@
 package server
 
 import (
+	"errors"
 	"net/http"
 	"sage/src/sage/cmd"
 	"sage/src/sage/data"
+	"sage/src/sage/dates"
 	"strconv"
-	"time"
+	"strings"
 
-	"cloud.google.com/go/civil"
 	"github.com/Rhymond/go-money"
 	"github.com/gin-gonic/gin"
 )
@@ -17,14 +18,21 @@ func addHandler(c *gin.Context) {
 	dateStr := c.Query("date")
 	locationStr := c.Query("location")
 	descStr := c.Query("description")
+	categoryStr := c.Query("category")
 	amtStr := c.Query("amount")
+	paidBy := c.DefaultQuery("paid_by", requestUser(c))
+	sharedStr := c.Query("shared")
 
 	if dateStr == "" || amtStr == "" {
 		c.JSON(http.StatusBadRequest, gin.H{"message": "amount and date are required"})
 		return
 	}
+	if !actsFor(c, paidBy) {
+		c.JSON(http.StatusForbidden, gin.H{"message": "recording expenses paid by another user requires the 'admin' scope"})
+		return
+	}
 
-	date, err := civil.ParseDate(dateStr)
+	date, err := dates.Parse(dateStr)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"message": "invalid date format"})
 		return
@@ -35,19 +43,34 @@ func addHandler(c *gin.Context) {
 		return
 	}
 	amt := money.NewFromFloat(fl, money.USD)
+	shared := false
+	if sharedStr != "" {
+		shared, err = strconv.ParseBool(sharedStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"message": "invalid shared format"})
+			return
+		}
+	}
 
 	addReq := &cmd.AddRequest{
 		Expense: data.Expense{
 			Date:        date,
 			Location:    locationStr,
 			Description: descStr,
+			Category:    categoryStr,
 			Amount:      amt,
+			PaidBy:      paidBy,
+			CreatedBy:   requestUser(c),
+			Shared:      shared,
 		},
 	}
 
 	addResp := cmd.AddExpense(db, addReq)
 	if addResp.Success {
+		publishExpense(c, cmd.CHANGE_CREATED, addResp.Id)
 		c.JSON(http.StatusOK, gin.H{"message": "expense added successfully"})
+	} else if errors.Is(addResp.Error, cmd.ErrUnknownCategory) || errors.Is(addResp.Error, cmd.ErrUnknownUser) {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"message": kindMessage(addResp.Error)})
 	} else {
 		c.JSON(http.StatusInternalServerError, gin.H{"message": addResp.Error.Error()})
 	}
@@ -55,8 +78,39 @@ func addHandler(c *gin.Context) {
 
 // logHandler handles logging expenses with the given query string parameters
 func logHandler(c *gin.Context) {
-	startStr := c.Query("start")
-	endStr := c.Query("end")
+	logReq, err := parseLogQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+	logResp := cmd.LogExpenses(db, logReq)
+	if logResp.Success {
+		defer logResp.Result.Close()
+
+		results, err := cmd.ScanExpenses(logResp.Result, logResp.ShowId)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+			return
+		}
+		body := gin.H{"show_id": logResp.ShowId, "result": results}
+		if logResp.NextCursor != "" {
+			body["next_cursor"] = logResp.NextCursor
+		}
+		if logResp.PrevCursor != "" {
+			body["prev_cursor"] = logResp.PrevCursor
+		}
+		c.JSON(http.StatusOK, body)
+	} else {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": logResp.Error.Error()})
+	}
+}
+
+// parseLogQuery constructs a LogRequest from the query string parameters accepted by logHandler
+func parseLogQuery(c *gin.Context) (*cmd.LogRequest, error) {
+	startStr, endStr, err := cmd.RangeArgs(c.Query("range"), c.Query("start"), c.Query("end"))
+	if err != nil {
+		return nil, err
+	}
 	yearStr := c.Query("year")
 	monthStr := c.Query("month")
 	limitStr := c.Query("limit")
@@ -64,6 +118,7 @@ func logHandler(c *gin.Context) {
 	pageStr := c.Query("page")
 	showIdStr := c.Query("show-id")
 	query := c.Query("query")
+	uncategorizedStr := c.Query("uncategorized")
 
 	year := 0
 	month := 0
@@ -71,173 +126,162 @@ func logHandler(c *gin.Context) {
 	pageSize := cmd.MAX_PAGE_SIZE
 	page := 0
 	showId := false
-	var err error
+	uncategorized := false
 
 	if yearStr != "" {
 		year, err = strconv.Atoi(yearStr)
 		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"message": "invalid year format"})
-			return
+			return nil, errors.New("invalid year format")
 		}
 	}
 	if monthStr != "" {
 		month, err = strconv.Atoi(monthStr)
 		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"message": "invalid month format"})
-			return
+			return nil, errors.New("invalid month format")
 		}
 	}
 	if limitStr != "" {
 		limit, err = strconv.Atoi(limitStr)
 		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"message": "invalid limit format"})
-			return
+			return nil, errors.New("invalid limit format")
 		}
 	}
 	if pageSizeStr != "" {
 		pageSize, err = strconv.Atoi(pageSizeStr)
 		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"message": "invalid page size format"})
-			return
+			return nil, errors.New("invalid page size format")
 		}
 	}
 	if pageStr != "" {
 		page, err = strconv.Atoi(pageStr)
 		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"message": "invalid page format"})
-			return
+			return nil, errors.New("invalid page format")
 		}
 	}
 	if showIdStr != "" {
 		showId, err = strconv.ParseBool(showIdStr)
 		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"message": "invalid show ID format"})
-			return
+			return nil, errors.New("invalid show ID format")
+		}
+	}
+	if uncategorizedStr != "" {
+		uncategorized, err = strconv.ParseBool(uncategorizedStr)
+		if err != nil {
+			return nil, errors.New("invalid uncategorized format")
 		}
 	}
 
-	logReq, err := cmd.ParseLogArgs(startStr, endStr, year, month, limit, pageSize, page, showId, query)
+	return cmd.ParseLogArgs(startStr, endStr, year, month, limit, pageSize, page, c.Query("cursor"), c.Query("sort"), showId, query, cmd.FilterArgs{
+		MinAmount:         c.Query("min-amount"),
+		MaxAmount:         c.Query("max-amount"),
+		Categories:        queryList(c, "category"),
+		ExcludeCategories: queryList(c, "exclude-category"),
+		Uncategorized:     uncategorized,
+		Location:          c.Query("location"),
+		Who:               c.Query("who"),
+	})
+}
+
+// queryList returns the values of a query string parameter that may be repeated and/or comma-separated
+func queryList(c *gin.Context, key string) []string {
+	var list []string
+	for _, value := range c.QueryArray(key) {
+		for _, item := range strings.Split(value, ",") {
+			item = strings.TrimSpace(item)
+			if item != "" {
+				list = append(list, item)
+			}
+		}
+	}
+	return list
+}
+
+// summaryHandler handles summarizing expenses with the given query string parameters
+func summaryHandler(c *gin.Context) {
+	sumReq, err := parseSummaryQuery(c)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
 		return
 	}
-	logResp := cmd.LogExpenses(db, logReq)
-	if logResp.Success {
-		defer logResp.Result.Close()
+	sumResp := cmd.SummarizeExpenses(db, sumReq)
+	if sumResp.Success {
+		defer sumResp.Result.Close()
 
-		var results []data.Expense
-		var date time.Time
-		var location string
-		var description string
-		var amt money.Amount
-
-		for logResp.Result.Next() {
-			if logResp.ShowId {
-				var id int
-				err := logResp.Result.Scan(&id, &date, &location, &description, &amt)
-				if err != nil {
-					c.JSON(http.StatusInternalServerError, gin.H{"message": err})
-				}
-				row := data.Expense{
-					Id:          id,
-					Date:        civil.DateOf(date),
-					Location:    location,
-					Description: description,
-					Amount:      money.New(amt, "USD"),
-				}
-				results = append(results, row)
-			} else {
-				err := logResp.Result.Scan(&date, &location, &description, &amt)
-				if err != nil {
-					c.JSON(http.StatusInternalServerError, gin.H{"message": err})
-				}
-				row := data.Expense{
-					Date:        civil.DateOf(date),
-					Location:    location,
-					Description: description,
-					Amount:      money.New(amt, "USD"),
-				}
-				results = append(results, row)
-			}
+		results, err := sumReq.ScanSummaries(sumResp)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+			return
 		}
-		c.JSON(http.StatusOK, gin.H{"show_id": logResp.ShowId, "result": results})
+		c.JSON(http.StatusOK, gin.H{"result": results})
 	} else {
-		c.JSON(http.StatusInternalServerError, gin.H{"message": logResp.Error.Error()})
+		c.JSON(http.StatusInternalServerError, gin.H{"message": sumResp.Error.Error()})
 	}
 }
 
-// summaryHandler handles summarizing expenses with the given query string parameters
-func summaryHandler(c *gin.Context) {
-	startStr := c.Query("start")
-	endStr := c.Query("end")
+// parseSummaryQuery constructs a SummaryRequest from the query string parameters accepted by summaryHandler
+func parseSummaryQuery(c *gin.Context) (*cmd.SummaryRequest, error) {
+	startStr, endStr, err := cmd.RangeArgs(c.Query("range"), c.Query("start"), c.Query("end"))
+	if err != nil {
+		return nil, err
+	}
 	yearStr := c.Query("year")
 	limitStr := c.Query("limit")
 	pageSizeStr := c.Query("page-size")
 	pageStr := c.Query("page")
+	fiscalStartStr := c.Query("fiscal-start")
+	statsStr := c.Query("stats")
 
 	year := 0
 	limit := 0
 	pageSize := cmd.MAX_PAGE_SIZE
 	page := 0
-	var err error
+	fiscalStart := 0
+	stats := false
 
 	if yearStr != "" {
 		year, err = strconv.Atoi(yearStr)
 		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"message": "invalid year format"})
-			return
+			return nil, errors.New("invalid year format")
 		}
 	}
 	if limitStr != "" {
 		limit, err = strconv.Atoi(limitStr)
 		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"message": "invalid limit format"})
-			return
+			return nil, errors.New("invalid limit format")
 		}
 	}
 	if pageSizeStr != "" {
 		pageSize, err = strconv.Atoi(pageSizeStr)
 		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"message": "invalid page size format"})
-			return
+			return nil, errors.New("invalid page size format")
 		}
 	}
 	if pageStr != "" {
 		page, err = strconv.Atoi(pageStr)
 		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"message": "invalid page format"})
-			return
+			return nil, errors.New("invalid page format")
 		}
 	}
 
-	sumReq, err := cmd.ParseSummaryArgs(startStr, endStr, year, limit, pageSize, page)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
-		return
+	if fiscalStartStr != "" {
+		fiscalStart, err = strconv.Atoi(fiscalStartStr)
+		if err != nil {
+			return nil, errors.New("invalid fiscal start format")
+		}
 	}
-	sumResp := cmd.SummarizeExpenses(db, sumReq)
-	if sumResp.Success {
-		defer sumResp.Result.Close()
-
-		var results []data.Summary
-		var month string
-		var totalSpent money.Amount
-
-		for sumResp.Result.Next() {
-			err := sumResp.Result.Scan(&month, &totalSpent)
-			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"message": err})
-			}
-			row := data.Summary{
-				Month: month,
-				Total: money.New(totalSpent, "USD"),
-			}
-			results = append(results, row)
+	if statsStr != "" {
+		stats, err = strconv.ParseBool(statsStr)
+		if err != nil {
+			return nil, errors.New("invalid stats format")
 		}
-		c.JSON(http.StatusOK, gin.H{"result": results})
-	} else {
-		c.JSON(http.StatusInternalServerError, gin.H{"message": sumResp.Error.Error()})
 	}
+
+	sumReq, err := cmd.ParseSummaryArgs(startStr, endStr, year, limit, pageSize, page, c.Query("interval"), fiscalStart, stats)
+	if err != nil {
+		return nil, err
+	}
+	sumReq.Who = c.Query("who")
+	return sumReq, nil
 }
 
 // deleteHandler handles deleting an expense with the given query string parameters
@@ -254,28 +298,328 @@ func deleteHandler(c *gin.Context) {
 		return
 	}
 
+	getResp := cmd.GetExpense(db, &cmd.GetRequest{Id: id})
 	deleteResp := cmd.DeleteExpense(db, &cmd.DeleteRequest{
 		Id: id,
 	})
 	if deleteResp.Success {
+		if getResp.Success {
+			publishChange(c, cmd.CHANGE_DELETED, getResp.Result)
+		}
 		c.JSON(http.StatusOK, gin.H{"message": "expense deleted successfully"})
 	} else {
 		c.JSON(http.StatusInternalServerError, gin.H{"message": deleteResp.Error.Error()})
 	}
 }
 
-// countHandler handles counting the number of total expenses
+// countHandler handles counting the total number of rows and pages for a log or summary request. Accepts the same
+// query string parameters as logHandler and summaryHandler respectively.
 func countHandler(c *gin.Context) {
 	typeStr := c.Params.ByName("type")
-	if typeStr != "log" && typeStr != "summary" {
+	countReq := &cmd.CountRequest{Type: typeStr}
+	if typeStr == "log" {
+		logReq, err := parseLogQuery(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+			return
+		}
+		countReq.ExpenseFilter = logReq.ExpenseFilter
+		countReq.PageSize = logReq.PageSize
+	} else if typeStr == "summary" {
+		sumReq, err := parseSummaryQuery(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+			return
+		}
+		countReq.ExpenseFilter = sumReq.ExpenseFilter
+		countReq.Interval = sumReq.Interval
+		countReq.FiscalStartMonth = sumReq.FiscalStartMonth
+		countReq.PageSize = sumReq.PageSize
+	} else {
 		c.JSON(http.StatusBadRequest, gin.H{"message": "invalid type"})
 		return
 	}
 
-	countResp := cmd.CountExpenses(db, &cmd.CountRequest{Type: typeStr})
+	countResp := cmd.CountExpenses(db, countReq)
 	if countResp.Success {
-		c.JSON(http.StatusOK, gin.H{"count": countResp.Result})
+		c.JSON(http.StatusOK, gin.H{"count": countResp.Result, "pages": countResp.Pages})
 	} else {
 		c.JSON(http.StatusInternalServerError, gin.H{"message": countResp.Error.Error()})
 	}
 }
+
+// compareHandler handles comparing spending by category between two periods with the given query string parameters
+func compareHandler(c *gin.Context) {
+	monthsStr := c.Query("months")
+	moversStr := c.Query("movers")
+
+	months := 0
+	movers := 0
+	var err error
+
+	if monthsStr != "" {
+		months, err = strconv.Atoi(monthsStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"message": "invalid months format"})
+			return
+		}
+	}
+	if moversStr != "" {
+		movers, err = strconv.Atoi(moversStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"message": "invalid movers format"})
+			return
+		}
+	}
+
+	compareReq, err := cmd.ParseCompareArgs(c.Query("current"), c.Query("baseline"), months, movers)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+	compareResp := cmd.CompareExpenses(db, compareReq)
+	if compareResp.Success {
+		c.JSON(http.StatusOK, gin.H{"result": compareResp.Result})
+	} else {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": compareResp.Error.Error()})
+	}
+}
+
+// anomaliesHandler handles flagging unusual expenses with the given query string parameters
+func anomaliesHandler(c *gin.Context) {
+	anomalyReq, err := cmd.ParseAnomalyArgs(c.Query("since"), c.Query("sensitivity"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+	anomalyResp := cmd.DetectAnomalies(db, anomalyReq)
+	if anomalyResp.Success {
+		c.JSON(http.StatusOK, gin.H{"result": anomalyResp.Result})
+	} else {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": anomalyResp.Error.Error()})
+	}
+}
+
+// forecastHandler handles projecting spending for the coming months with the given query string parameters
+func forecastHandler(c *gin.Context) {
+	monthsStr := c.Query("months")
+	lookbackStr := c.Query("lookback")
+
+	months := cmd.DEFAULT_FORECAST_MONTHS
+	lookback := 0
+	var err error
+
+	if monthsStr != "" {
+		months, err = strconv.Atoi(monthsStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"message": "invalid months format"})
+			return
+		}
+	}
+	if lookbackStr != "" {
+		lookback, err = strconv.Atoi(lookbackStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"message": "invalid lookback format"})
+			return
+		}
+	}
+
+	forecastReq, err := cmd.ParseForecastArgs(months, lookback)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+	forecastResp := cmd.ForecastExpenses(db, forecastReq)
+	if forecastResp.Success {
+		c.JSON(http.StatusOK, gin.H{"result": forecastResp.Result})
+	} else {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": forecastResp.Error.Error()})
+	}
+}
+
+// subscriptionsHandler handles listing the subscriptions detected in the expense history
+func subscriptionsHandler(c *gin.Context) {
+	all := false
+	if allStr := c.Query("all"); allStr != "" {
+		var err error
+		all, err = strconv.ParseBool(allStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"message": "invalid all format"})
+			return
+		}
+	}
+
+	subReq, err := cmd.ParseSubscriptionArgs("list", "", all)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+	subResp := cmd.ExpenseSubscriptions(db, subReq)
+	if subResp.Success {
+		c.JSON(http.StatusOK, gin.H{"result": subResp.Result})
+	} else {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": subResp.Error.Error()})
+	}
+}
+
+// adoptSubscriptionHandler handles converting a detected subscription into a recurring expense
+func adoptSubscriptionHandler(c *gin.Context) {
+	var body struct {
+		Location string `json:"location"`
+	}
+	if err := c.BindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "invalid request body"})
+		return
+	}
+
+	subReq, err := cmd.ParseSubscriptionArgs("adopt", body.Location, false)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+	subResp := cmd.ExpenseSubscriptions(db, subReq)
+	switch {
+	case subResp.Success:
+		c.JSON(http.StatusOK, gin.H{"result": subResp.Adopted})
+	case errors.Is(subResp.Error, cmd.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"message": subResp.Error.Error()})
+	case errors.Is(subResp.Error, cmd.ErrConflict):
+		c.JSON(http.StatusConflict, gin.H{"message": subResp.Error.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"message": subResp.Error.Error()})
+	}
+}
+
+// categoriesHandler handles listing every category
+func categoriesHandler(c *gin.Context) {
+	catResp := cmd.ExpenseCategory(db, &cmd.CategoryRequest{})
+	if !catResp.Success {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": catResp.Error.Error()})
+		return
+	}
+	defer catResp.Result.Close()
+
+	categories := []string{}
+	for catResp.Result.Next() {
+		var category string
+		if err := catResp.Result.Scan(&category); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+			return
+		}
+		categories = append(categories, category)
+	}
+	if err := catResp.Result.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"result": categories})
+}
+
+// addCategoryHandler handles adding a category with the name in the JSON body
+func addCategoryHandler(c *gin.Context) {
+	name, ok := bindCategoryName(c)
+	if !ok {
+		return
+	}
+
+	catResp := cmd.ExpenseCategory(db, &cmd.CategoryRequest{Subcommand: "add", CategoryName: name})
+	if catResp.Success {
+		c.JSON(http.StatusOK, gin.H{"message": "category added successfully"})
+	} else {
+		c.JSON(categoryErrorStatus(catResp.Error), gin.H{"message": catResp.Error.Error()})
+	}
+}
+
+// editCategoryHandler handles renaming the category in the path to the name in the JSON body, moving its expenses to
+// the new name. With dry-run=true, only reports how many expenses would be moved.
+func editCategoryHandler(c *gin.Context) {
+	name, ok := bindCategoryName(c)
+	if !ok {
+		return
+	}
+	dryRun, ok := dryRunQuery(c)
+	if !ok {
+		return
+	}
+
+	catResp := cmd.ExpenseCategory(db, &cmd.CategoryRequest{
+		Subcommand:      "edit",
+		CategoryName:    c.Param("name"),
+		NewCategoryName: name,
+		DryRun:          dryRun,
+	})
+	if !catResp.Success {
+		c.JSON(categoryErrorStatus(catResp.Error), gin.H{"message": catResp.Error.Error()})
+	} else if dryRun {
+		c.JSON(http.StatusOK, gin.H{"message": "category not changed (dry run)", "affected": catResp.Affected, "affected_recurring": catResp.AffectedRecurring})
+	} else {
+		publishExpenses(c, cmd.CHANGE_UPDATED, catResp.ExpenseIds)
+		c.JSON(http.StatusOK, gin.H{"message": "category changed successfully", "affected": catResp.Affected, "affected_recurring": catResp.AffectedRecurring})
+	}
+}
+
+// deleteCategoryHandler handles deleting the category in the path, uncategorizing its expenses. With dry-run=true, only
+// reports how many expenses would be uncategorized.
+func deleteCategoryHandler(c *gin.Context) {
+	dryRun, ok := dryRunQuery(c)
+	if !ok {
+		return
+	}
+
+	catResp := cmd.ExpenseCategory(db, &cmd.CategoryRequest{
+		Subcommand:   "delete",
+		CategoryName: c.Param("name"),
+		DryRun:       dryRun,
+	})
+	if !catResp.Success {
+		c.JSON(categoryErrorStatus(catResp.Error), gin.H{"message": catResp.Error.Error()})
+	} else if dryRun {
+		c.JSON(http.StatusOK, gin.H{"message": "category not deleted (dry run)", "affected": catResp.Affected, "affected_recurring": catResp.AffectedRecurring})
+	} else {
+		publishExpenses(c, cmd.CHANGE_UPDATED, catResp.ExpenseIds)
+		c.JSON(http.StatusOK, gin.H{"message": "category deleted successfully", "affected": catResp.Affected, "affected_recurring": catResp.AffectedRecurring})
+	}
+}
+
+// bindCategoryName reads the category name from a JSON body of the form {"name": ...}, responding with an error and
+// returning false if it is missing
+func bindCategoryName(c *gin.Context) (string, bool) {
+	var body struct {
+		Name string `json:"name"`
+	}
+	if err := c.BindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "invalid request body"})
+		return "", false
+	}
+	name := strings.TrimSpace(body.Name)
+	if name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "name is required"})
+		return "", false
+	}
+	return name, true
+}
+
+// dryRunQuery parses the dry-run query string parameter, responding with an error and returning false if it is invalid
+func dryRunQuery(c *gin.Context) (bool, bool) {
+	dryRunStr := c.Query("dry-run")
+	if dryRunStr == "" {
+		return false, true
+	}
+	dryRun, err := strconv.ParseBool(dryRunStr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "invalid dry run format"})
+		return false, false
+	}
+	return dryRun, true
+}
+
+// categoryErrorStatus returns the status code for an error from a category operation
+func categoryErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, cmd.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, cmd.ErrConflict):
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,19 @@
+package server
+
+import (
+	_ "embed"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// openAPI is the OpenAPI 3 document describing every route. It must be updated along with the routes, which
+// TestOpenAPICoversRoutes checks.
+//
+//go:embed openapi.json
+var openAPI []byte
+
+// openAPIHandler handles serving the OpenAPI document
+func openAPIHandler(c *gin.Context) {
+	c.Data(http.StatusOK, "application/json; charset=utf-8", openAPI)
+}
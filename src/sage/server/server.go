@@ -2,34 +2,115 @@ package server
 
 import (
 	"database/sql"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
 	"sage/src/sage/cmd"
+	"slices"
 
 	"github.com/gin-gonic/gin"
 )
 
 var db *sql.DB
 
-func RunServer() error {
-	db, _ = cmd.ConnectDB(cmd.SAGE_DB_NAME)
-	r := gin.Default()
-	r.Use(corsMiddleware())
+// Addresses the server listens on by default, and by default without authentication
+const (
+	DEFAULT_ADDR         = ":8080"
+	DEFAULT_NO_AUTH_ADDR = "localhost:8080"
+)
+
+// Config configures the server run by RunServer.
+type Config struct {
+	// Addr is the address to listen on, such as :8080 for every interface
+	Addr string
+	// NoAuth serves every route without a token, for development. It is only allowed on a loopback address.
+	NoAuth bool
+	// AllowedOrigins are the origins browsers may call the server from, such as https://sage.example.com. With NoAuth,
+	// any origin on the local machine is also allowed.
+	AllowedOrigins []string
+}
+
+func RunServer(cfg Config) error {
+	if cfg.Addr == "" {
+		cfg.Addr = DEFAULT_ADDR
+		if cfg.NoAuth {
+			cfg.Addr = DEFAULT_NO_AUTH_ADDR
+		}
+	}
+	host, _, err := net.SplitHostPort(cfg.Addr)
+	if err != nil {
+		return fmt.Errorf("invalid address '%s': %w", cfg.Addr, err)
+	}
+	if cfg.NoAuth && !isLoopbackHost(host) {
+		return errors.New("serving without authentication is only allowed on a loopback address such as " + DEFAULT_NO_AUTH_ADDR)
+	}
+
+	db, err = cmd.ConnectDB(cmd.SAGE_DB_NAME)
+	if err != nil {
+		return err
+	}
+	return newRouter(cfg).Run(cfg.Addr)
+}
+
+// newRouter returns a router serving the v1 routes used by sage-ui and the v2 API under /api/v2
+func newRouter(cfg Config) *gin.Engine {
+	r := gin.New()
+	r.Use(gin.LoggerWithFormatter(logFormatter), gin.Recovery())
+	if cfg.NoAuth {
+		r.Use(loopbackHostMiddleware())
+	}
+	r.Use(corsMiddleware(cfg))
+	if !cfg.NoAuth {
+		r.Use(authMiddleware())
+	}
 
 	r.POST("/add", addHandler)
 	r.GET("/log", logHandler)
 	r.GET("/summary", summaryHandler)
 	r.DELETE("/delete/:id", deleteHandler)
 	r.GET("/count/:type", countHandler)
+	r.GET("/compare", compareHandler)
+	r.GET("/insights/anomalies", anomaliesHandler)
+	r.GET("/forecast", forecastHandler)
+	r.GET("/subscriptions", subscriptionsHandler)
+	r.POST("/subscriptions/adopt", adoptSubscriptionHandler)
+	r.GET("/categories", categoriesHandler)
+	r.POST("/categories", addCategoryHandler)
+	r.PUT("/categories/:name", editCategoryHandler)
+	r.DELETE("/categories/:name", deleteCategoryHandler)
+	r.GET("/events", eventsHandler)
+	r.GET("/openapi.json", openAPIHandler)
 
-	r.Run(":8080")
+	registerV2(r.Group(V2_PREFIX))
+	r.NoRoute(noRouteHandler)
 
-	return nil
+	return r
 }
 
-func corsMiddleware() gin.HandlerFunc {
+// logFormatter formats a request like gin's default logger, but leaves out any token in the query string.
+func logFormatter(param gin.LogFormatterParams) string {
+	if u, err := url.Parse(param.Path); err == nil && u.Query().Has(TOKEN_PARAM) {
+		query := u.Query()
+		query.Set(TOKEN_PARAM, "REDACTED")
+		u.RawQuery = query.Encode()
+		param.Path = u.String()
+	}
+	return fmt.Sprintf("[GIN] %v | %3d | %13v | %15s | %-7s %#v\n%s",
+		param.TimeStamp.Format("2006/01/02 - 15:04:05"), param.StatusCode, param.Latency, param.ClientIP, param.Method,
+		param.Path, param.ErrorMessage)
+}
+
+// corsMiddleware allows browsers to call the server from the configured origins.
+func corsMiddleware(cfg Config) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
-		c.Writer.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		origin := c.GetHeader("Origin")
+		c.Writer.Header().Add("Vary", "Origin")
+		if origin != "" && (slices.Contains(cfg.AllowedOrigins, origin) || cfg.NoAuth && isLoopbackOrigin(origin)) {
+			c.Writer.Header().Set("Access-Control-Allow-Origin", origin)
+			c.Writer.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE")
+			c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, Last-Event-ID")
+		}
 
 		if c.Request.Method == "OPTIONS" {
 			c.AbortWithStatus(204)
@@ -1,17 +1,46 @@
 package server
 
 import (
+	"bufio"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
 	"net/http/httptest"
+	"regexp"
+	"sage/src/sage/client"
 	"sage/src/sage/cmd"
+	"sage/src/sage/data"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"cloud.google.com/go/civil"
+	"github.com/Rhymond/go-money"
 	"github.com/gin-gonic/gin"
 	"gotest.tools/v3/assert"
 )
 
+func init() {
+	gin.SetMode(gin.TestMode)
+	gin.DefaultWriter = io.Discard
+}
+
 func teardown() {
+	webhooks.wait()
 	db.Exec("DELETE FROM expenses")
+	db.Exec("DELETE FROM recurring_expenses")
+	db.Exec("DELETE FROM categories")
+	db.Exec("DELETE FROM tokens")
+	db.Exec("DELETE FROM sessions")
+	db.Exec("DELETE FROM expense_shares")
+	db.Exec("DELETE FROM settlements")
+	db.Exec("DELETE FROM changes")
+	db.Exec("DELETE FROM webhooks")
+	db.Exec("DELETE FROM users")
 	db.Close()
 }
 
@@ -34,12 +63,29 @@ func TestAddHandler(t *testing.T) {
 	assert.Equal(t, 200, w.Code)
 }
 
+func TestAddHandlerCategory(t *testing.T) {
+	defer teardown()
+
+	db, _ = cmd.ConnectDB(cmd.TEST_DB_NAME)
+	db.Exec("INSERT INTO categories (name) VALUES ('food')")
+
+	w := serve("POST", "/add?date=2021-01-01&amount=5&category=food", "")
+	assert.Equal(t, w.Code, 200)
+	var category string
+	assert.NilError(t, db.QueryRow("SELECT category FROM expenses").Scan(&category))
+	assert.Equal(t, category, "food")
+
+	w = serve("POST", "/add?date=2021-01-01&amount=5&category=travel", "")
+	assert.Equal(t, w.Code, 422)
+	assert.Equal(t, w.Body.String(), `{"message":"category 'travel' does not exist"}`)
+}
+
 func TestLogHandler(t *testing.T) {
 	defer teardown()
 
 	db, _ = cmd.ConnectDB(cmd.TEST_DB_NAME)
-	db.Exec("INSERT INTO expenses (date_spent, location, description, amt) VALUES ('2021-01-01', 'Test Location', 'Test Description', 20.12)")
-	db.Exec("INSERT INTO expenses (date_spent, location, description, amt) VALUES ('2022-04-16', 'Test Location 2', 'Test Description 2', 69.24)")
+	db.Exec("INSERT INTO expenses (date_spent, location, description, amt) VALUES ('2021-01-01', 'Test Location', 'Test Description', 2012)")
+	db.Exec("INSERT INTO expenses (date_spent, location, description, amt) VALUES ('2022-04-16', 'Test Location 2', 'Test Description 2', 6924)")
 
 	w := httptest.NewRecorder()
 	c, _ := gin.CreateTestContext(w)
@@ -48,26 +94,19 @@ func TestLogHandler(t *testing.T) {
 	logHandler(c)
 	assert.Equal(t, 200, w.Code)
 
-	body := gin.H{
-		"result": [][]string{
-			{"2021-01-01", "Test Location", "Test Description", "20.12"},
-			{"2022-04-16", "Test Location 2", "Test Description 2", "69.24"},
-		},
-		"show_id": false,
-	}
-	response, err := json.Marshal(body)
-
-	assert.NilError(t, err)
-	assert.Equal(t, w.Body.String(), string(response))
+	assert.Equal(t, w.Body.String(), `{"result":[`+
+		`{"id":0,"date":"2021-01-01","location":"Test Location","description":"Test Description","amount":{"amount":2012,"currency":"USD"}},`+
+		`{"id":0,"date":"2022-04-16","location":"Test Location 2","description":"Test Description 2","amount":{"amount":6924,"currency":"USD"}}`+
+		`],"show_id":false}`)
 }
 
 func TestSummaryHandler(t *testing.T) {
 	defer teardown()
 
 	db, _ = cmd.ConnectDB(cmd.TEST_DB_NAME)
-	db.Exec("INSERT INTO expenses (date_spent, location, description, amt) VALUES ('2021-01-01', 'Test Location', 'Test Description', 20.12)")
-	db.Exec("INSERT INTO expenses (date_spent, location, description, amt) VALUES ('2022-04-16', 'Test Location 2', 'Test Description 2', 2.00)")
-	db.Exec("INSERT INTO expenses (date_spent, location, description, amt) VALUES ('2022-04-25', 'Test Location 3', 'Test Description 3', 69.24)")
+	db.Exec("INSERT INTO expenses (date_spent, location, description, amt) VALUES ('2022-02-01', 'Test Location', 'Test Description', 2012)")
+	db.Exec("INSERT INTO expenses (date_spent, location, description, amt) VALUES ('2022-04-16', 'Test Location 2', 'Test Description 2', 200)")
+	db.Exec("INSERT INTO expenses (date_spent, location, description, amt) VALUES ('2022-04-25', 'Test Location 3', 'Test Description 3', 6924)")
 
 	w := httptest.NewRecorder()
 	c, _ := gin.CreateTestContext(w)
@@ -77,9 +116,10 @@ func TestSummaryHandler(t *testing.T) {
 	assert.Equal(t, 200, w.Code)
 
 	body := gin.H{
-		"result": [][]string{
-			{"2021-01", "20.12"},
-			{"2022-04", "71.24"},
+		"result": []data.Summary{
+			{Period: "2022-02", Month: "2022-02", Total: money.New(2012, money.USD)},
+			{Period: "2022-03", Month: "2022-03", Total: money.New(0, money.USD)},
+			{Period: "2022-04", Month: "2022-04", Total: money.New(7124, money.USD)},
 		},
 	}
 	response, err := json.Marshal(body)
@@ -88,11 +128,56 @@ func TestSummaryHandler(t *testing.T) {
 	assert.Equal(t, w.Body.String(), string(response))
 }
 
+func TestSummaryHandlerInterval(t *testing.T) {
+	defer teardown()
+
+	db, _ = cmd.ConnectDB(cmd.TEST_DB_NAME)
+	db.Exec("INSERT INTO expenses (date_spent, location, description, amt) VALUES ('2021-01-03', 'Test Location', 'Test Description', 100)")
+	db.Exec("INSERT INTO expenses (date_spent, location, description, amt) VALUES ('2021-01-04', 'Test Location 2', 'Test Description 2', 200)")
+	db.Exec("INSERT INTO expenses (date_spent, location, description, amt) VALUES ('2021-01-19', 'Test Location 3', 'Test Description 3', 400)")
+	db.Exec("INSERT INTO expenses (date_spent, location, description, amt) VALUES ('2021-10-01', 'Test Location 4', 'Test Description 4', 800)")
+
+	summarize := func(url string) []data.Summary {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest("GET", url, nil)
+		summaryHandler(c)
+		assert.Equal(t, 200, w.Code)
+
+		var body struct {
+			Result []data.Summary `json:"result"`
+		}
+		assert.NilError(t, json.Unmarshal(w.Body.Bytes(), &body))
+		return body.Result
+	}
+	periods := func(summaries []data.Summary) []string {
+		var result []string
+		for _, summary := range summaries {
+			result = append(result, fmt.Sprintf("%s=%d", summary.Period, summary.Total.Amount()))
+		}
+		return result
+	}
+
+	// 2021-01-03 is a Sunday, so it belongs to the last ISO week of 2020
+	assert.DeepEqual(t, periods(summarize("/summary?interval=week&end=2021-01-24")),
+		[]string{"2020-W53=100", "2021-W01=200", "2021-W02=0", "2021-W03=400"})
+	assert.DeepEqual(t, periods(summarize("/summary?interval=quarter")),
+		[]string{"2021-Q1=700", "2021-Q2=0", "2021-Q3=0", "2021-Q4=800"})
+	assert.DeepEqual(t, periods(summarize("/summary?interval=fiscal-year&fiscal-start=10")),
+		[]string{"FY2021=700", "FY2022=800"})
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("GET", "/summary?interval=fortnight", nil)
+	summaryHandler(c)
+	assert.Equal(t, 400, w.Code)
+}
+
 func TestDeleteHandler(t *testing.T) {
 	defer teardown()
 
 	db, _ = cmd.ConnectDB(cmd.TEST_DB_NAME)
-	db.Exec("INSERT INTO expenses (date_spent, location, description, amt) VALUES ('2021-01-01', 'Test Location', 'Test Description', 20.12)")
+	db.Exec("INSERT INTO expenses (date_spent, location, description, amt) VALUES ('2021-01-01', 'Test Location', 'Test Description', 2012)")
 
 	w := httptest.NewRecorder()
 	c, _ := gin.CreateTestContext(w)
@@ -102,3 +187,1103 @@ func TestDeleteHandler(t *testing.T) {
 	deleteHandler(c)
 	assert.Equal(t, 200, w.Code)
 }
+
+func TestLogHandlerFilters(t *testing.T) {
+	defer teardown()
+
+	db, _ = cmd.ConnectDB(cmd.TEST_DB_NAME)
+	db.Exec("INSERT INTO categories (name) VALUES ('food'), ('rent')")
+	db.Exec("INSERT INTO expenses (date_spent, location, description, category, amt) VALUES ('2021-01-01', 'Cafe', 'Coffee', 'food', 450)")
+	db.Exec("INSERT INTO expenses (date_spent, location, description, category, amt) VALUES ('2021-01-02', 'Landlord', 'January rent', 'rent', 150000)")
+	db.Exec("INSERT INTO expenses (date_spent, location, description, amt) VALUES ('2021-01-03', 'Cafe', 'Bagel', 325)")
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("GET", "/log?location=cafe&exclude-category=rent&max-amount=4", nil)
+
+	logHandler(c)
+	assert.Equal(t, 200, w.Code)
+
+	var body struct {
+		Result []data.Expense `json:"result"`
+	}
+	assert.NilError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Equal(t, len(body.Result), 1)
+	assert.Equal(t, body.Result[0].Description, "Bagel")
+	assert.Equal(t, body.Result[0].Category, "")
+
+	w = httptest.NewRecorder()
+	c, _ = gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("GET", "/count/log?category=food&uncategorized=true", nil)
+	c.Params = append(c.Params, gin.Param{Key: "type", Value: "log"})
+
+	countHandler(c)
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, w.Body.String(), `{"count":2,"pages":1}`)
+}
+
+func TestCountHandlerSummary(t *testing.T) {
+	defer teardown()
+
+	db, _ = cmd.ConnectDB(cmd.TEST_DB_NAME)
+	db.Exec("INSERT INTO expenses (date_spent, location, description, amt) VALUES ('2021-01-01', 'Test Location', 'Test Description', 2012)")
+	db.Exec("INSERT INTO expenses (date_spent, location, description, amt) VALUES ('2022-04-16', 'Test Location 2', 'Test Description 2', 200)")
+	db.Exec("INSERT INTO expenses (date_spent, location, description, amt) VALUES ('2022-04-25', 'Test Location 3', 'Test Description 3', 6924)")
+	db.Exec("INSERT INTO expenses (date_spent, location, description, amt) VALUES ('2022-05-02', 'Test Location 4', 'Test Description 4', 1000)")
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("GET", "/count/summary?year=2022&page-size=1", nil)
+	c.Params = append(c.Params, gin.Param{Key: "type", Value: "summary"})
+
+	countHandler(c)
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, w.Body.String(), `{"count":2,"pages":2}`)
+}
+
+func TestLogHandlerCursor(t *testing.T) {
+	defer teardown()
+
+	db, _ = cmd.ConnectDB(cmd.TEST_DB_NAME)
+	db.Exec("INSERT INTO expenses (date_spent, location, description, amt) VALUES ('2021-01-02', 'Test Location', 'Expense 3', 300)")
+	db.Exec("INSERT INTO expenses (date_spent, location, description, amt) VALUES ('2021-01-01', 'Test Location', 'Expense 1', 100)")
+	db.Exec("INSERT INTO expenses (date_spent, location, description, amt) VALUES ('2021-01-01', 'Test Location', 'Expense 2', 200)")
+	db.Exec("INSERT INTO expenses (date_spent, location, description, amt) VALUES ('2021-01-05', 'Test Location', 'Expense 5', 500)")
+	db.Exec("INSERT INTO expenses (date_spent, location, description, amt) VALUES ('2021-01-03', 'Test Location', 'Expense 4', 400)")
+
+	type page struct {
+		Result     []data.Expense `json:"result"`
+		NextCursor string         `json:"next_cursor"`
+		PrevCursor string         `json:"prev_cursor"`
+	}
+	getPage := func(cursor string) page {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest("GET", "/log?page-size=2&cursor="+cursor, nil)
+		logHandler(c)
+		assert.Equal(t, 200, w.Code)
+
+		var p page
+		assert.NilError(t, json.Unmarshal(w.Body.Bytes(), &p))
+		return p
+	}
+	descriptions := func(p page) []string {
+		var result []string
+		for _, expense := range p.Result {
+			result = append(result, expense.Description)
+		}
+		return result
+	}
+
+	first := getPage("")
+	assert.DeepEqual(t, descriptions(first), []string{"Expense 1", "Expense 2"})
+	assert.Equal(t, first.PrevCursor, "")
+
+	// an expense inserted before the current page must not shift the following pages
+	db.Exec("INSERT INTO expenses (date_spent, location, description, amt) VALUES ('2020-12-31', 'Test Location', 'Expense 0', 50)")
+
+	second := getPage(first.NextCursor)
+	assert.DeepEqual(t, descriptions(second), []string{"Expense 3", "Expense 4"})
+
+	third := getPage(second.NextCursor)
+	assert.DeepEqual(t, descriptions(third), []string{"Expense 5"})
+	assert.Equal(t, third.NextCursor, "")
+
+	back := getPage(third.PrevCursor)
+	assert.DeepEqual(t, descriptions(back), []string{"Expense 3", "Expense 4"})
+
+	back = getPage(back.PrevCursor)
+	assert.DeepEqual(t, descriptions(back), []string{"Expense 1", "Expense 2"})
+
+	back = getPage(back.PrevCursor)
+	assert.DeepEqual(t, descriptions(back), []string{"Expense 0"})
+	assert.Equal(t, back.PrevCursor, "")
+}
+
+func TestLogHandlerSort(t *testing.T) {
+	defer teardown()
+
+	db, _ = cmd.ConnectDB(cmd.TEST_DB_NAME)
+	db.Exec("INSERT INTO expenses (date_spent, location, description, amt) VALUES ('2021-01-01', 'Test Location', 'Expense 1', 300)")
+	db.Exec("INSERT INTO expenses (date_spent, location, description, amt) VALUES ('2021-01-02', 'Test Location', 'Expense 2', 1000)")
+	db.Exec("INSERT INTO expenses (date_spent, location, description, amt) VALUES ('2021-01-03', 'Test Location', 'Expense 3', 300)")
+	db.Exec("INSERT INTO expenses (date_spent, location, description, amt) VALUES ('2021-01-04', 'Test Location', 'Expense 4', 50)")
+
+	type page struct {
+		Result     []data.Expense `json:"result"`
+		NextCursor string         `json:"next_cursor"`
+	}
+	getPage := func(url string) page {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest("GET", url, nil)
+		logHandler(c)
+		assert.Equal(t, 200, w.Code)
+
+		var p page
+		assert.NilError(t, json.Unmarshal(w.Body.Bytes(), &p))
+		return p
+	}
+	descriptions := func(p page) []string {
+		var result []string
+		for _, expense := range p.Result {
+			result = append(result, expense.Description)
+		}
+		return result
+	}
+
+	first := getPage("/log?sort=-amount,-date&page-size=2")
+	assert.DeepEqual(t, descriptions(first), []string{"Expense 2", "Expense 3"})
+
+	second := getPage("/log?sort=-amount,-date&page-size=2&cursor=" + first.NextCursor)
+	assert.DeepEqual(t, descriptions(second), []string{"Expense 1", "Expense 4"})
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("GET", "/log?sort=price", nil)
+	logHandler(c)
+	assert.Equal(t, 400, w.Code)
+}
+
+func TestSummaryHandlerStats(t *testing.T) {
+	defer teardown()
+
+	db, _ = cmd.ConnectDB(cmd.TEST_DB_NAME)
+	db.Exec("INSERT INTO expenses (date_spent, location, description, amt) VALUES ('2022-02-01', 'Test Location', 'Test Description', 1000)")
+	db.Exec("INSERT INTO expenses (date_spent, location, description, amt) VALUES ('2022-04-16', 'Test Location 2', 'Test Description 2', 200)")
+	db.Exec("INSERT INTO expenses (date_spent, location, description, amt) VALUES ('2022-04-25', 'Test Location 3', 'Test Description 3', 1300)")
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("GET", "/summary?stats=true&page-size=1&page=3", nil)
+
+	summaryHandler(c)
+	assert.Equal(t, 200, w.Code)
+
+	var body struct {
+		Result []data.Summary `json:"result"`
+	}
+	assert.NilError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Equal(t, len(body.Result), 1)
+
+	stats := body.Result[0].Stats
+	assert.Equal(t, body.Result[0].Period, "2022-04")
+	assert.Equal(t, stats.Count, 2)
+	assert.Equal(t, stats.Average.Amount(), int64(750))
+	assert.Equal(t, stats.Median.Amount(), int64(750))
+	assert.Equal(t, stats.Min.Amount(), int64(200))
+	assert.Equal(t, stats.Max.Amount(), int64(1300))
+	assert.Equal(t, stats.P90.Amount(), int64(1300))
+	// the previous period is on another page and had no expenses
+	assert.Equal(t, stats.Change.Amount(), int64(1500))
+	assert.Assert(t, stats.ChangePercent == nil)
+}
+
+func TestCompareHandler(t *testing.T) {
+	defer teardown()
+
+	db, _ = cmd.ConnectDB(cmd.TEST_DB_NAME)
+	db.Exec("INSERT INTO categories (name) VALUES ('food'), ('rent'), ('travel')")
+	db.Exec("INSERT INTO expenses (date_spent, location, description, category, amt) VALUES ('2025-10-03', 'Cafe', 'Coffee', 'food', 1000)")
+	db.Exec("INSERT INTO expenses (date_spent, location, description, category, amt) VALUES ('2025-10-05', 'Airline', 'Flight', 'travel', 30000)")
+	db.Exec("INSERT INTO expenses (date_spent, location, description, category, amt) VALUES ('2025-10-01', 'Landlord', 'Rent', 'rent', 100000)")
+	db.Exec("INSERT INTO expenses (date_spent, location, description, category, amt) VALUES ('2026-10-02', 'Cafe', 'Coffee', 'food', 1500)")
+	db.Exec("INSERT INTO expenses (date_spent, location, description, category, amt) VALUES ('2026-10-01', 'Landlord', 'Rent', 'rent', 110000)")
+	db.Exec("INSERT INTO expenses (date_spent, location, description, amt) VALUES ('2026-10-09', 'Store', 'Gift', 2000)")
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("GET", "/compare?current=2026-10&movers=2", nil)
+
+	compareHandler(c)
+	assert.Equal(t, 200, w.Code)
+
+	var body struct {
+		Result data.Comparison `json:"result"`
+	}
+	assert.NilError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	comparison := body.Result
+
+	assert.Equal(t, comparison.Baseline.Label, "2025-10")
+	assert.DeepEqual(t, comparison.New, []string{""})
+	assert.DeepEqual(t, comparison.Disappeared, []string{"travel"})
+	assert.Equal(t, len(comparison.Movers), 2)
+	assert.Equal(t, comparison.Movers[0].Category, "travel")
+	assert.Equal(t, comparison.Movers[0].Change.Amount(), int64(-30000))
+	assert.Equal(t, comparison.Movers[1].Category, "rent")
+	assert.Equal(t, comparison.Total.Change.Amount(), int64(-17500))
+	assert.Equal(t, *comparison.Categories[1].ChangePercent, 50.0)
+}
+
+func TestAnomaliesHandler(t *testing.T) {
+	defer teardown()
+
+	db, _ = cmd.ConnectDB(cmd.TEST_DB_NAME)
+	db.Exec("INSERT INTO expenses (date_spent, location, description, amt) VALUES ('2026-06-01', 'Cafe', 'Coffee', 450)")
+	db.Exec("INSERT INTO expenses (date_spent, location, description, amt) VALUES ('2026-06-08', 'Cafe', 'Coffee', 500)")
+	db.Exec("INSERT INTO expenses (date_spent, location, description, amt) VALUES ('2026-06-15', 'Cafe', 'Coffee', 475)")
+	db.Exec("INSERT INTO expenses (date_spent, location, description, amt) VALUES ('2026-09-02', 'Cafe', 'Catering', 9000)")
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("GET", "/insights/anomalies?since=2026-09-01&sensitivity=low", nil)
+
+	anomaliesHandler(c)
+	assert.Equal(t, 200, w.Code)
+
+	var body struct {
+		Result []data.Anomaly `json:"result"`
+	}
+	assert.NilError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	// the catering order is unusual for the location, and makes September's uncategorized spending unusual too
+	assert.Equal(t, len(body.Result), 2)
+	assert.Equal(t, body.Result[0].Kind, "category")
+	assert.Equal(t, body.Result[0].Period, "2026-09")
+	assert.Equal(t, body.Result[1].Kind, "payee")
+	assert.Equal(t, body.Result[1].Expense.Description, "Catering")
+	assert.Equal(t, body.Result[1].Reason, "$90.00 at Cafe is 18.9x the usual $4.75 (median of 3 previous charges)")
+
+	w = httptest.NewRecorder()
+	c, _ = gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("GET", "/insights/anomalies?sensitivity=extreme", nil)
+
+	anomaliesHandler(c)
+	assert.Equal(t, 400, w.Code)
+}
+
+func TestForecastHandler(t *testing.T) {
+	defer teardown()
+
+	db, _ = cmd.ConnectDB(cmd.TEST_DB_NAME)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("GET", "/forecast?months=2", nil)
+
+	forecastHandler(c)
+	assert.Equal(t, 200, w.Code)
+
+	var body struct {
+		Result []data.Forecast `json:"result"`
+	}
+	assert.NilError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Equal(t, len(body.Result), 3)
+	assert.Equal(t, body.Result[0].Estimate.Amount(), int64(0))
+
+	// months defaults to 3, and 0 only forecasts the current month
+	for query, forecasts := range map[string]int{"": 4, "?months=0": 1} {
+		w = httptest.NewRecorder()
+		c, _ = gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest("GET", "/forecast"+query, nil)
+		forecastHandler(c)
+		assert.Equal(t, 200, w.Code)
+		assert.NilError(t, json.Unmarshal(w.Body.Bytes(), &body))
+		assert.Equal(t, len(body.Result), forecasts, query)
+	}
+
+	w = httptest.NewRecorder()
+	c, _ = gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("GET", "/forecast?months=-1", nil)
+
+	forecastHandler(c)
+	assert.Equal(t, 400, w.Code)
+}
+
+func TestSubscriptionsHandler(t *testing.T) {
+	defer teardown()
+
+	db, _ = cmd.ConnectDB(cmd.TEST_DB_NAME)
+	db.Exec("INSERT INTO expenses (date_spent, location, description, amt) VALUES ('2021-01-12', 'StreamCo', 'Subscription', 1599)")
+	db.Exec("INSERT INTO expenses (date_spent, location, description, amt) VALUES ('2021-02-12', 'StreamCo', 'Subscription', 1599)")
+	db.Exec("INSERT INTO expenses (date_spent, location, description, amt) VALUES ('2021-03-12', 'StreamCo', 'Subscription', 1599)")
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("GET", "/subscriptions?all=true", nil)
+
+	subscriptionsHandler(c)
+	assert.Equal(t, 200, w.Code)
+
+	var body struct {
+		Result []data.Subscription `json:"result"`
+	}
+	assert.NilError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Equal(t, len(body.Result), 1)
+	assert.Equal(t, body.Result[0].Cadence, "monthly")
+	assert.Equal(t, body.Result[0].AnnualCost.Amount(), int64(19188))
+	assert.Assert(t, !body.Result[0].Adopted)
+
+	w = httptest.NewRecorder()
+	c, _ = gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("POST", "/subscriptions/adopt", strings.NewReader(`{"location": "streamco"}`))
+
+	adoptSubscriptionHandler(c)
+	assert.Equal(t, 200, w.Code)
+
+	var adoptBody struct {
+		Result data.RecurringExpense `json:"result"`
+	}
+	assert.NilError(t, json.Unmarshal(w.Body.Bytes(), &adoptBody))
+	assert.Equal(t, adoptBody.Result.Location, "StreamCo")
+	assert.Equal(t, adoptBody.Result.NextDate, civil.Date{Year: 2021, Month: 4, Day: 12})
+
+	// a subscription can only be adopted once
+	w = httptest.NewRecorder()
+	c, _ = gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("POST", "/subscriptions/adopt", strings.NewReader(`{"location": "StreamCo"}`))
+
+	adoptSubscriptionHandler(c)
+	assert.Equal(t, 409, w.Code)
+
+	for body, code := range map[string]int{`{"location": "Nowhere"}`: 404, `{"location": ""}`: 400} {
+		w = httptest.NewRecorder()
+		c, _ = gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest("POST", "/subscriptions/adopt", strings.NewReader(body))
+		adoptSubscriptionHandler(c)
+		assert.Equal(t, code, w.Code, body)
+	}
+}
+
+// serve sends a request with a JSON body, which may be empty, through the router and returns the response
+func serve(method, url, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(method, url, strings.NewReader(body))
+	req.Host = "localhost:8080"
+	newRouter(Config{NoAuth: true}).ServeHTTP(w, req)
+	return w
+}
+
+// errorCode returns the code in a v2 error envelope
+func errorCode(t *testing.T, w *httptest.ResponseRecorder) string {
+	var body struct {
+		Error apiError `json:"error"`
+	}
+	assert.NilError(t, json.Unmarshal(w.Body.Bytes(), &body), w.Body.String())
+	return body.Error.Code
+}
+
+func TestV2Expenses(t *testing.T) {
+	defer teardown()
+
+	db, _ = cmd.ConnectDB(cmd.TEST_DB_NAME)
+	db.Exec("INSERT INTO categories (name) VALUES ('food')")
+
+	w := serve("POST", "/api/v2/expenses", `{"date": "2021-01-01", "location": "Market", "category": "food", "amount": 20.12}`)
+	assert.Equal(t, w.Code, 201)
+	var created struct {
+		Data data.Expense `json:"data"`
+	}
+	assert.NilError(t, json.Unmarshal(w.Body.Bytes(), &created))
+	assert.Equal(t, created.Data.Location, "Market")
+	assert.Equal(t, created.Data.Amount.Amount(), int64(2012))
+	url := fmt.Sprintf("/api/v2/expenses/%d", created.Data.Id)
+	assert.Equal(t, w.Header().Get("Location"), url)
+
+	w = serve("PATCH", url, `{"amount": 25, "category": ""}`)
+	assert.Equal(t, w.Code, 200)
+	var updated struct {
+		Data data.Expense `json:"data"`
+	}
+	assert.NilError(t, json.Unmarshal(w.Body.Bytes(), &updated))
+	assert.Equal(t, updated.Data.Amount.Amount(), int64(2500))
+	assert.Equal(t, updated.Data.Category, "")
+	assert.Equal(t, updated.Data.Location, "Market")
+
+	w = serve("GET", "/api/v2/expenses?location=market", "")
+	assert.Equal(t, w.Code, 200)
+	var list struct {
+		Data []data.Expense `json:"data"`
+	}
+	assert.NilError(t, json.Unmarshal(w.Body.Bytes(), &list))
+	assert.Equal(t, len(list.Data), 1)
+	assert.Equal(t, list.Data[0].Id, created.Data.Id)
+
+	w = serve("DELETE", url, "")
+	assert.Equal(t, w.Code, 204)
+	assert.Equal(t, w.Body.Len(), 0)
+	w = serve("GET", url, "")
+	assert.Equal(t, w.Code, 404)
+	assert.Equal(t, errorCode(t, w), CODE_NOT_FOUND)
+	w = serve("DELETE", url, "")
+	assert.Equal(t, w.Code, 404)
+
+	w = serve("GET", "/api/v2/expenses", "")
+	assert.Equal(t, w.Body.String(), `{"data":[]}`)
+}
+
+func TestV2ExpenseErrors(t *testing.T) {
+	defer teardown()
+
+	db, _ = cmd.ConnectDB(cmd.TEST_DB_NAME)
+
+	tests := []struct {
+		method, url, body string
+		status            int
+		code              string
+	}{
+		{"POST", "/api/v2/expenses", `{"date": "2021-01-01", "amount": 1`, 400, CODE_INVALID_REQUEST},
+		{"POST", "/api/v2/expenses", `{"date": "2021-01-01", "amount": 1, "amt": 2}`, 400, CODE_INVALID_REQUEST},
+		{"POST", "/api/v2/expenses", ``, 400, CODE_INVALID_REQUEST},
+		{"POST", "/api/v2/expenses", `{"date": "2021-01-01"}`, 422, CODE_VALIDATION_FAILED},
+		{"POST", "/api/v2/expenses", `{"date": "someday", "amount": 1}`, 422, CODE_VALIDATION_FAILED},
+		{"POST", "/api/v2/expenses", `{"date": "2021-01-01", "amount": 1, "category": "travel"}`, 422, CODE_UNKNOWN_CATEGORY},
+		{"GET", "/api/v2/expenses/abc", ``, 400, CODE_INVALID_REQUEST},
+		{"GET", "/api/v2/expenses?year=abc", ``, 400, CODE_INVALID_REQUEST},
+		{"PATCH", "/api/v2/expenses/1", `{}`, 422, CODE_VALIDATION_FAILED},
+		{"PATCH", "/api/v2/expenses/1", `{"location": "Cafe"}`, 404, CODE_NOT_FOUND},
+		{"GET", "/api/v2/budgets", ``, 404, CODE_NOT_FOUND},
+	}
+	for _, test := range tests {
+		w := serve(test.method, test.url, test.body)
+		assert.Equal(t, w.Code, test.status, "%s %s %s", test.method, test.url, test.body)
+		assert.Equal(t, errorCode(t, w), test.code, "%s %s %s", test.method, test.url, test.body)
+	}
+}
+
+func TestV2Categories(t *testing.T) {
+	defer teardown()
+
+	db, _ = cmd.ConnectDB(cmd.TEST_DB_NAME)
+
+	w := serve("POST", "/api/v2/categories", `{"name": "food"}`)
+	assert.Equal(t, w.Code, 201)
+	assert.Equal(t, w.Body.String(), `{"data":{"name":"food"}}`)
+	w = serve("POST", "/api/v2/categories", `{"name": "food"}`)
+	assert.Equal(t, w.Code, 409)
+	assert.Equal(t, errorCode(t, w), CODE_CONFLICT)
+	w = serve("POST", "/api/v2/categories", `{"name": " "}`)
+	assert.Equal(t, w.Code, 422)
+
+	w = serve("POST", "/api/v2/expenses", `{"date": "2021-01-01", "category": "food", "amount": 5}`)
+	assert.Equal(t, w.Code, 201)
+
+	w = serve("PATCH", "/api/v2/categories/food", `{"name": "groceries"}`)
+	assert.Equal(t, w.Code, 200)
+	w = serve("GET", "/api/v2/categories", "")
+	assert.Equal(t, w.Body.String(), `{"data":[{"name":"groceries"}]}`)
+	w = serve("GET", "/api/v2/expenses", "")
+	assert.Assert(t, strings.Contains(w.Body.String(), `"category":"groceries"`), w.Body.String())
+
+	w = serve("PATCH", "/api/v2/categories/food", `{"name": "dining"}`)
+	assert.Equal(t, w.Code, 404)
+
+	// deleting a category uncategorizes its expenses
+	w = serve("DELETE", "/api/v2/categories/groceries?dry-run=true", "")
+	assert.Equal(t, w.Code, 200)
+	assert.Equal(t, w.Body.String(), `{"affected":1,"affected_recurring":0,"data":{"name":"groceries"}}`)
+	w = serve("DELETE", "/api/v2/categories/groceries", "")
+	assert.Equal(t, w.Code, 200)
+	assert.Equal(t, w.Body.String(), `{"affected":1,"affected_recurring":0,"data":{"name":"groceries"}}`)
+	w = serve("GET", "/api/v2/expenses", "")
+	assert.Assert(t, !strings.Contains(w.Body.String(), `"category"`), w.Body.String())
+	w = serve("DELETE", "/api/v2/categories/groceries", "")
+	assert.Equal(t, w.Code, 404)
+}
+
+func TestV2Summaries(t *testing.T) {
+	defer teardown()
+
+	db, _ = cmd.ConnectDB(cmd.TEST_DB_NAME)
+	db.Exec("INSERT INTO expenses (date_spent, location, description, amt) VALUES ('2022-02-01', 'Test Location', 'Test Description', 2012)")
+	db.Exec("INSERT INTO expenses (date_spent, location, description, amt) VALUES ('2022-03-16', 'Test Location 2', 'Test Description 2', 200)")
+
+	w := serve("GET", "/api/v2/summaries", "")
+	assert.Equal(t, w.Code, 200)
+	var body struct {
+		Data []data.Summary `json:"data"`
+	}
+	assert.NilError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Equal(t, len(body.Data), 2)
+	assert.Equal(t, body.Data[1].Total.Amount(), int64(200))
+
+	w = serve("GET", "/api/v2/summaries?interval=fortnight", "")
+	assert.Equal(t, w.Code, 400)
+	assert.Equal(t, errorCode(t, w), CODE_INVALID_REQUEST)
+}
+
+func TestCategoryHandlers(t *testing.T) {
+	defer teardown()
+
+	db, _ = cmd.ConnectDB(cmd.TEST_DB_NAME)
+	db.Exec("INSERT INTO expenses (date_spent, location, description, amt) VALUES ('2021-01-01', 'Test Location', 'Test Description', 100)")
+
+	w := serve("POST", "/categories", `{"name": "food"}`)
+	assert.Equal(t, w.Code, 200)
+	w = serve("POST", "/categories", `{"name": "food"}`)
+	assert.Equal(t, w.Code, 409)
+	w = serve("POST", "/categories", `{}`)
+	assert.Equal(t, w.Code, 400)
+	db.Exec("UPDATE expenses SET category = 'food'")
+
+	w = serve("GET", "/categories", "")
+	assert.Equal(t, w.Code, 200)
+	assert.Equal(t, w.Body.String(), `{"result":["food"]}`)
+
+	// a dry run reports the affected expenses without changing anything
+	w = serve("PUT", "/categories/food?dry-run=true", `{"name": "groceries"}`)
+	assert.Equal(t, w.Code, 200)
+	assert.Equal(t, w.Body.String(), `{"affected":1,"affected_recurring":0,"message":"category not changed (dry run)"}`)
+	w = serve("GET", "/categories", "")
+	assert.Equal(t, w.Body.String(), `{"result":["food"]}`)
+
+	w = serve("PUT", "/categories/food", `{"name": "groceries"}`)
+	assert.Equal(t, w.Code, 200)
+	assert.Equal(t, w.Body.String(), `{"affected":1,"affected_recurring":0,"message":"category changed successfully"}`)
+	w = serve("PUT", "/categories/food", `{"name": "groceries"}`)
+	assert.Equal(t, w.Code, 404)
+
+	w = serve("DELETE", "/categories/groceries?dry-run=true", "")
+	assert.Equal(t, w.Body.String(), `{"affected":1,"affected_recurring":0,"message":"category not deleted (dry run)"}`)
+	w = serve("DELETE", "/categories/groceries", "")
+	assert.Equal(t, w.Code, 200)
+	assert.Equal(t, w.Body.String(), `{"affected":1,"affected_recurring":0,"message":"category deleted successfully"}`)
+
+	var category *string
+	assert.NilError(t, db.QueryRow("SELECT category FROM expenses").Scan(&category))
+	assert.Assert(t, category == nil)
+	w = serve("GET", "/categories", "")
+	assert.Equal(t, w.Body.String(), `{"result":[]}`)
+}
+
+func TestOpenAPICoversRoutes(t *testing.T) {
+	var doc struct {
+		Paths map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	assert.NilError(t, json.Unmarshal(openAPI, &doc))
+
+	// gin's :param segments are {param} in OpenAPI paths
+	param := regexp.MustCompile(`:(\w+)`)
+	documented := map[string]bool{}
+	for path, operations := range doc.Paths {
+		for method := range operations {
+			documented[strings.ToUpper(method)+" "+path] = true
+		}
+	}
+	for _, route := range newRouter(Config{}).Routes() {
+		route := route.Method + " " + param.ReplaceAllString(route.Path, "{$1}")
+		assert.Assert(t, documented[route], "%s is not documented in openapi.json", route)
+		delete(documented, route)
+	}
+	for route := range documented {
+		t.Errorf("%s is documented in openapi.json but not registered", route)
+	}
+
+	w := serve("GET", "/openapi.json", "")
+	assert.Equal(t, w.Code, 200)
+	assert.Equal(t, w.Body.String(), string(openAPI))
+}
+
+func TestClient(t *testing.T) {
+	defer teardown()
+
+	db, _ = cmd.ConnectDB(cmd.TEST_DB_NAME)
+	server := httptest.NewServer(newRouter(Config{NoAuth: true}))
+	defer server.Close()
+	c := client.New(server.URL)
+
+	_, err := c.CreateCategory("food")
+	assert.NilError(t, err)
+	date, category, amount := "2021-01-01", "food", json.Number("12.50")
+	created, err := c.CreateExpense(&client.ExpenseInput{Date: &date, Category: &category, Amount: &amount})
+	assert.NilError(t, err)
+	assert.Equal(t, created.Amount.Amount(), int64(1250))
+
+	page, err := c.ListExpenses(&client.ExpenseQuery{Categories: []string{"food"}})
+	assert.NilError(t, err)
+	assert.Equal(t, len(page.Data), 1)
+	summaries, err := c.ListSummaries(&client.SummaryQuery{Interval: "year"})
+	assert.NilError(t, err)
+	assert.Equal(t, summaries[0].Total.Amount(), int64(1250))
+
+	change, err := c.RenameCategory("food", "groceries", false)
+	assert.NilError(t, err)
+	assert.Equal(t, change.Affected, 1)
+	expense, err := c.GetExpense(created.Id)
+	assert.NilError(t, err)
+	assert.Equal(t, expense.Category, "groceries")
+
+	assert.NilError(t, c.DeleteExpense(created.Id))
+	err = c.DeleteExpense(created.Id)
+	var apiErr *client.Error
+	assert.Assert(t, errors.As(err, &apiErr))
+	assert.Equal(t, apiErr.Code, client.CODE_NOT_FOUND)
+}
+
+func TestAuth(t *testing.T) {
+	defer teardown()
+
+	db, _ = cmd.ConnectDB(cmd.TEST_DB_NAME)
+	read := cmd.APITokens(db, &cmd.TokenRequest{Subcommand: "create", Name: "read", Scopes: []string{cmd.SCOPE_READ}})
+	write := cmd.APITokens(db, &cmd.TokenRequest{Subcommand: "create", Name: "write", Scopes: []string{cmd.SCOPE_WRITE}})
+	assert.Assert(t, read.Success && write.Success)
+
+	router := newRouter(Config{})
+	request := func(method, url, token string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(method, url, strings.NewReader(`{"name":"food"}`))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := request("GET", "/api/v2/categories", "")
+	assert.Equal(t, w.Code, 401)
+	assert.Equal(t, errorCode(t, w), CODE_UNAUTHORIZED)
+	assert.Equal(t, w.Header().Get("WWW-Authenticate"), `Bearer realm="sage"`)
+
+	w = request("GET", "/log", "sage_bogus")
+	assert.Equal(t, w.Code, 401)
+	assert.Equal(t, w.Body.String(), `{"message":"token is invalid or has been revoked"}`)
+
+	w = request("GET", "/api/v2/categories", read.Token)
+	assert.Equal(t, w.Code, 200)
+	w = request("POST", "/api/v2/categories", read.Token)
+	assert.Equal(t, w.Code, 403)
+	assert.Equal(t, errorCode(t, w), CODE_FORBIDDEN)
+	w = request("POST", "/api/v2/categories", write.Token)
+	assert.Equal(t, w.Code, 201)
+
+	// renaming or deleting a category changes every expense in it, so write tokens can't
+	admin := cmd.APITokens(db, &cmd.TokenRequest{Subcommand: "create", Name: "admin", Scopes: []string{cmd.SCOPE_ADMIN}})
+	assert.Assert(t, admin.Success)
+	w = request("DELETE", "/api/v2/categories/food", write.Token)
+	assert.Equal(t, w.Code, 403)
+	assert.Equal(t, errorCode(t, w), CODE_FORBIDDEN)
+	w = request("DELETE", "/categories/food", write.Token)
+	assert.Equal(t, w.Code, 403)
+	w = request("GET", "/api/v2/users", write.Token)
+	assert.Equal(t, w.Code, 403)
+	w = request("GET", "/api/v2/users", admin.Token)
+	assert.Equal(t, w.Code, 200)
+	w = request("DELETE", "/api/v2/categories/food", admin.Token)
+	assert.Equal(t, w.Code, 200)
+
+	w = request("GET", "/openapi.json", "")
+	assert.Equal(t, w.Code, 200)
+
+	assert.Assert(t, cmd.APITokens(db, &cmd.TokenRequest{Subcommand: "revoke", Id: read.Created.Id}).Success)
+	w = request("GET", "/api/v2/categories", read.Token)
+	assert.Equal(t, w.Code, 401)
+}
+
+func TestCORS(t *testing.T) {
+	request := func(cfg Config, origin string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("OPTIONS", "http://localhost:8080/log", nil)
+		req.Header.Set("Origin", origin)
+		newRouter(cfg).ServeHTTP(w, req)
+		return w
+	}
+
+	w := request(Config{AllowedOrigins: []string{"https://sage.example.com"}}, "https://sage.example.com")
+	assert.Equal(t, w.Code, 204)
+	assert.Equal(t, w.Header().Get("Access-Control-Allow-Origin"), "https://sage.example.com")
+
+	w = request(Config{AllowedOrigins: []string{"https://sage.example.com"}}, "https://evil.example.com")
+	assert.Equal(t, w.Header().Get("Access-Control-Allow-Origin"), "")
+	w = request(Config{}, "http://localhost:3000")
+	assert.Equal(t, w.Header().Get("Access-Control-Allow-Origin"), "")
+	w = request(Config{NoAuth: true}, "http://localhost:3000")
+	assert.Equal(t, w.Header().Get("Access-Control-Allow-Origin"), "http://localhost:3000")
+}
+
+func TestNoAuthHost(t *testing.T) {
+	defer teardown()
+
+	db, _ = cmd.ConnectDB(cmd.TEST_DB_NAME)
+	request := func(host string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/api/v2/categories", nil)
+		req.Host = host
+		newRouter(Config{NoAuth: true}).ServeHTTP(w, req)
+		return w
+	}
+
+	for _, host := range []string{"localhost:8080", "127.0.0.1:8080", "[::1]:8080", "localhost"} {
+		assert.Equal(t, request(host).Code, 200, host)
+	}
+	// a page on another site that resolves its domain to 127.0.0.1 sends its own name as the host
+	w := request("rebind.example.com:8080")
+	assert.Equal(t, w.Code, 403)
+	assert.Equal(t, errorCode(t, w), CODE_FORBIDDEN)
+}
+
+func TestRunServerNoAuth(t *testing.T) {
+	err := RunServer(Config{Addr: ":8080", NoAuth: true})
+	assert.ErrorContains(t, err, "only allowed on a loopback address")
+}
+
+func TestSessions(t *testing.T) {
+	defer teardown()
+
+	db, _ = cmd.ConnectDB(cmd.TEST_DB_NAME)
+	assert.Assert(t, cmd.Users(db, &cmd.UserRequest{Subcommand: "add", Name: "alice", Password: "hunter2"}).Success)
+	assert.Assert(t, cmd.Users(db, &cmd.UserRequest{Subcommand: "add", Name: "bob"}).Success)
+
+	router := newRouter(Config{})
+	request := func(method, url, token, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(method, url, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := request("POST", "/api/v2/sessions", "", `{"name":"alice","password":"wrong"}`)
+	assert.Equal(t, w.Code, 401)
+	assert.Equal(t, errorCode(t, w), CODE_UNAUTHORIZED)
+	// bob has no password, so he can't log in at all
+	w = request("POST", "/api/v2/sessions", "", `{"name":"bob","password":""}`)
+	assert.Equal(t, w.Code, 401)
+
+	w = request("POST", "/api/v2/sessions", "", `{"name":"alice","password":"hunter2"}`)
+	assert.Equal(t, w.Code, 201)
+	var login struct {
+		Data data.Session `json:"data"`
+	}
+	assert.NilError(t, json.Unmarshal(w.Body.Bytes(), &login))
+	assert.Equal(t, login.Data.User, "alice")
+	token := login.Data.Token
+	assert.Assert(t, cmd.IsSessionToken(token))
+
+	// expenses are recorded by, and by default paid for by, the user that is logged in
+	w = request("POST", "/api/v2/expenses", token, `{"date":"2026-10-01","amount":"90","shared":true}`)
+	assert.Equal(t, w.Code, 201)
+	var created struct {
+		Data data.Expense `json:"data"`
+	}
+	assert.NilError(t, json.Unmarshal(w.Body.Bytes(), &created))
+	assert.Equal(t, created.Data.PaidBy, "alice")
+	assert.Equal(t, created.Data.CreatedBy, "alice")
+	assert.Assert(t, created.Data.Shared)
+
+	// only the admin scope can record expenses paid by, or settlements from, another user
+	w = request("POST", "/api/v2/expenses", token, `{"date":"2026-10-02","amount":"30","paid_by":"bob","shared":true}`)
+	assert.Equal(t, w.Code, 403)
+	assert.Equal(t, errorCode(t, w), CODE_FORBIDDEN)
+	w = request("PATCH", fmt.Sprintf("/api/v2/expenses/%d", created.Data.Id), token, `{"paid_by":"bob"}`)
+	assert.Equal(t, w.Code, 403)
+	w = request("POST", "/add?date=2026-10-02&amount=30&paid_by=bob", token, "")
+	assert.Equal(t, w.Code, 403)
+	admin := cmd.APITokens(db, &cmd.TokenRequest{Subcommand: "create", Name: "admin", Scopes: []string{cmd.SCOPE_ADMIN}})
+	assert.Assert(t, admin.Success)
+	w = request("POST", "/api/v2/expenses", admin.Token, `{"date":"2026-10-02","amount":"30","paid_by":"bob","shared":true}`)
+	assert.Equal(t, w.Code, 201)
+	w = request("POST", "/api/v2/expenses", admin.Token, `{"date":"2026-10-03","amount":"5","paid_by":"bob"}`)
+	assert.Equal(t, w.Code, 201)
+	w = request("POST", "/api/v2/expenses", admin.Token, `{"date":"2026-10-03","amount":"5","paid_by":"eve"}`)
+	assert.Equal(t, w.Code, 422)
+	assert.Equal(t, errorCode(t, w), CODE_UNKNOWN_USER)
+
+	w = request("GET", "/api/v2/expenses?who=bob", token, "")
+	assert.Equal(t, w.Code, 200)
+	var page struct {
+		Data []data.Expense `json:"data"`
+	}
+	assert.NilError(t, json.Unmarshal(w.Body.Bytes(), &page))
+	assert.Equal(t, len(page.Data), 2)
+
+	// sessions can't list users, which needs the admin scope
+	w = request("GET", "/api/v2/users", token, "")
+	assert.Equal(t, w.Code, 403)
+	w = request("GET", "/api/v2/users", admin.Token, "")
+	assert.Equal(t, w.Code, 200)
+	assert.Equal(t, w.Body.String(), `{"data":[{"name":"alice","can_log_in":true},{"name":"bob","can_log_in":false}]}`)
+
+	w = request("GET", "/api/v2/settle-up?start=2026-10-01", token, "")
+	assert.Equal(t, w.Code, 200)
+	var settle struct {
+		Data data.SettleUp `json:"data"`
+	}
+	assert.NilError(t, json.Unmarshal(w.Body.Bytes(), &settle))
+	assert.Equal(t, settle.Data.Total.Amount(), int64(12000))
+	assert.Equal(t, len(settle.Data.Transfers), 1)
+	assert.Equal(t, settle.Data.Transfers[0].From, "bob")
+	assert.Equal(t, settle.Data.Transfers[0].To, "alice")
+	assert.Equal(t, settle.Data.Transfers[0].Amount.Amount(), int64(3000))
+
+	w = request("POST", "/api/v2/settlements", token, `{"from":"bob","to":"alice","amount":"30"}`)
+	assert.Equal(t, w.Code, 403)
+	aliceAdmin := cmd.APITokens(db, &cmd.TokenRequest{Subcommand: "create", Name: "alice-admin", User: "alice",
+		Scopes: []string{cmd.SCOPE_ADMIN}})
+	assert.Assert(t, aliceAdmin.Success)
+	w = request("POST", "/api/v2/settlements", aliceAdmin.Token, `{"from":"bob","to":"alice","amount":"30"}`)
+	assert.Equal(t, w.Code, 201)
+
+	w = request("DELETE", "/api/v2/sessions/current", token, "")
+	assert.Equal(t, w.Code, 204)
+	w = request("GET", "/api/v2/users", token, "")
+	assert.Equal(t, w.Code, 401)
+}
+
+func TestSplitsAndSettlements(t *testing.T) {
+	defer teardown()
+
+	db, _ = cmd.ConnectDB(cmd.TEST_DB_NAME)
+	assert.Assert(t, cmd.Users(db, &cmd.UserRequest{Subcommand: "add", Name: "alice"}).Success)
+	assert.Assert(t, cmd.Users(db, &cmd.UserRequest{Subcommand: "add", Name: "bob"}).Success)
+	assert.Assert(t, cmd.Users(db, &cmd.UserRequest{Subcommand: "add", Name: "carol"}).Success)
+	server := httptest.NewServer(newRouter(Config{NoAuth: true}))
+	defer server.Close()
+	c := client.New(server.URL)
+
+	// carol isn't part of the split, so she owes nothing
+	date, amount, alice, percent := "2026-10-01", json.Number("100"), "alice", cmd.SPLIT_PERCENT
+	created, err := c.CreateExpense(&client.ExpenseInput{Date: &date, Amount: &amount, PaidBy: &alice, Split: &percent,
+		Participants: []string{"alice:60", "bob:40"}})
+	assert.NilError(t, err)
+	assert.Assert(t, created.Shared)
+	assert.Equal(t, created.Split, cmd.SPLIT_PERCENT)
+	assert.Equal(t, len(created.Shares), 2)
+	assert.Equal(t, created.Shares[1].User, "bob")
+	assert.Equal(t, created.Shares[1].Amount.Amount(), int64(4000))
+
+	exact := cmd.SPLIT_EXACT
+	_, err = c.CreateExpense(&client.ExpenseInput{Date: &date, Amount: &amount, Split: &exact,
+		Participants: []string{"alice:50", "bob:40"}})
+	var apiErr *client.Error
+	assert.Assert(t, errors.As(err, &apiErr))
+	assert.Equal(t, apiErr.Code, client.CODE_VALIDATION_FAILED)
+	assert.Equal(t, apiErr.Message, "exact shares add up to $90.00, not the amount of $100.00")
+
+	settle, err := c.SettleUp(nil)
+	assert.NilError(t, err)
+	assert.Equal(t, len(settle.Transfers), 1)
+	assert.Equal(t, settle.Transfers[0].Amount.Amount(), int64(4000))
+
+	settlement, err := c.CreateSettlement(&client.SettlementInput{From: "bob", To: "alice", Amount: "40", Date: "2026-10-05"})
+	assert.NilError(t, err)
+	assert.Assert(t, settlement.Id > 0)
+	_, err = c.CreateSettlement(&client.SettlementInput{From: "bob", To: "eve", Amount: "40"})
+	assert.Assert(t, errors.As(err, &apiErr))
+	assert.Equal(t, apiErr.Code, client.CODE_UNKNOWN_USER)
+	settlements, err := c.ListSettlements()
+	assert.NilError(t, err)
+	assert.Equal(t, len(settlements), 1)
+
+	settle, err = c.SettleUp(nil)
+	assert.NilError(t, err)
+	assert.Equal(t, len(settle.Transfers), 0)
+	assert.Equal(t, settle.Balances[1].User, "bob")
+	assert.Equal(t, settle.Balances[1].Settled.Amount(), int64(4000))
+}
+
+// readEvent reads the next server-sent event from a stream, skipping comments.
+func readEvent(t *testing.T, r *bufio.Reader) (id, event string, change data.Change) {
+	t.Helper()
+	for {
+		line, err := r.ReadString('\n')
+		assert.NilError(t, err)
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "" && id != "":
+			return id, event, change
+		case strings.HasPrefix(line, "id: "):
+			id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "event: "):
+			event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			assert.NilError(t, json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &change))
+		}
+	}
+}
+
+func TestEvents(t *testing.T) {
+	defer teardown()
+
+	db, _ = cmd.ConnectDB(cmd.TEST_DB_NAME)
+	server := httptest.NewServer(newRouter(Config{NoAuth: true}))
+	defer server.Close()
+	c := client.New(server.URL)
+	httpClient := &http.Client{Timeout: 5 * time.Second}
+	stream := func(lastId string) *http.Response {
+		req, err := http.NewRequest("GET", server.URL+"/events", nil)
+		assert.NilError(t, err)
+		if lastId != "" {
+			req.Header.Set("Last-Event-ID", lastId)
+		}
+		resp, err := httpClient.Do(req)
+		assert.NilError(t, err)
+		return resp
+	}
+
+	resp := stream("")
+	assert.Equal(t, resp.StatusCode, 200)
+	assert.Equal(t, resp.Header.Get("Content-Type"), "text/event-stream")
+	events := bufio.NewReader(resp.Body)
+
+	date, amount := "2026-10-01", json.Number("12.50")
+	created, err := c.CreateExpense(&client.ExpenseInput{Date: &date, Amount: &amount})
+	assert.NilError(t, err)
+	firstId, event, change := readEvent(t, events)
+	assert.Equal(t, event, cmd.CHANGE_CREATED)
+	assert.Equal(t, change.ExpenseId, created.Id)
+	assert.Equal(t, change.Expense.Amount.Amount(), int64(1250))
+
+	location := "Market"
+	_, err = c.UpdateExpense(created.Id, &client.ExpenseInput{Location: &location})
+	assert.NilError(t, err)
+	_, event, change = readEvent(t, events)
+	assert.Equal(t, event, cmd.CHANGE_UPDATED)
+	assert.Equal(t, change.Expense.Location, "Market")
+
+	assert.NilError(t, c.DeleteExpense(created.Id))
+	lastId, event, change := readEvent(t, events)
+	assert.Equal(t, event, cmd.CHANGE_DELETED)
+	assert.Equal(t, change.Expense.Location, "Market")
+	resp.Body.Close()
+
+	// reconnecting resumes after the last change received
+	resp = stream(firstId)
+	events = bufio.NewReader(resp.Body)
+	_, event, _ = readEvent(t, events)
+	assert.Equal(t, event, cmd.CHANGE_UPDATED)
+	id, event, _ := readEvent(t, events)
+	assert.Equal(t, event, cmd.CHANGE_DELETED)
+	assert.Equal(t, id, lastId)
+	resp.Body.Close()
+
+	// renaming or deleting a category changes its expenses
+	resp = stream("")
+	events = bufio.NewReader(resp.Body)
+	_, err = c.CreateCategory("food")
+	assert.NilError(t, err)
+	category := "food"
+	created, err = c.CreateExpense(&client.ExpenseInput{Date: &date, Amount: &amount, Category: &category})
+	assert.NilError(t, err)
+	_, event, _ = readEvent(t, events)
+	assert.Equal(t, event, cmd.CHANGE_CREATED)
+	_, err = c.RenameCategory("food", "groceries", false)
+	assert.NilError(t, err)
+	_, event, change = readEvent(t, events)
+	assert.Equal(t, event, cmd.CHANGE_UPDATED)
+	assert.Equal(t, change.ExpenseId, created.Id)
+	assert.Equal(t, change.Expense.Category, "groceries")
+	_, err = c.DeleteCategory("groceries", false)
+	assert.NilError(t, err)
+	lastId, event, change = readEvent(t, events)
+	assert.Equal(t, event, cmd.CHANGE_UPDATED)
+	assert.Equal(t, change.Expense.Category, "")
+	resp.Body.Close()
+
+	// a client that missed changes that are no longer kept is told to reload
+	_, err = db.Exec("DELETE FROM changes")
+	assert.NilError(t, err)
+	resp = stream(firstId)
+	events = bufio.NewReader(resp.Body)
+	id, event, _ = readEvent(t, events)
+	assert.Equal(t, event, RESET_EVENT)
+	assert.Equal(t, id, lastId)
+	resp.Body.Close()
+
+	resp = stream("yesterday")
+	assert.Equal(t, resp.StatusCode, 400)
+	resp.Body.Close()
+}
+
+func TestEventsAuth(t *testing.T) {
+	defer teardown()
+
+	db, _ = cmd.ConnectDB(cmd.TEST_DB_NAME)
+	read := cmd.APITokens(db, &cmd.TokenRequest{Subcommand: "create", Name: "read", Scopes: []string{cmd.SCOPE_READ}})
+	assert.Assert(t, read.Success)
+	server := httptest.NewServer(newRouter(Config{}))
+	defer server.Close()
+	httpClient := &http.Client{Timeout: 5 * time.Second}
+
+	resp, err := httpClient.Get(server.URL + "/events")
+	assert.NilError(t, err)
+	assert.Equal(t, resp.StatusCode, 401)
+	resp.Body.Close()
+
+	// browsers can't set headers on an EventSource, so the token can be given in the query string instead
+	resp, err = httpClient.Get(server.URL + "/events?token=" + read.Token)
+	assert.NilError(t, err)
+	assert.Equal(t, resp.StatusCode, 200)
+	assert.Equal(t, resp.Header.Get("Content-Type"), "text/event-stream")
+	resp.Body.Close()
+
+	// only for /events
+	resp, err = httpClient.Get(server.URL + "/api/v2/categories?token=" + read.Token)
+	assert.NilError(t, err)
+	assert.Equal(t, resp.StatusCode, 401)
+	resp.Body.Close()
+
+	line := logFormatter(gin.LogFormatterParams{Method: "GET", Path: "/events?token=" + read.Token, StatusCode: 200})
+	assert.Assert(t, !strings.Contains(line, read.Token), line)
+	assert.Assert(t, strings.Contains(line, "/events?token=REDACTED"), line)
+}
+
+func TestWebhooks(t *testing.T) {
+	defer teardown()
+
+	db, _ = cmd.ConnectDB(cmd.TEST_DB_NAME)
+	defer func(backoff time.Duration) { webhookBackoff = backoff }(webhookBackoff)
+	webhookBackoff = time.Millisecond
+
+	// the receiver fails the first delivery, so that it is retried
+	var mu sync.Mutex
+	var received []*http.Request
+	var bodies [][]byte
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		body, _ := io.ReadAll(r.Body)
+		received = append(received, r)
+		bodies = append(bodies, body)
+		if len(received) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer receiver.Close()
+
+	addReq, err := cmd.ParseWebhookArgs("add", receiver.URL, "", "whsec_test", 0)
+	assert.NilError(t, err)
+	addResp := cmd.Webhooks(db, addReq)
+	assert.Assert(t, addResp.Success)
+	deletesReq, err := cmd.ParseWebhookArgs("add", receiver.URL+"/deletes", cmd.CHANGE_DELETED, "", 0)
+	assert.NilError(t, err)
+	assert.Assert(t, cmd.Webhooks(db, deletesReq).Success)
+
+	server := httptest.NewServer(newRouter(Config{NoAuth: true}))
+	defer server.Close()
+	date, amount := "2026-10-01", json.Number("12.50")
+	created, err := client.New(server.URL).CreateExpense(&client.ExpenseInput{Date: &date, Amount: &amount})
+	assert.NilError(t, err)
+	webhooks.wait()
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, len(received), 2)
+	assert.Equal(t, received[1].URL.Path, "/")
+	assert.Equal(t, received[1].Header.Get(cmd.EVENT_HEADER), cmd.CHANGE_CREATED)
+	assert.Equal(t, received[1].Header.Get(cmd.DELIVERY_HEADER), received[0].Header.Get(cmd.DELIVERY_HEADER))
+	timestamp, err := strconv.ParseInt(received[1].Header.Get(cmd.TIMESTAMP_HEADER), 10, 64)
+	assert.NilError(t, err)
+	assert.Equal(t, received[1].Header.Get(cmd.SIGNATURE_HEADER), cmd.SignWebhook("whsec_test", timestamp, bodies[1]))
+	var change data.Change
+	assert.NilError(t, json.Unmarshal(bodies[1], &change))
+	assert.Equal(t, change.ExpenseId, created.Id)
+
+	logReq, err := cmd.ParseWebhookArgs("log", "", "", "", addResp.Created.Id)
+	assert.NilError(t, err)
+	logResp := cmd.Webhooks(db, logReq)
+	assert.Assert(t, logResp.Success)
+	assert.Equal(t, len(logResp.Deliveries), 2)
+	assert.Assert(t, logResp.Deliveries[0].Succeeded)
+	assert.Equal(t, logResp.Deliveries[0].Attempt, 2)
+	assert.Assert(t, !logResp.Deliveries[1].Succeeded)
+	assert.Equal(t, logResp.Deliveries[1].Status, 500)
+	assert.Equal(t, logResp.Deliveries[1].Error, "webhook responded with 500 Internal Server Error")
+
+	listResp := cmd.Webhooks(db, &cmd.WebhookRequest{Subcommand: "list"})
+	assert.Assert(t, listResp.Success)
+	assert.Equal(t, len(listResp.Result), 2)
+	assert.Equal(t, listResp.Result[0].Secret, "")
+	assert.Equal(t, listResp.Result[0].LastDelivery.Id, logResp.Deliveries[0].Id)
+	assert.Assert(t, listResp.Result[1].LastDelivery == nil)
+}
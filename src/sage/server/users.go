@@ -0,0 +1,140 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sage/src/sage/cmd"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// loginBody holds the credentials in a v2 login request body
+type loginBody struct {
+	Name     *string `json:"name"`
+	Password *string `json:"password"`
+}
+
+// loginV2 starts a session for the user named in the request body, and responds with the session's token
+func loginV2(c *gin.Context) {
+	var body loginBody
+	if !decodeBody(c, &body) {
+		return
+	}
+	if body.Name == nil || body.Password == nil {
+		abortV2(c, http.StatusUnprocessableEntity, CODE_VALIDATION_FAILED, "name and password are required")
+		return
+	}
+
+	session, err := cmd.Login(db, *body.Name, *body.Password)
+	if err != nil {
+		abortV2Error(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"data": session})
+}
+
+// logoutV2 ends the session the request is authenticated with
+func logoutV2(c *gin.Context) {
+	token := bearerToken(c)
+	if !cmd.IsSessionToken(token) {
+		abortV2(c, http.StatusBadRequest, CODE_INVALID_REQUEST, "request is not authenticated with a session")
+		return
+	}
+	if err := cmd.Logout(db, token); err != nil {
+		abortV2Error(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// listUsersV2 lists every user
+func listUsersV2(c *gin.Context) {
+	userResp := cmd.Users(db, &cmd.UserRequest{Subcommand: "list"})
+	if !userResp.Success {
+		abortV2Error(c, userResp.Error)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": userResp.Result})
+}
+
+// settleUpV2 responds with what each user owes the others for shared expenses between the requested dates
+func settleUpV2(c *gin.Context) {
+	settleReq, err := parseSettleUpQuery(c)
+	if err != nil {
+		abortV2(c, http.StatusBadRequest, CODE_INVALID_REQUEST, err.Error())
+		return
+	}
+	settleResp := cmd.SettleUp(db, settleReq)
+	if !settleResp.Success {
+		abortV2Error(c, settleResp.Error)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": settleResp.Result})
+}
+
+// parseSettleUpQuery constructs a SettleUpRequest from the start, end, range and year query string parameters
+func parseSettleUpQuery(c *gin.Context) (*cmd.SettleUpRequest, error) {
+	startStr, endStr, err := cmd.RangeArgs(c.Query("range"), c.Query("start"), c.Query("end"))
+	if err != nil {
+		return nil, err
+	}
+	year := 0
+	if yearStr := c.Query("year"); yearStr != "" {
+		year, err = strconv.Atoi(yearStr)
+		if err != nil {
+			return nil, errors.New("invalid year format")
+		}
+	}
+	return cmd.ParseSettleUpArgs(startStr, endStr, year)
+}
+
+// settlementBody holds the fields of a settlement in a v2 request body
+type settlementBody struct {
+	From   *string      `json:"from"`
+	To     *string      `json:"to"`
+	Amount *json.Number `json:"amount"`
+	Date   *string      `json:"date"`
+}
+
+// listSettlementsV2 lists every settlement, most recent first
+func listSettlementsV2(c *gin.Context) {
+	settleResp := cmd.Settlements(db, &cmd.SettlementRequest{Subcommand: "list"})
+	if !settleResp.Success {
+		abortV2Error(c, settleResp.Error)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": settleResp.Result})
+}
+
+// createSettlementV2 records a payment from one user to another from the request body, which requires who paid, who
+// was paid and the amount, and responds with the settlement. The date defaults to today.
+func createSettlementV2(c *gin.Context) {
+	var body settlementBody
+	if !decodeBody(c, &body) {
+		return
+	}
+	if body.From == nil || body.To == nil || body.Amount == nil {
+		abortV2(c, http.StatusUnprocessableEntity, CODE_VALIDATION_FAILED, "from, to and amount are required")
+		return
+	}
+	if !actsFor(c, *body.From) {
+		abortV2(c, http.StatusForbidden, CODE_FORBIDDEN, "recording settlements from another user requires the 'admin' scope")
+		return
+	}
+
+	settleReq, err := cmd.ParseSettlementArgs(*body.From, *body.To, body.Amount.String(), valueOf(body.Date))
+	if err != nil {
+		abortV2(c, http.StatusUnprocessableEntity, CODE_VALIDATION_FAILED, err.Error())
+		return
+	}
+	settleResp := cmd.Settlements(db, settleReq)
+	if !settleResp.Success {
+		abortV2Error(c, settleResp.Error)
+		return
+	}
+	settlement := settleReq.Settlement
+	settlement.Id = settleResp.Id
+	c.JSON(http.StatusCreated, gin.H{"data": settlement})
+}
@@ -0,0 +1,465 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sage/src/sage/cmd"
+	"sage/src/sage/data"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Error codes of the v2 API, returned in the error envelope alongside a message
+const (
+	CODE_INVALID_REQUEST   = "invalid_request"
+	CODE_VALIDATION_FAILED = "validation_failed"
+	CODE_UNKNOWN_CATEGORY  = "unknown_category"
+	CODE_UNKNOWN_USER      = "unknown_user"
+	CODE_NOT_FOUND         = "not_found"
+	CODE_CONFLICT          = "conflict"
+	CODE_INTERNAL          = "internal"
+)
+
+// Path that the v2 routes are served under
+const V2_PREFIX = "/api/v2"
+
+// apiError is the body of every v2 error response, under the "error" key
+type apiError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// expenseBody holds the fields of an expense in a v2 request body. Fields that are left out are unset, so that PATCH
+// only changes the fields given. Amount is a number of dollars.
+type expenseBody struct {
+	Date         *string      `json:"date"`
+	Location     *string      `json:"location"`
+	Description  *string      `json:"description"`
+	Category     *string      `json:"category"`
+	Amount       *json.Number `json:"amount"`
+	PaidBy       *string      `json:"paid_by"`
+	Shared       *bool        `json:"shared"`
+	Split        *string      `json:"split"`
+	Participants []string     `json:"participants"`
+}
+
+// categoryBody holds the fields of a category in a v2 request body
+type categoryBody struct {
+	Name *string `json:"name"`
+}
+
+// registerV2 adds the resource-oriented v2 routes to the group. Successful responses hold their resource under "data",
+// and errors are reported as {"error": {"code": ..., "message": ...}}.
+func registerV2(r *gin.RouterGroup) {
+	r.GET("/expenses", listExpensesV2)
+	r.POST("/expenses", createExpenseV2)
+	r.GET("/expenses/:id", getExpenseV2)
+	r.PATCH("/expenses/:id", updateExpenseV2)
+	r.DELETE("/expenses/:id", deleteExpenseV2)
+
+	r.GET("/categories", listCategoriesV2)
+	r.POST("/categories", createCategoryV2)
+	r.PATCH("/categories/:name", renameCategoryV2)
+	r.DELETE("/categories/:name", deleteCategoryV2)
+
+	r.GET("/summaries", listSummariesV2)
+
+	r.POST("/sessions", loginV2)
+	r.DELETE("/sessions/current", logoutV2)
+	r.GET("/users", listUsersV2)
+	r.GET("/settle-up", settleUpV2)
+	r.GET("/settlements", listSettlementsV2)
+	r.POST("/settlements", createSettlementV2)
+}
+
+// noRouteHandler reports unknown v2 routes with an error envelope, and anything else as gin does by default
+func noRouteHandler(c *gin.Context) {
+	if strings.HasPrefix(c.Request.URL.Path, V2_PREFIX+"/") {
+		abortV2(c, http.StatusNotFound, CODE_NOT_FOUND, "no route for "+c.Request.Method+" "+c.Request.URL.Path)
+		return
+	}
+	c.String(http.StatusNotFound, "404 page not found")
+}
+
+// abortV2 responds with an error envelope
+func abortV2(c *gin.Context, status int, code, message string) {
+	c.AbortWithStatusJSON(status, gin.H{"error": apiError{Code: code, Message: message}})
+}
+
+// abortV2Error responds with the error envelope for an error returned by an operation, choosing the status and code
+// from its kind
+func abortV2Error(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, cmd.ErrNotFound):
+		abortV2(c, http.StatusNotFound, CODE_NOT_FOUND, kindMessage(err))
+	case errors.Is(err, cmd.ErrConflict):
+		abortV2(c, http.StatusConflict, CODE_CONFLICT, kindMessage(err))
+	case errors.Is(err, cmd.ErrUnknownCategory):
+		abortV2(c, http.StatusUnprocessableEntity, CODE_UNKNOWN_CATEGORY, kindMessage(err))
+	case errors.Is(err, cmd.ErrUnknownUser):
+		abortV2(c, http.StatusUnprocessableEntity, CODE_UNKNOWN_USER, kindMessage(err))
+	case errors.Is(err, cmd.ErrInvalid):
+		abortV2(c, http.StatusUnprocessableEntity, CODE_VALIDATION_FAILED, kindMessage(err))
+	case errors.Is(err, cmd.ErrUnauthorized):
+		abortV2(c, http.StatusUnauthorized, CODE_UNAUTHORIZED, kindMessage(err))
+	default:
+		abortV2(c, http.StatusInternalServerError, CODE_INTERNAL, err.Error())
+	}
+}
+
+// kindMessage returns the message of the innermost error in err's chain, which describes the failure without the
+// context added by the operation, such as "category 'food' already exists"
+func kindMessage(err error) string {
+	for {
+		inner := errors.Unwrap(err)
+		if inner == nil {
+			return err.Error()
+		}
+		err = inner
+	}
+}
+
+// decodeBody decodes the JSON request body into v, rejecting unknown fields and trailing data. Responds with an error
+// and returns false if the body is malformed.
+func decodeBody(c *gin.Context, v any) bool {
+	decoder := json.NewDecoder(c.Request.Body)
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(v)
+	if err == nil && decoder.More() {
+		err = errors.New("unexpected data after JSON object")
+	}
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			err = errors.New("body is empty")
+		}
+		abortV2(c, http.StatusBadRequest, CODE_INVALID_REQUEST, "invalid request body: "+err.Error())
+		return false
+	}
+	return true
+}
+
+// expenseId parses the ID of the expense in the route. Responds with an error and returns false if it isn't a number.
+func expenseId(c *gin.Context) (int, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		abortV2(c, http.StatusBadRequest, CODE_INVALID_REQUEST, "invalid id format")
+		return 0, false
+	}
+	return id, true
+}
+
+// respondExpense responds with the expense with the given ID
+func respondExpense(c *gin.Context, status int, id int) {
+	getResp := cmd.GetExpense(db, &cmd.GetRequest{Id: id})
+	if !getResp.Success {
+		abortV2Error(c, getResp.Error)
+		return
+	}
+	c.JSON(status, gin.H{"data": getResp.Result})
+}
+
+// listExpensesV2 lists the expenses matching the same query string parameters as logHandler, one page at a time,
+// with the cursors of the adjacent pages
+func listExpensesV2(c *gin.Context) {
+	logReq, err := parseLogQuery(c)
+	if err != nil {
+		abortV2(c, http.StatusBadRequest, CODE_INVALID_REQUEST, err.Error())
+		return
+	}
+	logReq.ShowId = true
+
+	logResp := cmd.LogExpenses(db, logReq)
+	if !logResp.Success {
+		abortV2Error(c, logResp.Error)
+		return
+	}
+	defer logResp.Result.Close()
+
+	expenses, err := cmd.ScanExpenses(logResp.Result, true)
+	if err == nil {
+		err = cmd.LoadShares(db, expenses)
+	}
+	if err != nil {
+		abortV2Error(c, err)
+		return
+	}
+	if expenses == nil {
+		expenses = []data.Expense{}
+	}
+	body := gin.H{"data": expenses}
+	if logResp.NextCursor != "" {
+		body["next_cursor"] = logResp.NextCursor
+	}
+	if logResp.PrevCursor != "" {
+		body["prev_cursor"] = logResp.PrevCursor
+	}
+	c.JSON(http.StatusOK, body)
+}
+
+// createExpenseV2 adds an expense from the request body, which requires a date and an amount, and responds with the
+// created expense. The expense is created by the user the request is authenticated as, who also paid for it unless
+// the body says otherwise. Giving participants, with an optional split method, splits the expense between them.
+func createExpenseV2(c *gin.Context) {
+	var body expenseBody
+	if !decodeBody(c, &body) {
+		return
+	}
+	if body.Date == nil || body.Amount == nil {
+		abortV2(c, http.StatusUnprocessableEntity, CODE_VALIDATION_FAILED, "date and amount are required")
+		return
+	}
+
+	addReq, err := cmd.ParseAddArgs(*body.Date, valueOf(body.Location), valueOf(body.Description),
+		valueOf(body.Category), body.Amount.String())
+	if err != nil {
+		abortV2(c, http.StatusUnprocessableEntity, CODE_VALIDATION_FAILED, err.Error())
+		return
+	}
+	addReq.Expense.CreatedBy = requestUser(c)
+	addReq.Expense.PaidBy = addReq.Expense.CreatedBy
+	if body.PaidBy != nil {
+		addReq.Expense.PaidBy = *body.PaidBy
+	}
+	if !actsFor(c, addReq.Expense.PaidBy) {
+		abortV2(c, http.StatusForbidden, CODE_FORBIDDEN, "recording expenses paid by another user requires the 'admin' scope")
+		return
+	}
+	addReq.Expense.Shared = body.Shared != nil && *body.Shared
+	if body.Split != nil || body.Participants != nil {
+		if body.Shared != nil && !*body.Shared {
+			abortV2(c, http.StatusUnprocessableEntity, CODE_VALIDATION_FAILED, "cannot split an expense that isn't shared")
+			return
+		}
+		addReq.Split, err = cmd.ParseSplit(valueOf(body.Split), body.Participants)
+		if err != nil {
+			abortV2(c, http.StatusUnprocessableEntity, CODE_VALIDATION_FAILED, err.Error())
+			return
+		}
+	}
+	addResp := cmd.AddExpense(db, addReq)
+	if !addResp.Success {
+		abortV2Error(c, addResp.Error)
+		return
+	}
+	publishExpense(c, cmd.CHANGE_CREATED, addResp.Id)
+	c.Header("Location", fmt.Sprintf("%s/expenses/%d", V2_PREFIX, addResp.Id))
+	respondExpense(c, http.StatusCreated, addResp.Id)
+}
+
+// getExpenseV2 responds with a single expense
+func getExpenseV2(c *gin.Context) {
+	id, ok := expenseId(c)
+	if !ok {
+		return
+	}
+	respondExpense(c, http.StatusOK, id)
+}
+
+// updateExpenseV2 changes the fields of an expense given in the request body, and responds with the updated expense.
+// An empty category uncategorizes the expense, an empty payer leaves it unattributed, and a split of "none" shares it
+// between every user rather than its participants.
+func updateExpenseV2(c *gin.Context) {
+	id, ok := expenseId(c)
+	if !ok {
+		return
+	}
+	var body expenseBody
+	if !decodeBody(c, &body) {
+		return
+	}
+	if body.PaidBy != nil && !actsFor(c, *body.PaidBy) {
+		abortV2(c, http.StatusForbidden, CODE_FORBIDDEN, "recording expenses paid by another user requires the 'admin' scope")
+		return
+	}
+
+	editArgs := cmd.EditArgs{
+		Date:        body.Date,
+		Location:    body.Location,
+		Description: body.Description,
+		Category:    body.Category,
+		PaidBy:      body.PaidBy,
+		Split:       body.Split,
+		With:        body.Participants,
+	}
+	if body.Amount != nil {
+		amount := body.Amount.String()
+		editArgs.Amount = &amount
+	}
+	if body.Shared != nil {
+		shared := strconv.FormatBool(*body.Shared)
+		editArgs.Shared = &shared
+	}
+	editReq, err := cmd.ParseEditArgs(id, editArgs)
+	if err != nil {
+		abortV2(c, http.StatusUnprocessableEntity, CODE_VALIDATION_FAILED, err.Error())
+		return
+	}
+	editResp := cmd.EditExpense(db, editReq)
+	if !editResp.Success {
+		abortV2Error(c, editResp.Error)
+		return
+	}
+	publishExpense(c, cmd.CHANGE_UPDATED, id)
+	respondExpense(c, http.StatusOK, id)
+}
+
+// deleteExpenseV2 removes an expense
+func deleteExpenseV2(c *gin.Context) {
+	id, ok := expenseId(c)
+	if !ok {
+		return
+	}
+	getResp := cmd.GetExpense(db, &cmd.GetRequest{Id: id})
+	deleteResp := cmd.DeleteExpense(db, &cmd.DeleteRequest{Id: id})
+	if !deleteResp.Success {
+		abortV2Error(c, deleteResp.Error)
+		return
+	}
+	if getResp.Success {
+		publishChange(c, cmd.CHANGE_DELETED, getResp.Result)
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// listCategoriesV2 lists every category
+func listCategoriesV2(c *gin.Context) {
+	catResp := cmd.ExpenseCategory(db, &cmd.CategoryRequest{})
+	if !catResp.Success {
+		abortV2Error(c, catResp.Error)
+		return
+	}
+	defer catResp.Result.Close()
+
+	categories := []data.Category{}
+	for catResp.Result.Next() {
+		var category data.Category
+		if err := catResp.Result.Scan(&category.Name); err != nil {
+			abortV2Error(c, err)
+			return
+		}
+		categories = append(categories, category)
+	}
+	if err := catResp.Result.Err(); err != nil {
+		abortV2Error(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": categories})
+}
+
+// categoryName returns the name in a category request body. Responds with an error and returns false if it is missing.
+func categoryName(c *gin.Context) (string, bool) {
+	var body categoryBody
+	if !decodeBody(c, &body) {
+		return "", false
+	}
+	if body.Name == nil || strings.TrimSpace(*body.Name) == "" {
+		abortV2(c, http.StatusUnprocessableEntity, CODE_VALIDATION_FAILED, "name is required")
+		return "", false
+	}
+	return strings.TrimSpace(*body.Name), true
+}
+
+// createCategoryV2 adds a category with the name in the request body
+func createCategoryV2(c *gin.Context) {
+	name, ok := categoryName(c)
+	if !ok {
+		return
+	}
+	catResp := cmd.ExpenseCategory(db, &cmd.CategoryRequest{Subcommand: "add", CategoryName: name})
+	if !catResp.Success {
+		abortV2Error(c, catResp.Error)
+		return
+	}
+	c.Header("Location", V2_PREFIX+"/categories/"+name)
+	c.JSON(http.StatusCreated, gin.H{"data": data.Category{Name: name}})
+}
+
+// renameCategoryV2 renames a category to the name in the request body, moving its expenses to the new name. Responds
+// with the number of expenses moved, and with dry-run=true only reports how many would be.
+func renameCategoryV2(c *gin.Context) {
+	name, ok := categoryName(c)
+	if !ok {
+		return
+	}
+	dryRun, ok := dryRunV2(c)
+	if !ok {
+		return
+	}
+	catResp := cmd.ExpenseCategory(db, &cmd.CategoryRequest{Subcommand: "edit", CategoryName: c.Param("name"),
+		NewCategoryName: name, DryRun: dryRun})
+	if !catResp.Success {
+		abortV2Error(c, catResp.Error)
+		return
+	}
+	publishExpenses(c, cmd.CHANGE_UPDATED, catResp.ExpenseIds)
+	c.JSON(http.StatusOK, gin.H{"data": data.Category{Name: name}, "affected": catResp.Affected, "affected_recurring": catResp.AffectedRecurring})
+}
+
+// deleteCategoryV2 removes a category, uncategorizing its expenses. Responds with the number of expenses
+// uncategorized, and with dry-run=true only reports how many would be.
+func deleteCategoryV2(c *gin.Context) {
+	dryRun, ok := dryRunV2(c)
+	if !ok {
+		return
+	}
+	name := c.Param("name")
+	catResp := cmd.ExpenseCategory(db, &cmd.CategoryRequest{Subcommand: "delete", CategoryName: name, DryRun: dryRun})
+	if !catResp.Success {
+		abortV2Error(c, catResp.Error)
+		return
+	}
+	publishExpenses(c, cmd.CHANGE_UPDATED, catResp.ExpenseIds)
+	c.JSON(http.StatusOK, gin.H{"data": data.Category{Name: name}, "affected": catResp.Affected, "affected_recurring": catResp.AffectedRecurring})
+}
+
+// dryRunV2 parses the dry-run query string parameter. Responds with an error and returns false if it is invalid.
+func dryRunV2(c *gin.Context) (bool, bool) {
+	dryRunStr := c.Query("dry-run")
+	if dryRunStr == "" {
+		return false, true
+	}
+	dryRun, err := strconv.ParseBool(dryRunStr)
+	if err != nil {
+		abortV2(c, http.StatusBadRequest, CODE_INVALID_REQUEST, "invalid dry run format")
+		return false, false
+	}
+	return dryRun, true
+}
+
+// listSummariesV2 summarizes expenses with the same query string parameters as summaryHandler
+func listSummariesV2(c *gin.Context) {
+	sumReq, err := parseSummaryQuery(c)
+	if err != nil {
+		abortV2(c, http.StatusBadRequest, CODE_INVALID_REQUEST, err.Error())
+		return
+	}
+	sumResp := cmd.SummarizeExpenses(db, sumReq)
+	if !sumResp.Success {
+		abortV2Error(c, sumResp.Error)
+		return
+	}
+	defer sumResp.Result.Close()
+
+	summaries, err := sumReq.ScanSummaries(sumResp)
+	if err != nil {
+		abortV2Error(c, err)
+		return
+	}
+	if summaries == nil {
+		summaries = []data.Summary{}
+	}
+	c.JSON(http.StatusOK, gin.H{"data": summaries})
+}
+
+// valueOf returns the string s points to, or an empty string if it is nil
+func valueOf(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
@@ -0,0 +1,71 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"sage/src/sage/cmd"
+	"sage/src/sage/data"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Number of attempts made to deliver a change to a webhook before giving up
+const WEBHOOK_ATTEMPTS = 6
+
+// How long to wait before retrying a failed delivery, which doubles after each attempt. With 6 attempts, the last is
+// made about 15 minutes after the first.
+var webhookBackoff = 30 * time.Second
+
+// webhookWorker delivers the changes published by the hub to the webhooks that subscribe to them, retrying failed
+// deliveries with exponential backoff. Each delivery is made in its own goroutine, so that a slow webhook doesn't hold
+// up the others.
+type webhookWorker struct {
+	client  *http.Client
+	pending sync.WaitGroup
+}
+
+var webhooks = &webhookWorker{client: &http.Client{Timeout: cmd.WEBHOOK_TIMEOUT}}
+
+// dispatch starts delivering a change to every webhook that subscribes to it, without waiting for the deliveries.
+func (w *webhookWorker) dispatch(change data.Change) {
+	w.pending.Add(1)
+	go func() {
+		defer w.pending.Done()
+
+		subscribed, err := cmd.WebhooksFor(db, change.Type)
+		if err != nil {
+			fmt.Fprintf(gin.DefaultErrorWriter, "error delivering change %d to webhooks: %v\n", change.Id, err)
+			return
+		}
+		for _, webhook := range subscribed {
+			w.pending.Add(1)
+			go w.deliver(webhook, change)
+		}
+	}()
+}
+
+// deliver sends a change to a webhook until it is received or every attempt has failed.
+func (w *webhookWorker) deliver(webhook data.Webhook, change data.Change) {
+	defer w.pending.Done()
+
+	backoff := webhookBackoff
+	for attempt := 1; ; attempt++ {
+		delivery, err := cmd.DeliverWebhook(db, w.client, webhook, change, attempt)
+		if err != nil {
+			fmt.Fprintf(gin.DefaultErrorWriter, "error delivering change %d to webhook %d: %v\n", change.Id, webhook.Id, err)
+			return
+		}
+		if delivery.Succeeded || attempt == WEBHOOK_ATTEMPTS {
+			return
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// wait waits for every delivery in progress to finish.
+func (w *webhookWorker) wait() {
+	w.pending.Wait()
+}
@@ -0,0 +1,109 @@
+package tui
+
+import (
+	"bufio"
+)
+
+// Names of the special keys read by readKey
+const (
+	KEY_UP        = "up"
+	KEY_DOWN      = "down"
+	KEY_LEFT      = "left"
+	KEY_RIGHT     = "right"
+	KEY_PAGE_UP   = "pgup"
+	KEY_PAGE_DOWN = "pgdown"
+	KEY_HOME      = "home"
+	KEY_END       = "end"
+	KEY_DELETE    = "delete"
+	KEY_ENTER     = "enter"
+	KEY_ESCAPE    = "esc"
+	KEY_BACKSPACE = "backspace"
+	KEY_TAB       = "tab"
+	KEY_CTRL_C    = "ctrl-c"
+	KEY_CTRL_U    = "ctrl-u"
+)
+
+// key is a key press. name is one of the KEY_ constants for special keys, or empty for a printable character, which
+// is held in r.
+type key struct {
+	name string
+	r    rune
+}
+
+// Escape sequences sent by special keys after "\x1b[" or "\x1bO", by their final byte
+var escapeKeys = map[byte]string{
+	'A': KEY_UP,
+	'B': KEY_DOWN,
+	'C': KEY_RIGHT,
+	'D': KEY_LEFT,
+	'H': KEY_HOME,
+	'F': KEY_END,
+}
+
+// Escape sequences of the form "\x1b[<n>~", by their number
+var tildeKeys = map[string]string{
+	"1": KEY_HOME,
+	"7": KEY_HOME,
+	"4": KEY_END,
+	"8": KEY_END,
+	"3": KEY_DELETE,
+	"5": KEY_PAGE_UP,
+	"6": KEY_PAGE_DOWN,
+}
+
+// readKey reads a key press from a terminal in raw mode. Unrecognized control characters and escape sequences are
+// returned as a key with neither a name nor a character, which is ignored.
+func readKey(in *bufio.Reader) (key, error) {
+	r, _, err := in.ReadRune()
+	if err != nil {
+		return key{}, err
+	}
+	switch r {
+	case '\r', '\n':
+		return key{name: KEY_ENTER}, nil
+	case '\t':
+		return key{name: KEY_TAB}, nil
+	case 127, '\b':
+		return key{name: KEY_BACKSPACE}, nil
+	case 3:
+		return key{name: KEY_CTRL_C}, nil
+	case 21:
+		return key{name: KEY_CTRL_U}, nil
+	case 27:
+		return readEscape(in)
+	}
+	if r < ' ' {
+		return key{}, nil
+	}
+	return key{r: r}, nil
+}
+
+// readEscape reads the rest of an escape sequence after its escape character. A lone escape, with nothing else
+// already sent, is the escape key itself.
+func readEscape(in *bufio.Reader) (key, error) {
+	if in.Buffered() == 0 {
+		return key{name: KEY_ESCAPE}, nil
+	}
+	b, err := in.ReadByte()
+	if err != nil {
+		return key{}, err
+	}
+	if b != '[' && b != 'O' {
+		return key{}, nil
+	}
+
+	var params []byte
+	for {
+		b, err := in.ReadByte()
+		if err != nil {
+			return key{}, err
+		}
+		if b >= 0x40 && b <= 0x7e {
+			if b == '~' {
+				return key{name: tildeKeys[string(params)]}, nil
+			}
+			return key{name: escapeKeys[b]}, nil
+		}
+		params = append(params, b)
+	}
+}
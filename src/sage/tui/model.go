@@ -0,0 +1,360 @@
+package tui
+
+import (
+	"fmt"
+	"sage/src/sage/cmd"
+	"sage/src/sage/data"
+	"strconv"
+	"strings"
+
+	"github.com/Rhymond/go-money"
+)
+
+// backend performs the operations of the UI. It is implemented with the same cmd operations that the CLI uses.
+type backend interface {
+	// expenses returns the expenses matching the filter query, most recent first, with their IDs
+	expenses(query string) ([]data.Expense, error)
+	// categoryTotals returns the total of the expenses matching the filter query in each category, largest first
+	categoryTotals(query string) ([]categoryTotal, error)
+	// categories returns the names of every category
+	categories() ([]string, error)
+	edit(req *cmd.EditRequest) error
+	delete(id int) error
+}
+
+// categoryTotal is the total spent in a category, with uncategorized expenses under an empty name.
+type categoryTotal struct {
+	category string
+	total    int64
+}
+
+// mode is what key presses currently act on.
+type mode int
+
+const (
+	modeBrowse mode = iota
+	modeFilter
+	modeEdit
+	modeConfirmDelete
+	modeHelp
+)
+
+// Fields of an expense that can be edited inline
+const (
+	FIELD_CATEGORY = "category"
+	FIELD_AMOUNT   = "amount"
+)
+
+// model holds the state of the UI. Key presses update it through handleKey, and render draws it.
+type model struct {
+	backend backend
+
+	expenses   []data.Expense
+	totals     []categoryTotal
+	categories []string
+
+	mode      mode
+	selected  int
+	offset    int
+	filter    string
+	editField string
+	input     string
+	status    string
+	showPanel bool
+	quit      bool
+
+	width  int
+	height int
+
+	// completions cycled through by Tab while editing a category, and the position in the cycle
+	completions []string
+	completion  int
+}
+
+// newModel returns a model showing every expense, loaded from the backend.
+func newModel(b backend) (*model, error) {
+	m := &model{backend: b, showPanel: true}
+	categories, err := b.categories()
+	if err != nil {
+		return nil, err
+	}
+	m.categories = categories
+	if err := m.reload(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// reload retrieves the expenses and category totals matching the filter, keeping the same expense selected if it
+// still matches.
+func (m *model) reload() error {
+	selectedId := 0
+	if expense := m.selectedExpense(); expense != nil {
+		selectedId = expense.Id
+	}
+
+	expenses, err := m.backend.expenses(m.filter)
+	if err != nil {
+		return err
+	}
+	totals, err := m.backend.categoryTotals(m.filter)
+	if err != nil {
+		return err
+	}
+	m.expenses = expenses
+	m.totals = totals
+
+	m.selected = min(m.selected, max(len(m.expenses)-1, 0))
+	for i, expense := range m.expenses {
+		if expense.Id == selectedId {
+			m.selected = i
+			break
+		}
+	}
+	m.scroll()
+	return nil
+}
+
+// selectedExpense returns the selected expense, or nil if there are none.
+func (m *model) selectedExpense() *data.Expense {
+	if m.selected < len(m.expenses) {
+		return &m.expenses[m.selected]
+	}
+	return nil
+}
+
+// tableRows returns the number of expenses that fit on the screen.
+func (m *model) tableRows() int {
+	return max(m.height-TABLE_CHROME_LINES, 1)
+}
+
+// scroll moves the first expense shown so that the selected expense is on the screen.
+func (m *model) scroll() {
+	rows := m.tableRows()
+	if m.selected < m.offset {
+		m.offset = m.selected
+	}
+	if m.selected >= m.offset+rows {
+		m.offset = m.selected - rows + 1
+	}
+	m.offset = max(min(m.offset, len(m.expenses)-rows), 0)
+}
+
+// move moves the selection by n expenses, stopping at the first and last.
+func (m *model) move(n int) {
+	m.selected = max(min(m.selected+n, len(m.expenses)-1), 0)
+	m.scroll()
+}
+
+// handleKey updates the model for a key press.
+func (m *model) handleKey(k key) {
+	if k.name == KEY_CTRL_C {
+		m.quit = true
+		return
+	}
+
+	switch m.mode {
+	case modeBrowse:
+		m.status = ""
+		m.handleBrowseKey(k)
+	case modeFilter:
+		m.handleFilterKey(k)
+	case modeEdit:
+		m.handleEditKey(k)
+	case modeConfirmDelete:
+		m.handleConfirmDeleteKey(k)
+	case modeHelp:
+		m.mode = modeBrowse
+	}
+}
+
+func (m *model) handleBrowseKey(k key) {
+	switch {
+	case k.name == KEY_UP || k.r == 'k':
+		m.move(-1)
+	case k.name == KEY_DOWN || k.r == 'j':
+		m.move(1)
+	case k.name == KEY_PAGE_UP || k.r == 'b':
+		m.move(-m.tableRows())
+	case k.name == KEY_PAGE_DOWN || k.r == ' ':
+		m.move(m.tableRows())
+	case k.name == KEY_HOME || k.r == 'g':
+		m.move(-len(m.expenses))
+	case k.name == KEY_END || k.r == 'G':
+		m.move(len(m.expenses))
+	case k.r == '/':
+		m.mode = modeFilter
+	case k.name == KEY_ESCAPE && m.filter != "":
+		m.filter = ""
+		m.refresh()
+	case k.r == 'c':
+		m.startEdit(FIELD_CATEGORY)
+	case k.r == 'a':
+		m.startEdit(FIELD_AMOUNT)
+	case k.r == 'd' || k.name == KEY_DELETE:
+		if m.selectedExpense() != nil {
+			m.mode = modeConfirmDelete
+		}
+	case k.r == 's':
+		m.showPanel = !m.showPanel
+	case k.r == 'r':
+		m.refresh()
+		if m.status == "" {
+			m.status = "Reloaded"
+		}
+	case k.r == '?':
+		m.mode = modeHelp
+	case k.r == 'q':
+		m.quit = true
+	}
+}
+
+func (m *model) handleFilterKey(k key) {
+	switch {
+	case k.name == KEY_ENTER:
+		m.mode = modeBrowse
+	case k.name == KEY_ESCAPE:
+		m.mode = modeBrowse
+		m.filter = ""
+		m.refresh()
+	case k.name == KEY_BACKSPACE:
+		if m.filter != "" {
+			runes := []rune(m.filter)
+			m.filter = string(runes[:len(runes)-1])
+			m.refresh()
+		}
+	case k.name == KEY_CTRL_U:
+		m.filter = ""
+		m.refresh()
+	case k.name == KEY_UP:
+		m.move(-1)
+	case k.name == KEY_DOWN:
+		m.move(1)
+	case k.r != 0:
+		m.filter += string(k.r)
+		m.refresh()
+	}
+}
+
+// startEdit starts editing a field of the selected expense, starting from its current value.
+func (m *model) startEdit(field string) {
+	expense := m.selectedExpense()
+	if expense == nil {
+		return
+	}
+	m.mode = modeEdit
+	m.editField = field
+	m.completions = nil
+	if field == FIELD_CATEGORY {
+		m.input = expense.Category
+	} else {
+		m.input = fmt.Sprintf("%.2f", expense.Amount.AsMajorUnits())
+	}
+}
+
+func (m *model) handleEditKey(k key) {
+	switch {
+	case k.name == KEY_ENTER:
+		m.saveEdit()
+	case k.name == KEY_ESCAPE:
+		m.mode = modeBrowse
+	case k.name == KEY_BACKSPACE:
+		if m.input != "" {
+			runes := []rune(m.input)
+			m.input = string(runes[:len(runes)-1])
+		}
+		m.completions = nil
+	case k.name == KEY_CTRL_U:
+		m.input = ""
+		m.completions = nil
+	case k.name == KEY_TAB && m.editField == FIELD_CATEGORY:
+		m.completeCategory()
+	case k.r != 0:
+		m.input += string(k.r)
+		m.completions = nil
+	}
+}
+
+// completeCategory replaces the category being typed with the next category that starts with what was typed, cycling
+// through the matches on each Tab.
+func (m *model) completeCategory() {
+	if m.completions == nil {
+		prefix := strings.ToLower(m.input)
+		m.completions = []string{}
+		for _, category := range m.categories {
+			if strings.HasPrefix(strings.ToLower(category), prefix) {
+				m.completions = append(m.completions, category)
+			}
+		}
+		m.completion = -1
+	}
+	if len(m.completions) == 0 {
+		return
+	}
+	m.completion = (m.completion + 1) % len(m.completions)
+	m.input = m.completions[m.completion]
+}
+
+// saveEdit saves the field being edited, staying in edit mode with an error if it is invalid.
+func (m *model) saveEdit() {
+	expense := m.selectedExpense()
+	req := &cmd.EditRequest{Id: expense.Id}
+	value := strings.TrimSpace(m.input)
+
+	if m.editField == FIELD_CATEGORY {
+		category := ""
+		for _, name := range m.categories {
+			if strings.EqualFold(name, value) {
+				category = name
+			}
+		}
+		if category == "" && value != "" && value != cmd.DisplayCategory("") {
+			m.status = fmt.Sprintf("Category '%s' does not exist", value)
+			return
+		}
+		req.Category = &category
+	} else {
+		fl, err := strconv.ParseFloat(strings.TrimPrefix(value, "$"), 64)
+		if err != nil {
+			m.status = "Amount must be a number"
+			return
+		}
+		req.Amount = money.NewFromFloat(fl, money.USD)
+	}
+
+	if err := m.backend.edit(req); err != nil {
+		m.status = "Error editing expense: " + err.Error()
+		return
+	}
+	m.mode = modeBrowse
+	m.refresh()
+	if m.status == "" {
+		m.status = fmt.Sprintf("Changed the %s of expense %d", m.editField, expense.Id)
+	}
+}
+
+func (m *model) handleConfirmDeleteKey(k key) {
+	m.mode = modeBrowse
+	if k.r != 'y' && k.r != 'Y' {
+		m.status = "Delete cancelled"
+		return
+	}
+
+	id := m.selectedExpense().Id
+	if err := m.backend.delete(id); err != nil {
+		m.status = "Error deleting expense: " + err.Error()
+		return
+	}
+	m.refresh()
+	if m.status == "" {
+		m.status = fmt.Sprintf("Deleted expense %d", id)
+	}
+}
+
+// refresh reloads the expenses, showing an error in the status line if they can't be retrieved.
+func (m *model) refresh() {
+	m.status = ""
+	if err := m.reload(); err != nil {
+		m.status = "Error loading expenses: " + err.Error()
+	}
+}
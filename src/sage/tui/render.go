@@ -0,0 +1,249 @@
+package tui
+
+import (
+	"fmt"
+	"sage/src/sage/chart"
+	"sage/src/sage/cmd"
+	"strings"
+	"unicode/utf8"
+)
+
+// Number of lines around the expense table: the title, filter box, table header and rule, status line and key hints
+const TABLE_CHROME_LINES = 6
+
+// Width of the category panel, and the narrowest screen it is shown on
+const (
+	PANEL_WIDTH     = 34
+	PANEL_MIN_WIDTH = 90
+)
+
+// ANSI escape sequences for highlighting
+const (
+	REVERSE = "\x1b[7m"
+	BOLD    = "\x1b[1m"
+	RESET   = "\x1b[0m"
+)
+
+var helpLines = []string{
+	"Keys",
+	"",
+	"  up/k, down/j     move the selection",
+	"  pgup/b, pgdn/spc move a page",
+	"  home/g, end/G    move to the first or last expense",
+	"  /                filter by date, location, description or amount",
+	"  esc              clear the filter",
+	"  c                change the category (tab completes)",
+	"  a                change the amount",
+	"  d/delete         delete the expense",
+	"  s                show or hide the category totals",
+	"  r                reload",
+	"  q/ctrl-c         quit",
+	"",
+	"Press any key to return.",
+}
+
+// render draws the screen as lines of exactly the model's width. unicode allows box-drawing and block characters.
+func (m *model) render(unicode bool) []string {
+	width := max(m.width, 20)
+	height := max(m.height, TABLE_CHROME_LINES+1)
+	lines := make([]string, 0, height)
+
+	var total int64
+	for _, expense := range m.expenses {
+		total += expense.Amount.Amount()
+	}
+	title := fmt.Sprintf(" sage  %d expenses  total %s", len(m.expenses), formatCents(total))
+	lines = append(lines, REVERSE+spread(title, "? help  q quit ", width)+RESET)
+
+	filterLine := " / " + m.filter
+	if m.mode == modeFilter {
+		filterLine = BOLD + pad(filterLine+"_", width) + RESET
+	} else if m.filter == "" {
+		filterLine = pad(" Press / to filter", width)
+	} else {
+		filterLine = pad(filterLine, width)
+	}
+	lines = append(lines, filterLine)
+
+	tableWidth := width
+	showPanel := m.showPanel && width >= PANEL_MIN_WIDTH
+	if showPanel {
+		tableWidth = width - PANEL_WIDTH - 3
+	}
+	var body []string
+	if m.mode == modeHelp {
+		for _, line := range helpLines {
+			body = append(body, pad(" "+line, tableWidth))
+		}
+	} else {
+		body = m.renderTable(tableWidth, unicode)
+	}
+	for len(body) < height-4 {
+		body = append(body, strings.Repeat(" ", tableWidth))
+	}
+	body = body[:height-4]
+
+	if showPanel {
+		panel := m.renderPanel(unicode)
+		separator := " | "
+		if unicode {
+			separator = " │ "
+		}
+		for i := range body {
+			cell := strings.Repeat(" ", PANEL_WIDTH)
+			if i < len(panel) {
+				cell = panel[i]
+			}
+			body[i] += separator + cell
+		}
+	}
+	lines = append(lines, body...)
+
+	lines = append(lines, pad(" "+m.statusLine(), width))
+	lines = append(lines, REVERSE+pad(" "+m.keyHints(), width)+RESET)
+	return lines
+}
+
+// renderTable draws the table header and the expenses that fit on the screen, highlighting the selected one.
+func (m *model) renderTable(width int, unicode bool) []string {
+	// the location and description share the width left by the other columns
+	fixed := []int{5, 10, 14, 11}
+	flexible := max(width-5-10-14-11-10, 8)
+	locationWidth := flexible * 2 / 5
+	widths := []int{fixed[0], fixed[1], locationWidth, flexible - locationWidth, fixed[2], fixed[3]}
+	right := []bool{true, false, false, false, false, true}
+
+	row := func(cells []string) string {
+		padded := make([]string, len(cells))
+		for i, cell := range cells {
+			cell = truncate(cell, widths[i], unicode)
+			if right[i] {
+				padded[i] = strings.Repeat(" ", widths[i]-utf8.RuneCountInString(cell)) + cell
+			} else {
+				padded[i] = pad(cell, widths[i])
+			}
+		}
+		return pad(strings.Join(padded, "  "), width)
+	}
+
+	rule := "-"
+	if unicode {
+		rule = "─"
+	}
+	lines := []string{
+		BOLD + row([]string{"ID", "Date", "Location", "Description", "Category", "Amount"}) + RESET,
+		strings.Repeat(rule, width),
+	}
+	if len(m.expenses) == 0 {
+		message := "No expenses"
+		if m.filter != "" {
+			message = "No expenses match the filter"
+		}
+		return append(lines, pad(" "+message, width))
+	}
+
+	end := min(m.offset+m.tableRows(), len(m.expenses))
+	for i := m.offset; i < end; i++ {
+		expense := m.expenses[i]
+		line := row([]string{fmt.Sprint(expense.Id), expense.Date.String(), expense.Location, expense.Description,
+			cmd.DisplayCategory(expense.Category), formatCents(expense.Amount.Amount())})
+		if i == m.selected {
+			line = REVERSE + line + RESET
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// renderPanel draws the category totals of the filtered expenses as bars, in lines of exactly PANEL_WIDTH.
+func (m *model) renderPanel(unicode bool) []string {
+	rule := "-"
+	if unicode {
+		rule = "─"
+	}
+	lines := []string{BOLD + pad("Category totals", PANEL_WIDTH) + RESET, strings.Repeat(rule, PANEL_WIDTH)}
+
+	var labels []string
+	var values []int64
+	for _, total := range m.totals {
+		labels = append(labels, cmd.DisplayCategory(total.category))
+		values = append(values, total.total)
+	}
+	c := &chart.Chart{Width: PANEL_WIDTH, Unicode: unicode}
+	for _, bar := range c.Bars(labels, values) {
+		lines = append(lines, pad(bar, PANEL_WIDTH))
+	}
+	return lines
+}
+
+// statusLine returns the prompt for the current mode, or the latest status message.
+func (m *model) statusLine() string {
+	switch m.mode {
+	case modeEdit:
+		prompt := fmt.Sprintf("New %s: %s_", m.editField, m.input)
+		if m.status != "" {
+			prompt += "  (" + m.status + ")"
+		}
+		return prompt
+	case modeConfirmDelete:
+		expense := m.selectedExpense()
+		return fmt.Sprintf("Delete expense %d (%s, %s, %s)? y/n", expense.Id, expense.Date, expense.Location,
+			formatCents(expense.Amount.Amount()))
+	}
+	return m.status
+}
+
+// keyHints returns the keys available in the current mode.
+func (m *model) keyHints() string {
+	switch m.mode {
+	case modeFilter:
+		return "type to filter  enter done  esc clear"
+	case modeEdit:
+		if m.editField == FIELD_CATEGORY {
+			return "tab complete  enter save  esc cancel"
+		}
+		return "enter save  esc cancel"
+	case modeConfirmDelete:
+		return "y delete  any other key cancels"
+	case modeHelp:
+		return "any key returns"
+	}
+	return "j/k move  / filter  c category  a amount  d delete  s totals  r reload  ? help  q quit"
+}
+
+// formatCents formats an amount in cents as dollars.
+func formatCents(cents int64) string {
+	return fmt.Sprintf("$%.2f", float64(cents)/100)
+}
+
+// pad truncates or pads s with spaces to exactly width characters.
+func pad(s string, width int) string {
+	s = truncate(s, width, false)
+	return s + strings.Repeat(" ", width-utf8.RuneCountInString(s))
+}
+
+// spread places left at the start and right at the end of a line of width characters, dropping right if there isn't
+// room for both.
+func spread(left, right string, width int) string {
+	gap := width - utf8.RuneCountInString(left) - utf8.RuneCountInString(right)
+	if gap < 1 {
+		return pad(left, width)
+	}
+	return left + strings.Repeat(" ", gap) + right
+}
+
+// truncate shortens s to at most width characters, marking where it was cut with an ellipsis, or a period if unicode
+// isn't set.
+func truncate(s string, width int, unicode bool) string {
+	if utf8.RuneCountInString(s) <= width {
+		return s
+	}
+	if width <= 0 {
+		return ""
+	}
+	mark := "."
+	if unicode {
+		mark = "…"
+	}
+	return string([]rune(s)[:width-1]) + mark
+}
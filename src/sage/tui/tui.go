@@ -0,0 +1,142 @@
+package tui
+
+import (
+	"bufio"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sage/src/sage/chart"
+	"sage/src/sage/cmd"
+	"sage/src/sage/data"
+	"strings"
+
+	"github.com/Rhymond/go-money"
+	"golang.org/x/term"
+)
+
+// ANSI escape sequences for taking over the terminal
+const (
+	ALT_SCREEN_ON  = "\x1b[?1049h"
+	ALT_SCREEN_OFF = "\x1b[?1049l"
+	HIDE_CURSOR    = "\x1b[?25l"
+	SHOW_CURSOR    = "\x1b[?25h"
+	CURSOR_HOME    = "\x1b[H"
+	CLEAR_LINE     = "\x1b[K"
+)
+
+// Run shows the expenses in db full screen on the terminal in, drawing to out until the user quits. The terminal is
+// restored before returning.
+func Run(db *sql.DB, in *os.File, out io.Writer) error {
+	fd := int(in.Fd())
+	if !term.IsTerminal(fd) {
+		return errors.New("must be run in a terminal")
+	}
+
+	m, err := newModel(&dbBackend{db: db})
+	if err != nil {
+		return err
+	}
+
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		return fmt.Errorf("error setting up terminal: %w", err)
+	}
+	defer term.Restore(fd, state)
+	fmt.Fprint(out, ALT_SCREEN_ON+HIDE_CURSOR)
+	defer fmt.Fprint(out, SHOW_CURSOR+ALT_SCREEN_OFF)
+
+	unicode := chart.Unicode()
+	reader := bufio.NewReader(in)
+	for !m.quit {
+		// the size is checked before every redraw so that the screen follows the terminal when it is resized
+		m.width, m.height = chart.DEFAULT_WIDTH, 24
+		if width, height, err := term.GetSize(fd); err == nil {
+			m.width, m.height = width, height
+		}
+		m.scroll()
+		fmt.Fprint(out, CURSOR_HOME+strings.Join(m.render(unicode), CLEAR_LINE+"\r\n")+CLEAR_LINE)
+
+		k, err := readKey(reader)
+		if err != nil {
+			return nil
+		}
+		m.handleKey(k)
+	}
+	return nil
+}
+
+// dbBackend performs the operations of the UI on a database.
+type dbBackend struct {
+	db *sql.DB
+}
+
+func (b *dbBackend) expenses(query string) ([]data.Expense, error) {
+	logReq, err := cmd.ParseLogArgs("", "", 0, 0, 0, 0, 0, "", "-date,-created", true, query, cmd.FilterArgs{})
+	if err != nil {
+		return nil, err
+	}
+	logResp := cmd.LogExpenses(b.db, logReq)
+	if !logResp.Success {
+		return nil, logResp.Error
+	}
+	defer logResp.Result.Close()
+
+	return cmd.ScanExpenses(logResp.Result, true)
+}
+
+func (b *dbBackend) categoryTotals(query string) ([]categoryTotal, error) {
+	catResp := cmd.SummarizeCategories(b.db, &cmd.CategorySummaryRequest{ExpenseFilter: cmd.ExpenseFilter{Query: query}})
+	if !catResp.Success {
+		return nil, catResp.Error
+	}
+	defer catResp.Result.Close()
+
+	var totals []categoryTotal
+	for catResp.Result.Next() {
+		var total categoryTotal
+		var amount money.Amount
+		var count int
+		if err := catResp.Result.Scan(&total.category, &amount, &count); err != nil {
+			return nil, err
+		}
+		total.total = amount
+		totals = append(totals, total)
+	}
+	return totals, catResp.Result.Err()
+}
+
+func (b *dbBackend) categories() ([]string, error) {
+	catResp := cmd.ExpenseCategory(b.db, &cmd.CategoryRequest{})
+	if !catResp.Success {
+		return nil, catResp.Error
+	}
+	defer catResp.Result.Close()
+
+	var categories []string
+	for catResp.Result.Next() {
+		var category string
+		if err := catResp.Result.Scan(&category); err != nil {
+			return nil, err
+		}
+		categories = append(categories, category)
+	}
+	return categories, catResp.Result.Err()
+}
+
+func (b *dbBackend) edit(req *cmd.EditRequest) error {
+	editResp := cmd.EditExpense(b.db, req)
+	if !editResp.Success {
+		return editResp.Error
+	}
+	return nil
+}
+
+func (b *dbBackend) delete(id int) error {
+	deleteResp := cmd.DeleteExpense(b.db, &cmd.DeleteRequest{Id: id})
+	if !deleteResp.Success {
+		return deleteResp.Error
+	}
+	return nil
+}
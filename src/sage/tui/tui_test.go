@@ -0,0 +1,225 @@
+package tui
+
+import (
+	"bufio"
+	"errors"
+	"sage/src/sage/cmd"
+	"sage/src/sage/data"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"cloud.google.com/go/civil"
+	"github.com/Rhymond/go-money"
+	"github.com/google/go-cmp/cmp"
+	"gotest.tools/v3/assert"
+)
+
+// fakeBackend holds expenses in memory, most recent first.
+type fakeBackend struct {
+	all []data.Expense
+}
+
+func (b *fakeBackend) expenses(query string) ([]data.Expense, error) {
+	var matches []data.Expense
+	for _, expense := range b.all {
+		if strings.Contains(strings.ToLower(expense.Location+" "+expense.Description), strings.ToLower(query)) {
+			matches = append(matches, expense)
+		}
+	}
+	return matches, nil
+}
+
+func (b *fakeBackend) categoryTotals(query string) ([]categoryTotal, error) {
+	expenses, _ := b.expenses(query)
+	var totals []categoryTotal
+	for _, expense := range expenses {
+		totals = append(totals, categoryTotal{category: expense.Category, total: expense.Amount.Amount()})
+	}
+	return totals, nil
+}
+
+func (b *fakeBackend) categories() ([]string, error) {
+	return []string{"food", "fun", "rent"}, nil
+}
+
+func (b *fakeBackend) edit(req *cmd.EditRequest) error {
+	for i := range b.all {
+		if b.all[i].Id == req.Id {
+			if req.Category != nil {
+				b.all[i].Category = *req.Category
+			}
+			if req.Amount != nil {
+				b.all[i].Amount = req.Amount
+			}
+			return nil
+		}
+	}
+	return errors.New("no expense found")
+}
+
+func (b *fakeBackend) delete(id int) error {
+	for i := range b.all {
+		if b.all[i].Id == id {
+			b.all = append(b.all[:i], b.all[i+1:]...)
+			return nil
+		}
+	}
+	return errors.New("no expense found")
+}
+
+func newTestModel(t *testing.T, n int) (*model, *fakeBackend) {
+	b := &fakeBackend{}
+	for i := n; i > 0; i-- {
+		b.all = append(b.all, data.Expense{
+			Id:       i,
+			Date:     civil.Date{Year: 2026, Month: 10, Day: i},
+			Location: []string{"Market", "Cafe"}[i%2],
+			Category: "food",
+			Amount:   money.New(int64(i*100), money.USD),
+		})
+	}
+	m, err := newModel(b)
+	assert.NilError(t, err)
+	m.width, m.height = 100, 10
+	return m, b
+}
+
+// typeKeys sends each character of s to the model as a key press.
+func typeKeys(m *model, s string) {
+	for _, r := range s {
+		m.handleKey(key{r: r})
+	}
+}
+
+func TestNavigation(t *testing.T) {
+	m, _ := newTestModel(t, 20)
+	rows := m.tableRows()
+	assert.Equal(t, rows, 4)
+
+	m.handleKey(key{name: KEY_UP})
+	assert.Equal(t, m.selected, 0)
+	typeKeys(m, "jjjjj")
+	assert.Equal(t, m.selected, 5)
+	assert.Equal(t, m.offset, 2)
+
+	m.handleKey(key{name: KEY_END})
+	assert.Equal(t, m.selected, 19)
+	assert.Equal(t, m.offset, 16)
+	m.handleKey(key{name: KEY_PAGE_UP})
+	assert.Equal(t, m.selected, 15)
+	typeKeys(m, "g")
+	assert.Equal(t, m.selected, 0)
+	assert.Equal(t, m.offset, 0)
+
+	typeKeys(m, "q")
+	assert.Assert(t, m.quit)
+}
+
+func TestFilter(t *testing.T) {
+	m, _ := newTestModel(t, 6)
+	typeKeys(m, "j/caf")
+	assert.Equal(t, m.mode, modeFilter)
+	assert.Equal(t, len(m.expenses), 3)
+
+	// the selected expense stays selected while it matches
+	assert.Equal(t, m.selectedExpense().Id, 5)
+	m.handleKey(key{name: KEY_BACKSPACE})
+	assert.Equal(t, m.filter, "ca")
+	m.handleKey(key{name: KEY_ENTER})
+	assert.Equal(t, m.mode, modeBrowse)
+
+	m.handleKey(key{name: KEY_ESCAPE})
+	assert.Equal(t, m.filter, "")
+	assert.Equal(t, len(m.expenses), 6)
+}
+
+func TestEdit(t *testing.T) {
+	m, b := newTestModel(t, 3)
+
+	typeKeys(m, "c")
+	assert.Equal(t, m.mode, modeEdit)
+	assert.Equal(t, m.input, "food")
+	m.handleKey(key{name: KEY_CTRL_U})
+	typeKeys(m, "f")
+	m.handleKey(key{name: KEY_TAB})
+	assert.Equal(t, m.input, "food")
+	m.handleKey(key{name: KEY_TAB})
+	assert.Equal(t, m.input, "fun")
+	m.handleKey(key{name: KEY_ENTER})
+	assert.Equal(t, m.mode, modeBrowse)
+	assert.Equal(t, b.all[0].Category, "fun")
+	assert.Equal(t, m.status, "Changed the category of expense 3")
+
+	typeKeys(m, "c")
+	m.handleKey(key{name: KEY_CTRL_U})
+	typeKeys(m, "travel")
+	m.handleKey(key{name: KEY_ENTER})
+	assert.Equal(t, m.mode, modeEdit)
+	assert.Equal(t, m.status, "Category 'travel' does not exist")
+	m.handleKey(key{name: KEY_ESCAPE})
+
+	typeKeys(m, "ja")
+	assert.Equal(t, m.input, "2.00")
+	m.handleKey(key{name: KEY_CTRL_U})
+	typeKeys(m, "abc")
+	m.handleKey(key{name: KEY_ENTER})
+	assert.Equal(t, m.status, "Amount must be a number")
+	m.handleKey(key{name: KEY_CTRL_U})
+	typeKeys(m, "$20.05")
+	m.handleKey(key{name: KEY_ENTER})
+	assert.Equal(t, b.all[1].Amount.Amount(), int64(2005))
+}
+
+func TestDelete(t *testing.T) {
+	m, b := newTestModel(t, 3)
+
+	typeKeys(m, "dn")
+	assert.Equal(t, m.status, "Delete cancelled")
+	assert.Equal(t, len(b.all), 3)
+
+	typeKeys(m, "Gdy")
+	assert.Equal(t, m.status, "Deleted expense 1")
+	assert.Equal(t, len(m.expenses), 2)
+	assert.Equal(t, m.selected, 1)
+}
+
+func TestRender(t *testing.T) {
+	m, _ := newTestModel(t, 3)
+	lines := m.render(true)
+	assert.Equal(t, len(lines), m.height)
+	for _, line := range lines {
+		line = strings.NewReplacer(REVERSE, "", BOLD, "", RESET, "").Replace(line)
+		assert.Equal(t, utf8.RuneCountInString(line), m.width, line)
+	}
+	assert.Assert(t, strings.Contains(lines[0], "3 expenses  total $6.00"))
+	assert.Assert(t, strings.HasPrefix(lines[4], REVERSE+"    3  2026-10-03  Cafe"))
+	assert.Assert(t, strings.Contains(lines[4], "│ food "))
+
+	// the category panel is hidden on narrow screens
+	m.width = 60
+	lines = m.render(false)
+	assert.Assert(t, !strings.Contains(lines[4], "|"))
+}
+
+func TestReadKey(t *testing.T) {
+	in := bufio.NewReader(strings.NewReader("a\x1b[A\x1b[6~\x1bOH\r\x7f\x03é"))
+	var keys []key
+	for {
+		k, err := readKey(in)
+		if err != nil {
+			break
+		}
+		keys = append(keys, k)
+	}
+	assert.DeepEqual(t, keys, []key{
+		{r: 'a'},
+		{name: KEY_UP},
+		{name: KEY_PAGE_DOWN},
+		{name: KEY_HOME},
+		{name: KEY_ENTER},
+		{name: KEY_BACKSPACE},
+		{name: KEY_CTRL_C},
+		{r: 'é'},
+	}, cmp.AllowUnexported(key{}))
+}